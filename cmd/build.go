@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
@@ -21,10 +22,12 @@ import (
 	"github.com/djcass44/all-your-base/pkg/airutil"
 	aybv1 "github.com/djcass44/all-your-base/pkg/api/v1"
 	cacertificates "github.com/djcass44/all-your-base/pkg/ca-certificates"
+	debianindex "github.com/djcass44/all-your-base/pkg/debian"
 	"github.com/djcass44/all-your-base/pkg/downloader"
 	"github.com/djcass44/all-your-base/pkg/lockfile"
 	"github.com/djcass44/all-your-base/pkg/packages/alpine"
 	"github.com/djcass44/all-your-base/pkg/packages/debian"
+	"github.com/djcass44/all-your-base/pkg/triggers"
 	"github.com/go-logr/logr"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/spf13/cobra"
@@ -52,6 +55,8 @@ const (
 
 	flagSkipCACerts          = "skip-ca-certificates"
 	flagSkipPackageRecording = "skip-package-recording"
+	flagSkipLdCache          = "skip-ld-cache"
+	flagTriggers             = "triggers"
 )
 
 const (
@@ -74,6 +79,8 @@ func init() {
 
 	buildCmd.Flags().Bool(flagSkipCACerts, false, "skip running update-ca-certificates")
 	buildCmd.Flags().Bool(flagSkipPackageRecording, true, "skip package recording")
+	buildCmd.Flags().Bool(flagSkipLdCache, false, "skip generating /etc/ld.so.cache once packages have been installed")
+	buildCmd.Flags().StringSlice(flagTriggers, nil, "install script effects to emulate (users, alternatives, ldconfig, tmpfiles)")
 
 	_ = buildCmd.MarkFlagRequired(flagConfig)
 	_ = buildCmd.MarkFlagFilename(flagConfig, ".yaml", ".yml")
@@ -101,6 +108,8 @@ func build(cmd *cobra.Command, _ []string) error {
 	forceUid, _ := cmd.Flags().GetInt(flagUid)
 
 	skipPackageRecording, _ := cmd.Flags().GetBool(flagSkipPackageRecording)
+	enabledTriggers, _ := cmd.Flags().GetStringSlice(flagTriggers)
+	skipLdCache, _ := cmd.Flags().GetBool(flagSkipLdCache)
 
 	// if the platform value exists, then we should
 	// treat it as a multi-arch build
@@ -200,15 +209,30 @@ func build(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
-	alpineKeeper, err := alpine.NewPackageKeeper(cmd.Context(), repoURLs(cfg.Spec.Repositories[strings.ToLower(string(aybv1.PackageAlpine))]), filesystem, baseImg)
+	// scratch images don't have a package database to
+	// extend, so the package managers start a new one
+	var packageBase v1.Image
+	if baseImage != containers.MagicImageScratch {
+		packageBase = baseImg
+	}
+
+	alpineKeeper, err := alpine.NewPackageKeeper(cmd.Context(), repoURLs(cfg.Spec.Repositories[strings.ToLower(string(aybv1.PackageAlpine))]), filesystem, packageBase)
+	if err != nil {
+		return err
+	}
+	debianRepos, err := debianRepositories(cmd.Context(), cfg.Spec.Repositories[strings.ToLower(string(aybv1.PackageDebian))], &fileReader{from: baseImage, img: baseImg})
 	if err != nil {
 		return err
 	}
-	debianKeeper, err := debian.NewPackageKeeper(cmd.Context(), repoURLs(cfg.Spec.Repositories[strings.ToLower(string(aybv1.PackageDebian))]), filesystem, baseImg)
+	debianKeeper, err := debian.NewPackageKeeper(cmd.Context(), debianRepos, debianindex.Arch(imgPlatform.Architecture, imgPlatform.Variant), filesystem, packageBase)
 	if err != nil {
 		return err
 	}
-	yumKeeper, err := rpm.NewPackageKeeper(cmd.Context(), repoURLs(cfg.Spec.Repositories[strings.ToLower(string(aybv1.PackageRPM))]))
+	rpmRepos, err := lockedRPMRepositories(cfg.Spec.Repositories[strings.ToLower(string(aybv1.PackageRPM))], lockFile)
+	if err != nil {
+		return err
+	}
+	yumKeeper, err := rpm.NewPackageKeeper(cmd.Context(), rpmRepos, cfg.Spec.Modules, filesystem, packageBase)
 	if err != nil {
 		return err
 	}
@@ -227,27 +251,91 @@ func build(cmd *cobra.Command, _ []string) error {
 	// something should run after files are in place
 	var pkgDeps []string
 
+	exclude := alpineExclusions(cfg.Spec.Packages)
+
 	// install packages
 	for i, name := range pkgKeys {
 		p := lockFile.Packages[name]
 
 		id := fmt.Sprintf("pkg-%d", i)
+		options := map[string]any{
+			"type":               string(p.Type),
+			"name":               name,
+			"version":            p.Version,
+			"resolved":           p.Resolved,
+			"checksum":           p.Integrity,
+			"record":             !skipPackageRecording,
+			"install-recommends": p.InstallRecommends,
+			"constraint":         p.Constraint,
+			"direct":             p.Direct,
+		}
+		// only apk has a concept of exclusions
+		if p.Type == aybv1.PackageAlpine {
+			options["exclude"] = exclude
+		}
 		pipelineStatements = append(pipelineStatements, pipelines.OrderedPipelineStatement{
-			ID: id,
-			Options: map[string]any{
-				"type":     string(p.Type),
-				"name":     name,
-				"version":  p.Version,
-				"resolved": p.Resolved,
-				"checksum": p.Integrity,
-				"record":   !skipPackageRecording,
-			},
+			ID:        id,
+			Options:   options,
 			Statement: statements.NewPackageStatement(alpineKeeper, debianKeeper, yumKeeper, dl, lockFile.LockfileVersion > 1),
 			DependsOn: []string{statements.StatementEnv},
 		})
 		pkgDeps = append(pkgDeps, id)
 	}
 
+	// declared users are added before the install scripts are
+	// emulated, so that the ids they ask for are used. The
+	// sysusers.d configuration is only applied alongside the
+	// users that install scripts would create.
+	handlers, err := triggerHandlers(packageBase, enabledTriggers, uid)
+	if err != nil {
+		return err
+	}
+	sysusers := slices.ContainsFunc(handlers, func(h triggers.Handler) bool {
+		_, ok := h.(*triggers.Users)
+		return ok
+	})
+	if len(cfg.Spec.Users) > 0 || len(cfg.Spec.Groups) > 0 || sysusers {
+		pipelineStatements = append(pipelineStatements, pipelines.OrderedPipelineStatement{
+			ID:        statements.StatementUsers,
+			Statement: statements.NewUsersStatement(packageBase, cfg.Spec.Users, cfg.Spec.Groups, sysusers, username, uid),
+			DependsOn: pkgDeps,
+		})
+		pkgDeps = append(pkgDeps, statements.StatementUsers)
+	}
+
+	// install scripts are never run, so once every package is
+	// in place they're reported, and the effects of the enabled
+	// handlers (if any) are emulated
+	pipelineStatements = append(pipelineStatements, pipelines.OrderedPipelineStatement{
+		ID:        statements.StatementTriggers,
+		Statement: statements.NewTriggerStatement(triggers.NewEmulator(handlers...), alpineKeeper, debianKeeper, yumKeeper),
+		DependsOn: pkgDeps,
+	})
+	pkgDeps = append(pkgDeps, statements.StatementTriggers)
+
+	// apk runs a trigger that installs the busybox applets, which
+	// can only happen once every package has been unpacked so that
+	// the real binaries (e.g. from coreutils) aren't replaced
+	if p, ok := lockFile.Packages["busybox"]; ok && p.Type == aybv1.PackageAlpine {
+		pipelineStatements = append(pipelineStatements, pipelines.OrderedPipelineStatement{
+			ID:        statements.StatementBusybox,
+			Statement: &statements.BusyboxStatement{},
+			DependsOn: pkgDeps,
+		})
+		pkgDeps = append(pkgDeps, statements.StatementBusybox)
+	}
+
+	// ldconfig never runs, so the cache needs to be
+	// generated once every library is in place
+	if !skipLdCache {
+		pipelineStatements = append(pipelineStatements, pipelines.OrderedPipelineStatement{
+			ID:        statements.StatementLdCache,
+			Statement: statements.NewLdCacheStatement(packageBase),
+			DependsOn: pkgDeps,
+		})
+		pkgDeps = append(pkgDeps, statements.StatementLdCache)
+	}
+
 	imgCfg, err := baseImg.ConfigFile()
 	if err != nil {
 		return err
@@ -413,6 +501,44 @@ func repoURLs(p []aybv1.Repository) []string {
 	return s
 }
 
+// alpineExclusions returns the Alpine packages
+// that must not be installed (e.g. "!foo").
+func alpineExclusions(p []aybv1.Package) []string {
+	var s []string
+	for i := range p {
+		if p[i].Type != aybv1.PackageAlpine {
+			continue
+		}
+		for _, name := range p[i].Names {
+			if strings.HasPrefix(name, "!") {
+				s = append(s, strings.TrimPrefix(name, "!"))
+			}
+		}
+	}
+	return s
+}
+
+// triggerHandlers returns the enabled handlers used to emulate
+// install scripts. The uid of the non-root user is never given
+// to another user.
+func triggerHandlers(base v1.Image, enabled []string, uid int) ([]triggers.Handler, error) {
+	all := triggers.Handlers(base, uid)
+	for _, name := range enabled {
+		if !slices.ContainsFunc(all, func(h triggers.Handler) bool {
+			return h.Name() == name
+		}) {
+			return nil, fmt.Errorf("unknown install script handler: %s", name)
+		}
+	}
+	var out []triggers.Handler
+	for _, h := range all {
+		if slices.Contains(enabled, h.Name()) {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
 func readConfig(s string) (aybv1.Build, error) {
 	f, err := os.Open(s)
 	if err != nil {
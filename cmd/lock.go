@@ -3,9 +3,12 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 
 	"chainguard.dev/apko/pkg/apk/fs"
@@ -14,6 +17,7 @@ import (
 	"github.com/Snakdy/container-build-engine/pkg/oci/auth"
 	"github.com/djcass44/all-your-base/pkg/airutil"
 	aybv1 "github.com/djcass44/all-your-base/pkg/api/v1"
+	debianindex "github.com/djcass44/all-your-base/pkg/debian"
 	"github.com/djcass44/all-your-base/pkg/lockfile"
 	"github.com/djcass44/all-your-base/pkg/packages"
 	"github.com/djcass44/all-your-base/pkg/packages/alpine"
@@ -21,6 +25,7 @@ import (
 	"github.com/djcass44/all-your-base/pkg/packages/rpm"
 	"github.com/go-logr/logr"
 	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/spf13/cobra"
 )
 
@@ -36,6 +41,7 @@ func init() {
 	lockCmd.Flags().StringP(flagConfig, "c", "", "path to an image configuration file")
 
 	lockCmd.Flags().Bool(flagSkipImageLocking, false, "skip locking of the base image")
+	lockCmd.Flags().String(flagPlatform, "", "platform that packages are locked for")
 
 	_ = lockCmd.MarkFlagRequired(flagConfig)
 	_ = lockCmd.MarkFlagFilename(flagConfig, ".yaml", ".yml")
@@ -47,6 +53,7 @@ func lock(cmd *cobra.Command, _ []string) error {
 
 	configPath, _ := cmd.Flags().GetString(flagConfig)
 	skipImageLocking, _ := cmd.Flags().GetBool(flagSkipImageLocking)
+	platform, _ := cmd.Flags().GetString(flagPlatform)
 
 	// read the config file
 	cfg, err := readConfig(configPath)
@@ -54,6 +61,14 @@ func lock(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
+	if platform == "" {
+		platform = fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	imgPlatform, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return err
+	}
+
 	configPath, err = filepath.Abs(configPath)
 	if err != nil {
 		return err
@@ -102,6 +117,11 @@ func lock(cmd *cobra.Command, _ []string) error {
 	var repoList []expandedRepo
 	for _, v := range cfg.Spec.Repositories {
 		for _, vv := range v {
+			// repositories from a .repo file are
+			// recorded using their resolved url
+			if vv.URL == "" {
+				continue
+			}
 			repoList = append(repoList, expandedRepo{
 				URL:      airutil.ExpandEnv(vv.URL),
 				Original: vv.URL,
@@ -113,22 +133,46 @@ func lock(cmd *cobra.Command, _ []string) error {
 	if err != nil {
 		return err
 	}
-	debianKeeper, err := debian.NewPackageKeeper(cmd.Context(), repoURLs(cfg.Spec.Repositories[strings.ToLower(string(aybv1.PackageDebian))]), fs.NewMemFS(), nil)
+	debianRepos, err := debianRepositories(cmd.Context(), cfg.Spec.Repositories[strings.ToLower(string(aybv1.PackageDebian))], &fileReader{from: cfg.Spec.From})
+	if err != nil {
+		return err
+	}
+	debianKeeper, err := debian.NewPackageKeeper(cmd.Context(), debianRepos, debianindex.Arch(imgPlatform.Architecture, imgPlatform.Variant), fs.NewMemFS(), nil)
 	if err != nil {
 		return err
 	}
-	yumKeeper, err := rpm.NewPackageKeeper(cmd.Context(), repoURLs(cfg.Spec.Repositories[strings.ToLower(string(aybv1.PackageRPM))]))
+	rpmRepos, lockedRepos, err := resolveRPMRepositories(cmd.Context(), cfg.Spec.Repositories[strings.ToLower(string(aybv1.PackageRPM))], cfg.Spec.From, imgPlatform.Architecture)
 	if err != nil {
 		return err
 	}
+	lockFile.Repositories = lockedRepos
+	yumKeeper, err := rpm.NewPackageKeeper(cmd.Context(), rpmRepos, cfg.Spec.Modules, fs.NewMemFS(), nil)
+	if err != nil {
+		return err
+	}
+
+	// repositories may ask for the packages needed to
+	// bootstrap a minimal system, which are locked in
+	// the same way as the packages that were requested
+	pkgs := cfg.Spec.Packages
+	if names := debianKeeper.Bootstrap(); len(names) > 0 {
+		log.Info("bootstrapping minimal Debian system", "count", len(names))
+		pkgs = append([]aybv1.Package{{Type: aybv1.PackageDebian, Names: names}}, pkgs...)
+	}
+
+	// Alpine packages may exclude other packages (e.g. "!foo"),
+	// which applies to every package that is resolved
+	exclude := alpineExclusions(cfg.Spec.Packages)
 
 	// get package integrity
 	log.Info("generating package checksums")
-	for _, pkg := range cfg.Spec.Packages {
+	for _, pkg := range pkgs {
 		var keeper packages.PackageManager
+		opts := packages.ResolveOptions{InstallRecommends: pkg.InstallRecommends}
 		switch pkg.Type {
 		case aybv1.PackageAlpine:
 			keeper = alpineKeeper
+			opts.Exclude = exclude
 		case aybv1.PackageDebian:
 			keeper = debianKeeper
 		case aybv1.PackageRPM:
@@ -138,7 +182,7 @@ func lock(cmd *cobra.Command, _ []string) error {
 		}
 
 		for _, name := range pkg.Names {
-			packageList, err := keeper.Resolve(cmd.Context(), name, false)
+			packageList, err := keeper.Resolve(cmd.Context(), name, opts, false)
 			if err != nil {
 				return err
 			}
@@ -163,13 +207,26 @@ func lock(cmd *cobra.Command, _ []string) error {
 
 				p.Resolved = packageUrl
 				p.Integrity = "sha256:" + checksum
-				lockFile.Packages[p.Name] = p
+				if err := lockFile.Add(p); err != nil {
+					return err
+				}
 				log.V(4).Info("downloaded package", "name", p.Name, "resolved", p.Resolved, "checksum", p.Integrity)
 			}
 		}
 
 	}
 
+	// each name is resolved on its own, so check that the
+	// packages can be installed together
+	rpms, err := yumKeeper.Select(cmd.Context(), lockedOfType(lockFile.Packages, aybv1.PackageRPM))
+	if err != nil {
+		return fmt.Errorf("checking rpm packages: %w", err)
+	}
+	replaceLocked(lockFile.Packages, aybv1.PackageRPM, rpms)
+	if err := debianKeeper.Check(cmd.Context(), lockedOfType(lockFile.Packages, aybv1.PackageDebian)); err != nil {
+		return fmt.Errorf("checking debian packages: %w", err)
+	}
+
 	// get file integrity
 	log.Info("generating file checksums")
 	for _, file := range cfg.Spec.Files {
@@ -233,3 +290,26 @@ func lock(cmd *cobra.Command, _ []string) error {
 	enc.SetIndent("", "\t")
 	return enc.Encode(lockFile)
 }
+
+// lockedOfType returns the locked packages of the given
+// type, ordered by their name.
+func lockedOfType(pkgs map[string]lockfile.Package, t aybv1.PackageType) []lockfile.Package {
+	var out []lockfile.Package
+	for _, k := range slices.Sorted(maps.Keys(pkgs)) {
+		if pkgs[k].Type == t {
+			out = append(out, pkgs[k])
+		}
+	}
+	return out
+}
+
+// replaceLocked replaces the locked packages of the
+// given type.
+func replaceLocked(pkgs map[string]lockfile.Package, t aybv1.PackageType, replacement []lockfile.Package) {
+	maps.DeleteFunc(pkgs, func(_ string, p lockfile.Package) bool {
+		return p.Type == t
+	})
+	for _, p := range replacement {
+		pkgs[p.Name] = p
+	}
+}
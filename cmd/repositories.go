@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Snakdy/container-build-engine/pkg/containers"
+	"github.com/djcass44/all-your-base/pkg/airutil"
+	aybv1 "github.com/djcass44/all-your-base/pkg/api/v1"
+	"github.com/djcass44/all-your-base/pkg/archiveutil"
+	debianindex "github.com/djcass44/all-your-base/pkg/debian"
+	"github.com/djcass44/all-your-base/pkg/lockfile"
+	"github.com/djcass44/all-your-base/pkg/packages/debian"
+	"github.com/djcass44/all-your-base/pkg/yum"
+	"github.com/djcass44/all-your-base/pkg/yum/yumrepo"
+	"github.com/go-logr/logr"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// prefixImage indicates that a file should be read
+// from the base image rather than the workspace.
+const prefixImage = "image:"
+
+// fileReader reads files from the workspace, or from the base
+// image if the path is prefixed with 'image:'. The base image
+// is only pulled if it's needed.
+type fileReader struct {
+	from string
+	img  v1.Image
+}
+
+func (r *fileReader) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	if !strings.HasPrefix(path, prefixImage) {
+		return os.ReadFile(path)
+	}
+	if r.img == nil {
+		img, err := containers.GetImage(ctx, airutil.ExpandEnv(r.from))
+		if err != nil {
+			return nil, err
+		}
+		r.img = img
+	}
+	return archiveutil.ReadFile(ctx, mutate.Extract(r.img), strings.TrimPrefix(path, prefixImage))
+}
+
+// resolveRPMRepositories converts the RPM repositories into a list of
+// baseurls. Repositories read from a .repo file have their mirrors
+// resolved for the given Go architecture, and are returned so that
+// they can be locked.
+func resolveRPMRepositories(ctx context.Context, repos []aybv1.Repository, from, goarch string) ([]string, []lockfile.Repository, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	files := &fileReader{from: from}
+	readFile := func(path string) ([]byte, error) {
+		return files.ReadFile(ctx, path)
+	}
+
+	var urls []string
+	var locked []lockfile.Repository
+	for _, r := range repos {
+		if r.File == "" {
+			urls = append(urls, airutil.ExpandEnv(r.URL))
+			continue
+		}
+		path := airutil.ExpandEnv(r.File)
+		log.V(1).Info("reading repository file", "path", path)
+		data, err := readFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading repository file '%s': %w", path, err)
+		}
+
+		vars := map[string]string{
+			"basearch": yumrepo.BaseArch(goarch),
+			"arch":     yumrepo.BaseArch(goarch),
+		}
+		// try to figure out the release from the base image
+		if _, ok := r.Vars["releasever"]; !ok && from != containers.MagicImageScratch {
+			if osRelease, err := readFile(prefixImage + "/etc/os-release"); err == nil {
+				vars["releasever"] = releaseVersion(osRelease)
+			} else {
+				log.V(1).Info("unable to detect releasever from base image", "err", err.Error())
+			}
+		}
+		for k, v := range r.Vars {
+			vars[k] = v
+		}
+
+		repoFile, err := yumrepo.ParseRepoFile(bytes.NewReader(data), vars)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing repository file '%s': %w", path, err)
+		}
+		for _, rf := range repoFile {
+			if !rf.Enabled {
+				log.V(2).Info("skipping disabled repository", "repo", rf.ID)
+				continue
+			}
+			if slices.ContainsFunc(locked, func(l lockfile.Repository) bool {
+				return l.ID == rf.ID
+			}) {
+				return nil, nil, fmt.Errorf("duplicate repository id: %s", rf.ID)
+			}
+			baseURL, err := yum.ResolveBaseURL(ctx, rf)
+			if err != nil {
+				return nil, nil, err
+			}
+			locked = append(locked, lockfile.Repository{ID: rf.ID, File: r.File, BaseURL: baseURL})
+			urls = append(urls, baseURL)
+		}
+	}
+	return urls, locked, nil
+}
+
+// lockedRPMRepositories returns the RPM repository baseurls using
+// the values resolved when the lockfile was generated, in the same
+// order. The mirrors aren't checked again, so the build trusts
+// whichever mirror was chosen when the lockfile was generated.
+func lockedRPMRepositories(repos []aybv1.Repository, lock *lockfile.Lock) ([]string, error) {
+	hasFile := slices.ContainsFunc(repos, func(r aybv1.Repository) bool {
+		return r.File != ""
+	})
+	if hasFile && len(lock.Repositories) == 0 {
+		return nil, fmt.Errorf("repository files have not been locked - regenerate the lockfile")
+	}
+	var urls []string
+	for _, r := range repos {
+		if r.File == "" {
+			urls = append(urls, airutil.ExpandEnv(r.URL))
+			continue
+		}
+		for _, l := range lock.Repositories {
+			if l.File == r.File {
+				urls = append(urls, l.BaseURL)
+			}
+		}
+	}
+	return urls, nil
+}
+
+// debianRepositories converts the configured Debian repositories
+// into the form expected by the keeper. Repositories can be given
+// as a 'base release component...' string, in a structured form, or
+// imported from a sources.list or deb822 .sources file.
+func debianRepositories(ctx context.Context, repos []aybv1.Repository, files *fileReader) ([]debian.Repository, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	var out []debian.Repository
+	for _, r := range repos {
+		var sources []debianindex.Source
+		// keyrings are read from the same
+		// place as the repository file
+		var prefix string
+		switch {
+		case r.File != "":
+			path := airutil.ExpandEnv(r.File)
+			log.V(1).Info("reading repository file", "path", path)
+			data, err := files.ReadFile(ctx, path)
+			if err != nil {
+				return nil, fmt.Errorf("reading repository file '%s': %w", path, err)
+			}
+			if strings.HasSuffix(path, ".sources") {
+				sources, err = debianindex.ParseSources(bytes.NewReader(data))
+			} else {
+				sources, err = debianindex.ParseSourcesList(bytes.NewReader(data))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("parsing repository file '%s': %w", path, err)
+			}
+			if strings.HasPrefix(path, prefixImage) {
+				prefix = prefixImage
+			}
+		case len(r.Suites) > 0:
+			src := debianindex.Source{
+				URIs:          []string{airutil.ExpandEnv(r.URL)},
+				Suites:        r.Suites,
+				Components:    r.Components,
+				Architectures: r.Architectures,
+			}
+			if err := src.Validate(); err != nil {
+				return nil, err
+			}
+			sources = append(sources, src)
+		default:
+			var err error
+			sources, err = debianindex.ParseSourcesList(strings.NewReader("deb " + airutil.ExpandEnv(r.URL)))
+			if err != nil {
+				return nil, fmt.Errorf("malformed repository url, expecting: 'base release component...': %w", err)
+			}
+		}
+		for _, src := range sources {
+			signedBy := src.SignedBy
+			if r.SignedBy != "" {
+				signedBy = r.SignedBy
+			}
+			keyring, err := readKeyring(ctx, files, signedBy, prefix)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, debian.Repository{
+				Source:    src,
+				Keyring:   keyring,
+				Priority:  r.Priority,
+				Bootstrap: r.Bootstrap,
+			})
+		}
+	}
+	return out, nil
+}
+
+// readKeyring returns the keys referred to by a "Signed-By" option,
+// which is either an ASCII armored key or a comma-separated list of
+// paths. Relative paths are read using the prefix.
+func readKeyring(ctx context.Context, files *fileReader, signedBy, prefix string) ([]byte, error) {
+	if signedBy == "" {
+		return nil, nil
+	}
+	if strings.Contains(signedBy, "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+		return []byte(signedBy), nil
+	}
+	var out []byte
+	for _, path := range strings.Split(signedBy, ",") {
+		path = airutil.ExpandEnv(strings.TrimSpace(path))
+		if !strings.HasPrefix(path, prefixImage) {
+			path = prefix + path
+		}
+		data, err := files.ReadFile(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("reading keyring '%s': %w", path, err)
+		}
+		out = append(out, data...)
+	}
+	return out, nil
+}
+
+// releaseVersion returns the major version from an
+// os-release file, which is what yum uses as
+// $releasever.
+func releaseVersion(osRelease []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(osRelease))
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || k != "VERSION_ID" {
+			continue
+		}
+		v = strings.Trim(v, `"'`)
+		major, _, _ := strings.Cut(v, ".")
+		return major
+	}
+	return ""
+}
@@ -0,0 +1,30 @@
+package statements
+
+import (
+	cbev1 "github.com/Snakdy/container-build-engine/pkg/api/v1"
+	"github.com/Snakdy/container-build-engine/pkg/pipelines"
+	"github.com/djcass44/all-your-base/pkg/packages/alpine"
+)
+
+// BusyboxStatement installs the busybox applets once every
+// package has been unpacked, in the same way as the trigger
+// that apk would normally run.
+type BusyboxStatement struct{}
+
+func (*BusyboxStatement) Run(ctx *pipelines.BuildContext, _ ...cbev1.Options) (cbev1.Options, error) {
+	return cbev1.Options{}, alpine.InstallBusybox(ctx.Context, ctx.FS)
+}
+
+func (*BusyboxStatement) Name() string {
+	return StatementBusybox
+}
+
+func (*BusyboxStatement) MutatesConfig() bool {
+	return true
+}
+
+func (*BusyboxStatement) MutatesFS() bool {
+	return false
+}
+
+func (*BusyboxStatement) SetOptions(cbev1.Options) {}
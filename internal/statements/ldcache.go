@@ -0,0 +1,40 @@
+package statements
+
+import (
+	cbev1 "github.com/Snakdy/container-build-engine/pkg/api/v1"
+	"github.com/Snakdy/container-build-engine/pkg/pipelines"
+	"github.com/djcass44/all-your-base/pkg/ldconfig"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// LdCacheStatement generates /etc/ld.so.cache once every
+// package has been unpacked, in the same way as running
+// ldconfig at the end of an install.
+type LdCacheStatement struct {
+	base v1.Image
+}
+
+// NewLdCacheStatement creates a LdCacheStatement. The base image
+// is read so that its libraries remain in the cache, and may be
+// nil when building from scratch.
+func NewLdCacheStatement(base v1.Image) *LdCacheStatement {
+	return &LdCacheStatement{base: base}
+}
+
+func (s *LdCacheStatement) Run(ctx *pipelines.BuildContext, _ ...cbev1.Options) (cbev1.Options, error) {
+	return cbev1.Options{}, ldconfig.UpdateCache(ctx.Context, ctx.FS, s.base)
+}
+
+func (*LdCacheStatement) Name() string {
+	return StatementLdCache
+}
+
+func (*LdCacheStatement) MutatesConfig() bool {
+	return true
+}
+
+func (*LdCacheStatement) MutatesFS() bool {
+	return false
+}
+
+func (*LdCacheStatement) SetOptions(cbev1.Options) {}
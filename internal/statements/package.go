@@ -51,6 +51,10 @@ func (s *PackageStatement) Run(ctx *pipelines.BuildContext, _ ...cbev1.Options)
 		return cbev1.Options{}, err
 	}
 	record, _ := cbev1.GetOptional[bool](s.options, "record")
+	installRecommends, _ := cbev1.GetOptional[bool](s.options, "install-recommends")
+	constraint, _ := cbev1.GetOptional[string](s.options, "constraint")
+	exclude, _ := cbev1.GetOptional[[]string](s.options, "exclude")
+	direct, _ := cbev1.GetOptional[bool](s.options, "direct")
 
 	var keeper packages.PackageManager
 	switch aybv1.PackageType(packageType) {
@@ -95,7 +99,12 @@ func (s *PackageStatement) Run(ctx *pipelines.BuildContext, _ ...cbev1.Options)
 
 	if record {
 		log.V(1).Info("recording package", "name", name, "version", version)
-		if _, err := keeper.Resolve(ctx.Context, name, true); err != nil {
+		// resolve the package in the same way that it was locked
+		resolveName := name
+		if constraint != "" {
+			resolveName = constraint
+		}
+		if _, err := keeper.Resolve(ctx.Context, resolveName, packages.ResolveOptions{InstallRecommends: installRecommends, Exclude: exclude, Direct: direct}, true); err != nil {
 			log.Error(err, "failed to resolve package", "name", name, "version", version)
 			return cbev1.Options{}, fmt.Errorf("resolving package details: %w", err)
 		}
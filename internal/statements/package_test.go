@@ -8,7 +8,9 @@ import (
 	"github.com/Snakdy/container-build-engine/pkg/containers"
 	"github.com/Snakdy/container-build-engine/pkg/pipelines"
 	"github.com/Snakdy/container-build-engine/pkg/vfs"
+	debianindex "github.com/djcass44/all-your-base/pkg/debian"
 	"github.com/djcass44/all-your-base/pkg/downloader"
+	"github.com/djcass44/all-your-base/pkg/packages"
 	"github.com/djcass44/all-your-base/pkg/packages/debian"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/logr/testr"
@@ -24,10 +26,10 @@ func TestPackageStatement_Run(t *testing.T) {
 	baseImage, err := containers.GetImage(ctx, "harbor.dcas.dev/docker.io/library/alpine:3.23")
 	require.NoError(t, err)
 
-	pkg, err := debian.NewPackageKeeper(ctx, []string{"https://mirror.aarnet.edu.au/pub/debian bullseye main"}, rootfs, baseImage)
+	pkg, err := debian.NewPackageKeeper(ctx, []debian.Repository{{Source: debianindex.Source{URIs: []string{"https://mirror.aarnet.edu.au/pub/debian"}, Suites: []string{"bullseye"}, Components: []string{"main"}}}}, "amd64", rootfs, baseImage)
 	require.NoError(t, err)
 
-	packageNames, err := pkg.Resolve(ctx, "openjdk-17-jdk", false)
+	packageNames, err := pkg.Resolve(ctx, "openjdk-17-jdk", packages.ResolveOptions{}, false)
 	require.NoError(t, err)
 
 	dl, err := downloader.NewDownloader(t.TempDir())
@@ -0,0 +1,58 @@
+package statements
+
+import (
+	cbev1 "github.com/Snakdy/container-build-engine/pkg/api/v1"
+	"github.com/Snakdy/container-build-engine/pkg/pipelines"
+	"github.com/djcass44/all-your-base/pkg/triggers"
+	"github.com/go-logr/logr"
+)
+
+// TriggerStatement reports the install scripts of every package,
+// once they've all been unpacked, and emulates the effects of the
+// commands that the emulator has a handler for.
+type TriggerStatement struct {
+	emulator *triggers.Emulator
+	sources  []triggers.Source
+}
+
+func NewTriggerStatement(emulator *triggers.Emulator, sources ...triggers.Source) *TriggerStatement {
+	return &TriggerStatement{
+		emulator: emulator,
+		sources:  sources,
+	}
+}
+
+func (s *TriggerStatement) Run(ctx *pipelines.BuildContext, _ ...cbev1.Options) (cbev1.Options, error) {
+	log := logr.FromContextOrDiscard(ctx.Context)
+
+	var scripts []triggers.Script
+	for _, source := range s.sources {
+		scripts = append(scripts, source.Scripts()...)
+	}
+	report, err := s.emulator.Run(ctx.Context, scripts, ctx.FS)
+	if err != nil {
+		return cbev1.Options{}, err
+	}
+
+	// we can't run install scripts, so make sure that the
+	// user knows about anything that couldn't be emulated
+	for _, script := range report.Unhandled {
+		log.Info("install script was not run and may need manual configuration", "name", script.Script.Package, "script", script.Script.Name, "commands", script.Commands)
+	}
+	log.Info("read install scripts", "handled", len(report.Handled), "unhandled", len(report.Unhandled))
+	return cbev1.Options{}, nil
+}
+
+func (*TriggerStatement) Name() string {
+	return StatementTriggers
+}
+
+func (*TriggerStatement) MutatesConfig() bool {
+	return true
+}
+
+func (*TriggerStatement) MutatesFS() bool {
+	return false
+}
+
+func (*TriggerStatement) SetOptions(cbev1.Options) {}
@@ -9,8 +9,12 @@ import (
 )
 
 const (
-	StatementPackage = "package"
-	StatementEnv     = "set-env"
+	StatementPackage  = "package"
+	StatementEnv      = "set-env"
+	StatementBusybox  = "busybox"
+	StatementTriggers = "triggers"
+	StatementLdCache  = "ld-cache"
+	StatementUsers    = "users"
 )
 
 type PackageStatement struct {
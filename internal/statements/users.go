@@ -0,0 +1,128 @@
+package statements
+
+import (
+	"fmt"
+
+	cbev1 "github.com/Snakdy/container-build-engine/pkg/api/v1"
+	"github.com/Snakdy/container-build-engine/pkg/pipelines"
+	aybv1 "github.com/djcass44/all-your-base/pkg/api/v1"
+	"github.com/djcass44/all-your-base/pkg/users"
+	"github.com/go-logr/logr"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// UsersStatement adds the users and groups declared in the build
+// spec and, if enabled, by the sysusers.d configuration of installed
+// packages. Declared users are added first so that the ids they ask
+// for take priority over the ones that are generated.
+type UsersStatement struct {
+	base     v1.Image
+	users    []aybv1.SystemUser
+	groups   []aybv1.SystemGroup
+	sysusers bool
+	// username and uid are the non-root user that
+	// the builder creates once the pipeline finishes
+	username string
+	uid      int
+}
+
+func NewUsersStatement(base v1.Image, users []aybv1.SystemUser, groups []aybv1.SystemGroup, sysusers bool, username string, uid int) *UsersStatement {
+	return &UsersStatement{
+		base:     base,
+		users:    users,
+		groups:   groups,
+		sysusers: sysusers,
+		username: username,
+		uid:      uid,
+	}
+}
+
+func (s *UsersStatement) Run(ctx *pipelines.BuildContext, _ ...cbev1.Options) (cbev1.Options, error) {
+	log := logr.FromContextOrDiscard(ctx.Context)
+
+	var entries []users.SysusersEntry
+	if s.sysusers {
+		var err error
+		entries, err = users.ReadSysusers(ctx.Context, ctx.FS)
+		if err != nil {
+			return cbev1.Options{}, err
+		}
+	}
+	if len(entries) == 0 && len(s.users) == 0 && len(s.groups) == 0 {
+		log.V(4).Info("skipping users as none have been declared")
+		return cbev1.Options{}, nil
+	}
+
+	db, err := users.Read(ctx.Context, ctx.FS, s.base)
+	if err != nil {
+		return cbev1.Options{}, err
+	}
+	db.Reserve(s.uid)
+
+	for _, g := range s.groups {
+		if _, err := db.AddGroup(users.Group{Name: g.Name, Gid: requestedID(g.Gid)}); err != nil {
+			return cbev1.Options{}, err
+		}
+		for _, member := range g.Members {
+			if err := db.AddMember(g.Name, member); err != nil {
+				return cbev1.Options{}, err
+			}
+		}
+	}
+	for _, u := range s.users {
+		if u.Name == s.username {
+			return cbev1.Options{}, fmt.Errorf("user '%s' conflicts with the non-root user", u.Name)
+		}
+		gid := -1
+		if u.Group != "" {
+			g, err := db.AddGroup(users.Group{Name: u.Group, Gid: -1})
+			if err != nil {
+				return cbev1.Options{}, err
+			}
+			gid = g.Gid
+		}
+		user, err := db.AddUser(users.User{Name: u.Name, Uid: requestedID(u.Uid), Gid: gid, Home: u.Home, Shell: u.Shell})
+		if err != nil {
+			return cbev1.Options{}, err
+		}
+		if u.Uid > 0 && user.Uid != u.Uid {
+			log.Info("user already exists with a different uid", "name", u.Name, "uid", user.Uid, "requested", u.Uid)
+		}
+		for _, group := range u.Groups {
+			if _, err := db.AddGroup(users.Group{Name: group, Gid: -1}); err != nil {
+				return cbev1.Options{}, err
+			}
+			if err := db.AddMember(group, u.Name); err != nil {
+				return cbev1.Options{}, err
+			}
+		}
+	}
+	if err := db.AddSysusers(ctx.Context, entries); err != nil {
+		return cbev1.Options{}, err
+	}
+	log.Info("added users", "users", len(s.users), "groups", len(s.groups), "sysusers", len(entries))
+	return cbev1.Options{}, db.Write(ctx.Context, ctx.FS)
+}
+
+// requestedID converts an id from the build spec, where
+// zero (unset) means that a free id should be chosen.
+func requestedID(id int) int {
+	if id <= 0 {
+		return -1
+	}
+	return id
+}
+
+func (*UsersStatement) Name() string {
+	return StatementUsers
+}
+
+func (*UsersStatement) MutatesConfig() bool {
+	return true
+}
+
+func (*UsersStatement) MutatesFS() bool {
+	return false
+}
+
+func (*UsersStatement) SetOptions(cbev1.Options) {}
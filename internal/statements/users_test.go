@@ -0,0 +1,68 @@
+package statements
+
+import (
+	"context"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/Snakdy/container-build-engine/pkg/pipelines"
+	aybv1 "github.com/djcass44/all-your-base/pkg/api/v1"
+	"github.com/djcass44/all-your-base/pkg/users"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsersStatement_Run(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	rootfs := fs.NewMemFS()
+	require.NoError(t, rootfs.MkdirAll("/etc", 0755))
+	require.NoError(t, rootfs.MkdirAll("/usr/lib/sysusers.d", 0755))
+	require.NoError(t, rootfs.WriteFile(users.PasswdFile, []byte("root:x:0:0:root:/root:/bin/sh\n"), 0644))
+	require.NoError(t, rootfs.WriteFile(users.GroupFile, []byte("root:x:0:root\n"), 0644))
+	require.NoError(t, rootfs.WriteFile("/usr/lib/sysusers.d/nginx.conf", []byte("u nginx - \"nginx web server\" /var/lib/nginx\nm nginx www-data\n"), 0644))
+
+	bctx := &pipelines.BuildContext{
+		Context:          ctx,
+		WorkingDirectory: t.TempDir(),
+		FS:               rootfs,
+	}
+
+	statement := NewUsersStatement(nil, []aybv1.SystemUser{
+		{Name: "app", Uid: 500, Groups: []string{"www-data"}},
+		{Name: "worker", Group: "app"},
+	}, []aybv1.SystemGroup{
+		{Name: "www-data", Gid: 33},
+	}, true, "somebody", 101)
+	_, err := statement.Run(bctx)
+	require.NoError(t, err)
+
+	for path, expected := range map[string]string{
+		users.PasswdFile: "root:x:0:0:root:/root:/bin/sh\napp:x:500:500::/nonexistent:/usr/sbin/nologin\nworker:x:100:500::/nonexistent:/usr/sbin/nologin\nnginx:x:102:102:nginx web server:/var/lib/nginx:/usr/sbin/nologin\n",
+		users.GroupFile:  "root:x:0:root\nwww-data:x:33:app,nginx\napp:x:500:\nnginx:x:102:\n",
+	} {
+		data, err := rootfs.ReadFile(path)
+		require.NoError(t, err)
+		assert.EqualValues(t, expected, string(data), path)
+	}
+
+	t.Run("non-root user", func(t *testing.T) {
+		statement := NewUsersStatement(nil, []aybv1.SystemUser{{Name: "somebody"}}, nil, false, "somebody", 1001)
+		_, err := statement.Run(bctx)
+		assert.Error(t, err)
+	})
+	t.Run("sysusers disabled", func(t *testing.T) {
+		rootfs := fs.NewMemFS()
+		require.NoError(t, rootfs.MkdirAll("/usr/lib/sysusers.d", 0755))
+		require.NoError(t, rootfs.WriteFile("/usr/lib/sysusers.d/nginx.conf", []byte("u nginx -\n"), 0644))
+
+		statement := NewUsersStatement(nil, nil, nil, false, "somebody", 1001)
+		_, err := statement.Run(&pipelines.BuildContext{Context: ctx, WorkingDirectory: t.TempDir(), FS: rootfs})
+		require.NoError(t, err)
+
+		_, err = rootfs.Stat(users.PasswdFile)
+		assert.Error(t, err)
+	})
+}
@@ -19,10 +19,13 @@ type BuildSpec struct {
 	Command      []string                `json:"command,omitempty"`
 	Packages     []Package               `json:"packages,omitempty"`
 	Repositories map[string][]Repository `json:"repositories,omitempty"`
+	Modules      []string                `json:"modules,omitempty"`
 	Files        []File                  `json:"files,omitempty"`
 	Links        []Link                  `json:"links,omitempty"`
 	Env          []EnvVar                `json:"env,omitempty"`
 	User         User                    `json:"user,omitempty"`
+	Users        []SystemUser            `json:"users,omitempty"`
+	Groups       []SystemGroup           `json:"groups,omitempty"`
 	DirFS        bool                    `json:"dirFS,omitempty"`
 }
 
@@ -32,13 +35,70 @@ type User struct {
 	Shell    string `json:"shell,omitempty"`
 }
 
+// SystemUser is an additional user (e.g. for a daemon)
+// that's added alongside the non-root User.
+type SystemUser struct {
+	Name string `json:"name"`
+	// Uid is the id of the user. A free id
+	// is chosen when it isn't set.
+	Uid int `json:"uid,omitempty"`
+	// Group is the primary group of the user. A group with
+	// the same name as the user is created when it isn't set.
+	Group string `json:"group,omitempty"`
+	// Groups are the supplementary groups of the user.
+	Groups []string `json:"groups,omitempty"`
+	Home   string   `json:"home,omitempty"`
+	Shell  string   `json:"shell,omitempty"`
+}
+
+// SystemGroup is an additional group.
+type SystemGroup struct {
+	Name string `json:"name"`
+	// Gid is the id of the group. A free id
+	// is chosen when it isn't set.
+	Gid     int      `json:"gid,omitempty"`
+	Members []string `json:"members,omitempty"`
+}
+
 type Repository struct {
-	URL string `json:"url"`
+	URL string `json:"url,omitempty"`
+	// File is the path to a yum .repo file, or a Debian
+	// sources.list or deb822 .sources file. Paths prefixed
+	// with 'image:' are read from the base image rather than
+	// the workspace.
+	File string `json:"file,omitempty"`
+	// Vars are substituted into the .repo file (e.g. $releasever).
+	Vars map[string]string `json:"vars,omitempty"`
+	// Priority is the pinning priority of a Debian repository.
+	// Packages from repositories with a higher priority are
+	// preferred, even if a newer version is available elsewhere.
+	// Repositories with a negative priority are never used.
+	Priority int `json:"priority,omitempty"`
+	// Suites, Components and Architectures describe a structured
+	// Debian repository, in which case URL is the base URL of
+	// the repository. A suite ending in '/' (e.g. "./") refers
+	// to a flat repository, which has no components.
+	Suites        []string `json:"suites,omitempty"`
+	Components    []string `json:"components,omitempty"`
+	Architectures []string `json:"architectures,omitempty"`
+	// SignedBy is the keyring used to verify a Debian repository.
+	// It's either an ASCII armored public key, or the path to a
+	// keyring. Paths prefixed with 'image:' are read from the base
+	// image rather than the workspace.
+	SignedBy string `json:"signedBy,omitempty"`
+	// Bootstrap installs the packages from a Debian repository
+	// that are needed to build a minimal system from scratch.
+	// The only supported value is "minbase", which selects
+	// every "Essential" and "required" package.
+	Bootstrap string `json:"bootstrap,omitempty"`
 }
 
 type Package struct {
 	Type  PackageType `json:"type"`
 	Names []string    `json:"names"`
+	// InstallRecommends includes weak dependencies
+	// (e.g. Recommends) when resolving the packages.
+	InstallRecommends bool `json:"installRecommends,omitempty"`
 }
 
 type File struct {
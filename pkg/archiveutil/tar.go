@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -51,6 +52,7 @@ func UntarFile(ctx context.Context, r io.Reader, filename string, rootfs fs.Full
 	log.V(3).Info("searching tarball for file")
 	tr := tar.NewReader(r)
 
+	filename = filepath.Clean("/" + filename)
 	for {
 		header, err := tr.Next()
 		switch {
@@ -65,8 +67,8 @@ func UntarFile(ctx context.Context, r io.Reader, filename string, rootfs fs.Full
 
 		target := filepath.Clean("/" + header.Name)
 
-		if header.Typeflag != tar.TypeReg || header.Name != filename {
-			return nil
+		if header.Typeflag != tar.TypeReg || target != filename {
+			continue
 		}
 		log.V(5).Info("creating file", "target", target, "mode", header.Mode)
 		f, err := rootfs.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
@@ -87,8 +89,43 @@ func UntarFile(ctx context.Context, r io.Reader, filename string, rootfs fs.Full
 	}
 }
 
+// ReadFile returns the contents of a single file from
+// a given archive. If the file cannot be found, an error
+// wrapping os.ErrNotExist is returned.
+func ReadFile(ctx context.Context, r io.Reader, filename string) ([]byte, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("filename", filename)
+	log.V(3).Info("searching tarball for file")
+	tr := tar.NewReader(r)
+
+	filename = filepath.Clean("/" + filename)
+	for {
+		header, err := tr.Next()
+		switch {
+		case err == io.EOF:
+			return nil, fmt.Errorf("%w: %s", os.ErrNotExist, filename)
+		case err != nil:
+			log.Error(err, "failed to read file from archive")
+			return nil, err
+		case header == nil:
+			continue
+		}
+		if header.Typeflag != tar.TypeReg || filepath.Clean("/"+header.Name) != filename {
+			continue
+		}
+		log.V(5).Info("reading file", "size", header.Size)
+		return io.ReadAll(tr)
+	}
+}
+
 // Untar expands a tar archive into the given path.
 func Untar(ctx context.Context, r io.Reader, rootfs fs.FullFS) error {
+	return UntarFilter(ctx, r, rootfs, nil)
+}
+
+// UntarFilter is the same as Untar, but only extracts the entries
+// that the filter returns true for. Directories are always created.
+// A nil filter extracts everything.
+func UntarFilter(ctx context.Context, r io.Reader, rootfs fs.FullFS, filter func(target string, header *tar.Header) bool) error {
 	log := logr.FromContextOrDiscard(ctx)
 	tr := tar.NewReader(r)
 
@@ -106,6 +143,11 @@ func Untar(ctx context.Context, r io.Reader, rootfs fs.FullFS) error {
 
 		target := filepath.Clean("/" + header.Name)
 
+		if filter != nil && header.Typeflag != tar.TypeDir && !filter(target, header) {
+			log.V(5).Info("skipping filtered file", "target", target)
+			continue
+		}
+
 		switch header.Typeflag {
 		default:
 			log.V(4).Info("unexpected header type", "target", target, "type", header.Typeflag)
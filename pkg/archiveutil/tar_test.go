@@ -1,6 +1,8 @@
 package archiveutil
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"os"
 	"testing"
@@ -45,3 +47,31 @@ func TestUntarFile(t *testing.T) {
 	_, err = rootfs.Stat("test.txt")
 	assert.NotErrorIs(t, err, os.ErrNotExist)
 }
+
+func TestReadFile(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"etc/os-release":                "ID=fedora\n",
+		"./etc/yum.repos.d/fedora.repo": "[fedora]\n",
+	} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	data, err := ReadFile(ctx, bytes.NewReader(buf.Bytes()), "/etc/yum.repos.d/fedora.repo")
+	require.NoError(t, err)
+	assert.EqualValues(t, "[fedora]\n", string(data))
+
+	_, err = ReadFile(ctx, bytes.NewReader(buf.Bytes()), "/etc/missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -1,57 +1,145 @@
 package debian
 
 import (
-	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
-	"slices"
+	"strings"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/djcass44/all-your-base/pkg/requestutil"
 	"github.com/go-logr/logr"
 	version "github.com/knqyf263/go-deb-version"
-	"github.com/ulikunitz/xz"
 	"pault.ag/go/debian/control"
 )
 
 const (
-	PackageFileGzip = "Packages.gz"
-	PackageFileXZ   = "Packages.xz"
+	PackageFileGzip  = "Packages.gz"
+	PackageFileXZ    = "Packages.xz"
+	PackageFileBzip2 = "Packages.bz2"
+	PackageFile      = "Packages"
 )
 
+// packageFiles is the order in which we try to
+// download the package index.
+var packageFiles = []string{
+	PackageFileGzip,
+	PackageFileXZ,
+	PackageFileBzip2,
+	PackageFile,
+}
+
 var ErrNotFound = errors.New("package file not found")
 
+// NewIndex downloads the package index for the given architecture.
+// If the Release file lists the "all" architecture, architecture
+// independent packages are included as well.
 func NewIndex(ctx context.Context, repository, release, component, arch string) (*Index, error) {
-	// try to download the gzip repository
-	index, err := downloadIndex(ctx, repository, release, component, arch, PackageFileGzip, func(r io.Reader) (io.ReadCloser, error) {
-		return gzip.NewReader(r)
-	})
-	if err == nil {
+	return NewVerifiedIndex(ctx, repository, release, component, arch, nil)
+}
+
+// NewVerifiedIndex is the same as NewIndex, however if a keyring is
+// provided the InRelease file must be signed by one of its keys and
+// the package index must match the checksum that it lists.
+func NewVerifiedIndex(ctx context.Context, repository, release, component, arch string, keyring openpgp.EntityList) (*Index, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	dir := fmt.Sprintf("%s/dists/%s", repository, release)
+	rel, err := GetRelease(ctx, dir, keyring)
+	if err != nil {
+		// the release file is only required if
+		// we need to verify the repository
+		if keyring != nil {
+			return nil, err
+		}
+		log.V(1).Info("unable to read release file, architecture independent packages won't be fetched separately", "err", err.Error())
+	}
+	verified := rel
+	if keyring == nil {
+		verified = nil
+	}
+
+	index, err := newDirIndex(ctx, repository, dir, fmt.Sprintf("%s/binary-%s/", component, arch), verified)
+	if err != nil {
+		return nil, err
+	}
+	if arch == ArchAll || rel == nil || !rel.HasSeparateArchAll() {
 		return index, nil
 	}
-	if !errors.Is(err, ErrNotFound) {
+	all, err := newDirIndex(ctx, repository, dir, fmt.Sprintf("%s/binary-%s/", component, ArchAll), verified)
+	if err != nil {
+		// not every component has
+		// architecture independent packages
+		if errors.Is(err, ErrNotFound) {
+			return index, nil
+		}
 		return nil, err
 	}
+	index.packages = append(index.packages, all.packages...)
+	return index, nil
+}
+
+// NewFlatIndex downloads the package index of a flat repository,
+// where the directory is relative to the repository (e.g. "./").
+// Flat repositories don't have suites, components or architectures.
+//
+// https://wiki.debian.org/DebianRepository/Format#Flat_Repository_Format
+func NewFlatIndex(ctx context.Context, repository, directory string, keyring openpgp.EntityList) (*Index, error) {
+	dir := strings.TrimSuffix(repository, "/") + "/" + strings.TrimSuffix(strings.TrimPrefix(directory, "./"), "/")
+	dir = strings.TrimSuffix(dir, "/")
 
-	// try to download the xz repository
-	return downloadIndex(ctx, repository, release, component, arch, PackageFileXZ, func(r io.Reader) (io.ReadCloser, error) {
-		reader, err := xz.NewReader(r)
+	var rel *Release
+	if keyring != nil {
+		var err error
+		rel, err = GetRelease(ctx, dir, keyring)
 		if err != nil {
 			return nil, err
 		}
-		return io.NopCloser(reader), nil
-	})
+	}
+	return newDirIndex(ctx, repository, dir, "", rel)
 }
 
-func downloadIndex(ctx context.Context, repository, release, component, arch, filename string, reader func(r io.Reader) (io.ReadCloser, error)) (*Index, error) {
-	log := logr.FromContextOrDiscard(ctx).WithValues("repo", repository, "release", release, "component", component, "arch", arch, "filename", filename)
+// newDirIndex downloads the package index found in the path relative
+// to the release directory. If the release is set, the index must
+// match the checksum it contains.
+func newDirIndex(ctx context.Context, source, dir, path string, rel *Release) (*Index, error) {
+	for _, filename := range packageFiles {
+		index, err := downloadIndex(ctx, source, dir, path+filename, rel)
+		if err == nil {
+			return index, nil
+		}
+		// if the file doesn't exist, try the next one
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s/%s", ErrNotFound, dir, path)
+}
+
+func downloadIndex(ctx context.Context, source, dir, path string, rel *Release) (*Index, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("repo", source, "dir", dir, "path", path)
 	log.V(1).Info("downloading index")
 
-	target := fmt.Sprintf("%s/dists/%s/%s/binary-%s/%s", repository, release, component, arch, filename)
-	f, err := os.CreateTemp("", fmt.Sprintf("Packages-*%s", filepath.Ext(filename)))
+	var expected string
+	if rel != nil {
+		var ok bool
+		expected, ok = rel.Checksum(path)
+		// if the release doesn't list the file,
+		// then we can't trust it
+		if !ok {
+			log.V(1).Info("skipping index as it isn't listed in the release file")
+			return nil, ErrNotFound
+		}
+	}
+
+	target := dir + "/" + path
+	f, err := os.CreateTemp("", fmt.Sprintf("Packages-*%s", filepath.Ext(path)))
 	if err != nil {
 		return nil, err
 	}
@@ -75,17 +163,34 @@ func downloadIndex(ctx context.Context, repository, release, component, arch, fi
 		return nil, fmt.Errorf("http response failed with code: %d", resp.StatusCode)
 	}
 	log.V(1).Info("successfully downloaded index", "code", resp.StatusCode)
-	gr, err := reader(resp.Body)
+	// hash the file as it was downloaded, since
+	// that's what the release file describes
+	h := sha256.New()
+	body := io.TeeReader(resp.Body, h)
+	// detect the compression from the content rather than the
+	// filename as some mirrors transparently decompress files
+	gr, compression, err := requestutil.Decompress(body)
 	if err != nil {
 		return nil, err
 	}
 	defer gr.Close()
+	log.V(4).Info("detected index compression", "compression", compression)
 	if _, err := io.Copy(f, gr); err != nil {
 		return nil, err
 	}
 	_ = f.Close()
+	if expected != "" {
+		// make sure we've hashed everything
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return nil, err
+		}
+		if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+			return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", target, expected, actual)
+		}
+		log.V(2).Info("verified index checksum", "sha256", expected)
+	}
 
-	return newIndex(ctx, repository, f.Name())
+	return newIndex(ctx, source, f.Name())
 }
 
 func newIndex(ctx context.Context, source, path string) (*Index, error) {
@@ -118,38 +223,32 @@ func (idx *Index) Source() string {
 	return idx.source
 }
 
-func (idx *Index) GetPackageWithDependencies(ctx context.Context, existing map[string]Package, pv *PackageVersion) ([]Package, error) {
-	log := logr.FromContextOrDiscard(ctx)
+// Required returns the names of the packages that are needed to
+// bootstrap a minimal system, which are those that are "Essential"
+// or have the "required" priority.
+func (idx *Index) Required() []string {
+	var out []string
 	for _, p := range idx.packages {
-		if slices.Contains(pv.Names, p.Package) && pv.Matches(p.Version) {
-			log.V(5).Info("found package match", "name", p.Package, "version", p.Version, "deps", len(p.Depends))
-			// skip duplicate packages
-			if _, ok := existing[p.String()]; ok {
-				log.V(4).Info("skipping package as we already have it", "name", p.Package, "version", p.Version)
-				continue
-			}
-			existing[p.String()] = p
-			for _, dep := range p.Depends {
-				dv, err := ParseVersion(dep)
-				if err != nil {
-					return nil, err
-				}
-				deps, err := idx.GetPackageWithDependencies(ctx, existing, dv)
-				if err != nil {
-					return nil, err
-				}
-				for _, d := range deps {
-					existing[d.String()] = d
-				}
-			}
-			var pkg []Package
-			for _, v := range existing {
-				pkg = append(pkg, v)
-			}
-			return pkg, nil
+		if p.Required() {
+			out = append(out, p.Package)
 		}
 	}
-	return nil, nil
+	return out
+}
+
+// SetPriority sets the pinning priority of the index. Packages from
+// indices with a higher priority are preferred regardless of their
+// version. A priority of zero uses DefaultPriority.
+func (idx *Index) SetPriority(priority int) {
+	idx.priority = priority
+}
+
+// Priority returns the pinning priority of the index.
+func (idx *Index) Priority() int {
+	if idx.priority == 0 {
+		return DefaultPriority
+	}
+	return idx.priority
 }
 
 func (pv *PackageVersion) Matches(s1 string) bool {
@@ -181,3 +280,15 @@ func (pv *PackageVersion) Matches(s1 string) bool {
 		return true
 	}
 }
+
+// forName returns a copy of the version that
+// only refers to a single package.
+func (pv *PackageVersion) forName(name string) *PackageVersion {
+	return &PackageVersion{
+		Names:         []string{name},
+		Version:       pv.Version,
+		Constraint:    pv.Constraint,
+		Qualifier:     pv.Qualifier,
+		Architectures: pv.Architectures,
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/go-logr/logr/testr"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
 )
 
@@ -19,25 +20,63 @@ func TestIndex_GetPackageWithDependencies(t *testing.T) {
 	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
 
 	idx, err := newIndex(ctx, "", "./testdata/Packages.gz")
-	assert.NoError(t, err)
+	require.NoError(t, err)
 
 	t.Run("dependencies are found", func(t *testing.T) {
-		out, err := idx.GetPackageWithDependencies(ctx, map[string]Package{}, &PackageVersion{
+		out, err := NewResolver("amd64", idx).Resolve(ctx, &PackageVersion{
 			Names:   []string{"0ad"},
 			Version: "0.0.23.1-5+b1",
-		})
+		}, false)
 		assert.NoError(t, err)
 		assert.Len(t, out, 2)
 	})
 	t.Run("no dependents returns package", func(t *testing.T) {
-		out, err := idx.GetPackageWithDependencies(ctx, map[string]Package{}, &PackageVersion{
+		out, err := NewResolver("amd64", idx).Resolve(ctx, &PackageVersion{
 			Names: []string{"0ad-data"},
-		})
+		}, false)
 		assert.NoError(t, err)
 		assert.Len(t, out, 1)
 	})
 }
 
+func TestIndex_GetPackageWithDependenciesRecommends(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	idx := &Index{
+		packages: []Package{
+			{
+				Package:    "git",
+				Version:    "1:2.39.2-1.1",
+				Depends:    []string{"git-man (>> 1:2.39.2)"},
+				Recommends: []string{"less", "patch"},
+			},
+			{
+				Package: "git-man",
+				Version: "1:2.39.2-1.1",
+			},
+			{
+				Package: "less",
+				Version: "590-2.1~deb12u2",
+			},
+		},
+	}
+
+	t.Run("recommends are skipped", func(t *testing.T) {
+		out, err := NewResolver("amd64", idx).Resolve(ctx, &PackageVersion{
+			Names: []string{"git"},
+		}, false)
+		assert.NoError(t, err)
+		assert.Len(t, out, 2)
+	})
+	t.Run("recommends are included", func(t *testing.T) {
+		out, err := NewResolver("amd64", idx).Resolve(ctx, &PackageVersion{
+			Names: []string{"git"},
+		}, true)
+		assert.NoError(t, err)
+		assert.Len(t, out, 3)
+	})
+}
+
 func TestPackageMatchesConstraints(t *testing.T) {
 	var cases = []struct {
 		s1 string
@@ -0,0 +1,16 @@
+package debian
+
+import (
+	"bytes"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// ReadKeyring reads OpenPGP public keys that are either
+// ASCII armored (.asc) or binary (.gpg).
+func ReadKeyring(data []byte) (openpgp.EntityList, error) {
+	if bytes.Contains(data, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
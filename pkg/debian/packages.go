@@ -3,40 +3,170 @@ package debian
 import (
 	"errors"
 	"regexp"
+	"slices"
 	"strings"
 )
 
-var regexpParseVersion = regexp.MustCompile(`\((?P<constraint>\W{1,2})?(?P<version>.*)\)`)
-var regexpName = regexp.MustCompile(`^[^([]+`)
+var regexpRelation = regexp.MustCompile(`^(?P<name>[^\s:(\[<]+)(?::(?P<qualifier>[^\s(\[<]+))?\s*(?:\(\s*(?P<constraint>[<>=]{1,2})?\s*(?P<version>[^)]*?)\s*\))?\s*(?:\[(?P<arch>[^\]]*)\])?\s*(?:<.*>)?$`)
 
 // ParseVersion parses a debian version as used in the "Depends" section.
+// The names of all alternatives are returned along with the first
+// version constraint. Use ParseRelation to retain the details of
+// each alternative.
 //
 // https://www.debian.org/doc/debian-policy/ch-relationships.html
 func ParseVersion(s string) (*PackageVersion, error) {
-	matches := regexpName.FindStringSubmatch(s)
-	if len(matches) == 0 {
-		return nil, errors.New("unable to extract package names")
-	}
-	// extract the possible names
-	names := strings.Split(matches[0], " | ")
-	for i := range names {
-		names[i] = strings.TrimSpace(names[i])
-	}
-	// extract the version and constraint if they're present
-	matches = regexpParseVersion.FindStringSubmatch(strings.TrimPrefix(s, matches[0]))
-	var version string
-	var constraint string
-	if len(matches) >= 2 {
-		version = strings.TrimSpace(matches[regexpParseVersion.SubexpIndex("version")])
-		constraint = strings.TrimSpace(matches[regexpParseVersion.SubexpIndex("constraint")])
-	}
-	return &PackageVersion{
-		Names:      names,
-		Version:    version,
-		Constraint: constraint,
-	}, nil
+	alternatives, err := ParseRelation(s)
+	if err != nil {
+		return nil, err
+	}
+	out := &PackageVersion{}
+	for _, a := range alternatives {
+		out.Names = append(out.Names, a.Names...)
+		if out.Version == "" {
+			out.Version = a.Version
+			out.Constraint = a.Constraint
+		}
+	}
+	return out, nil
+}
+
+// ParseRelation parses a relationship (e.g. "foo:any (>= 1.0) | bar [amd64]")
+// into its alternatives, each of which refers to a single package.
+//
+// https://www.debian.org/doc/debian-policy/ch-relationships.html
+func ParseRelation(s string) ([]*PackageVersion, error) {
+	var out []*PackageVersion
+	for _, alt := range strings.Split(s, "|") {
+		matches := regexpRelation.FindStringSubmatch(strings.TrimSpace(alt))
+		if len(matches) == 0 {
+			return nil, errors.New("unable to extract package names")
+		}
+		pv := &PackageVersion{
+			Names:      []string{matches[regexpRelation.SubexpIndex("name")]},
+			Version:    matches[regexpRelation.SubexpIndex("version")],
+			Constraint: matches[regexpRelation.SubexpIndex("constraint")],
+			Qualifier:  matches[regexpRelation.SubexpIndex("qualifier")],
+		}
+		// the "<" and ">" constraints are deprecated
+		// aliases of "<=" and ">="
+		switch pv.Constraint {
+		case "<":
+			pv.Constraint = "<="
+		case ">":
+			pv.Constraint = ">="
+		}
+		if arch := matches[regexpRelation.SubexpIndex("arch")]; arch != "" {
+			pv.Architectures = strings.Fields(arch)
+		}
+		out = append(out, pv)
+	}
+	return out, nil
+}
+
+// AppliesTo returns true if the architecture restrictions
+// (e.g. "[amd64 !i386]") allow the relationship to be used
+// on the given architecture.
+func (pv *PackageVersion) AppliesTo(arch string) bool {
+	if len(pv.Architectures) == 0 {
+		return true
+	}
+	// restrictions are either all negated or none are
+	negated := strings.HasPrefix(pv.Architectures[0], "!")
+	for _, a := range pv.Architectures {
+		if strings.TrimPrefix(a, "!") == arch {
+			return !negated
+		}
+	}
+	return negated
+}
+
+// Dependencies returns the relationships that must be installed
+// alongside the package, starting with "Pre-Depends". If recommends
+// is set, the "Recommends" relationships are included.
+func (p *Package) Dependencies(recommends bool) []string {
+	if !recommends {
+		return slices.Concat(p.PreDepends, p.Depends)
+	}
+	return slices.Concat(p.PreDepends, p.Depends, p.Recommends)
+}
+
+// Required returns true if the package is part of a minimal
+// system, which is the set of packages that debootstrap installs
+// for the "minbase" variant.
+func (p *Package) Required() bool {
+	return strings.EqualFold(p.Essential, "yes") || p.Priority == "required"
+}
+
+// ConflictsWith returns true if the package declares that it
+// "Conflicts" with or "Breaks" the other package, either directly
+// or through a virtual package that it provides.
+func (p *Package) ConflictsWith(o *Package) bool {
+	// a package can't conflict with itself, even if
+	// it conflicts with a virtual package it provides
+	if p.Package == o.Package {
+		return false
+	}
+	for _, rel := range slices.Concat(p.Conflicts, p.Breaks) {
+		if p.relatesTo(rel, o) {
+			return true
+		}
+	}
+	return false
+}
+
+// Overwrites returns true if the package declares that it
+// "Replaces" files in the other package, and is therefore
+// allowed to overwrite them.
+func (p *Package) Overwrites(o *Package) bool {
+	for _, rel := range p.Replaces {
+		if p.relatesTo(rel, o) {
+			return true
+		}
+	}
+	return false
+}
+
+// relatesTo returns true if the relationship
+// refers to the other package.
+func (p *Package) relatesTo(rel string, o *Package) bool {
+	pv, err := ParseVersion(rel)
+	if err != nil {
+		return false
+	}
+	for _, name := range pv.Names {
+		if name == o.Package && pv.Matches(o.Version) {
+			return true
+		}
+		for _, provides := range o.Provides {
+			vp, err := ParseVersion(provides)
+			if err != nil || vp.Names[0] != name {
+				continue
+			}
+			// unversioned provides can't satisfy
+			// a versioned relationship
+			if pv.Version == "" || (vp.Version != "" && pv.Matches(vp.Version)) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (p *Package) String() string {
 	return p.Package + p.Version
 }
+
+func (pv *PackageVersion) String() string {
+	s := strings.Join(pv.Names, " | ")
+	if pv.Qualifier != "" {
+		s += ":" + pv.Qualifier
+	}
+	if pv.Version != "" {
+		s += " (" + strings.TrimSpace(pv.Constraint+" "+pv.Version) + ")"
+	}
+	if len(pv.Architectures) > 0 {
+		s += " [" + strings.Join(pv.Architectures, " ") + "]"
+	}
+	return s
+}
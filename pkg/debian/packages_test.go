@@ -38,6 +38,20 @@ func TestParseVersion(t *testing.T) {
 			},
 			true,
 		},
+		{
+			"foo:any (>= 1.0) | bar (<< 2.0)",
+			&PackageVersion{
+				Names:      []string{"foo", "bar"},
+				Version:    "1.0",
+				Constraint: ">=",
+			},
+			true,
+		},
+		{
+			"",
+			nil,
+			false,
+		},
 	}
 
 	for _, tt := range cases {
@@ -52,3 +66,111 @@ func TestParseVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestPackage_ConflictsWith(t *testing.T) {
+	var cases = []struct {
+		name string
+		p    Package
+		o    Package
+		ok   bool
+	}{
+		{
+			"direct conflict",
+			Package{Package: "foo", Conflicts: []string{"bar"}},
+			Package{Package: "bar", Version: "1.0"},
+			true,
+		},
+		{
+			"versioned breaks",
+			Package{Package: "foo", Breaks: []string{"bar (<< 2.0)"}},
+			Package{Package: "bar", Version: "2.1"},
+			false,
+		},
+		{
+			"virtual conflict",
+			Package{Package: "postfix", Conflicts: []string{"mail-transport-agent"}},
+			Package{Package: "exim4", Provides: []string{"mail-transport-agent"}},
+			true,
+		},
+		{
+			"self conflict is ignored",
+			Package{Package: "postfix", Provides: []string{"mail-transport-agent"}, Conflicts: []string{"mail-transport-agent"}},
+			Package{Package: "postfix", Provides: []string{"mail-transport-agent"}},
+			false,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.EqualValues(t, tt.ok, tt.p.ConflictsWith(&tt.o))
+		})
+	}
+}
+
+func TestPackage_Overwrites(t *testing.T) {
+	p := Package{Package: "foo", Replaces: []string{"foo-data (<< 2.0)"}}
+	assert.True(t, p.Overwrites(&Package{Package: "foo-data", Version: "1.0"}))
+	assert.False(t, p.Overwrites(&Package{Package: "foo-data", Version: "2.0"}))
+}
+
+func TestIndex_Required(t *testing.T) {
+	idx := &Index{
+		packages: []Package{
+			{Package: "base-files", Essential: "yes", Priority: "required"},
+			{Package: "libc6", Priority: "required"},
+			{Package: "dpkg", Essential: "yes", Priority: "important"},
+			{Package: "apt", Priority: "important"},
+			{Package: "curl", Priority: "optional"},
+		},
+	}
+	assert.EqualValues(t, []string{"base-files", "libc6", "dpkg"}, idx.Required())
+}
+
+func TestParseRelation(t *testing.T) {
+	var cases = []struct {
+		in  string
+		out []*PackageVersion
+	}{
+		{
+			"libc6:any",
+			[]*PackageVersion{{Names: []string{"libc6"}, Qualifier: "any"}},
+		},
+		{
+			"python3:native (>= 3.11) <!nocheck>",
+			[]*PackageVersion{{Names: []string{"python3"}, Qualifier: "native", Version: "3.11", Constraint: ">="}},
+		},
+		{
+			"foo [amd64] | bar (< 2.0) [!i386 !arm64]",
+			[]*PackageVersion{
+				{Names: []string{"foo"}, Architectures: []string{"amd64"}},
+				{Names: []string{"bar"}, Version: "2.0", Constraint: "<=", Architectures: []string{"!i386", "!arm64"}},
+			},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			out, err := ParseRelation(tt.in)
+			assert.NoError(t, err)
+			assert.EqualValues(t, tt.out, out)
+		})
+	}
+}
+
+func TestPackageVersion_AppliesTo(t *testing.T) {
+	var cases = []struct {
+		archs []string
+		arch  string
+		ok    bool
+	}{
+		{nil, "amd64", true},
+		{[]string{"amd64"}, "amd64", true},
+		{[]string{"i386", "arm64"}, "amd64", false},
+		{[]string{"!i386"}, "amd64", true},
+		{[]string{"!amd64"}, "amd64", false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.arch, func(t *testing.T) {
+			pv := &PackageVersion{Names: []string{"foo"}, Architectures: tt.archs}
+			assert.EqualValues(t, tt.ok, pv.AppliesTo(tt.arch))
+		})
+	}
+}
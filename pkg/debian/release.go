@@ -0,0 +1,133 @@
+package debian
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/go-logr/logr"
+	"pault.ag/go/debian/control"
+)
+
+// ArchAll is the architecture of
+// architecture independent packages.
+const ArchAll = "all"
+
+// Arch converts a Go architecture (and variant)
+// into the name that Debian uses for it.
+//
+// https://wiki.debian.org/SupportedArchitectures
+func Arch(goarch, variant string) string {
+	switch goarch {
+	case "386":
+		return "i386"
+	case "arm":
+		if variant == "v5" || variant == "v6" {
+			return "armel"
+		}
+		return "armhf"
+	case "ppc64le":
+		return "ppc64el"
+	case "mips64le":
+		return "mips64el"
+	default:
+		return goarch
+	}
+}
+
+// Release is the subset of a repository
+// Release file that we care about.
+//
+// https://wiki.debian.org/DebianRepository/Format#A.22Release.22_files
+type Release struct {
+	Suite         string
+	Codename      string
+	Architectures []string `delim:" "`
+	Components    []string `delim:" "`
+	// NoSupportForArchitectureAll indicates that
+	// architecture independent packages are also listed
+	// in the architecture specific indices.
+	NoSupportForArchitectureAll string `control:"No-Support-for-Architecture-all"`
+	// SHA256 lists the checksum, size and path
+	// of each index, one per line.
+	SHA256 string
+}
+
+// GetRelease downloads the Release file from a release directory
+// (e.g. "https://deb.debian.org/debian/dists/bookworm"). If a keyring
+// is provided, the InRelease file is used instead and it must be
+// signed by one of the keys.
+func GetRelease(ctx context.Context, dir string, keyring openpgp.EntityList) (*Release, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("dir", dir)
+
+	filename := "Release"
+	if keyring != nil {
+		filename = "InRelease"
+	}
+	log.V(1).Info("downloading release file", "filename", filename)
+
+	target := dir + "/" + filename
+	resp, err := http.Get(target)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, target)
+		}
+		return nil, fmt.Errorf("http response failed with code: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if keyring != nil {
+		// the control package verifies signatures using the
+		// deprecated x/crypto openpgp, so we check it ourselves
+		// and only hand it the signed text
+		block, _ := clearsign.Decode(data)
+		if block == nil {
+			return nil, errors.New("release file is not signed")
+		}
+		signer, err := block.VerifySignature(keyring, nil)
+		if err != nil {
+			return nil, fmt.Errorf("verifying release file: %w", err)
+		}
+		log.V(2).Info("verified release file signature", "signer", signer.PrimaryKey.KeyIdString())
+		data = block.Plaintext
+	}
+	var out Release
+	if err := control.Unmarshal(&out, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("parsing release file: %w", err)
+	}
+	return &out, nil
+}
+
+// HasSeparateArchAll returns true if architecture independent
+// packages are published in their own "binary-all" index.
+func (r *Release) HasSeparateArchAll() bool {
+	return slices.Contains(r.Architectures, ArchAll) && r.NoSupportForArchitectureAll != "Packages"
+}
+
+// Checksum returns the SHA256 checksum of a file
+// relative to the release directory.
+func (r *Release) Checksum(path string) (string, bool) {
+	scanner := bufio.NewScanner(strings.NewReader(r.SHA256))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[2] == path {
+			return fields[0], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,60 @@
+package debian
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIndexArchAll(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	var cases = []struct {
+		name    string
+		release string
+		count   int
+	}{
+		{
+			"all is listed",
+			"Suite: stable\nArchitectures: all amd64 arm64\nComponents: main\n",
+			2,
+		},
+		{
+			"all is not listed",
+			"Suite: stable\nArchitectures: amd64 arm64\nComponents: main\n",
+			1,
+		},
+		{
+			"all is included in arch indices",
+			"Suite: stable\nArchitectures: all amd64\nNo-Support-for-Architecture-all: Packages\n",
+			1,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			mux.HandleFunc("/dists/stable/Release", func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(tt.release))
+			})
+			mux.HandleFunc("/dists/stable/main/binary-amd64/Packages", func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("Package: curl\nVersion: 7.88.1-10\nArchitecture: amd64\n"))
+			})
+			mux.HandleFunc("/dists/stable/main/binary-all/Packages", func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("Package: ca-certificates\nVersion: 20230311\nArchitecture: all\n"))
+			})
+
+			idx, err := NewIndex(ctx, srv.URL, "stable", "main", "amd64")
+			require.NoError(t, err)
+			assert.EqualValues(t, tt.count, idx.Count())
+		})
+	}
+}
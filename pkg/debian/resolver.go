@@ -0,0 +1,397 @@
+package debian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-logr/logr"
+	version "github.com/knqyf263/go-deb-version"
+)
+
+// DefaultPriority is the priority given to indices that
+// don't have one set. It's the same default that apt uses.
+const DefaultPriority = 500
+
+// maxRestarts limits how many times we restart resolution
+// after discovering a version conflict.
+const maxRestarts = 100
+
+// Multi-Arch values.
+//
+// https://wiki.debian.org/Multiarch/HOWTO
+const (
+	MultiArchSame    = "same"
+	MultiArchForeign = "foreign"
+	MultiArchAllowed = "allowed"
+)
+
+// Candidate is a package along with the index that it came from.
+type Candidate struct {
+	Package
+	Index *Index
+}
+
+// Resolver finds packages and their dependencies across
+// a set of indices.
+//
+// When multiple versions of a package are available, the
+// version from the index with the highest priority is
+// preferred, followed by the highest version. Indices with a
+// negative priority are never used. Packages that conflict with, or
+// break, a package that has already been selected are skipped.
+type Resolver struct {
+	// arch is the native architecture
+	arch string
+	// candidates contains every version of a package,
+	// ordered by preference
+	candidates map[string][]Candidate
+	// providers contains the packages that provide
+	// a virtual package, ordered by preference
+	providers map[string][]provider
+}
+
+// provider is a package that provides a virtual package.
+type provider struct {
+	Candidate
+	// version is the version of the virtual package
+	// being provided, if any
+	version string
+}
+
+// NewResolver creates a Resolver from the given indices. The arch
+// is the native architecture that packages are installed for,
+// unless a dependency explicitly asks for another architecture.
+func NewResolver(arch string, indices ...*Index) *Resolver {
+	r := &Resolver{
+		arch:       arch,
+		candidates: map[string][]Candidate{},
+		providers:  map[string][]provider{},
+	}
+	for _, idx := range indices {
+		if idx.Priority() < 0 {
+			continue
+		}
+		for _, p := range idx.packages {
+			c := Candidate{Package: p, Index: idx}
+			r.candidates[p.Package] = append(r.candidates[p.Package], c)
+			for _, v := range p.Provides {
+				pv, err := ParseVersion(v)
+				if err != nil {
+					continue
+				}
+				r.providers[pv.Names[0]] = append(r.providers[pv.Names[0]], provider{Candidate: c, version: pv.Version})
+			}
+		}
+	}
+	for _, c := range r.candidates {
+		slices.SortStableFunc(c, compareCandidates)
+	}
+	for _, p := range r.providers {
+		slices.SortStableFunc(p, func(a, b provider) int {
+			if a.Index.Priority() != b.Index.Priority() {
+				return b.Index.Priority() - a.Index.Priority()
+			}
+			// when there are multiple providers, pick one
+			// consistently so that builds are reproducible
+			if a.Package.Package != b.Package.Package {
+				return strings.Compare(a.Package.Package, b.Package.Package)
+			}
+			return compareCandidates(a.Candidate, b.Candidate)
+		})
+	}
+	return r
+}
+
+// satisfies returns true if the provider can be used to satisfy
+// the given dependency. Unversioned provides can't satisfy a
+// versioned dependency.
+func (p provider) satisfies(pv *PackageVersion) bool {
+	if pv.Version == "" {
+		return true
+	}
+	return p.version != "" && pv.Matches(p.version)
+}
+
+// compareCandidates sorts candidates by their index priority
+// and then version, in descending order.
+func compareCandidates(a, b Candidate) int {
+	if a.Index.Priority() != b.Index.Priority() {
+		return b.Index.Priority() - a.Index.Priority()
+	}
+	v1, err1 := version.NewVersion(a.Version)
+	v2, err2 := version.NewVersion(b.Version)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	return v2.Compare(v1)
+}
+
+// archOf returns the architecture that a package is installed
+// as. Architecture independent packages are treated as native.
+func (r *Resolver) archOf(c Candidate) string {
+	if c.Architecture == "" || c.Architecture == "all" {
+		return r.arch
+	}
+	return c.Architecture
+}
+
+// accepts returns true if the candidate can satisfy a dependency
+// with the given architecture qualifier, from a package
+// installed for the given architecture.
+func (r *Resolver) accepts(c Candidate, qualifier, arch string) bool {
+	ca := r.archOf(c)
+	switch qualifier {
+	case "":
+		return ca == arch || c.MultiArch == MultiArchForeign
+	case "any":
+		return ca == arch || c.MultiArch == MultiArchForeign || c.MultiArch == MultiArchAllowed
+	case "native":
+		return ca == r.arch
+	default:
+		return ca == qualifier
+	}
+}
+
+// versionConflict is returned when a package has already been
+// selected, but a later dependency requires a different version.
+type versionConflict struct {
+	name string
+	dep  *PackageVersion
+}
+
+func (e *versionConflict) Error() string {
+	return fmt.Sprintf("version conflict for package '%s'", e.name)
+}
+
+// Resolve finds the best version of a package along with its
+// dependencies. If recommends is set, packages listed in the
+// "Recommends" field are treated as dependencies.
+func (r *Resolver) Resolve(ctx context.Context, pv *PackageVersion, recommends bool) ([]Candidate, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	alternatives := make([]*PackageVersion, len(pv.Names))
+	for i, name := range pv.Names {
+		alternatives[i] = pv.forName(name)
+	}
+
+	// constraints are the version requirements that we've discovered
+	// need to be considered up-front. Each time we find a conflict,
+	// we add the requirement and start again.
+	constraints := map[string][]*PackageVersion{}
+	for i := 0; i < maxRestarts; i++ {
+		s := &resolution{
+			resolver:    r,
+			recommends:  recommends,
+			constraints: constraints,
+			selected:    map[string][]Candidate{},
+		}
+		err := s.require(ctx, alternatives, r.arch, true)
+		var conflict *versionConflict
+		if errors.As(err, &conflict) {
+			log.V(3).Info("restarting resolution due to version conflict", "name", conflict.name, "constraint", conflict.dep.Constraint, "version", conflict.dep.Version)
+			constraints[conflict.name] = append(constraints[conflict.name], conflict.dep)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s.order, nil
+	}
+	return nil, fmt.Errorf("unable to resolve package '%s': too many version conflicts", pv)
+}
+
+type resolution struct {
+	resolver    *Resolver
+	recommends  bool
+	constraints map[string][]*PackageVersion
+	// selected contains the selected packages by name. There may
+	// be more than one if the package is "Multi-Arch: same".
+	selected map[string][]Candidate
+	order    []Candidate
+}
+
+// require makes sure that one of the alternatives is selected
+// for a package installed for the given architecture. If hard is
+// set, failing to find a package is an error.
+func (s *resolution) require(ctx context.Context, alternatives []*PackageVersion, arch string, hard bool) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	// drop any alternatives that are restricted to
+	// other architectures. If there's nothing left, the
+	// relationship doesn't apply to us
+	alternatives = slices.DeleteFunc(slices.Clone(alternatives), func(pv *PackageVersion) bool {
+		return !pv.AppliesTo(s.resolver.arch)
+	})
+	if len(alternatives) == 0 {
+		return nil
+	}
+
+	// check if the dependency has already been satisfied
+	// by a real or virtual package
+	for _, pv := range alternatives {
+		name := pv.Names[0]
+		if c, ok := s.selectedFor(name, pv.Qualifier, arch); ok && pv.Matches(c.Version) {
+			return nil
+		}
+		for _, p := range s.resolver.providers[name] {
+			if !p.satisfies(pv) || !s.resolver.accepts(p.Candidate, "", arch) {
+				continue
+			}
+			if c, ok := s.selectedFor(p.Package.Package, "", arch); ok && c.Version == p.Version && c.Index == p.Index {
+				return nil
+			}
+		}
+	}
+
+	for _, pv := range alternatives {
+		name := pv.Names[0]
+		// we already picked a version that doesn't match. If this
+		// is the only option, then we need to start again
+		if _, ok := s.selectedFor(name, pv.Qualifier, arch); ok {
+			if len(alternatives) == 1 {
+				return &versionConflict{name: name, dep: pv}
+			}
+			continue
+		}
+		c, ok := s.best(name, pv, arch)
+		if !ok {
+			c, ok = s.provider(name, pv, arch)
+		}
+		if !ok {
+			continue
+		}
+		log.V(5).Info("selected package", "name", c.Package.Package, "version", c.Version, "arch", s.resolver.archOf(c), "source", c.Index.Source(), "dep", pv.String())
+		s.selected[c.Package.Package] = append(s.selected[c.Package.Package], c)
+		s.order = append(s.order, c)
+
+		// dependencies are resolved for the same
+		// architecture as the package
+		depArch := s.resolver.archOf(c)
+		for _, dep := range c.Dependencies(false) {
+			dv, err := ParseRelation(dep)
+			if err != nil {
+				return err
+			}
+			if err := s.require(ctx, dv, depArch, true); err != nil {
+				return fmt.Errorf("resolving dependencies of %s: %w", c.Package.Package, err)
+			}
+		}
+		// weak dependencies are skipped if they
+		// can't be satisfied
+		if s.recommends {
+			for _, dep := range c.Recommends {
+				dv, err := ParseRelation(dep)
+				if err != nil {
+					return err
+				}
+				if err := s.require(ctx, dv, depArch, false); err != nil {
+					return fmt.Errorf("resolving recommendations of %s: %w", c.Package.Package, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	names := make([]string, len(alternatives))
+	for i := range alternatives {
+		names[i] = alternatives[i].String()
+	}
+	if hard {
+		return fmt.Errorf("unable to satisfy dependency: %s", strings.Join(names, " | "))
+	}
+	log.V(4).Info("skipping dependency as it could not be satisfied", "dep", strings.Join(names, " | "))
+	return nil
+}
+
+// selectedFor returns the selected package that can satisfy
+// a dependency with the given architecture qualifier.
+func (s *resolution) selectedFor(name, qualifier, arch string) (Candidate, bool) {
+	for _, c := range s.selected[name] {
+		if s.resolver.accepts(c, qualifier, arch) {
+			return c, true
+		}
+	}
+	return Candidate{}, false
+}
+
+// provider returns the most preferred package that provides
+// the virtual package, and hasn't already been selected.
+func (s *resolution) provider(name string, pv *PackageVersion, arch string) (Candidate, bool) {
+	for _, p := range s.resolver.providers[name] {
+		if !p.satisfies(pv) || !s.resolver.accepts(p.Candidate, "", arch) {
+			continue
+		}
+		if _, ok := s.selected[p.Package.Package]; ok {
+			continue
+		}
+		if c, ok := s.best(p.Package.Package, &PackageVersion{Names: []string{p.Package.Package}, Version: p.Version, Constraint: "="}, arch); ok {
+			return c, true
+		}
+	}
+	return Candidate{}, false
+}
+
+// best returns the most preferred version of a package that
+// satisfies pv and any known constraints.
+func (s *resolution) best(name string, pv *PackageVersion, arch string) (Candidate, bool) {
+	for _, c := range s.resolver.candidates[name] {
+		if !pv.Matches(c.Version) || !s.resolver.accepts(c, pv.Qualifier, arch) || !s.compatible(c) {
+			continue
+		}
+		ok := true
+		for _, constraint := range s.constraints[name] {
+			if !constraint.Matches(c.Version) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return c, true
+		}
+	}
+	return Candidate{}, false
+}
+
+// compatible returns true if the candidate can be installed
+// alongside the packages that have already been selected.
+func (s *resolution) compatible(c Candidate) bool {
+	// a package can only be installed for multiple
+	// architectures if it's "Multi-Arch: same"
+	for _, o := range s.selected[c.Package.Package] {
+		if s.resolver.archOf(o) == s.resolver.archOf(c) || o.MultiArch != MultiArchSame || c.MultiArch != MultiArchSame {
+			return false
+		}
+	}
+	for _, o := range s.order {
+		if c.ConflictsWith(&o.Package) || o.ConflictsWith(&c.Package) {
+			return false
+		}
+	}
+	return true
+}
+
+// Candidates returns every version of a package that
+// can be installed, ordered by preference.
+func (r *Resolver) Candidates(name string) []Candidate {
+	return r.candidates[name]
+}
+
+// CheckConflicts returns an error describing every pair of
+// packages in the list that cannot be installed together.
+func CheckConflicts(candidates []Candidate) error {
+	var errs []error
+	for i := range candidates {
+		for j := range candidates {
+			if i == j {
+				continue
+			}
+			if candidates[i].ConflictsWith(&candidates[j].Package) {
+				errs = append(errs, fmt.Errorf("package '%s' (%s) conflicts with or breaks '%s' (%s)", candidates[i].Package.Package, candidates[i].Version, candidates[j].Package.Package, candidates[j].Version))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,250 @@
+package debian
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	stable := &Index{
+		source: "https://deb.debian.org/debian",
+		packages: []Package{
+			{Package: "curl", Version: "7.88.1-10", Depends: []string{"libcurl4 (= 7.88.1-10)"}},
+			{Package: "libcurl4", Version: "7.88.1-10"},
+			{Package: "app", Version: "1.0", Depends: []string{"libfoo (>= 1.0)", "libbar"}},
+			{Package: "libfoo", Version: "1.0"},
+			{Package: "libbar", Version: "1.0", Depends: []string{"libfoo (<< 2.0)"}},
+		},
+	}
+	backports := &Index{
+		source: "https://deb.debian.org/debian-backports",
+		packages: []Package{
+			{Package: "curl", Version: "8.5.0-2", Depends: []string{"libcurl4 (= 8.5.0-2)"}},
+			{Package: "libcurl4", Version: "8.5.0-2"},
+			{Package: "libfoo", Version: "2.0"},
+		},
+	}
+
+	var cases = []struct {
+		name     string
+		pkg      string
+		priority int
+		expected map[string]string
+	}{
+		{
+			"highest version is selected across indices",
+			"curl",
+			0,
+			map[string]string{"curl": "8.5.0-2", "libcurl4": "8.5.0-2"},
+		},
+		{
+			"lower priority index is not preferred",
+			"curl",
+			100,
+			map[string]string{"curl": "7.88.1-10", "libcurl4": "7.88.1-10"},
+		},
+		{
+			"version satisfies all constraints",
+			"app",
+			0,
+			map[string]string{"app": "1.0", "libfoo": "1.0", "libbar": "1.0"},
+		},
+		{
+			"negative priority index is ignored",
+			"libfoo",
+			-1,
+			map[string]string{"libfoo": "1.0"},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			backports.SetPriority(tt.priority)
+
+			out, err := NewResolver("amd64", stable, backports).Resolve(ctx, &PackageVersion{Names: []string{tt.pkg}}, false)
+			require.NoError(t, err)
+
+			versions := map[string]string{}
+			for _, c := range out {
+				versions[c.Package.Package] = c.Version
+				// make sure the package comes from the index
+				// that contains it
+				assert.Contains(t, c.Index.packages, c.Package)
+			}
+			assert.EqualValues(t, tt.expected, versions)
+		})
+	}
+
+	t.Run("missing package returns an error", func(t *testing.T) {
+		_, err := NewResolver("amd64", stable, backports).Resolve(ctx, &PackageVersion{Names: []string{"wget"}}, false)
+		assert.Error(t, err)
+	})
+}
+
+func TestResolver_ResolveProvides(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	idx := &Index{
+		packages: []Package{
+			{Package: "mailx", Version: "1.0", Depends: []string{"exim4 | mail-transport-agent"}},
+			{Package: "postfix", Version: "3.7.10-0+deb12u1", Provides: []string{"mail-transport-agent"}},
+			{Package: "msmtp-mta", Version: "1.8.23-1", Provides: []string{"mail-transport-agent"}},
+			{Package: "gawk", Version: "1:5.2.1-2", Provides: []string{"awk"}},
+			{Package: "mawk", Version: "1.3.4.20200120-3.1", Provides: []string{"awk"}},
+			{Package: "scripts", Version: "1.0", Depends: []string{"mawk", "awk"}},
+			{Package: "libfoo-dev", Version: "1.0", Depends: []string{"libfoo-abi (>= 2)"}},
+			{Package: "libfoo2", Version: "2.1", Provides: []string{"libfoo-abi (= 2.1)"}},
+			{Package: "libfoo1", Version: "1.9", Provides: []string{"libfoo-abi"}},
+			{Package: "broken", Version: "1.0", Depends: []string{"does-not-exist"}},
+			{Package: "unversioned", Version: "1.0", Depends: []string{"libfoo-abi (>= 1)"}},
+		},
+	}
+	idx2 := &Index{
+		packages: []Package{
+			{Package: "libfoo1", Version: "1.9", Provides: []string{"libfoo-abi"}},
+		},
+	}
+
+	var cases = []struct {
+		name     string
+		pkg      string
+		expected []string
+	}{
+		{
+			"virtual alternative uses a provider",
+			"mailx",
+			[]string{"mailx", "msmtp-mta"},
+		},
+		{
+			"selected package satisfies a virtual dependency",
+			"scripts",
+			[]string{"scripts", "mawk"},
+		},
+		{
+			"versioned provides",
+			"libfoo-dev",
+			[]string{"libfoo-dev", "libfoo2"},
+		},
+		{
+			"virtual package can be requested directly",
+			"awk",
+			[]string{"gawk"},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := NewResolver("amd64", idx).Resolve(ctx, &PackageVersion{Names: []string{tt.pkg}}, false)
+			require.NoError(t, err)
+
+			names := make([]string, len(out))
+			for i := range out {
+				names[i] = out[i].Package.Package
+			}
+			assert.EqualValues(t, tt.expected, names)
+		})
+	}
+
+	t.Run("unsatisfiable dependency returns an error", func(t *testing.T) {
+		_, err := NewResolver("amd64", idx).Resolve(ctx, &PackageVersion{Names: []string{"broken"}}, false)
+		assert.ErrorContains(t, err, "does-not-exist")
+	})
+	t.Run("unversioned provides can't satisfy a versioned dependency", func(t *testing.T) {
+		_, err := NewResolver("amd64", idx2, &Index{packages: idx.packages[len(idx.packages)-1:]}).Resolve(ctx, &PackageVersion{Names: []string{"unversioned"}}, false)
+		assert.Error(t, err)
+	})
+}
+
+func TestResolver_ResolveConflicts(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	idx := &Index{
+		packages: []Package{
+			{Package: "app", Version: "1.0", PreDepends: []string{"init-system-helpers"}, Depends: []string{"default-mta | mail-transport-agent"}},
+			{Package: "init-system-helpers", Version: "1.65.2"},
+			{Package: "default-mta", Version: "1.0", Depends: []string{"exim4"}, Conflicts: []string{"init-system-helpers"}},
+			{Package: "postfix", Version: "3.7.10", Provides: []string{"mail-transport-agent"}},
+			{Package: "broken", Version: "1.0", Depends: []string{"libfoo", "libbar"}},
+			{Package: "libfoo", Version: "1.0"},
+			{Package: "libbar", Version: "1.0", Breaks: []string{"libfoo (<< 2.0)"}},
+		},
+	}
+
+	t.Run("conflicting alternatives are skipped", func(t *testing.T) {
+		out, err := NewResolver("amd64", idx).Resolve(ctx, &PackageVersion{Names: []string{"app"}}, false)
+		require.NoError(t, err)
+
+		names := make([]string, len(out))
+		for i := range out {
+			names[i] = out[i].Package.Package
+		}
+		assert.EqualValues(t, []string{"app", "init-system-helpers", "postfix"}, names)
+	})
+	t.Run("mutually exclusive packages return an error", func(t *testing.T) {
+		_, err := NewResolver("amd64", idx).Resolve(ctx, &PackageVersion{Names: []string{"broken"}}, false)
+		assert.ErrorContains(t, err, "libbar")
+	})
+}
+
+func TestResolver_ResolveMultiArch(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	idx := &Index{
+		packages: []Package{
+			{Package: "app", Version: "1.0", Architecture: "amd64", Depends: []string{"python3:any", "libc6", "sh-tools [i386] | coreutils"}},
+			{Package: "python3", Version: "3.11.2-1", Architecture: "amd64", MultiArch: MultiArchAllowed},
+			{Package: "libc6", Version: "2.36-9", Architecture: "amd64", MultiArch: MultiArchSame},
+			{Package: "coreutils", Version: "9.1-1", Architecture: "amd64", MultiArch: MultiArchForeign},
+			{Package: "sh-tools", Version: "1.0", Architecture: "amd64"},
+			{Package: "tzdata", Version: "2024a", Architecture: "all", MultiArch: MultiArchForeign},
+		},
+	}
+	foreign := &Index{
+		packages: []Package{
+			{Package: "wine32", Version: "8.0", Architecture: "i386", Depends: []string{"libc6", "coreutils", "tzdata"}},
+			{Package: "libc6", Version: "2.36-9", Architecture: "i386", MultiArch: MultiArchSame},
+			{Package: "coreutils", Version: "9.1-1", Architecture: "i386", MultiArch: MultiArchForeign},
+			{Package: "python3", Version: "3.11.2-1", Architecture: "i386", MultiArch: MultiArchAllowed},
+			{Package: "legacy", Version: "1.0", Architecture: "i386", Depends: []string{"sh-tools"}},
+		},
+	}
+
+	var cases = []struct {
+		name     string
+		pkg      *PackageVersion
+		expected []string
+	}{
+		{
+			"qualifiers and restrictions",
+			&PackageVersion{Names: []string{"app"}},
+			[]string{"app:amd64", "python3:amd64", "libc6:amd64", "coreutils:amd64"},
+		},
+		{
+			"foreign architecture",
+			&PackageVersion{Names: []string{"wine32"}, Qualifier: "i386"},
+			[]string{"wine32:i386", "libc6:i386", "coreutils:amd64", "tzdata:all"},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := NewResolver("amd64", idx, foreign).Resolve(ctx, tt.pkg, false)
+			require.NoError(t, err)
+
+			names := make([]string, len(out))
+			for i := range out {
+				names[i] = out[i].Package.Package + ":" + out[i].Architecture
+			}
+			assert.EqualValues(t, tt.expected, names)
+		})
+	}
+
+	t.Run("dependencies must match the architecture", func(t *testing.T) {
+		_, err := NewResolver("amd64", idx, foreign).Resolve(ctx, &PackageVersion{Names: []string{"legacy"}, Qualifier: "i386"}, false)
+		assert.ErrorContains(t, err, "sh-tools")
+	})
+}
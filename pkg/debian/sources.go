@@ -0,0 +1,154 @@
+package debian
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"pault.ag/go/debian/control"
+)
+
+// Source is a repository as defined in a
+// sources.list or deb822 .sources file.
+//
+// https://manpages.debian.org/bookworm/apt/sources.list.5.en.html
+type Source struct {
+	URIs          []string
+	Suites        []string
+	Components    []string
+	Architectures []string
+	// SignedBy is either the path to a keyring,
+	// or an ASCII armored public key.
+	SignedBy string
+}
+
+// Flat returns true if the source refers to a flat
+// repository, which is indicated by a suite that ends
+// with a '/' (e.g. "./").
+func (s *Source) Flat() bool {
+	return len(s.Suites) > 0 && strings.HasSuffix(s.Suites[0], "/")
+}
+
+// ParseSourcesList reads repositories from the one-line style
+// sources.list format. Source package ("deb-src") entries are
+// ignored.
+//
+//	deb [ arch=amd64 signed-by=/usr/share/keyrings/foo.gpg ] https://deb.debian.org/debian bookworm main contrib
+func ParseSourcesList(r io.Reader) ([]Source, error) {
+	var out []Source
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "deb" {
+			continue
+		}
+		fields = fields[1:]
+
+		var src Source
+		// options are wrapped in brackets, which may
+		// or may not be separated by spaces
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "[") {
+			var options []string
+			for len(fields) > 0 {
+				f := fields[0]
+				fields = fields[1:]
+				end := strings.HasSuffix(f, "]")
+				options = append(options, strings.Trim(f, "[]"))
+				if end {
+					break
+				}
+			}
+			for _, opt := range options {
+				k, v, _ := strings.Cut(opt, "=")
+				switch k {
+				case "arch":
+					src.Architectures = strings.Split(v, ",")
+				case "signed-by":
+					src.SignedBy = v
+				}
+			}
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("malformed sources.list entry: %s", scanner.Text())
+		}
+		src.URIs = []string{fields[0]}
+		src.Suites = []string{fields[1]}
+		src.Components = fields[2:]
+		if err := src.Validate(); err != nil {
+			return nil, err
+		}
+		out = append(out, src)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// deb822Source is a single paragraph of a deb822 .sources file.
+type deb822Source struct {
+	Types         string
+	URIs          string
+	Suites        string
+	Components    string
+	Architectures string
+	SignedBy      string `control:"Signed-By"`
+	Enabled       string
+}
+
+// ParseSources reads repositories from the deb822 style .sources
+// format. Disabled and source package entries are ignored.
+func ParseSources(r io.Reader) ([]Source, error) {
+	dec, err := control.NewDecoder(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	var paragraphs []deb822Source
+	if err := dec.Decode(&paragraphs); err != nil {
+		return nil, err
+	}
+	var out []Source
+	for _, p := range paragraphs {
+		if strings.EqualFold(p.Enabled, "no") || !containsField(p.Types, "deb") {
+			continue
+		}
+		src := Source{
+			URIs:          strings.Fields(p.URIs),
+			Suites:        strings.Fields(p.Suites),
+			Components:    strings.Fields(p.Components),
+			Architectures: strings.Fields(p.Architectures),
+			SignedBy:      strings.TrimSpace(p.SignedBy),
+		}
+		if err := src.Validate(); err != nil {
+			return nil, err
+		}
+		out = append(out, src)
+	}
+	return out, nil
+}
+
+// Validate checks that the source has everything that we need.
+func (s *Source) Validate() error {
+	if len(s.URIs) == 0 || len(s.Suites) == 0 {
+		return errors.New("repository must have at least one uri and suite")
+	}
+	if s.Flat() && len(s.Components) > 0 {
+		return fmt.Errorf("flat repository '%s' must not have components", s.Suites[0])
+	}
+	if !s.Flat() && len(s.Components) == 0 {
+		return fmt.Errorf("repository suite '%s' must have at least one component", s.Suites[0])
+	}
+	return nil
+}
+
+func containsField(s, v string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package debian
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSourcesList(t *testing.T) {
+	in := `# main repositories
+deb [arch=amd64,i386 signed-by=/usr/share/keyrings/debian-archive-keyring.gpg] http://deb.debian.org/debian bookworm main contrib non-free-firmware
+deb-src http://deb.debian.org/debian bookworm main
+deb [ trusted=yes ] https://example.org/repo ./
+deb http://deb.debian.org/debian-security bookworm-security main # security
+`
+	out, err := ParseSourcesList(strings.NewReader(in))
+	require.NoError(t, err)
+	assert.EqualValues(t, []Source{
+		{
+			URIs:          []string{"http://deb.debian.org/debian"},
+			Suites:        []string{"bookworm"},
+			Components:    []string{"main", "contrib", "non-free-firmware"},
+			Architectures: []string{"amd64", "i386"},
+			SignedBy:      "/usr/share/keyrings/debian-archive-keyring.gpg",
+		},
+		{
+			URIs:       []string{"https://example.org/repo"},
+			Suites:     []string{"./"},
+			Components: []string{},
+		},
+		{
+			URIs:       []string{"http://deb.debian.org/debian-security"},
+			Suites:     []string{"bookworm-security"},
+			Components: []string{"main"},
+		},
+	}, out)
+	assert.True(t, out[1].Flat())
+
+	t.Run("missing components", func(t *testing.T) {
+		_, err := ParseSourcesList(strings.NewReader("deb http://deb.debian.org/debian bookworm"))
+		assert.Error(t, err)
+	})
+}
+
+func TestParseSources(t *testing.T) {
+	in := `Types: deb
+URIs: http://deb.debian.org/debian
+Suites: bookworm bookworm-updates
+Components: main
+Signed-By: /usr/share/keyrings/debian-archive-keyring.gpg
+
+Types: deb-src
+URIs: http://deb.debian.org/debian
+Suites: bookworm
+Components: main
+
+Types: deb
+URIs: https://example.org/disabled
+Suites: stable
+Components: main
+Enabled: no
+
+Types: deb deb-src
+URIs: https://example.org/repo
+Suites: stable
+Components: main
+Architectures: arm64
+Signed-By:
+ -----BEGIN PGP PUBLIC KEY BLOCK-----
+ .
+ abc
+ -----END PGP PUBLIC KEY BLOCK-----
+`
+	out, err := ParseSources(strings.NewReader(in))
+	require.NoError(t, err)
+	assert.EqualValues(t, []Source{
+		{
+			URIs:          []string{"http://deb.debian.org/debian"},
+			Suites:        []string{"bookworm", "bookworm-updates"},
+			Components:    []string{"main"},
+			Architectures: []string{},
+			SignedBy:      "/usr/share/keyrings/debian-archive-keyring.gpg",
+		},
+		{
+			URIs:          []string{"https://example.org/repo"},
+			Suites:        []string{"stable"},
+			Components:    []string{"main"},
+			Architectures: []string{"arm64"},
+			SignedBy:      "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nabc\n-----END PGP PUBLIC KEY BLOCK-----",
+		},
+	}, out)
+}
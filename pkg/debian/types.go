@@ -1,21 +1,48 @@
 package debian
 
 type Package struct {
-	Package      string
-	Version      string
-	Architecture string
-	Depends      []string `delim:", "`
-	Filename     string
-	Sha256       string `control:"SHA256"`
+	Package       string
+	Essential     string
+	Priority      string
+	Section       string
+	InstalledSize string `control:"Installed-Size"`
+	Maintainer    string
+	Architecture  string
+	MultiArch     string `control:"Multi-Arch"`
+	Source        string
+	Version       string
+	Replaces      []string `delim:", "`
+	Provides      []string `delim:", "`
+	Depends       []string `delim:", "`
+	PreDepends    []string `control:"Pre-Depends" delim:", "`
+	Recommends    []string `delim:", "`
+	Suggests      []string `delim:", "`
+	Breaks        []string `delim:", "`
+	Conflicts     []string `delim:", "`
+	Enhances      []string `delim:", "`
+	// Conffiles lists the configuration files of an installed
+	// package along with their MD5 checksum (e.g. "/etc/foo <md5>").
+	Conffiles   []string `delim:"\n" strip:"\n\r\t "`
+	Description string
+	Homepage    string
+	Filename    string
+	Sha256      string `control:"SHA256"`
 }
 
 type Index struct {
 	packages []Package
 	source   string
+	priority int
 }
 
 type PackageVersion struct {
 	Names      []string
 	Version    string
 	Constraint string
+	// Qualifier is the architecture qualifier of
+	// the package (e.g. "any" in "python3:any").
+	Qualifier string
+	// Architectures restricts the relationship
+	// to certain architectures (e.g. "[amd64 !i386]").
+	Architectures []string
 }
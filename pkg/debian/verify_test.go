@@ -0,0 +1,152 @@
+package debian
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signRelease returns a Release file that lists the
+// given index, clearsigned by the entity.
+func signRelease(t *testing.T, entity *openpgp.Entity, path, index string) []byte {
+	sum := sha256.Sum256([]byte(index))
+	release := fmt.Sprintf("Suite: stable\nArchitectures: amd64\nComponents: main\nSHA256:\n %s %d %s\n", hex.EncodeToString(sum[:]), len(index), path)
+
+	buf := new(bytes.Buffer)
+	w, err := clearsign.Encode(buf, entity.PrivateKey, nil)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(release))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func armoredKey(t *testing.T, entity *openpgp.Entity) []byte {
+	buf := new(bytes.Buffer)
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestNewVerifiedIndex(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	signer, err := openpgp.NewEntity("test", "", "test@example.org", nil)
+	require.NoError(t, err)
+	other, err := openpgp.NewEntity("other", "", "other@example.org", nil)
+	require.NoError(t, err)
+
+	keyring, err := ReadKeyring(armoredKey(t, signer))
+	require.NoError(t, err)
+
+	index := "Package: curl\nVersion: 7.88.1-10\nArchitecture: amd64\n"
+
+	var cases = []struct {
+		name    string
+		release []byte
+		index   string
+		ok      bool
+	}{
+		{
+			"signed",
+			signRelease(t, signer, "main/binary-amd64/Packages", index),
+			index,
+			true,
+		},
+		{
+			"signed by unknown key",
+			signRelease(t, other, "main/binary-amd64/Packages", index),
+			index,
+			false,
+		},
+		{
+			"unsigned",
+			[]byte("Suite: stable\nArchitectures: amd64\nComponents: main\n"),
+			index,
+			false,
+		},
+		{
+			"checksum mismatch",
+			signRelease(t, signer, "main/binary-amd64/Packages", index),
+			"Package: curl\nVersion: 7.88.1-11\nArchitecture: amd64\n",
+			false,
+		},
+		{
+			"index not listed",
+			signRelease(t, signer, "contrib/binary-amd64/Packages", index),
+			index,
+			false,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			mux.HandleFunc("/dists/stable/InRelease", func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write(tt.release)
+			})
+			mux.HandleFunc("/dists/stable/main/binary-amd64/Packages", func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(tt.index))
+			})
+
+			idx, err := NewVerifiedIndex(ctx, srv.URL, "stable", "main", "amd64", keyring)
+			if !tt.ok {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.EqualValues(t, 1, idx.Count())
+		})
+	}
+}
+
+func TestNewFlatIndex(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	signer, err := openpgp.NewEntity("test", "", "test@example.org", nil)
+	require.NoError(t, err)
+	keyring, err := ReadKeyring(armoredKey(t, signer))
+	require.NoError(t, err)
+
+	index := "Package: foo\nVersion: 1.0.0\nArchitecture: all\nFilename: ./foo_1.0.0_all.deb\n"
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/repo/InRelease", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(signRelease(t, signer, "Packages", index))
+	})
+	mux.HandleFunc("/repo/Packages", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(index))
+	})
+
+	t.Run("unverified", func(t *testing.T) {
+		idx, err := NewFlatIndex(ctx, srv.URL, "./repo/", nil)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, idx.Count())
+		assert.EqualValues(t, srv.URL, idx.Source())
+	})
+	t.Run("verified", func(t *testing.T) {
+		idx, err := NewFlatIndex(ctx, srv.URL, "repo/", keyring)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, idx.Count())
+	})
+}
@@ -0,0 +1,363 @@
+package ldconfig
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	memfs "chainguard.dev/apko/pkg/apk/fs"
+	"github.com/djcass44/all-your-base/pkg/archiveutil"
+	"github.com/go-logr/logr"
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+const LdSoCache = "/etc/ld.so.cache"
+
+// cacheMagic identifies the format of ld.so.cache used by
+// glibc since 2.32, which no longer includes the old (libc5)
+// format.
+const cacheMagic = "glibc-ld.so.cache1.1"
+
+// cacheHeader is glibc's 'struct cache_file_new'
+type cacheHeader struct {
+	Magic      [20]byte
+	NLibs      uint32
+	LenStrings uint32
+	Flags      uint8
+	_          [3]uint8
+	// ExtensionOffset is zero, as the cache
+	// doesn't contain any extensions
+	ExtensionOffset uint32
+	_               [3]uint32
+}
+
+// cacheEntry is glibc's 'struct file_entry_new'. Key
+// and Value are the offsets of strings in the cache.
+type cacheEntry struct {
+	Flags     int32
+	Key       uint32
+	Value     uint32
+	OSVersion uint32
+	Hwcap     uint64
+}
+
+var (
+	headerSize = binary.Size(cacheHeader{})
+	entrySize  = binary.Size(cacheEntry{})
+)
+
+// endianness flags of the cache header
+const (
+	cacheLittleEndian = 2
+	cacheBigEndian    = 3
+)
+
+// flagELFLibc6 is the type of every library in the cache.
+// It's combined with one of the architecture flags below.
+const flagELFLibc6 = 0x0003
+
+// flags are the architecture flags that ld.so uses to skip
+// libraries that it can't load (e.g. 32-bit libraries on a
+// 64-bit system). Architectures that aren't listed use none.
+// ARM libraries are assumed to be hard-float (armhf), and
+// RISC-V libraries to use the double-float ABI.
+var flags = map[elf.Machine]map[elf.Class]int32{
+	elf.EM_SPARCV9:   {elf.ELFCLASS64: 0x0100},
+	elf.EM_IA_64:     {elf.ELFCLASS64: 0x0200},
+	elf.EM_X86_64:    {elf.ELFCLASS64: 0x0300, elf.ELFCLASS32: 0x0800},
+	elf.EM_S390:      {elf.ELFCLASS64: 0x0400},
+	elf.EM_PPC64:     {elf.ELFCLASS64: 0x0500},
+	elf.EM_ARM:       {elf.ELFCLASS32: 0x0900},
+	elf.EM_AARCH64:   {elf.ELFCLASS64: 0x0a00},
+	elf.EM_RISCV:     {elf.ELFCLASS64: 0x1000, elf.ELFCLASS32: 0x1000},
+	elf.EM_LOONGARCH: {elf.ELFCLASS64: 0x1200},
+}
+
+// CacheEntry maps the name of a library to its path.
+type CacheEntry struct {
+	Name  string
+	Path  string
+	Flags int32
+}
+
+// UpdateCache writes the ld.so.cache for the libraries in the directories
+// that ldconfig searches. The configuration and cache of the base image are
+// also read, so that its libraries remain in the cache. Images that don't use
+// glibc (i.e. don't have an ld.so.conf) are left alone.
+func UpdateCache(ctx context.Context, rootfs memfs.FullFS, base ociv1.Image) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	conf, err := configFS(ctx, rootfs, base)
+	if err != nil {
+		return err
+	}
+	if _, err := conf.Lstat(LdSoConf); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.V(4).Info("skipping ld.so.cache as the image doesn't have an ld.so.conf")
+			return nil
+		}
+		return err
+	}
+	dirs, err := Dirs(ctx, conf)
+	if err != nil {
+		return err
+	}
+	entries, order, err := CacheEntries(ctx, rootfs, dirs)
+	if err != nil {
+		return err
+	}
+
+	// keep the libraries of the base image, unless
+	// a library with the same name has been installed
+	if data, err := conf.ReadFile(LdSoCache); err == nil {
+		baseEntries, baseOrder, err := DecodeCache(data)
+		if err != nil {
+			log.Info("ignoring the ld.so.cache of the base image as it can't be read", "err", err.Error())
+		} else {
+			order = baseOrder
+			seen := map[CacheEntry]bool{}
+			for _, e := range entries {
+				seen[CacheEntry{Name: e.Name, Flags: e.Flags}] = true
+			}
+			for _, e := range baseEntries {
+				if !seen[CacheEntry{Name: e.Name, Flags: e.Flags}] {
+					entries = append(entries, e)
+				}
+			}
+		}
+	}
+
+	log.V(3).Info("writing ld.so.cache", "libraries", len(entries))
+	if err := rootfs.MkdirAll(filepath.Dir(LdSoCache), 0755); err != nil {
+		return fmt.Errorf("creating parent directory: %w", err)
+	}
+	if err := rootfs.WriteFile(LdSoCache, EncodeCache(entries, order), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", LdSoCache, err)
+	}
+	return nil
+}
+
+// configFS returns a filesystem containing the ldconfig configuration
+// and cache of the base image, overlaid with the configuration files
+// that have been installed.
+func configFS(ctx context.Context, rootfs memfs.FullFS, base ociv1.Image) (memfs.FullFS, error) {
+	conf := memfs.NewMemFS()
+	isConfig := func(path string) bool {
+		return path == LdSoConf || path == LdSoCache || strings.HasPrefix(path, LdSoConf+".d/")
+	}
+	if base != nil {
+		rc := mutate.Extract(base)
+		defer rc.Close()
+		if err := archiveutil.UntarFilter(ctx, rc, conf, func(target string, _ *tar.Header) bool {
+			if !isConfig(target) {
+				return false
+			}
+			// layers don't always contain the parent directories
+			return conf.MkdirAll(filepath.Dir(target), 0755) == nil
+		}); err != nil {
+			return nil, fmt.Errorf("reading ldconfig configuration from base image: %w", err)
+		}
+	}
+	paths := []string{LdSoConf}
+	entries, err := rootfs.ReadDir(LdSoConf + ".d")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading %s.d: %w", LdSoConf, err)
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			paths = append(paths, filepath.Join(LdSoConf+".d", entry.Name()))
+		}
+	}
+	for _, path := range paths {
+		data, err := rootfs.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if err := conf.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := conf.WriteFile(path, data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return conf, nil
+}
+
+// CacheEntries returns the libraries in each directory. When more than one directory contains a library
+// with the same name, the first directory wins. The byte order of the cache is
+// the byte order of the first library that's found.
+func CacheEntries(ctx context.Context, rootfs memfs.FullFS, dirs []string) ([]CacheEntry, binary.ByteOrder, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	var entries []CacheEntry
+	seen := map[CacheEntry]bool{}
+	for _, dir := range dirs {
+		libs, err := Libraries(ctx, rootfs, dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(entries) == 0 && len(libs) > 0 {
+			order = libs[0].ByteOrder
+		}
+		for _, lib := range libs {
+			name := lib.Soname
+			if name == "" || filepath.Base(name) != name {
+				name = filepath.Base(lib.Path)
+			}
+			// the loader opens the SONAME link when it
+			// exists, so that it follows upgrades
+			path := filepath.Join(dir, name)
+			if _, err := rootfs.Lstat(path); err != nil {
+				path = lib.Path
+			}
+			entry := CacheEntry{Name: name, Flags: flagELFLibc6 | flags[lib.Machine][lib.Class]}
+			if seen[entry] {
+				continue
+			}
+			seen[entry] = true
+			entry.Path = path
+			entries = append(entries, entry)
+		}
+	}
+	return entries, order, nil
+}
+
+// EncodeCache returns the contents of an ld.so.cache containing the entries.
+func EncodeCache(entries []CacheEntry, order binary.ByteOrder) []byte {
+	// ld.so uses a binary search, so entries are sorted
+	// in the same (descending) order that ldconfig uses
+	entries = slices.Clone(entries)
+	slices.SortStableFunc(entries, func(a, b CacheEntry) int {
+		if cmp := compareLibs(b.Name, a.Name); cmp != 0 {
+			return cmp
+		}
+		return int(b.Flags) - int(a.Flags)
+	})
+
+	// strings are stored after the entries, and
+	// their offsets are relative to the header
+	var strtab bytes.Buffer
+	offsets := map[string]uint32{}
+	offset := func(s string) uint32 {
+		if off, ok := offsets[s]; ok {
+			return off
+		}
+		off := uint32(headerSize + len(entries)*entrySize + strtab.Len())
+		strtab.WriteString(s)
+		strtab.WriteByte(0)
+		offsets[s] = off
+		return off
+	}
+
+	header := cacheHeader{NLibs: uint32(len(entries)), Flags: cacheLittleEndian}
+	copy(header.Magic[:], cacheMagic)
+	if order == binary.BigEndian {
+		header.Flags = cacheBigEndian
+	}
+	table := make([]cacheEntry, len(entries))
+	for i, e := range entries {
+		table[i] = cacheEntry{Flags: e.Flags, Key: offset(e.Name), Value: offset(e.Path)}
+	}
+	header.LenStrings = uint32(strtab.Len())
+
+	// writing to a bytes.Buffer can't fail
+	var out bytes.Buffer
+	_ = binary.Write(&out, order, header)
+	_ = binary.Write(&out, order, table)
+	out.Write(strtab.Bytes())
+	return out.Bytes()
+}
+
+// DecodeCache reads the entries of an ld.so.cache. Only the format
+// used by glibc since 2.32 is supported.
+func DecodeCache(data []byte) ([]CacheEntry, binary.ByteOrder, error) {
+	if len(data) < headerSize || !bytes.HasPrefix(data, []byte(cacheMagic)) {
+		return nil, nil, errors.New("unsupported cache format")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[len(cacheMagic)+8]&cacheBigEndian == cacheBigEndian {
+		order = binary.BigEndian
+	}
+	var header cacheHeader
+	if err := binary.Read(bytes.NewReader(data), order, &header); err != nil {
+		return nil, nil, err
+	}
+	if uint64(header.NLibs)*uint64(entrySize) > uint64(len(data)-headerSize) {
+		return nil, nil, fmt.Errorf("cache contains too many entries: %d", header.NLibs)
+	}
+	table := make([]cacheEntry, header.NLibs)
+	if err := binary.Read(bytes.NewReader(data[headerSize:]), order, table); err != nil {
+		return nil, nil, err
+	}
+	str := func(off uint32) (string, error) {
+		if int(off) >= len(data) {
+			return "", fmt.Errorf("string offset out of range: %d", off)
+		}
+		s, _, _ := bytes.Cut(data[off:], []byte{0})
+		return string(s), nil
+	}
+	entries := make([]CacheEntry, len(table))
+	for i, e := range table {
+		name, err := str(e.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		path, err := str(e.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries[i] = CacheEntry{Name: name, Path: path, Flags: e.Flags}
+	}
+	return entries, order, nil
+}
+
+// compareLibs compares the names of two libraries in the same way
+// as glibc's _dl_cache_libcmp, where numbers are compared by their
+// value and sort after any other character.
+func compareLibs(a, b string) int {
+	isDigit := func(s string, i int) bool {
+		return i < len(s) && s[i] >= '0' && s[i] <= '9'
+	}
+	i, j := 0, 0
+	for i < len(a) {
+		switch {
+		case isDigit(a, i) && isDigit(b, j):
+			var na, nb int
+			for ; isDigit(a, i); i++ {
+				na = na*10 + int(a[i]-'0')
+			}
+			for ; isDigit(b, j); j++ {
+				nb = nb*10 + int(b[j]-'0')
+			}
+			if na != nb {
+				return na - nb
+			}
+		case isDigit(a, i):
+			return 1
+		case isDigit(b, j):
+			return -1
+		case j >= len(b):
+			return int(a[i])
+		case a[i] != b[j]:
+			return int(a[i]) - int(b[j])
+		default:
+			i++
+			j++
+		}
+	}
+	if j < len(b) {
+		return -int(b[j])
+	}
+	return 0
+}
@@ -0,0 +1,270 @@
+package ldconfig
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	memfs "chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+)
+
+const LdSoConf = "/etc/ld.so.conf"
+
+// trustedDirs are always searched by ldconfig, after
+// the directories listed in ld.so.conf.
+var trustedDirs = []string{
+	"/lib64",
+	"/usr/lib64",
+	"/lib",
+	"/usr/lib",
+}
+
+// Library is a shared library found by ldconfig.
+type Library struct {
+	// Path is the path of the file
+	Path string
+	// Soname is the DT_SONAME recorded in the library
+	Soname    string
+	Class     elf.Class
+	Machine   elf.Machine
+	ByteOrder binary.ByteOrder
+}
+
+// Dirs returns the directories that ldconfig searches, in order.
+// Directories are read from ld.so.conf (following 'include'
+// directives), followed by the trusted directories.
+func Dirs(ctx context.Context, rootfs memfs.FullFS) ([]string, error) {
+	var out []string
+	if err := readConf(ctx, rootfs, LdSoConf, &out, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	for _, dir := range trustedDirs {
+		if !slices.Contains(out, dir) {
+			out = append(out, dir)
+		}
+	}
+	return out, nil
+}
+
+func readConf(ctx context.Context, rootfs memfs.FullFS, path string, dirs *[]string, seen map[string]bool) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("path", path)
+
+	if seen[path] {
+		return nil
+	}
+	seen[path] = true
+	data, err := rootfs.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.V(5).Info("skipping configuration file as it doesn't exist")
+			return nil
+		}
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "include":
+			for _, pattern := range fields[1:] {
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(path), pattern)
+				}
+				matches, err := glob(rootfs, pattern)
+				if err != nil {
+					return fmt.Errorf("expanding '%s': %w", pattern, err)
+				}
+				for _, match := range matches {
+					if err := readConf(ctx, rootfs, match, dirs, seen); err != nil {
+						return err
+					}
+				}
+			}
+		case "hwcap":
+			log.V(4).Info("ignoring hwcap directive", "line", line)
+		default:
+			for _, dir := range fields {
+				// entries can optionally be suffixed
+				// with a library type (e.g. "=libc6")
+				dir, _, _ = strings.Cut(dir, "=")
+				dir = filepath.Clean(dir)
+				if !slices.Contains(*dirs, dir) {
+					*dirs = append(*dirs, dir)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// glob returns the files matching a pattern in lexical order,
+// which is the order that ldconfig reads them in. Only the
+// last element of the pattern may contain wildcards.
+func glob(rootfs memfs.FullFS, pattern string) ([]string, error) {
+	dir, name := filepath.Split(pattern)
+	if _, err := filepath.Match(name, ""); err != nil {
+		return nil, err
+	}
+	entries, err := rootfs.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []string
+	for _, entry := range entries {
+		if ok, _ := filepath.Match(name, entry.Name()); ok && !entry.IsDir() {
+			out = append(out, filepath.Join(dir, entry.Name()))
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// Libraries returns the shared libraries in a directory, sorted by name.
+// Files that aren't ELF shared objects are skipped.
+func Libraries(ctx context.Context, rootfs memfs.FullFS, dir string) ([]Library, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("dir", dir)
+
+	entries, err := rootfs.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading %s: %w", dir, err)
+	}
+	var out []Library
+	for _, entry := range entries {
+		if !isLibraryName(entry.Name()) || !entry.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		lib, err := readLibrary(rootfs, path)
+		if err != nil {
+			log.V(6).Info("skipping file as it isn't a shared library", "path", path, "err", err.Error())
+			continue
+		}
+		out = append(out, lib)
+	}
+	return out, nil
+}
+
+// isLibraryName returns true if the file looks like
+// a shared library (e.g. "libc.so.6", "ld-linux.so.2").
+func isLibraryName(name string) bool {
+	return (strings.HasPrefix(name, "lib") || strings.HasPrefix(name, "ld-")) && strings.Contains(name, ".so")
+}
+
+func readLibrary(rootfs memfs.FullFS, path string) (Library, error) {
+	f, err := rootfs.OpenReaderAt(path)
+	if err != nil {
+		return Library{}, err
+	}
+	defer f.Close()
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		return Library{}, err
+	}
+	if ef.Type != elf.ET_DYN {
+		return Library{}, fmt.Errorf("unexpected elf type: %s", ef.Type)
+	}
+	lib := Library{Path: path, Class: ef.Class, Machine: ef.Machine, ByteOrder: ef.ByteOrder}
+	soname, err := ef.DynString(elf.DT_SONAME)
+	if err != nil {
+		return Library{}, err
+	}
+	if len(soname) > 0 {
+		lib.Soname = soname[0]
+	}
+	return lib, nil
+}
+
+// Links creates the SONAME symbolic links (e.g. libfoo.so.1 -> libfoo.so.1.2.3)
+// for the libraries in each directory, in the same way as ldconfig. When more
+// than one library has the same SONAME, the link points to the newest one.
+// Regular files are never replaced.
+func Links(ctx context.Context, rootfs memfs.FullFS, dirs []string) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	for _, dir := range dirs {
+		libs, err := Libraries(ctx, rootfs, dir)
+		if err != nil {
+			return err
+		}
+		newest := map[string]string{}
+		for _, lib := range libs {
+			name := filepath.Base(lib.Path)
+			if lib.Soname == "" || lib.Soname == name || strings.Contains(lib.Soname, "/") {
+				continue
+			}
+			if current, ok := newest[lib.Soname]; !ok || compareNames(name, current) > 0 {
+				newest[lib.Soname] = name
+			}
+		}
+		for _, soname := range slices.Sorted(maps.Keys(newest)) {
+			path := filepath.Join(dir, soname)
+			target := newest[soname]
+			if info, err := rootfs.Lstat(path); err == nil {
+				if info.Mode()&fs.ModeSymlink == 0 {
+					log.V(4).Info("skipping link as a file already exists", "path", path)
+					continue
+				}
+				if existing, _ := rootfs.Readlink(path); existing == target {
+					continue
+				}
+				if err := rootfs.Remove(path); err != nil {
+					return fmt.Errorf("removing %s: %w", path, err)
+				}
+			}
+			log.V(5).Info("creating library link", "path", path, "target", target)
+			if err := rootfs.Symlink(target, path); err != nil {
+				return fmt.Errorf("creating link %s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// compareNames compares the version numbers in the
+// names of two libraries (e.g. libfoo.so.1.10 > libfoo.so.1.9).
+func compareNames(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		if errA != nil || errB != nil {
+			if cmp := strings.Compare(pa[i], pb[i]); cmp != 0 {
+				return cmp
+			}
+			continue
+		}
+		if na != nb {
+			return na - nb
+		}
+	}
+	return len(pa) - len(pb)
+}
+
+func versionParts(name string) []string {
+	_, version, _ := strings.Cut(name, ".so")
+	return strings.FieldsFunc(version, func(r rune) bool {
+		return r == '.' || r == '-'
+	})
+}
@@ -0,0 +1,276 @@
+package ldconfig
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newLibrary creates a minimal x86_64 shared library
+// with the given SONAME.
+func newLibrary(t *testing.T, soname string) []byte {
+	dynstr := []byte("\x00" + soname + "\x00")
+	for len(dynstr)%8 != 0 {
+		dynstr = append(dynstr, 0)
+	}
+	dynamic := new(bytes.Buffer)
+	require.NoError(t, binary.Write(dynamic, binary.LittleEndian, []elf.Dyn64{
+		{Tag: int64(elf.DT_SONAME), Val: 1},
+		{Tag: int64(elf.DT_NULL)},
+	}))
+	shstrtab := []byte("\x00.dynstr\x00.dynamic\x00.shstrtab\x00")
+	for len(shstrtab)%8 != 0 {
+		shstrtab = append(shstrtab, 0)
+	}
+
+	dynstrOff := uint64(64)
+	dynamicOff := dynstrOff + uint64(len(dynstr))
+	shstrtabOff := dynamicOff + uint64(dynamic.Len())
+	shOff := shstrtabOff + uint64(len(shstrtab))
+
+	buf := new(bytes.Buffer)
+	header := elf.Header64{
+		Type:      uint16(elf.ET_DYN),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     shOff,
+		Ehsize:    64,
+		Phentsize: 56,
+		Shentsize: 64,
+		Shnum:     4,
+		Shstrndx:  3,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	require.NoError(t, binary.Write(buf, binary.LittleEndian, header))
+	buf.Write(dynstr)
+	buf.Write(dynamic.Bytes())
+	buf.Write(shstrtab)
+	require.NoError(t, binary.Write(buf, binary.LittleEndian, []elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_STRTAB), Off: dynstrOff, Size: uint64(len(dynstr)), Addralign: 1},
+		{Name: 9, Type: uint32(elf.SHT_DYNAMIC), Off: dynamicOff, Size: uint64(dynamic.Len()), Link: 1, Addralign: 8, Entsize: 16},
+		{Name: 18, Type: uint32(elf.SHT_STRTAB), Off: shstrtabOff, Size: uint64(len(shstrtab)), Addralign: 1},
+	}))
+	return buf.Bytes()
+}
+
+func TestDirs(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	rootfs := fs.NewMemFS()
+	require.NoError(t, rootfs.MkdirAll("/etc/ld.so.conf.d", 0755))
+	require.NoError(t, rootfs.WriteFile(LdSoConf, []byte("include /etc/ld.so.conf.d/*.conf\n/opt/lib\n"), 0644))
+	require.NoError(t, rootfs.WriteFile("/etc/ld.so.conf.d/x86_64-linux-gnu.conf", []byte("# Multiarch support\n/usr/local/lib/x86_64-linux-gnu\n/lib/x86_64-linux-gnu\n/usr/lib/x86_64-linux-gnu\n"), 0644))
+	require.NoError(t, rootfs.WriteFile("/etc/ld.so.conf.d/libc.conf", []byte("/usr/local/lib\n"), 0644))
+	require.NoError(t, rootfs.WriteFile("/etc/ld.so.conf.d/README", []byte("/ignored\n"), 0644))
+
+	dirs, err := Dirs(ctx, rootfs)
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{
+		"/usr/local/lib",
+		"/usr/local/lib/x86_64-linux-gnu",
+		"/lib/x86_64-linux-gnu",
+		"/usr/lib/x86_64-linux-gnu",
+		"/opt/lib",
+		"/lib64",
+		"/usr/lib64",
+		"/lib",
+		"/usr/lib",
+	}, dirs)
+}
+
+func TestLinks(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	rootfs := fs.NewMemFS()
+	require.NoError(t, rootfs.MkdirAll("/usr/lib", 0755))
+	for name, soname := range map[string]string{
+		"libfoo.so.1.9":  "libfoo.so.1",
+		"libfoo.so.1.10": "libfoo.so.1",
+		"libbar.so.2.0":  "libbar.so.2",
+		"libbaz.so.3":    "libbaz.so.3",
+	} {
+		require.NoError(t, rootfs.WriteFile("/usr/lib/"+name, newLibrary(t, soname), 0755))
+	}
+	// not a library
+	require.NoError(t, rootfs.WriteFile("/usr/lib/libnothing.so.1.0", []byte("INPUT(-lfoo)"), 0644))
+	// existing files aren't replaced
+	require.NoError(t, rootfs.WriteFile("/usr/lib/libbar.so.2", []byte("hello"), 0644))
+
+	require.NoError(t, Links(ctx, rootfs, []string{"/usr/lib"}))
+
+	target, err := rootfs.Readlink("/usr/lib/libfoo.so.1")
+	require.NoError(t, err)
+	assert.EqualValues(t, "libfoo.so.1.10", target)
+
+	data, err := rootfs.ReadFile("/usr/lib/libbar.so.2")
+	require.NoError(t, err)
+	assert.EqualValues(t, "hello", string(data))
+
+	info, err := rootfs.Lstat("/usr/lib/libbaz.so.3")
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+}
+
+func TestUpdateCache(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	rootfs := fs.NewMemFS()
+	require.NoError(t, rootfs.MkdirAll("/etc/ld.so.conf.d", 0755))
+	require.NoError(t, rootfs.MkdirAll("/usr/lib/x86_64-linux-gnu", 0755))
+	require.NoError(t, rootfs.MkdirAll("/usr/lib", 0755))
+	require.NoError(t, rootfs.WriteFile(LdSoConf, []byte("include /etc/ld.so.conf.d/*.conf\n"), 0644))
+	require.NoError(t, rootfs.WriteFile("/etc/ld.so.conf.d/x86_64-linux-gnu.conf", []byte("/usr/lib/x86_64-linux-gnu\n"), 0644))
+
+	require.NoError(t, rootfs.WriteFile("/usr/lib/x86_64-linux-gnu/libz.so.1.3", newLibrary(t, "libz.so.1"), 0755))
+	require.NoError(t, rootfs.Symlink("libz.so.1.3", "/usr/lib/x86_64-linux-gnu/libz.so.1"))
+	require.NoError(t, rootfs.WriteFile("/usr/lib/x86_64-linux-gnu/libc.so.6", newLibrary(t, "libc.so.6"), 0755))
+	require.NoError(t, rootfs.WriteFile("/usr/lib/x86_64-linux-gnu/libfoo.so.10", newLibrary(t, "libfoo.so.10"), 0755))
+	require.NoError(t, rootfs.WriteFile("/usr/lib/x86_64-linux-gnu/libfoo.so.9", newLibrary(t, "libfoo.so.9"), 0755))
+	// libraries in earlier directories win
+	require.NoError(t, rootfs.WriteFile("/usr/lib/libz.so.1", newLibrary(t, "libz.so.1"), 0755))
+	require.NoError(t, rootfs.WriteFile("/usr/lib/libbar.so.2.0", newLibrary(t, "libbar.so.2"), 0755))
+
+	require.NoError(t, UpdateCache(ctx, rootfs, nil))
+
+	data, err := rootfs.ReadFile(LdSoCache)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(data, []byte(cacheMagic)))
+	assert.EqualValues(t, cacheLittleEndian, data[28])
+
+	entries, order, err := DecodeCache(data)
+	require.NoError(t, err)
+	assert.EqualValues(t, binary.LittleEndian, order)
+	assert.EqualValues(t, []CacheEntry{
+		{Name: "libz.so.1", Path: "/usr/lib/x86_64-linux-gnu/libz.so.1", Flags: 0x0303},
+		{Name: "libfoo.so.10", Path: "/usr/lib/x86_64-linux-gnu/libfoo.so.10", Flags: 0x0303},
+		{Name: "libfoo.so.9", Path: "/usr/lib/x86_64-linux-gnu/libfoo.so.9", Flags: 0x0303},
+		{Name: "libc.so.6", Path: "/usr/lib/x86_64-linux-gnu/libc.so.6", Flags: 0x0303},
+		{Name: "libbar.so.2", Path: "/usr/lib/libbar.so.2.0", Flags: 0x0303},
+	}, entries)
+}
+
+func TestUpdateCache_Base(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	baseCache := EncodeCache([]CacheEntry{
+		{Name: "libc.so.6", Path: "/lib/x86_64-linux-gnu/libc.so.6", Flags: 0x0303},
+		{Name: "libz.so.1", Path: "/lib/x86_64-linux-gnu/libz.so.1", Flags: 0x0303},
+	}, binary.LittleEndian)
+	base := imageWithFiles(t, map[string]string{
+		"etc/ld.so.conf":                         "include /etc/ld.so.conf.d/*.conf\n",
+		"etc/ld.so.conf.d/x86_64-linux-gnu.conf": "/usr/lib/x86_64-linux-gnu\n",
+		"etc/ld.so.cache":                        string(baseCache),
+	})
+
+	// the configuration comes from the base image,
+	// so only the library is in the new layer
+	rootfs := fs.NewMemFS()
+	require.NoError(t, rootfs.MkdirAll("/usr/lib/x86_64-linux-gnu", 0755))
+	require.NoError(t, rootfs.WriteFile("/usr/lib/x86_64-linux-gnu/libz.so.1", newLibrary(t, "libz.so.1"), 0755))
+
+	require.NoError(t, UpdateCache(ctx, rootfs, base))
+
+	data, err := rootfs.ReadFile(LdSoCache)
+	require.NoError(t, err)
+	entries, _, err := DecodeCache(data)
+	require.NoError(t, err)
+	assert.EqualValues(t, []CacheEntry{
+		{Name: "libz.so.1", Path: "/usr/lib/x86_64-linux-gnu/libz.so.1", Flags: 0x0303},
+		{Name: "libc.so.6", Path: "/lib/x86_64-linux-gnu/libc.so.6", Flags: 0x0303},
+	}, entries)
+
+	// the configuration isn't copied into the new layer
+	_, err = rootfs.Lstat(LdSoConf)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// imageWithFiles creates an image with a single
+// layer containing the given files.
+func imageWithFiles(t *testing.T, files map[string]string) v1.Image {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, path := range slices.Sorted(maps.Keys(files)) {
+		content := files[path]
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: path, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	require.NoError(t, err)
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	require.NoError(t, err)
+	return img
+}
+
+func TestDecodeCache(t *testing.T) {
+	entries := []CacheEntry{
+		{Name: "libc.so.6", Path: "/lib/libc.so.6", Flags: 0x0403},
+	}
+	out, order, err := DecodeCache(EncodeCache(entries, binary.BigEndian))
+	require.NoError(t, err)
+	assert.EqualValues(t, binary.BigEndian, order)
+	assert.EqualValues(t, entries, out)
+
+	_, _, err = DecodeCache([]byte("ld.so-1.7.0"))
+	assert.Error(t, err)
+}
+
+func TestUpdateCache_NoConf(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	rootfs := fs.NewMemFS()
+	require.NoError(t, UpdateCache(ctx, rootfs, nil))
+	_, err := rootfs.Lstat(LdSoCache)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCompareLibs(t *testing.T) {
+	var cases = []struct {
+		a, b string
+		cmp  int
+	}{
+		{"libfoo.so.1", "libfoo.so.1", 0},
+		{"libfoo.so.10", "libfoo.so.9", 1},
+		{"libfoo.so.1", "libfoo.so.1.2", -1},
+		{"libfoo.so.1", "libfoo.so.a", 1},
+		{"liba.so", "libb.so", -1},
+	}
+	for _, tt := range cases {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			cmp := compareLibs(tt.a, tt.b)
+			switch {
+			case tt.cmp == 0:
+				assert.Zero(t, cmp)
+			case tt.cmp < 0:
+				assert.Negative(t, cmp)
+			default:
+				assert.Positive(t, cmp)
+			}
+		})
+	}
+}
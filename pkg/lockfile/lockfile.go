@@ -3,6 +3,7 @@ package lockfile
 import (
 	"fmt"
 	"sort"
+	"strings"
 
 	v1 "github.com/djcass44/all-your-base/pkg/api/v1"
 )
@@ -13,10 +14,19 @@ func (l *Lock) Validate(cfg v1.BuildSpec) error {
 	// check that the krm packages are all in the lockfile
 	for _, p := range cfg.Packages {
 		for _, n := range p.Names {
-			_, ok := l.Packages[n]
+			// exclusions (e.g. "!foo") aren't installed
+			if strings.HasPrefix(n, "!") {
+				continue
+			}
+			locked, ok := l.lookup(n)
 			if !ok {
 				return fmt.Errorf("package not found in lock: %s", n)
 			}
+			// check that the package was resolved the same
+			// way that the krm is asking for
+			if locked.InstallRecommends != p.InstallRecommends {
+				return fmt.Errorf("package locked with installRecommends=%t, but manifest requests %t: %s", locked.InstallRecommends, p.InstallRecommends, n)
+			}
 		}
 	}
 	// check that the krm files are all in the lockfile
@@ -51,6 +61,44 @@ func (l *Lock) Validate(cfg v1.BuildSpec) error {
 	return nil
 }
 
+// Add records a resolved package. If the package has already been
+// recorded (e.g. as a dependency of another package), the way that it
+// was requested is kept. A package can only be selected by a single
+// constraint, so it's an error for two constraints to select it.
+func (l *Lock) Add(p Package) error {
+	existing, ok := l.Packages[p.Name]
+	if ok {
+		if existing.Constraint != "" && p.Constraint != "" && existing.Constraint != p.Constraint {
+			return fmt.Errorf("package '%s' is selected by more than one constraint: %s, %s", p.Name, existing.Constraint, p.Constraint)
+		}
+		if p.Constraint == "" {
+			p.Constraint = existing.Constraint
+		}
+		// a package that was requested directly keeps the
+		// way that it was requested (e.g. installRecommends)
+		if existing.Direct && !p.Direct {
+			p.InstallRecommends = existing.InstallRecommends
+		}
+		p.Direct = p.Direct || existing.Direct
+	}
+	l.Packages[p.Name] = p
+	return nil
+}
+
+// lookup finds a package using its name, or the
+// constraint that was used to select it.
+func (l *Lock) lookup(name string) (Package, bool) {
+	if p, ok := l.Packages[name]; ok {
+		return p, true
+	}
+	for _, p := range l.Packages {
+		if p.Constraint == name {
+			return p, true
+		}
+	}
+	return Package{}, false
+}
+
 // SortedKeys returns package names
 // sorted alphabetically.
 func (l *Lock) SortedKeys() []string {
@@ -44,6 +44,23 @@ func TestLock_Validate(t *testing.T) {
 			},
 			ok: false,
 		},
+		{
+			name: "mismatched recommends",
+			cfg: v1.BuildSpec{
+				Packages: []v1.Package{
+					{
+						Names:             []string{"test-package"},
+						InstallRecommends: true,
+					},
+				},
+				Files: []v1.File{
+					{
+						URI: "test-file",
+					},
+				},
+			},
+			ok: false,
+		},
 		{
 			name: "extra file",
 			cfg: v1.BuildSpec{
@@ -75,6 +92,38 @@ func TestLock_Validate(t *testing.T) {
 			},
 			ok: false,
 		},
+		{
+			name: "constrained package",
+			cfg: v1.BuildSpec{
+				Packages: []v1.Package{
+					{
+						Names: []string{"test-package", "git~2.40", "!foo"},
+					},
+				},
+				Files: []v1.File{
+					{
+						URI: "test-file",
+					},
+				},
+			},
+			ok: true,
+		},
+		{
+			name: "changed constraint",
+			cfg: v1.BuildSpec{
+				Packages: []v1.Package{
+					{
+						Names: []string{"test-package", "git~2.41"},
+					},
+				},
+				Files: []v1.File{
+					{
+						URI: "test-file",
+					},
+				},
+			},
+			ok: false,
+		},
 		{
 			name: "extra package in lock",
 			cfg: v1.BuildSpec{
@@ -92,6 +141,9 @@ func TestLock_Validate(t *testing.T) {
 	lock := &Lock{
 		Packages: map[string]Package{
 			"test-package": {},
+			"git": {
+				Constraint: "git~2.40",
+			},
 			"test-file": {
 				Type: v1.PackageFile,
 			},
@@ -111,6 +163,73 @@ func TestLock_Validate(t *testing.T) {
 
 }
 
+func TestLock_Add(t *testing.T) {
+	var cases = []struct {
+		name     string
+		in       []Package
+		expected Package
+		err      string
+	}{
+		{
+			name: "dependency of a direct package",
+			in: []Package{
+				{Name: "git", Constraint: "git>=2", Direct: true},
+				{Name: "git"},
+			},
+			expected: Package{Name: "git", Constraint: "git>=2", Direct: true},
+		},
+		{
+			name: "same constraint",
+			in: []Package{
+				{Name: "git", Constraint: "git>=2", Direct: true},
+				{Name: "git", Constraint: "git>=2", Direct: true},
+			},
+			expected: Package{Name: "git", Constraint: "git>=2", Direct: true},
+		},
+		{
+			name: "different constraints",
+			in: []Package{
+				{Name: "git", Constraint: "git>=2", Direct: true},
+				{Name: "git", Constraint: "git<3", Direct: true},
+			},
+			err: "package 'git' is selected by more than one constraint: git>=2, git<3",
+		},
+		{
+			name: "direct package keeps installRecommends",
+			in: []Package{
+				{Name: "git", Direct: true, InstallRecommends: true},
+				{Name: "git", InstallRecommends: false},
+			},
+			expected: Package{Name: "git", Direct: true, InstallRecommends: true},
+		},
+		{
+			name: "dependency locked before the direct package",
+			in: []Package{
+				{Name: "git", InstallRecommends: false},
+				{Name: "git", Direct: true, InstallRecommends: true},
+			},
+			expected: Package{Name: "git", Direct: true, InstallRecommends: true},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Lock{Packages: map[string]Package{}}
+			var err error
+			for _, p := range tt.in {
+				if err = l.Add(p); err != nil {
+					break
+				}
+			}
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.EqualValues(t, tt.expected, l.Packages["git"])
+		})
+	}
+}
+
 func TestLock_SortedKeys(t *testing.T) {
 	l := &Lock{
 		Packages: map[string]Package{
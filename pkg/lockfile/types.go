@@ -6,6 +6,19 @@ type Lock struct {
 	Name            string             `json:"name"`
 	LockfileVersion int                `json:"lockfileVersion"`
 	Packages        map[string]Package `json:"packages"`
+	// Repositories contains the repositories read from a .repo
+	// file, in the order that they were read.
+	Repositories []Repository `json:"repositories,omitempty"`
+}
+
+// Repository is a repository read from a .repo file, along
+// with the baseurl that it was resolved to.
+type Repository struct {
+	ID string `json:"id"`
+	// File is the path of the .repo file in the build
+	// spec that the repository was read from.
+	File    string `json:"file"`
+	BaseURL string `json:"baseURL"`
 }
 
 type Package struct {
@@ -15,4 +28,11 @@ type Package struct {
 	Resolved  string         `json:"resolved"`
 	Integrity string         `json:"integrity"`
 	Direct    bool           `json:"direct"`
+	// InstallRecommends records whether weak dependencies
+	// were included when the package was resolved.
+	InstallRecommends bool `json:"installRecommends,omitempty"`
+	// Constraint is the name from the manifest that selected
+	// the package (e.g. "git~2.40" or "so:libssl.so.3"), if
+	// it isn't the name of the package.
+	Constraint string `json:"constraint,omitempty"`
 }
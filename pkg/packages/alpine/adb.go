@@ -0,0 +1,330 @@
+package alpine
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ADB is the binary database format that apk-tools v3 uses for both
+// packages and indices. A file is made of a header followed by 8-byte
+// aligned blocks, the first of which contains a tree of values that
+// refer to each other by their offset within the block.
+//
+// https://gitlab.alpinelinux.org/alpine/apk-tools
+
+const (
+	adbMagic = "ADB"
+	// adbSchemaIndex is the schema of a Packages.adb index ("indx")
+	adbSchemaIndex uint32 = 0x78646e69
+	// adbSchemaPackage is the schema of a v3 package ("pckg")
+	adbSchemaPackage uint32 = 0x676b6370
+)
+
+// compression algorithms of an "ADBc" file
+const (
+	adbCompNone    = 0
+	adbCompDeflate = 1
+	adbCompZstd    = 2
+)
+
+// block types
+const (
+	adbBlockADB  = 0
+	adbBlockSig  = 1
+	adbBlockData = 2
+	// adbBlockExt blocks have a 64-bit size, and store
+	// their real type in the lower bits of the header
+	adbBlockExt = 3
+
+	adbBlockAlignment = 8
+)
+
+// value types, which are stored in the upper 4 bits of a value
+const (
+	adbTypeSpecial = 0x00000000
+	adbTypeInt     = 0x10000000
+	adbTypeInt32   = 0x20000000
+	adbTypeInt64   = 0x30000000
+	adbTypeBlob8   = 0x80000000
+	adbTypeBlob16  = 0x90000000
+	adbTypeBlob32  = 0xa0000000
+	adbTypeArray   = 0xd0000000
+	adbTypeObject  = 0xe0000000
+	adbTypeMask    = 0xf0000000
+	adbValueMask   = 0x0fffffff
+)
+
+// isADB returns true if the header
+// belongs to an ADB file.
+func isADB(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(adbMagic))
+}
+
+// adbReader reads the blocks of an ADB file one at a time.
+type adbReader struct {
+	r      io.Reader
+	close  func()
+	schema uint32
+	// block is whatever is left of the current block,
+	// and padding is the number of bytes after it
+	block   *io.LimitedReader
+	padding int64
+}
+
+// newADBReader decompresses an ADB file and reads its header.
+func newADBReader(r io.Reader) (*adbReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(adbMagic) + 1)
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if !isADB(magic) {
+		return nil, errors.New("file is not in the ADB format")
+	}
+
+	out := &adbReader{r: br, close: func() {}}
+	switch magic[3] {
+	case '.':
+	case 'd':
+		_, _ = br.Discard(4)
+		fr := flate.NewReader(br)
+		out.r, out.close = fr, func() { _ = fr.Close() }
+	case 'c':
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(br, header); err != nil {
+			return nil, fmt.Errorf("reading compression header: %w", err)
+		}
+		switch header[4] {
+		case adbCompNone:
+		case adbCompDeflate:
+			fr := flate.NewReader(br)
+			out.r, out.close = fr, func() { _ = fr.Close() }
+		case adbCompZstd:
+			zr, err := zstd.NewReader(br)
+			if err != nil {
+				return nil, err
+			}
+			out.r, out.close = zr, zr.Close
+		default:
+			return nil, fmt.Errorf("unsupported compression: %d", header[4])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ADB header: %q", magic)
+	}
+
+	// the decompressed stream starts with the
+	// uncompressed header and the schema
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(out.r, header); err != nil {
+		out.close()
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if string(header[:4]) != adbMagic+"." {
+		out.close()
+		return nil, fmt.Errorf("unexpected ADB header: %q", header[:4])
+	}
+	out.schema = binary.LittleEndian.Uint32(header[4:])
+	return out, nil
+}
+
+// next returns the type and payload of the next block. The
+// payload is only valid until next is called again.
+func (a *adbReader) next() (uint32, io.Reader, error) {
+	// skip anything that wasn't read from the previous block
+	if a.block != nil {
+		if _, err := io.Copy(io.Discard, a.block); err != nil {
+			return 0, nil, err
+		}
+		if _, err := io.CopyN(io.Discard, a.r, a.padding); err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(a.r, header); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, fmt.Errorf("reading block header: %w", err)
+	}
+	typeSize := binary.LittleEndian.Uint32(header)
+	blockType := typeSize >> 30
+	size := uint64(typeSize & 0x3fffffff)
+	headerSize := uint64(4)
+	if blockType == adbBlockExt {
+		ext := make([]byte, 12)
+		if _, err := io.ReadFull(a.r, ext); err != nil {
+			return 0, nil, fmt.Errorf("reading extended block header: %w", err)
+		}
+		blockType = typeSize & 0x3fffffff
+		size = binary.LittleEndian.Uint64(ext[4:])
+		headerSize = 16
+	}
+	// the size includes the header
+	if size < headerSize {
+		return 0, nil, fmt.Errorf("invalid block size: %d", size)
+	}
+	a.block = &io.LimitedReader{R: a.r, N: int64(size - headerSize)}
+	a.padding = int64(-size & (adbBlockAlignment - 1))
+	return blockType, a.block, nil
+}
+
+// adb is the payload of an ADB block. Errors are recorded
+// rather than returned, so that objects can be read without
+// checking every value.
+type adb struct {
+	data []byte
+	err  error
+}
+
+// adbObject is an object or an array. Fields are
+// numbered from 1, as the first value is the length.
+type adbObject struct {
+	db   *adb
+	vals []uint32
+}
+
+// parseADB returns the root object of an ADB block.
+func parseADB(data []byte) (adbObject, *adb, error) {
+	db := &adb{data: data}
+	// compat version, version, reserved and then the root
+	if len(data) < 8 {
+		return adbObject{}, nil, errors.New("ADB block is too short")
+	}
+	root := db.object(binary.LittleEndian.Uint32(data[4:]))
+	return root, db, db.err
+}
+
+func (db *adb) fail(format string, args ...any) {
+	if db.err == nil {
+		db.err = fmt.Errorf(format, args...)
+	}
+}
+
+// deref returns the bytes at the given offset.
+func (db *adb) deref(offset, size uint64) []byte {
+	if offset+size < offset || offset+size > uint64(len(db.data)) {
+		db.fail("value at offset %d is out of bounds", offset)
+		return nil
+	}
+	return db.data[offset : offset+size]
+}
+
+func (db *adb) object(v uint32) adbObject {
+	out := adbObject{db: db}
+	switch v & adbTypeMask {
+	case adbTypeObject, adbTypeArray:
+	case adbTypeSpecial:
+		return out
+	default:
+		db.fail("expected an object but got type %x", v&adbTypeMask)
+		return out
+	}
+	offset := uint64(v & adbValueMask)
+	b := db.deref(offset, 4)
+	if b == nil {
+		return out
+	}
+	b = db.deref(offset, uint64(binary.LittleEndian.Uint32(b))*4)
+	for i := 0; i+4 <= len(b); i += 4 {
+		out.vals = append(out.vals, binary.LittleEndian.Uint32(b[i:]))
+	}
+	return out
+}
+
+func (db *adb) int(v uint32) uint64 {
+	offset := uint64(v & adbValueMask)
+	switch v & adbTypeMask {
+	case adbTypeInt:
+		return offset
+	case adbTypeInt32:
+		if b := db.deref(offset, 4); b != nil {
+			return uint64(binary.LittleEndian.Uint32(b))
+		}
+	case adbTypeInt64:
+		if b := db.deref(offset, 8); b != nil {
+			return binary.LittleEndian.Uint64(b)
+		}
+	case adbTypeSpecial:
+	default:
+		db.fail("expected an integer but got type %x", v&adbTypeMask)
+	}
+	return 0
+}
+
+func (db *adb) blob(v uint32) []byte {
+	offset := uint64(v & adbValueMask)
+	var size uint64
+	switch v & adbTypeMask {
+	case adbTypeBlob8:
+		if b := db.deref(offset, 1); b != nil {
+			size = uint64(b[0])
+		}
+		offset += 1
+	case adbTypeBlob16:
+		if b := db.deref(offset, 2); b != nil {
+			size = uint64(binary.LittleEndian.Uint16(b))
+		}
+		offset += 2
+	case adbTypeBlob32:
+		if b := db.deref(offset, 4); b != nil {
+			size = uint64(binary.LittleEndian.Uint32(b))
+		}
+		offset += 4
+	case adbTypeSpecial:
+		return nil
+	default:
+		db.fail("expected a blob but got type %x", v&adbTypeMask)
+		return nil
+	}
+	return db.deref(offset, size)
+}
+
+// len returns the number of items in an array.
+func (o adbObject) len() int {
+	if len(o.vals) == 0 {
+		return 0
+	}
+	return len(o.vals) - 1
+}
+
+// value returns the raw value of a field, or
+// null if the object doesn't have it.
+func (o adbObject) value(i int) uint32 {
+	if i <= 0 || i >= len(o.vals) {
+		return 0
+	}
+	return o.vals[i]
+}
+
+func (o adbObject) object(i int) adbObject {
+	if o.db == nil {
+		return o
+	}
+	return o.db.object(o.value(i))
+}
+
+func (o adbObject) int(i int) uint64 {
+	if o.db == nil {
+		return 0
+	}
+	return o.db.int(o.value(i))
+}
+
+func (o adbObject) blob(i int) []byte {
+	if o.db == nil {
+		return nil
+	}
+	return o.db.blob(o.value(i))
+}
+
+func (o adbObject) str(i int) string {
+	return string(o.blob(i))
+}
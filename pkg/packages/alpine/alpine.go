@@ -1,9 +1,15 @@
 package alpine
 
 import (
+	"archive/tar"
+	"bufio"
 	"context"
-	"net/http"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
 
 	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/apk/fs"
@@ -12,12 +18,14 @@ import (
 	v1 "github.com/djcass44/all-your-base/pkg/api/v1"
 	"github.com/djcass44/all-your-base/pkg/archiveutil"
 	"github.com/djcass44/all-your-base/pkg/lockfile"
+	"github.com/djcass44/all-your-base/pkg/packages"
+	"github.com/djcass44/all-your-base/pkg/triggers"
 	"github.com/go-logr/logr"
 )
 
 func NewPackageKeeper(ctx context.Context, repositories []string, rootfs fs.FullFS, base ociv1.Image) (*PackageKeeper, error) {
 	log := logr.FromContextOrDiscard(ctx)
-	indices, err := apk.GetRepositoryIndexes(ctx, repositories, map[string][]byte{}, "x86_64", apk.WithIgnoreSignatures(true), apk.WithHTTPClient(http.DefaultClient))
+	indices, err := getIndices(ctx, repositories, "x86_64")
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +42,7 @@ func NewPackageKeeper(ctx context.Context, repositories []string, rootfs fs.Full
 	}, nil
 }
 
-func (*PackageKeeper) Unpack(ctx context.Context, pkg string, rootfs fs.FullFS) error {
+func (p *PackageKeeper) Unpack(ctx context.Context, pkg string, rootfs fs.FullFS) error {
 	log := logr.FromContextOrDiscard(ctx).WithValues("pkg", pkg)
 	log.V(4).Info("unpacking apk")
 
@@ -45,18 +53,145 @@ func (*PackageKeeper) Unpack(ctx context.Context, pkg string, rootfs fs.FullFS)
 	}
 	defer f.Close()
 
-	if err := archiveutil.Guntar(ctx, f, rootfs); err != nil {
+	// detect whether this is a v2 (gzip) or v3 (ADB) package
+	br := bufio.NewReader(f)
+	read := readAPK
+	if header, _ := br.Peek(len(adbMagic)); isADB(header) {
+		log.V(4).Info("detected apk v3 package")
+		read = readADBPackage
+	}
+
+	var unpacked *pkgInfo
+	err = read(ctx, br, func(info *pkgInfo, data io.Reader) error {
+		// keep track of the file checksums so that
+		// we can verify them once they've been written
+		checksums := map[string]*tar.Header{}
+		filter := func(target string, header *tar.Header) bool {
+			if header.Typeflag == tar.TypeReg {
+				checksums[target] = header
+			}
+			return true
+		}
+		if err := archiveutil.UntarFilter(ctx, data, rootfs, filter); err != nil {
+			return err
+		}
+		if err := verifyFiles(ctx, rootfs, checksums); err != nil {
+			return err
+		}
+		unpacked = info
+		return p.writeDB(ctx, info, rootfs)
+	})
+	if err != nil {
 		return err
 	}
+	// the scripts are only kept once the
+	// whole package has been verified
+	p.addScripts(unpacked)
 	return nil
 }
 
-func (p *PackageKeeper) Resolve(ctx context.Context, pkg string, write bool) ([]lockfile.Package, error) {
-	resolver := apk.NewPkgResolver(ctx, p.indices)
+// addScripts keeps track of the scripts that apk
+// would run when the package is installed.
+func (p *PackageKeeper) addScripts(info *pkgInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, scriptType := range installScripts {
+		if script, ok := info.scripts["."+scriptType]; ok {
+			p.scripts = append(p.scripts, triggers.Script{Package: info.name, Name: scriptType, Content: string(script)})
+		}
+	}
+}
+
+// Scripts returns the install scripts of the
+// packages that have been unpacked.
+func (p *PackageKeeper) Scripts() []triggers.Script {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.scripts)
+}
+
+// writeDB records the scripts and triggers of a package
+// if it has been written to the installed database.
+func (p *PackageKeeper) writeDB(ctx context.Context, info *pkgInfo, rootfs fs.FullFS) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("name", info.name)
 
-	// resolve the package
+	installed, ok := p.getRecorded(info.name)
+	if !ok {
+		log.V(5).Info("skipping scripts and triggers as the package hasn't been recorded")
+		return nil
+	}
+	if installed.Version != info.version {
+		log.V(4).Info("skipping scripts and triggers as the recorded package has a different version", "version", info.version, "recorded", installed.Version)
+		return nil
+	}
+	if err := p.writeScripts(ctx, installed, info, rootfs); err != nil {
+		return fmt.Errorf("recording scripts: %w", err)
+	}
+	if err := p.writeTriggers(ctx, installed, info, rootfs); err != nil {
+		return fmt.Errorf("recording triggers: %w", err)
+	}
+	return nil
+}
+
+// verifyFiles checks the contents of each file against
+// the checksum recorded in its tar header.
+func verifyFiles(ctx context.Context, rootfs fs.FullFS, headers map[string]*tar.Header) error {
+	log := logr.FromContextOrDiscard(ctx)
+	for target, header := range headers {
+		newHash, expected, ok := fileChecksum(header)
+		if !ok {
+			continue
+		}
+		f, err := rootfs.Open(target)
+		if err != nil {
+			return err
+		}
+		h := newHash()
+		_, err = io.Copy(h, f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("hashing %s: %w", target, err)
+		}
+		if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", target, expected, actual)
+		}
+		log.V(9).Info("verified file checksum", "target", target)
+	}
+	return nil
+}
+
+func (p *PackageKeeper) Resolve(ctx context.Context, pkg string, opts packages.ResolveOptions, write bool) ([]lockfile.Package, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("pkg", pkg)
+
+	c, ok := parseConstraint(pkg)
+	if !ok {
+		return nil, fmt.Errorf("invalid package: %s", pkg)
+	}
+	// exclusions aren't installed, they only
+	// change how other packages are resolved
+	if c.exclude {
+		log.V(4).Info("skipping excluded package")
+		if write {
+			return nil, p.writeWorld(ctx, []string{pkg}, p.rootfs)
+		}
+		return nil, nil
+	}
+
+	indices := p.indices
+	if len(opts.Exclude) > 0 {
+		indices = excludePackages(indices, opts.Exclude)
+	}
+	selected, err := selectPackage(indices, c)
+	if err != nil {
+		return nil, err
+	}
+	log.V(4).Info("selected package", "name", selected.Name, "version", selected.Version)
+
+	resolver := apk.NewPkgResolver(ctx, indices)
+
+	// resolve the exact package that we selected
 	dq := map[*apk.RepositoryPackage]string{}
-	repoPkg, repoPkgDeps, _, err := resolver.GetPackageWithDependencies(ctx, pkg, nil, dq)
+	repoPkg, repoPkgDeps, _, err := resolver.GetPackageWithDependencies(ctx, selected.Name+"="+selected.Version, nil, dq)
 	if err != nil {
 		return nil, err
 	}
@@ -65,6 +200,20 @@ func (p *PackageKeeper) Resolve(ctx context.Context, pkg string, write bool) ([]
 		if err := p.writeInstalled(ctx, append(repoPkgDeps, repoPkg), p.rootfs); err != nil {
 			return nil, err
 		}
+		// keep the world in sync so that apk knows
+		// which packages were explicitly requested
+		var world []string
+		if opts.Direct {
+			world = append(world, pkg)
+		}
+		for _, name := range opts.Exclude {
+			world = append(world, "!"+name)
+		}
+		if len(world) > 0 {
+			if err := p.writeWorld(ctx, world, p.rootfs); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	// collect the urls for each package
@@ -77,6 +226,11 @@ func (p *PackageKeeper) Resolve(ctx context.Context, pkg string, write bool) ([]
 		Type:      v1.PackageAlpine,
 		Direct:    true,
 	}
+	// keep track of how the package was chosen
+	// so that it can be resolved the same way
+	if pkg != repoPkg.Name {
+		names[0].Constraint = pkg
+	}
 	for i := range repoPkgDeps {
 		names[i+1] = lockfile.Package{
 			Name:      repoPkgDeps[i].Name,
@@ -89,3 +243,102 @@ func (p *PackageKeeper) Resolve(ctx context.Context, pkg string, write bool) ([]
 
 	return names, nil
 }
+
+// selectPackage finds the newest package that satisfies a constraint.
+// Packages whose name matches are preferred over packages that provide
+// the name (e.g. "so:libssl.so.3" or "cmd:curl"), and providers are
+// ordered by their priority.
+func selectPackage(indices []apk.NamedIndex, c constraint) (*apk.RepositoryPackage, error) {
+	var selected *apk.RepositoryPackage
+	var selectedProvider bool
+	for _, index := range indices {
+		for _, rp := range index.Packages() {
+			ok, provider := satisfies(rp.Package, c)
+			if !ok {
+				continue
+			}
+			if selected == nil || better(rp.Package, provider, selected.Package, selectedProvider) {
+				selected, selectedProvider = rp, provider
+			}
+		}
+	}
+	if selected == nil {
+		if c.op != "" {
+			return nil, fmt.Errorf("no package satisfies %s%s%s", c.name, c.op, c.version)
+		}
+		return nil, fmt.Errorf("package not found: %s", c.name)
+	}
+	return selected, nil
+}
+
+// satisfies returns true if the package satisfies the constraint,
+// and whether it does so by providing it rather than by name.
+func satisfies(pkg *apk.Package, c constraint) (bool, bool) {
+	if pkg.Name == c.name {
+		return c.matches(pkg.Version), false
+	}
+	for _, provides := range pkg.Provides {
+		p, ok := parseConstraint(provides)
+		if !ok || p.name != c.name {
+			continue
+		}
+		// unversioned provides can't satisfy
+		// a versioned constraint
+		if p.version == "" {
+			if c.op == "" {
+				return true, true
+			}
+			continue
+		}
+		if c.matches(p.version) {
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// better returns true if package a should be chosen over package b.
+func better(a *apk.Package, aProvider bool, b *apk.Package, bProvider bool) bool {
+	if aProvider != bProvider {
+		return !aProvider
+	}
+	if a.ProviderPriority != b.ProviderPriority {
+		return a.ProviderPriority > b.ProviderPriority
+	}
+	if cmp := compareVersions(a.Version, b.Version); cmp != 0 {
+		return cmp > 0
+	}
+	return a.Name < b.Name
+}
+
+// excludePackages returns a copy of the indices without the given
+// packages, or any package that provides them.
+func excludePackages(indices []apk.NamedIndex, exclude []string) []apk.NamedIndex {
+	excluded := func(pkg *apk.Package) bool {
+		for _, name := range exclude {
+			name = strings.TrimPrefix(name, "!")
+			if pkg.Name == name {
+				return true
+			}
+			for _, provides := range pkg.Provides {
+				if p, ok := parseConstraint(provides); ok && p.name == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	out := make([]apk.NamedIndex, len(indices))
+	for i, index := range indices {
+		filtered := &apk.APKIndex{}
+		for _, rp := range index.Packages() {
+			if !excluded(rp.Package) {
+				filtered.Packages = append(filtered.Packages, rp.Package)
+			}
+		}
+		repo := &apk.Repository{URI: index.Source()}
+		out[i] = apk.NewNamedRepositoryWithIndex(index.Name(), repo.WithIndex(filtered))
+	}
+	return out
+}
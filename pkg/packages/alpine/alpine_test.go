@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/apk/fs"
 	"github.com/Snakdy/container-build-engine/pkg/containers"
 	"github.com/djcass44/all-your-base/pkg/packages"
@@ -41,7 +42,7 @@ func TestPackageKeeper_Resolve(t *testing.T) {
 	pkg, err := NewPackageKeeper(ctx, []string{"https://mirror.aarnet.edu.au/pub/alpine/v3.23/main"}, testfs, baseImage)
 	require.NoError(t, err)
 
-	packageNames, err := pkg.Resolve(ctx, "git", true)
+	packageNames, err := pkg.Resolve(ctx, "git", packages.ResolveOptions{}, true)
 	assert.NoError(t, err)
 	t.Logf("%+v", packageNames)
 
@@ -51,3 +52,57 @@ func TestPackageKeeper_Resolve(t *testing.T) {
 		t.Logf("%+v", string(out))
 	}
 }
+
+func newIndex(pkgs ...*apk.Package) []apk.NamedIndex {
+	repo := &apk.Repository{URI: "https://example.com/main/x86_64"}
+	return []apk.NamedIndex{apk.NewNamedRepositoryWithIndex("test", repo.WithIndex(&apk.APKIndex{Packages: pkgs}))}
+}
+
+func TestSelectPackage(t *testing.T) {
+	indices := newIndex(
+		&apk.Package{Name: "git", Version: "2.39.2-r0"},
+		&apk.Package{Name: "git", Version: "2.40.1-r0", Provides: []string{"cmd:git=2.40.1-r0"}},
+		&apk.Package{Name: "git", Version: "2.41.0-r0", Provides: []string{"cmd:git=2.41.0-r0"}},
+		&apk.Package{Name: "libssl3", Version: "3.1.0-r0", Provides: []string{"so:libssl.so.3=3"}},
+		&apk.Package{Name: "curl", Version: "8.0.1-r0", Provides: []string{"cmd:curl=8.0.1-r0"}},
+		&apk.Package{Name: "busybox", Version: "1.36.0-r0", Provides: []string{"/bin/sh", "cmd:sh"}, ProviderPriority: 100},
+		&apk.Package{Name: "dash", Version: "0.5.12-r0", Provides: []string{"/bin/sh", "cmd:sh"}, ProviderPriority: 10},
+	)
+
+	var cases = []struct {
+		in      string
+		name    string
+		version string
+	}{
+		{"git", "git", "2.41.0-r0"},
+		{"git=2.40.1-r0", "git", "2.40.1-r0"},
+		{"git~2.40", "git", "2.40.1-r0"},
+		{"git<2.40", "git", "2.39.2-r0"},
+		{"so:libssl.so.3", "libssl3", "3.1.0-r0"},
+		{"cmd:curl", "curl", "8.0.1-r0"},
+		{"cmd:git=2.40.1-r0", "git", "2.40.1-r0"},
+		{"cmd:sh", "busybox", "1.36.0-r0"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			c, ok := parseConstraint(tt.in)
+			require.True(t, ok)
+			out, err := selectPackage(indices, c)
+			require.NoError(t, err)
+			assert.EqualValues(t, tt.name, out.Name)
+			assert.EqualValues(t, tt.version, out.Version)
+		})
+	}
+
+	t.Run("unsatisfiable", func(t *testing.T) {
+		c, _ := parseConstraint("git=1.0-r0")
+		_, err := selectPackage(indices, c)
+		assert.Error(t, err)
+	})
+	t.Run("exclusion", func(t *testing.T) {
+		c, _ := parseConstraint("cmd:sh")
+		out, err := selectPackage(excludePackages(indices, []string{"busybox"}), c)
+		require.NoError(t, err)
+		assert.EqualValues(t, "dash", out.Name)
+	})
+}
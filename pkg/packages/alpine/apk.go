@@ -0,0 +1,243 @@
+package alpine
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	pkgInfoFile = ".PKGINFO"
+	// signaturePrefix is the prefix of the files
+	// in the signature segment (e.g. ".SIGN.RSA.foo.rsa.pub")
+	signaturePrefix = ".SIGN."
+)
+
+// checksumRecords are the PAX records that abuild uses to store
+// the hex encoded checksum of each file in the data segment, along
+// with the hash that they were created with. The SHA256 record is
+// only used when converting v3 packages.
+var checksumRecords = []struct {
+	key     string
+	newHash func() hash.Hash
+}{
+	{"APK-TOOLS.checksum.SHA1", sha1.New},
+	{"APK-TOOLS.checksum.MD5", md5.New},
+	{"APK-TOOLS.checksum.SHA256", sha256.New},
+}
+
+// pkgInfo is the subset of the
+// .PKGINFO file that we care about.
+type pkgInfo struct {
+	name    string
+	version string
+	arch    string
+	// dataHash is the hex encoded SHA256
+	// checksum of the data segment
+	dataHash string
+	// triggers are the paths that the
+	// package's trigger script watches
+	triggers []string
+	// scripts are the install scripts
+	// in the control segment
+	scripts map[string][]byte
+}
+
+// parsePkgInfo parses a .PKGINFO file, which
+// contains 'key = value' pairs.
+func parsePkgInfo(data []byte) (*pkgInfo, error) {
+	var out pkgInfo
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "pkgname":
+			out.name = strings.TrimSpace(v)
+		case "pkgver":
+			out.version = strings.TrimSpace(v)
+		case "arch":
+			out.arch = strings.TrimSpace(v)
+		case "datahash":
+			out.dataHash = strings.TrimSpace(v)
+		case "triggers":
+			out.triggers = append(out.triggers, strings.Fields(v)...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if out.name == "" {
+		return nil, errors.New("package name is missing")
+	}
+	return &out, nil
+}
+
+// segmentReader reads the concatenated gzip segments of an apk,
+// and optionally hashes the compressed bytes as they're read. It
+// implements io.ByteReader so that the gzip reader doesn't read
+// past the end of each segment.
+type segmentReader struct {
+	r *bufio.Reader
+	h hash.Hash
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if s.h != nil {
+		s.h.Write(p[:n])
+	}
+	return n, err
+}
+
+func (s *segmentReader) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err == nil && s.h != nil {
+		s.h.Write([]byte{b})
+	}
+	return b, err
+}
+
+// readAPK reads an apk v2 package, which is made of 3 concatenated
+// gzip streams: an optional signature, the control segment (that
+// contains the .PKGINFO) and the data segment. The data segment is
+// streamed into the extract function and verified against the
+// "datahash" once it has been completely read.
+//
+// https://wiki.alpinelinux.org/wiki/Apk_spec
+func readAPK(ctx context.Context, r io.Reader, extract func(info *pkgInfo, data io.Reader) error) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	sr := &segmentReader{r: bufio.NewReader(r)}
+	zr, err := gzip.NewReader(sr)
+	if err != nil {
+		return fmt.Errorf("reading apk: %w", err)
+	}
+	defer zr.Close()
+
+	// the signature segment is optional, so we need
+	// to keep reading until we find the .PKGINFO
+	var info *pkgInfo
+	for info == nil {
+		zr.Multistream(false)
+		info, err = readControlSegment(ctx, zr)
+		if err != nil {
+			return err
+		}
+		// make sure that we've reached the end of the
+		// segment before we start on the next one
+		if _, err := io.Copy(io.Discard, zr); err != nil {
+			return err
+		}
+		// hash the data segment as it's read
+		if info != nil && info.dataHash != "" {
+			sr.h = sha256.New()
+		}
+		if err := zr.Reset(sr); err != nil {
+			if err == io.EOF {
+				return errors.New("apk is missing the control or data segment")
+			}
+			return err
+		}
+	}
+	log.V(5).Info("read package info", "name", info.name, "version", info.version, "scripts", slices.Sorted(maps.Keys(info.scripts)))
+
+	zr.Multistream(false)
+	if err := extract(info, zr); err != nil {
+		return err
+	}
+	if sr.h == nil {
+		log.V(4).Info("skipping data segment verification as the package doesn't have a datahash")
+		return nil
+	}
+	// drain everything so that the hash
+	// covers the entire segment
+	if _, err := io.Copy(io.Discard, zr); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, sr); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(sr.h.Sum(nil)); actual != info.dataHash {
+		return fmt.Errorf("data segment checksum mismatch: expected %s, got %s", info.dataHash, actual)
+	}
+	log.V(4).Info("verified data segment", "datahash", info.dataHash)
+	return nil
+}
+
+// readControlSegment reads the .PKGINFO from a segment. If the
+// segment doesn't have one (e.g. it's the signature), nil is
+// returned.
+func readControlSegment(ctx context.Context, r io.Reader) (*pkgInfo, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	var info *pkgInfo
+	scripts := map[string][]byte{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		// control segments don't have an end-of-archive
+		// marker, so they finish abruptly
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading control segment: %w", err)
+		}
+		switch {
+		case strings.HasPrefix(header.Name, signaturePrefix):
+			log.V(5).Info("skipping signature", "name", header.Name)
+		case header.Name == pkgInfoFile:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %w", pkgInfoFile, err)
+			}
+			info, err = parsePkgInfo(data)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", pkgInfoFile, err)
+			}
+		case strings.HasPrefix(header.Name, "."):
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %w", header.Name, err)
+			}
+			scripts[header.Name] = data
+		}
+	}
+	if info != nil {
+		info.scripts = scripts
+	}
+	return info, nil
+}
+
+// fileChecksum returns the expected checksum of a
+// file from the data segment, if it has one.
+func fileChecksum(header *tar.Header) (func() hash.Hash, string, bool) {
+	for _, r := range checksumRecords {
+		if v, ok := header.PAXRecords[r.key]; ok {
+			return r.newHash, v, true
+		}
+	}
+	return nil, "", false
+}
@@ -0,0 +1,150 @@
+package alpine
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/djcass44/all-your-base/pkg/triggers"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tarEntry struct {
+	name    string
+	content string
+	pax     map[string]string
+}
+
+// newSegment creates a gzip compressed tar archive. Only the data
+// segment has an end-of-archive marker.
+func newSegment(t *testing.T, eof bool, files ...tarEntry) []byte {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		header := &tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       f.name,
+			Mode:       0644,
+			Size:       int64(len(f.content)),
+			PAXRecords: f.pax,
+		}
+		if f.name[len(f.name)-1] == '/' {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err := tw.Write([]byte(f.content))
+		require.NoError(t, err)
+	}
+	if eof {
+		require.NoError(t, tw.Close())
+	} else {
+		require.NoError(t, tw.Flush())
+	}
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func sha1sum(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPK creates an apk v2 package. If the datahash is
+// empty, the checksum of the data segment is used.
+func newAPK(t *testing.T, signed bool, datahash, checksum string) string {
+	content := "#!/bin/sh\necho hello\n"
+	if checksum == "" {
+		checksum = sha1sum(content)
+	}
+	data := newSegment(t, true,
+		tarEntry{name: "usr/", content: ""},
+		tarEntry{name: "usr/bin/", content: ""},
+		tarEntry{name: "usr/bin/hello", content: content, pax: map[string]string{"APK-TOOLS.checksum.SHA1": checksum}},
+	)
+	if datahash == "" {
+		sum := sha256.Sum256(data)
+		datahash = hex.EncodeToString(sum[:])
+	}
+	control := newSegment(t, false,
+		tarEntry{name: ".PKGINFO", content: fmt.Sprintf("# Generated by abuild\npkgname = hello\npkgver = 1.0-r0\narch = x86_64\ntriggers = /usr/share/fonts/* /usr/share/icons/*\ndatahash = %s\n", datahash)},
+		tarEntry{name: ".post-install", content: "#!/bin/sh\nexit 0\n"},
+	)
+
+	var out []byte
+	if signed {
+		out = append(out, newSegment(t, false, tarEntry{name: ".SIGN.RSA.test.rsa.pub", content: "signature"})...)
+	}
+	out = append(out, control...)
+	out = append(out, data...)
+
+	path := filepath.Join(t.TempDir(), "hello-1.0-r0.apk")
+	require.NoError(t, os.WriteFile(path, out, 0644))
+	return path
+}
+
+func TestPackageKeeper_UnpackSegments(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	var cases = []struct {
+		name     string
+		signed   bool
+		datahash string
+		checksum string
+		ok       bool
+	}{
+		{"signed", true, "", "", true},
+		{"unsigned", false, "", "", true},
+		{"datahash mismatch", true, "0000000000000000000000000000000000000000000000000000000000000000", "", false},
+		{"file checksum mismatch", true, "", sha1sum("something else"), false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			rootfs := fs.NewMemFS()
+			keeper := &PackageKeeper{}
+			err := keeper.Unpack(ctx, newAPK(t, tt.signed, tt.datahash, tt.checksum), rootfs)
+			if !tt.ok {
+				assert.Error(t, err)
+				assert.Empty(t, keeper.Scripts())
+				return
+			}
+			require.NoError(t, err)
+			assert.EqualValues(t, []triggers.Script{{Package: "hello", Name: "post-install", Content: "#!/bin/sh\nexit 0\n"}}, keeper.Scripts())
+
+			out, err := rootfs.ReadFile("/usr/bin/hello")
+			require.NoError(t, err)
+			assert.EqualValues(t, "#!/bin/sh\necho hello\n", string(out))
+
+			// control files must not end up in the image
+			for _, name := range []string{"/.PKGINFO", "/.post-install", "/.SIGN.RSA.test.rsa.pub"} {
+				_, err = rootfs.Stat(name)
+				assert.ErrorIs(t, err, os.ErrNotExist)
+			}
+		})
+	}
+}
+
+func TestParsePkgInfo(t *testing.T) {
+	info, err := parsePkgInfo([]byte("# Generated by abuild 3.11.0\n# using fakeroot version 1.31\npkgname = git\npkgver = 2.40.1-r0\npkgdesc = Distributed version control system\narch = x86_64\ndepend = so:libc.musl-x86_64.so.1\ndatahash = abc123\n"))
+	require.NoError(t, err)
+	assert.EqualValues(t, "git", info.name)
+	assert.EqualValues(t, "2.40.1-r0", info.version)
+	assert.EqualValues(t, "x86_64", info.arch)
+	assert.EqualValues(t, "abc123", info.dataHash)
+
+	_, err = parsePkgInfo([]byte("pkgver = 1.0\n"))
+	assert.Error(t, err)
+}
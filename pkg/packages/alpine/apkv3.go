@@ -0,0 +1,419 @@
+package alpine
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"github.com/go-logr/logr"
+)
+
+// fields of a dependency
+const (
+	adbDepName    = 1
+	adbDepVersion = 2
+	adbDepMatch   = 3
+)
+
+// fields of the package info
+const (
+	adbPIName             = 1
+	adbPIVersion          = 2
+	adbPIHashes           = 3
+	adbPIDescription      = 4
+	adbPIArch             = 5
+	adbPILicense          = 6
+	adbPIOrigin           = 7
+	adbPIMaintainer       = 8
+	adbPIURL              = 9
+	adbPIRepoCommit       = 10
+	adbPIBuildTime        = 11
+	adbPIInstalledSize    = 12
+	adbPIFileSize         = 13
+	adbPIProviderPriority = 14
+	adbPIDepends          = 15
+	adbPIProvides         = 16
+	adbPIReplaces         = 17
+	adbPIInstallIf        = 18
+)
+
+// fields of an ACL
+const (
+	adbACLMode  = 1
+	adbACLUser  = 2
+	adbACLGroup = 3
+)
+
+// fields of a file
+const (
+	adbFIName   = 1
+	adbFIACL    = 2
+	adbFISize   = 3
+	adbFIMtime  = 4
+	adbFIHashes = 5
+	adbFITarget = 6
+)
+
+// fields of a directory
+const (
+	adbDIName  = 1
+	adbDIACL   = 2
+	adbDIFiles = 3
+)
+
+// fields of a package
+const (
+	adbPkgInfo     = 1
+	adbPkgPaths    = 2
+	adbPkgScripts  = 3
+	adbPkgTriggers = 4
+)
+
+// fields of an index
+const (
+	adbIndexDescription = 1
+	adbIndexPackages    = 2
+)
+
+// version match flags of a dependency
+const (
+	versionEqual    = 1
+	versionLess     = 2
+	versionGreater  = 4
+	versionFuzzy    = 8
+	versionConflict = 16
+)
+
+// file types stored in the mode of a file's target
+const (
+	modeTypeMask = 0xf000
+	modeFifo     = 0x1000
+	modeChar     = 0x2000
+	modeBlock    = 0x6000
+	modeReg      = 0x8000
+	modeSymlink  = 0xa000
+)
+
+// adbScripts are the fields of the scripts object, named
+// the same way as the scripts in a v2 control segment.
+var adbScripts = []string{
+	1: ".trigger",
+	2: ".pre-install",
+	3: ".post-install",
+	4: ".pre-deinstall",
+	5: ".post-deinstall",
+	6: ".pre-upgrade",
+	7: ".post-upgrade",
+}
+
+// adbVersionOps converts the match flags of a
+// dependency into the operator used by apk.
+var adbVersionOps = map[uint64]string{
+	versionEqual:                  "=",
+	versionLess:                   "<",
+	versionGreater:                ">",
+	versionLess | versionEqual:    "<=",
+	versionGreater | versionEqual: ">=",
+	versionFuzzy | versionEqual:   "~",
+	versionFuzzy:                  "~",
+}
+
+// adbToPackage converts the package info of an index
+// or package into the format used by the resolver.
+func adbToPackage(pi adbObject) *apk.Package {
+	return &apk.Package{
+		Name:             pi.str(adbPIName),
+		Version:          pi.str(adbPIVersion),
+		Arch:             pi.str(adbPIArch),
+		Description:      pi.str(adbPIDescription),
+		License:          pi.str(adbPILicense),
+		Origin:           pi.str(adbPIOrigin),
+		Maintainer:       pi.str(adbPIMaintainer),
+		URL:              pi.str(adbPIURL),
+		Checksum:         pi.blob(adbPIHashes),
+		Dependencies:     adbDependencies(pi.object(adbPIDepends)),
+		Provides:         adbDependencies(pi.object(adbPIProvides)),
+		InstallIf:        adbDependencies(pi.object(adbPIInstallIf)),
+		Replaces:         adbDependencies(pi.object(adbPIReplaces)),
+		Size:             pi.int(adbPIFileSize),
+		InstalledSize:    pi.int(adbPIInstalledSize),
+		ProviderPriority: pi.int(adbPIProviderPriority),
+		BuildTime:        time.Unix(int64(pi.int(adbPIBuildTime)), 0).UTC(),
+		RepoCommit:       pi.str(adbPIRepoCommit),
+	}
+}
+
+// adbDependencies converts an array of dependencies into
+// strings (e.g. "so:libc.musl-x86_64.so.1", "busybox>=1.36").
+func adbDependencies(deps adbObject) []string {
+	var out []string
+	for i := 1; i <= deps.len(); i++ {
+		dep := deps.object(i)
+		name := dep.str(adbDepName)
+		if name == "" {
+			continue
+		}
+		match := dep.int(adbDepMatch)
+		prefix := ""
+		if match&versionConflict != 0 {
+			prefix = "!"
+		}
+		version := dep.str(adbDepVersion)
+		if version == "" {
+			out = append(out, prefix+name)
+			continue
+		}
+		// versioned dependencies default to an exact match
+		op, ok := adbVersionOps[match&^versionConflict]
+		if !ok {
+			op = "="
+		}
+		out = append(out, prefix+name+op+version)
+	}
+	return out
+}
+
+// readADBIndex reads a Packages.adb index.
+func readADBIndex(r io.Reader) (*apk.APKIndex, error) {
+	root, db, err := readADBRoot(r, adbSchemaIndex)
+	if err != nil {
+		return nil, err
+	}
+	out := &apk.APKIndex{
+		Description: root.str(adbIndexDescription),
+	}
+	pkgs := root.object(adbIndexPackages)
+	for i := 1; i <= pkgs.len(); i++ {
+		out.Packages = append(out.Packages, adbToPackage(pkgs.object(i)))
+	}
+	if db.err != nil {
+		return nil, fmt.Errorf("reading index: %w", db.err)
+	}
+	return out, nil
+}
+
+// readADBRoot reads the first block of an ADB file, which
+// contains the database. Signature and data blocks are
+// ignored.
+func readADBRoot(r io.Reader, schema uint32) (adbObject, *adb, error) {
+	ar, err := newADBReader(r)
+	if err != nil {
+		return adbObject{}, nil, err
+	}
+	defer ar.close()
+	return ar.root(schema)
+}
+
+// root reads the database from the first block.
+func (a *adbReader) root(schema uint32) (adbObject, *adb, error) {
+	if a.schema != schema {
+		return adbObject{}, nil, fmt.Errorf("unexpected ADB schema: %x", a.schema)
+	}
+	blockType, block, err := a.next()
+	if err != nil {
+		return adbObject{}, nil, fmt.Errorf("reading database: %w", err)
+	}
+	if blockType != adbBlockADB {
+		return adbObject{}, nil, fmt.Errorf("expected the database but got block type %d", blockType)
+	}
+	data, err := io.ReadAll(block)
+	if err != nil {
+		return adbObject{}, nil, fmt.Errorf("reading database: %w", err)
+	}
+	return parseADB(data)
+}
+
+// readADBPackage reads an apk v3 package. The files described by
+// the database are converted into a tar stream (along with the
+// contents of each data block) so that they can be extracted in
+// the same way as a v2 package.
+func readADBPackage(ctx context.Context, r io.Reader, extract func(info *pkgInfo, data io.Reader) error) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	ar, err := newADBReader(r)
+	if err != nil {
+		return fmt.Errorf("reading apk: %w", err)
+	}
+	defer ar.close()
+	root, db, err := ar.root(adbSchemaPackage)
+	if err != nil {
+		return fmt.Errorf("reading apk: %w", err)
+	}
+
+	pi := root.object(adbPkgInfo)
+	info := &pkgInfo{
+		name:    pi.str(adbPIName),
+		version: pi.str(adbPIVersion),
+		arch:    pi.str(adbPIArch),
+	}
+	info.scripts = map[string][]byte{}
+	scripts := root.object(adbPkgScripts)
+	for i, name := range adbScripts {
+		if data := scripts.blob(i); len(data) > 0 {
+			info.scripts[name] = data
+		}
+	}
+	triggers := root.object(adbPkgTriggers)
+	for i := 1; i <= triggers.len(); i++ {
+		info.triggers = append(info.triggers, triggers.str(i))
+	}
+	if db.err != nil {
+		return fmt.Errorf("reading package info: %w", db.err)
+	}
+	if info.name == "" {
+		return errors.New("package name is missing")
+	}
+	log.V(5).Info("read package info", "name", info.name, "version", info.version, "scripts", slices.Sorted(maps.Keys(info.scripts)))
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := writeADBTar(ar, root.object(adbPkgPaths), pw)
+		_ = pw.CloseWithError(err)
+		done <- err
+	}()
+	err = extract(info, pr)
+	// unblock the writer if we stopped reading early
+	_ = pr.Close()
+	if werr := <-done; err == nil && werr != nil {
+		err = werr
+	}
+	return err
+}
+
+// writeADBTar writes the files of a package as a tar archive,
+// reading the contents of each regular file from its data block.
+func writeADBTar(ar *adbReader, paths adbObject, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for i := 1; i <= paths.len(); i++ {
+		dir := paths.object(i)
+		name := dir.str(adbDIName)
+		// the root directory doesn't have a name
+		if name != "" {
+			acl := dir.object(adbDIACL)
+			if err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     name + "/",
+				Mode:     int64(acl.int(adbACLMode)),
+				Uname:    acl.str(adbACLUser),
+				Gname:    acl.str(adbACLGroup),
+			}); err != nil {
+				return err
+			}
+		}
+		files := dir.object(adbDIFiles)
+		for j := 1; j <= files.len(); j++ {
+			header := adbFileHeader(name, files.object(j))
+			if paths.db.err != nil {
+				return fmt.Errorf("reading file info: %w", paths.db.err)
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if header.Typeflag != tar.TypeReg || header.Size == 0 {
+				continue
+			}
+			data, err := nextDataBlock(ar, i, j)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", header.Name, err)
+			}
+			if _, err := io.Copy(tw, data); err != nil {
+				return fmt.Errorf("writing %s: %w", header.Name, err)
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// nextDataBlock returns the contents of the next data block,
+// which must belong to the given file.
+func nextDataBlock(ar *adbReader, pathIdx, fileIdx int) (io.Reader, error) {
+	for {
+		blockType, block, err := ar.next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("data block is missing")
+			}
+			return nil, err
+		}
+		if blockType == adbBlockSig {
+			continue
+		}
+		if blockType != adbBlockData {
+			return nil, fmt.Errorf("unexpected block type: %d", blockType)
+		}
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(block, header); err != nil {
+			return nil, fmt.Errorf("reading data block header: %w", err)
+		}
+		p, f := binary.LittleEndian.Uint32(header), binary.LittleEndian.Uint32(header[4:])
+		if int(p) != pathIdx || int(f) != fileIdx {
+			return nil, fmt.Errorf("data block belongs to file %d/%d", p, f)
+		}
+		return block, nil
+	}
+}
+
+// adbFileHeader converts a file into a tar header. Its
+// checksum is stored as a PAX record so that it can be
+// verified in the same way as a v2 package.
+func adbFileHeader(dir string, file adbObject) *tar.Header {
+	acl := file.object(adbFIACL)
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join(dir, file.str(adbFIName)),
+		Mode:     int64(acl.int(adbACLMode)),
+		Uname:    acl.str(adbACLUser),
+		Gname:    acl.str(adbACLGroup),
+		Size:     int64(file.int(adbFISize)),
+		ModTime:  time.Unix(int64(file.int(adbFIMtime)), 0),
+	}
+	if hash := file.blob(adbFIHashes); len(hash) > 0 {
+		for _, r := range checksumRecords {
+			if r.newHash().Size() == len(hash) {
+				header.PAXRecords = map[string]string{r.key: hex.EncodeToString(hash)}
+				break
+			}
+		}
+	}
+	// anything other than a regular file has a target, which
+	// starts with the file type
+	target := file.blob(adbFITarget)
+	if len(target) < 2 {
+		return header
+	}
+	header.Size = 0
+	header.PAXRecords = nil
+	mode := binary.LittleEndian.Uint16(target)
+	switch mode & modeTypeMask {
+	case modeSymlink:
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = string(target[2:])
+	case modeReg:
+		header.Typeflag = tar.TypeLink
+		header.Linkname = strings.TrimPrefix(string(target[2:]), "/")
+	case modeChar, modeBlock:
+		header.Typeflag = tar.TypeChar
+		if mode&modeTypeMask == modeBlock {
+			header.Typeflag = tar.TypeBlock
+		}
+		if len(target) >= 10 {
+			dev := binary.LittleEndian.Uint64(target[2:])
+			header.Devmajor = int64((dev>>8)&0xfff | (dev>>32)&^0xfff)
+			header.Devminor = int64(dev&0xff | (dev>>12)&^0xff)
+		}
+	case modeFifo:
+		header.Typeflag = tar.TypeFifo
+	}
+	return header
+}
@@ -0,0 +1,264 @@
+package alpine
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// adbWriter builds the payload of an ADB block.
+type adbWriter struct {
+	buf []byte
+}
+
+func newADBWriter() *adbWriter {
+	// compat version, version, reserved and the root
+	return &adbWriter{buf: make([]byte, 8)}
+}
+
+func (w *adbWriter) u32(v uint32) {
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, v)
+}
+
+func (w *adbWriter) str(s string) uint32 {
+	offset := uint32(len(w.buf))
+	if len(s) < 256 {
+		w.buf = append(w.buf, byte(len(s)))
+		w.buf = append(w.buf, s...)
+		return adbTypeBlob8 | offset
+	}
+	w.u32(uint32(len(s)))
+	w.buf = append(w.buf, s...)
+	return adbTypeBlob32 | offset
+}
+
+func (w *adbWriter) int(v uint32) uint32 {
+	if v <= adbValueMask {
+		return adbTypeInt | v
+	}
+	offset := uint32(len(w.buf))
+	w.u32(v)
+	return adbTypeInt32 | offset
+}
+
+func (w *adbWriter) object(vals ...uint32) uint32 {
+	offset := uint32(len(w.buf))
+	w.u32(uint32(len(vals) + 1))
+	for _, v := range vals {
+		w.u32(v)
+	}
+	return adbTypeObject | offset
+}
+
+func (w *adbWriter) array(vals ...uint32) uint32 {
+	return w.object(vals...)&^adbTypeMask | adbTypeArray
+}
+
+func (w *adbWriter) finish(root uint32) []byte {
+	binary.LittleEndian.PutUint32(w.buf[4:], root)
+	return w.buf
+}
+
+// adbBlock encodes a block, including its padding.
+func adbBlock(blockType uint32, payload []byte) []byte {
+	out := binary.LittleEndian.AppendUint32(nil, blockType<<30|uint32(len(payload)+4))
+	out = append(out, payload...)
+	for len(out)%adbBlockAlignment != 0 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// adbFile encodes an ADB file with the given compression.
+func adbFile(t *testing.T, schema uint32, compression string, blocks ...[]byte) []byte {
+	out := []byte("ADB.")
+	out = binary.LittleEndian.AppendUint32(out, schema)
+	for _, b := range blocks {
+		out = append(out, b...)
+	}
+
+	buf := new(bytes.Buffer)
+	switch compression {
+	case "":
+		return out
+	case "deflate":
+		buf.WriteString("ADBd")
+		fw, err := flate.NewWriter(buf, flate.DefaultCompression)
+		require.NoError(t, err)
+		_, err = fw.Write(out)
+		require.NoError(t, err)
+		require.NoError(t, fw.Close())
+	case "zstd":
+		buf.WriteString("ADBc")
+		buf.Write([]byte{adbCompZstd, 0})
+		zw, err := zstd.NewWriter(buf)
+		require.NoError(t, err)
+		_, err = zw.Write(out)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+	}
+	return buf.Bytes()
+}
+
+// newAPKv3 creates an apk v3 package containing a single
+// script and a symbolic link.
+func newAPKv3(t *testing.T, compression, content string) string {
+	const script = "#!/bin/sh\necho hello\n"
+	sum := sha256.Sum256([]byte(script))
+
+	w := newADBWriter()
+	dirACL := w.object(w.int(0755), w.str("root"), w.str("root"))
+	fileACL := w.object(w.int(0755), w.str("root"), w.str("root"))
+	target := binary.LittleEndian.AppendUint16(nil, modeSymlink)
+	target = append(target, "hello"...)
+	paths := w.array(
+		w.object(0, dirACL, w.array()),
+		w.object(w.str("usr"), dirACL, w.array()),
+		w.object(w.str("usr/bin"), dirACL, w.array(
+			w.object(w.str("hello"), fileACL, w.int(uint32(len(script))), w.int(1700000000), w.str(string(sum[:]))),
+			w.object(w.str("hi"), fileACL, 0, 0, 0, w.str(string(target))),
+		)),
+	)
+	pi := w.object(w.str("hello"), w.str("1.0-r0"), 0, w.str("says hello"), w.str("x86_64"))
+	scripts := w.object(0, 0, w.str("#!/bin/sh\nexit 0\n"))
+	db := w.finish(w.object(pi, paths, scripts))
+
+	data := binary.LittleEndian.AppendUint32(nil, 3)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = append(data, content...)
+
+	out := adbFile(t, adbSchemaPackage, compression,
+		adbBlock(adbBlockADB, db),
+		adbBlock(adbBlockSig, []byte("signature")),
+		adbBlock(adbBlockData, data),
+	)
+	path := filepath.Join(t.TempDir(), "hello-1.0-r0.apk")
+	require.NoError(t, os.WriteFile(path, out, 0644))
+	return path
+}
+
+func TestPackageKeeper_UnpackADB(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	var cases = []struct {
+		name        string
+		compression string
+		content     string
+		ok          bool
+	}{
+		{"uncompressed", "", "#!/bin/sh\necho hello\n", true},
+		{"deflate", "deflate", "#!/bin/sh\necho hello\n", true},
+		{"zstd", "zstd", "#!/bin/sh\necho hello\n", true},
+		{"checksum mismatch", "", "#!/bin/sh\necho HELLO\n", false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			rootfs := fs.DirFS(ctx, tempDir)
+			err := (&PackageKeeper{}).Unpack(ctx, newAPKv3(t, tt.compression, tt.content), rootfs)
+			if !tt.ok {
+				assert.ErrorContains(t, err, "checksum mismatch")
+				return
+			}
+			require.NoError(t, err)
+
+			out, err := rootfs.ReadFile("/usr/bin/hello")
+			require.NoError(t, err)
+			assert.EqualValues(t, tt.content, string(out))
+
+			info, err := rootfs.Lstat("/usr/bin/hi")
+			require.NoError(t, err)
+			assert.EqualValues(t, os.ModeSymlink, info.Mode()&os.ModeSymlink)
+		})
+	}
+}
+
+func TestReadADBIndex(t *testing.T) {
+	w := newADBWriter()
+	dep := func(name, version string, match uint32) uint32 {
+		if version == "" {
+			return w.object(w.str(name))
+		}
+		return w.object(w.str(name), w.str(version), w.int(match))
+	}
+	git := w.object(
+		w.str("git"), w.str("2.40.1-r0"), w.str("checksum"), w.str("Distributed version control system"), w.str("x86_64"),
+		w.str("GPL-2.0-or-later"), w.str("git"), 0, 0, 0, w.int(1700000000), w.int(12345), w.int(4567), 0,
+		w.array(dep("so:libc.musl-x86_64.so.1", "", 0), dep("pcre2", "10.42", versionGreater|versionEqual), dep("git-bash", "2", versionConflict|versionLess)),
+		w.array(dep("cmd:git", "2.40.1-r0", 0)),
+	)
+	musl := w.object(w.str("musl"), w.str("1.2.4-r2"))
+	db := w.finish(w.object(w.str("test repository"), w.array(git, musl)))
+
+	index, err := readADBIndex(bytes.NewReader(adbFile(t, adbSchemaIndex, "deflate", adbBlock(adbBlockADB, db))))
+	require.NoError(t, err)
+	assert.EqualValues(t, "test repository", index.Description)
+	require.Len(t, index.Packages, 2)
+
+	pkg := index.Packages[0]
+	assert.EqualValues(t, "git", pkg.Name)
+	assert.EqualValues(t, "2.40.1-r0", pkg.Version)
+	assert.EqualValues(t, "x86_64", pkg.Arch)
+	assert.EqualValues(t, "GPL-2.0-or-later", pkg.License)
+	assert.EqualValues(t, 12345, pkg.InstalledSize)
+	assert.EqualValues(t, 4567, pkg.Size)
+	assert.EqualValues(t, []string{"so:libc.musl-x86_64.so.1", "pcre2>=10.42", "!git-bash<2"}, pkg.Dependencies)
+	assert.EqualValues(t, []string{"cmd:git=2.40.1-r0"}, pkg.Provides)
+
+	assert.EqualValues(t, "musl", index.Packages[1].Name)
+
+	t.Run("wrong schema", func(t *testing.T) {
+		_, err := readADBIndex(bytes.NewReader(adbFile(t, adbSchemaPackage, "", adbBlock(adbBlockADB, db))))
+		assert.Error(t, err)
+	})
+	t.Run("out of bounds", func(t *testing.T) {
+		w := newADBWriter()
+		root := w.object(w.str("test repository"), adbTypeArray|0xfffff)
+		_, err := readADBIndex(bytes.NewReader(adbFile(t, adbSchemaIndex, "", adbBlock(adbBlockADB, w.finish(root)))))
+		assert.Error(t, err)
+	})
+}
+
+func TestGetIndexV3(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	w := newADBWriter()
+	db := w.finish(w.object(0, w.array(w.object(w.str("musl"), w.str("1.2.4-r2")))))
+	index := adbFile(t, adbSchemaIndex, "", adbBlock(adbBlockADB, db))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/x86_64/Packages.adb" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(index)
+	}))
+	defer ts.Close()
+
+	t.Run("v3", func(t *testing.T) {
+		out, err := getIndexV3(ctx, ts.URL+"/v3", "x86_64")
+		require.NoError(t, err)
+		require.NotNil(t, out)
+		assert.EqualValues(t, 1, out.Count())
+		assert.EqualValues(t, ts.URL+"/v3/x86_64/Packages.adb", out.Name())
+	})
+	t.Run("v2", func(t *testing.T) {
+		out, err := getIndexV3(ctx, ts.URL+"/v2", "x86_64")
+		assert.NoError(t, err)
+		assert.Nil(t, out)
+	})
+}
@@ -0,0 +1,146 @@
+package alpine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+)
+
+const busyboxPath = "/bin/busybox"
+
+// busyboxPathsDir contains the output of 'busybox --list-full',
+// which Alpine ships so that the applets can be installed in
+// their usual locations.
+var busyboxPathsDir = filepath.Join("/etc", "busybox-paths.d")
+
+// appletListStart is the start of the list of applet names
+// embedded in the busybox binary, which is sorted and
+// separated by null bytes.
+var appletListStart = []byte("[\x00[[\x00")
+
+// InstallBusybox emulates the trigger that runs 'busybox --install -s'
+// when busybox is installed, creating a symbolic link for each applet
+// (e.g. /bin/ls -> /bin/busybox). Files that already exist are never
+// replaced.
+func InstallBusybox(ctx context.Context, rootfs fs.FullFS) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	if _, err := rootfs.Stat(busyboxPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.V(4).Info("skipping busybox installation as it hasn't been installed")
+			return nil
+		}
+		return err
+	}
+	applets, err := busyboxApplets(ctx, rootfs)
+	if err != nil {
+		return err
+	}
+	if len(applets) == 0 {
+		log.Info("unable to locate the busybox applets, links will not be created")
+		return nil
+	}
+
+	var created int
+	for _, path := range applets {
+		if path == busyboxPath {
+			continue
+		}
+		if _, err := rootfs.Lstat(path); err == nil {
+			log.V(6).Info("skipping applet as the file already exists", "path", path)
+			continue
+		}
+		if err := rootfs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("creating parent directory: %w", err)
+		}
+		if err := rootfs.Symlink(busyboxPath, path); err != nil {
+			return fmt.Errorf("creating applet '%s': %w", path, err)
+		}
+		created++
+	}
+	log.V(1).Info("installed busybox applets", "count", created)
+	return nil
+}
+
+// busyboxApplets returns the path of each applet. The list shipped
+// alongside busybox is preferred, otherwise the applet names are read
+// from the binary and installed into /bin.
+func busyboxApplets(ctx context.Context, rootfs fs.FullFS) ([]string, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	var out []string
+	entries, err := rootfs.ReadDir(busyboxPathsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading %s: %w", busyboxPathsDir, err)
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(busyboxPathsDir, entry.Name())
+		data, err := rootfs.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				out = append(out, filepath.Clean("/"+line))
+			}
+		}
+		log.V(4).Info("read applet list", "path", path)
+	}
+	if len(out) == 0 {
+		data, err := rootfs.ReadFile(busyboxPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", busyboxPath, err)
+		}
+		for _, name := range parseApplets(data) {
+			out = append(out, filepath.Join("/bin", name))
+		}
+		log.V(4).Info("read applet names from the busybox binary", "count", len(out))
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// parseApplets finds the list of applet
+// names in the busybox binary.
+func parseApplets(data []byte) []string {
+	i := bytes.Index(data, appletListStart)
+	if i < 0 {
+		return nil
+	}
+	var out []string
+	rest := data[i:]
+	for {
+		j := bytes.IndexByte(rest, 0)
+		// the list ends with an empty name
+		if j <= 0 || !isApplet(rest[:j]) {
+			break
+		}
+		out = append(out, string(rest[:j]))
+		rest = rest[j+1:]
+	}
+	return out
+}
+
+func isApplet(name []byte) bool {
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '[', c == ']':
+		default:
+			return false
+		}
+	}
+	return true
+}
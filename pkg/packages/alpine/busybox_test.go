@@ -0,0 +1,79 @@
+package alpine
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBusybox contains an applet list in the
+// same format as the busybox binary.
+const fakeBusybox = "\x7fELF\x00\x01\x02[\x00[[\x00ash\x00cat\x00ls\x00\x00busybox\x00"
+
+func TestInstallBusybox(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	var cases = []struct {
+		name     string
+		files    map[string]string
+		expected map[string]bool
+	}{
+		{
+			"applets from binary",
+			nil,
+			map[string]bool{"/bin/[": true, "/bin/[[": true, "/bin/ash": true, "/bin/cat": true, "/bin/ls": true},
+		},
+		{
+			"applets from list",
+			map[string]string{"/etc/busybox-paths.d/busybox": "bin/ash\nusr/bin/awk\nbin/busybox\n"},
+			map[string]bool{"/bin/ash": true, "/usr/bin/awk": true, "/bin/ls": false},
+		},
+		{
+			"existing files",
+			map[string]string{"/bin/ls": "coreutils"},
+			map[string]bool{"/bin/cat": true, "/bin/ls": false},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			rootfs := fs.NewMemFS()
+			require.NoError(t, rootfs.MkdirAll("/bin", 0755))
+			require.NoError(t, rootfs.WriteFile(busyboxPath, []byte(fakeBusybox), 0755))
+			for path, content := range tt.files {
+				require.NoError(t, rootfs.MkdirAll(filepath.Dir(path), 0755))
+				require.NoError(t, rootfs.WriteFile(path, []byte(content), 0644))
+			}
+
+			require.NoError(t, InstallBusybox(ctx, rootfs))
+
+			for path, link := range tt.expected {
+				target, err := rootfs.Readlink(path)
+				if !link {
+					assert.Error(t, err, path)
+					continue
+				}
+				require.NoError(t, err, path)
+				assert.EqualValues(t, busyboxPath, target)
+			}
+			// the binary itself must be left alone
+			info, err := rootfs.Lstat(busyboxPath)
+			require.NoError(t, err)
+			assert.True(t, info.Mode().IsRegular())
+		})
+	}
+
+	t.Run("not installed", func(t *testing.T) {
+		assert.NoError(t, InstallBusybox(ctx, fs.NewMemFS()))
+	})
+}
+
+func TestParseApplets(t *testing.T) {
+	assert.EqualValues(t, []string{"[", "[[", "ash", "cat", "ls"}, parseApplets([]byte(fakeBusybox)))
+	assert.Empty(t, parseApplets([]byte("\x7fELF\x00ls\x00")))
+}
@@ -0,0 +1,75 @@
+package alpine
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"github.com/go-logr/logr"
+)
+
+// indexV3 is the name of the index used
+// by apk-tools v3 repositories.
+const indexV3 = "Packages.adb"
+
+// getIndices downloads the index of each repository. Repositories
+// that have an apk v3 index are read directly, and everything else
+// is assumed to be a v2 repository with an APKINDEX.tar.gz.
+func getIndices(ctx context.Context, repositories []string, arch string) ([]apk.NamedIndex, error) {
+	var indices []apk.NamedIndex
+	for _, repo := range repositories {
+		index, err := getIndexV3(ctx, repo, arch)
+		if err != nil {
+			return nil, err
+		}
+		if index != nil {
+			indices = append(indices, index)
+			continue
+		}
+		v2, err := apk.GetRepositoryIndexes(ctx, []string{repo}, map[string][]byte{}, arch, apk.WithIgnoreSignatures(true), apk.WithHTTPClient(http.DefaultClient))
+		if err != nil {
+			return nil, err
+		}
+		indices = append(indices, v2...)
+	}
+	return indices, nil
+}
+
+// getIndexV3 downloads and parses the Packages.adb index of a
+// repository. If the repository doesn't have one, nil is returned.
+func getIndexV3(ctx context.Context, repo, arch string) (apk.NamedIndex, error) {
+	repoBase := strings.TrimSuffix(repo, "/") + "/" + arch
+	target := repoBase + "/" + indexV3
+	log := logr.FromContextOrDiscard(ctx).WithValues("url", target)
+
+	// local repositories are handled by apko
+	if !strings.HasPrefix(repo, "http://") && !strings.HasPrefix(repo, "https://") {
+		return nil, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// anything other than a successful response means that
+	// this probably isn't a v3 repository
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.V(4).Info("failed to locate apk v3 index", "code", resp.StatusCode)
+		return nil, nil
+	}
+
+	index, err := readADBIndex(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading index %s: %w", target, err)
+	}
+	log.V(1).Info("loaded apk v3 index", "count", len(index.Packages))
+	ref := apk.Repository{URI: repoBase}
+	return apk.NewNamedRepositoryWithIndex(target, ref.WithIndex(index)), nil
+}
@@ -1,13 +1,22 @@
 package alpine
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/apk/fs"
 	"github.com/djcass44/all-your-base/pkg/packages"
+	"github.com/go-logr/logr"
 )
 
 var installedFiles = []string{
@@ -15,10 +24,233 @@ var installedFiles = []string{
 	filepath.Join("/usr", "lib", "apk", "db", "installed"),
 }
 
+// worldFile contains the packages that were
+// explicitly requested (e.g. 'apk add git').
+var worldFile = filepath.Join("/etc", "apk", "world")
+
+// scriptTypes are the scripts that apk keeps in
+// scripts.tar so that they can be run again later
+// (e.g. when the package is removed).
+var scriptTypes = []string{
+	"pre-install",
+	"post-install",
+	"pre-deinstall",
+	"post-deinstall",
+	"pre-upgrade",
+	"post-upgrade",
+	"trigger",
+}
+
+// installScripts are the scripts that apk runs when a
+// package is installed, in the order that they're run.
+var installScripts = []string{
+	"pre-install",
+	"post-install",
+	"trigger",
+}
+
 // writeInstalled updates an Alpine "installed packages" database to include
 // a given package.
 func (p *PackageKeeper) writeInstalled(ctx context.Context, pkg []*apk.RepositoryPackage, rootfs fs.FullFS) error {
+	p.mu.Lock()
+	if p.recorded == nil {
+		p.recorded = map[string]*apk.RepositoryPackage{}
+	}
+	for i := range pkg {
+		p.recorded[pkg[i].Name] = pkg[i]
+	}
+	p.mu.Unlock()
+
 	return packages.RecordAll(ctx, p.base, installedFiles, pkg, rootfs, func(t *apk.RepositoryPackage) string {
 		return strings.Join(apk.PackageToInstalled(t.Package), "\n")
 	})
 }
+
+// getRecorded returns the package if it has been
+// written to the installed database.
+func (p *PackageKeeper) getRecorded(pkg string) (*apk.RepositoryPackage, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	installed, ok := p.recorded[pkg]
+	return installed, ok
+}
+
+// dbDirs returns the directories that
+// contain the installed database.
+func dbDirs() []string {
+	out := make([]string, len(installedFiles))
+	for i := range installedFiles {
+		out[i] = filepath.Dir(installedFiles[i])
+	}
+	return out
+}
+
+// readDB returns the contents of a database file, copying it
+// from the base image first if needed. A file that doesn't
+// exist is treated as empty.
+func (p *PackageKeeper) readDB(ctx context.Context, path string, rootfs fs.FullFS) ([]byte, error) {
+	if err := packages.Extract(ctx, p.base, path, rootfs); err != nil {
+		return nil, err
+	}
+	data, err := rootfs.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// writeWorld adds entries (e.g. "git~2.40" or "!foo") to the world
+// file. An entry replaces any existing entry for the same package.
+func (p *PackageKeeper) writeWorld(ctx context.Context, entries []string, rootfs fs.FullFS) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("path", worldFile)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	data, err := p.readDB(ctx, worldFile, rootfs)
+	if err != nil {
+		return err
+	}
+	world := map[string]string{}
+	for _, entry := range append(strings.Fields(string(data)), entries...) {
+		c, ok := parseConstraint(entry)
+		if !ok {
+			log.V(4).Info("skipping invalid world entry", "entry", entry)
+			continue
+		}
+		world[c.name] = entry
+	}
+
+	// apk keeps the world sorted by name
+	names := make([]string, 0, len(world))
+	for name := range world {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	sb := strings.Builder{}
+	for _, name := range names {
+		sb.WriteString(world[name] + "\n")
+	}
+	log.V(5).Info("updating world", "entries", entries)
+	if err := rootfs.WriteFile(worldFile, []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", worldFile, err)
+	}
+	return nil
+}
+
+// scriptName returns the name that apk uses for a script
+// in scripts.tar (e.g. "busybox-1.36.1-r2.Q1...=.post-install").
+func scriptName(pkg *apk.RepositoryPackage, scriptType string) string {
+	return fmt.Sprintf("%s-%s.%s.%s", pkg.Name, pkg.Version, pkg.ChecksumString(), scriptType)
+}
+
+// writeScripts adds the install scripts of a package to scripts.tar,
+// replacing any scripts that were already recorded for it.
+func (p *PackageKeeper) writeScripts(ctx context.Context, pkg *apk.RepositoryPackage, info *pkgInfo, rootfs fs.FullFS) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("pkg", pkg.Name)
+
+	var scripts []string
+	for _, scriptType := range scriptTypes {
+		if _, ok := info.scripts["."+scriptType]; ok {
+			scripts = append(scripts, scriptType)
+		}
+	}
+	if len(scripts) == 0 {
+		return nil
+	}
+	prefix := scriptName(pkg, "")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, dir := range dbDirs() {
+		path := filepath.Join(dir, "scripts.tar")
+		data, err := p.readDB(ctx, path, rootfs)
+		if err != nil {
+			return err
+		}
+
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		// copy the existing scripts
+		tr := tar.NewReader(bytes.NewReader(data))
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", path, err)
+			}
+			if strings.HasPrefix(header.Name, prefix) {
+				continue
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				return err
+			}
+		}
+		for _, scriptType := range scripts {
+			script := info.scripts["."+scriptType]
+			if err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     scriptName(pkg, scriptType),
+				Mode:     0755,
+				Size:     int64(len(script)),
+				ModTime:  time.Unix(0, 0),
+			}); err != nil {
+				return err
+			}
+			if _, err := tw.Write(script); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		log.V(5).Info("updating scripts", "path", path, "scripts", scripts)
+		if err := rootfs.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// writeTriggers records the paths that a package's trigger
+// script watches, replacing any existing entry for it.
+func (p *PackageKeeper) writeTriggers(ctx context.Context, pkg *apk.RepositoryPackage, info *pkgInfo, rootfs fs.FullFS) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("pkg", pkg.Name)
+
+	if len(info.triggers) == 0 {
+		return nil
+	}
+	checksum := pkg.ChecksumString()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, dir := range dbDirs() {
+		path := filepath.Join(dir, "triggers")
+		data, err := p.readDB(ctx, path, rootfs)
+		if err != nil {
+			return err
+		}
+		sb := strings.Builder{}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || fields[0] == checksum {
+				continue
+			}
+			sb.WriteString(line + "\n")
+		}
+		sb.WriteString(checksum + " " + strings.Join(info.triggers, " ") + "\n")
+
+		log.V(5).Info("updating triggers", "path", path, "triggers", info.triggers)
+		if err := rootfs.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package alpine
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/Snakdy/container-build-engine/pkg/oci/empty"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTar creates a tar archive containing the given files.
+func newTar(t *testing.T, files ...tarEntry) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, f := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: f.name, Mode: 0644, Size: int64(len(f.content))}))
+		_, err := tw.Write([]byte(f.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// newBaseImage creates an image with a single
+// layer containing the given files.
+func newBaseImage(t *testing.T, files ...tarEntry) ociv1.Image {
+	data := newTar(t, files...)
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	require.NoError(t, err)
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	require.NoError(t, err)
+	return img
+}
+
+func TestPackageKeeper_writeWorld(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	t.Run("base image", func(t *testing.T) {
+		rootfs := fs.NewMemFS()
+		keeper := &PackageKeeper{base: newBaseImage(t, tarEntry{name: "etc/apk/world", content: "alpine-baselayout\nbusybox\ngit\n"})}
+
+		require.NoError(t, keeper.writeWorld(ctx, []string{"git~2.40", "!dash"}, rootfs))
+		require.NoError(t, keeper.writeWorld(ctx, []string{"curl"}, rootfs))
+
+		out, err := rootfs.ReadFile(worldFile)
+		require.NoError(t, err)
+		assert.EqualValues(t, "alpine-baselayout\nbusybox\ncurl\n!dash\ngit~2.40\n", string(out))
+	})
+	t.Run("scratch", func(t *testing.T) {
+		rootfs := fs.NewMemFS()
+		keeper := &PackageKeeper{}
+
+		require.NoError(t, keeper.writeWorld(ctx, []string{"so:libssl.so.3"}, rootfs))
+
+		out, err := rootfs.ReadFile(worldFile)
+		require.NoError(t, err)
+		assert.EqualValues(t, "so:libssl.so.3\n", string(out))
+	})
+}
+
+func TestPackageKeeper_UnpackScripts(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	base := newBaseImage(t,
+		tarEntry{name: "lib/apk/db/scripts.tar", content: string(newTar(t, tarEntry{name: "busybox-1.36.1-r2.Q1YnVzeWJveA==.post-install", content: "#!/bin/sh\n"}))},
+		tarEntry{name: "lib/apk/db/triggers", content: "Q1YnVzeWJveA== /bin /usr/bin\n"},
+	)
+	hello := &apk.RepositoryPackage{Package: &apk.Package{Name: "hello", Version: "1.0-r0", Checksum: []byte("hello")}}
+
+	rootfs := fs.NewMemFS()
+	keeper := &PackageKeeper{
+		base:     base,
+		recorded: map[string]*apk.RepositoryPackage{"hello": hello},
+	}
+	// unpack twice to make sure that the
+	// scripts and triggers aren't duplicated
+	for range 2 {
+		require.NoError(t, keeper.Unpack(ctx, newAPK(t, false, "", ""), rootfs))
+	}
+
+	data, err := rootfs.ReadFile("/lib/apk/db/scripts.tar")
+	require.NoError(t, err)
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+	assert.EqualValues(t, []string{"busybox-1.36.1-r2.Q1YnVzeWJveA==.post-install", "hello-1.0-r0.Q1aGVsbG8=.post-install"}, names)
+
+	data, err = rootfs.ReadFile("/lib/apk/db/triggers")
+	require.NoError(t, err)
+	assert.EqualValues(t, "Q1YnVzeWJveA== /bin /usr/bin\nQ1aGVsbG8= /usr/share/fonts/* /usr/share/icons/*\n", string(data))
+
+	t.Run("unrecorded packages are skipped", func(t *testing.T) {
+		rootfs := fs.NewMemFS()
+		require.NoError(t, (&PackageKeeper{base: base}).Unpack(ctx, newAPK(t, false, "", ""), rootfs))
+		_, err := rootfs.Stat("/lib/apk/db/scripts.tar")
+		assert.Error(t, err)
+	})
+}
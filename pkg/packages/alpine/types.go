@@ -1,8 +1,11 @@
 package alpine
 
 import (
+	"sync"
+
 	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/djcass44/all-your-base/pkg/triggers"
 	ociv1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
@@ -10,4 +13,12 @@ type PackageKeeper struct {
 	rootfs  fs.FullFS
 	indices []apk.NamedIndex
 	base    ociv1.Image
+
+	mu sync.Mutex
+	// recorded contains the packages that have been
+	// written to the installed database, keyed by name
+	recorded map[string]*apk.RepositoryPackage
+	// scripts are the install scripts of
+	// the packages that have been unpacked
+	scripts []triggers.Script
 }
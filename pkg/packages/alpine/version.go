@@ -0,0 +1,174 @@
+package alpine
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// regexpConstraint parses a package from the apk world file
+// (e.g. "git", "git=2.40.1-r0", "git~2.40", "!foo").
+var regexpConstraint = regexp.MustCompile(`^(?P<exclude>!)?(?P<name>[^<>=~!]+)(?:(?P<op>[<>]=?|=|~)(?P<version>.+))?$`)
+
+// regexpVersion parses an apk version, which looks like
+// "1.2.3a_rc1_p2-r0".
+var regexpVersion = regexp.MustCompile(`^(?P<numbers>\d+(?:\.\d+)*)(?P<letter>[a-z])?(?P<suffixes>(?:_[a-z]+\d*)*)(?:~[0-9a-f]+)?(?:-r(?P<revision>\d+))?$`)
+
+var regexpSuffix = regexp.MustCompile(`_([a-z]+)(\d*)`)
+
+// suffixOrder is the order of version suffixes. Pre-release
+// suffixes sort before a version without a suffix, and
+// everything else sorts after it.
+var suffixOrder = map[string]int{
+	"alpha": -4,
+	"beta":  -3,
+	"pre":   -2,
+	"rc":    -1,
+	"cvs":   1,
+	"svn":   2,
+	"git":   3,
+	"hg":    4,
+	"p":     5,
+}
+
+// constraint is a package that has been requested, along with
+// the version that it must have.
+type constraint struct {
+	name    string
+	op      string
+	version string
+	// exclude indicates that the package
+	// must not be installed
+	exclude bool
+}
+
+// parseConstraint parses a package using the same syntax
+// as the apk world file.
+func parseConstraint(s string) (constraint, bool) {
+	match := regexpConstraint.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return constraint{}, false
+	}
+	return constraint{
+		name:    match[regexpConstraint.SubexpIndex("name")],
+		op:      match[regexpConstraint.SubexpIndex("op")],
+		version: match[regexpConstraint.SubexpIndex("version")],
+		exclude: match[regexpConstraint.SubexpIndex("exclude")] != "",
+	}, true
+}
+
+// matches returns true if the version satisfies the constraint.
+func (c constraint) matches(version string) bool {
+	switch c.op {
+	case "":
+		return true
+	case "~":
+		// fuzzy matches only compare as much
+		// of the version as was given
+		if !strings.HasPrefix(version, c.version) {
+			return false
+		}
+		rest := strings.TrimPrefix(version, c.version)
+		return rest == "" || strings.ContainsAny(rest[:1], ".-_~") || isLetter(rest[0]) && !isLetter(c.version[len(c.version)-1])
+	}
+	cmp := compareVersions(version, c.version)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func isLetter(b byte) bool {
+	return b >= 'a' && b <= 'z'
+}
+
+type apkVersion struct {
+	numbers  []string
+	letter   string
+	suffixes [][2]string
+	revision int
+}
+
+func parseAPKVersion(s string) (apkVersion, bool) {
+	match := regexpVersion.FindStringSubmatch(s)
+	if match == nil {
+		return apkVersion{}, false
+	}
+	out := apkVersion{
+		numbers: strings.Split(match[regexpVersion.SubexpIndex("numbers")], "."),
+		letter:  match[regexpVersion.SubexpIndex("letter")],
+	}
+	for _, suffix := range regexpSuffix.FindAllStringSubmatch(match[regexpVersion.SubexpIndex("suffixes")], -1) {
+		out.suffixes = append(out.suffixes, [2]string{suffix[1], suffix[2]})
+	}
+	out.revision, _ = strconv.Atoi(match[regexpVersion.SubexpIndex("revision")])
+	return out, true
+}
+
+// compareVersions compares two apk versions, returning a negative
+// number if a is older than b, and a positive number if it's newer.
+// Versions that can't be parsed are compared as strings.
+func compareVersions(a, b string) int {
+	va, okA := parseAPKVersion(a)
+	vb, okB := parseAPKVersion(b)
+	if !okA || !okB {
+		return strings.Compare(a, b)
+	}
+
+	for i := 0; i < len(va.numbers) && i < len(vb.numbers); i++ {
+		if cmp := compareNumber(va.numbers[i], vb.numbers[i], i == 0); cmp != 0 {
+			return cmp
+		}
+	}
+	if cmp := len(va.numbers) - len(vb.numbers); cmp != 0 {
+		return cmp
+	}
+	if cmp := strings.Compare(va.letter, vb.letter); cmp != 0 {
+		return cmp
+	}
+	for i := 0; i < len(va.suffixes) || i < len(vb.suffixes); i++ {
+		// a missing suffix sorts between the
+		// pre-release and post-release suffixes
+		var sa, sb [2]string
+		if i < len(va.suffixes) {
+			sa = va.suffixes[i]
+		}
+		if i < len(vb.suffixes) {
+			sb = vb.suffixes[i]
+		}
+		if cmp := suffixOrder[sa[0]] - suffixOrder[sb[0]]; cmp != 0 {
+			return cmp
+		}
+		if cmp := compareNumber(sa[1], sb[1], true); cmp != 0 {
+			return cmp
+		}
+	}
+	return va.revision - vb.revision
+}
+
+// compareNumber compares a version component. Components after
+// the first that have a leading zero are compared as strings, in
+// the same way as apk.
+func compareNumber(a, b string, first bool) int {
+	if !first && (strings.HasPrefix(a, "0") || strings.HasPrefix(b, "0")) {
+		return strings.Compare(a, b)
+	}
+	na, _ := strconv.ParseUint(a, 10, 64)
+	nb, _ := strconv.ParseUint(b, 10, 64)
+	switch {
+	case na < nb:
+		return -1
+	case na > nb:
+		return 1
+	}
+	return 0
+}
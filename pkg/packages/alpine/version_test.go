@@ -0,0 +1,87 @@
+package alpine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+	var cases = []struct {
+		a, b string
+		cmp  int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0-r1", "1.0-r0", 1},
+		{"1.10", "1.9", 1},
+		{"1.0.1", "1.0", 1},
+		{"1.0a", "1.0", 1},
+		{"1.0_rc1", "1.0", -1},
+		{"1.0_alpha1", "1.0_beta1", -1},
+		{"1.0_p1", "1.0", 1},
+		{"1.0_rc2", "1.0_rc10", -1},
+		{"1.05", "1.1", -1},
+		{"2.40.1-r0", "2.39.2-r0", 1},
+	}
+	for _, tt := range cases {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			cmp := compareVersions(tt.a, tt.b)
+			switch {
+			case tt.cmp < 0:
+				assert.Negative(t, cmp)
+			case tt.cmp > 0:
+				assert.Positive(t, cmp)
+			default:
+				assert.Zero(t, cmp)
+			}
+		})
+	}
+}
+
+func TestParseConstraint(t *testing.T) {
+	var cases = []struct {
+		in  string
+		out constraint
+	}{
+		{"git", constraint{name: "git"}},
+		{"git=2.40.1-r0", constraint{name: "git", op: "=", version: "2.40.1-r0"}},
+		{"git~2.40", constraint{name: "git", op: "~", version: "2.40"}},
+		{"git>=2.40", constraint{name: "git", op: ">=", version: "2.40"}},
+		{"so:libssl.so.3", constraint{name: "so:libssl.so.3"}},
+		{"cmd:curl", constraint{name: "cmd:curl"}},
+		{"!foo", constraint{name: "foo", exclude: true}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			out, ok := parseConstraint(tt.in)
+			assert.True(t, ok)
+			assert.EqualValues(t, tt.out, out)
+		})
+	}
+}
+
+func TestConstraint_Matches(t *testing.T) {
+	var cases = []struct {
+		constraint string
+		version    string
+		ok         bool
+	}{
+		{"git", "2.40.1-r0", true},
+		{"git=2.40.1-r0", "2.40.1-r0", true},
+		{"git=2.40.1-r0", "2.40.1-r1", false},
+		{"git~2.40", "2.40.1-r0", true},
+		{"git~2.40", "2.40-r0", true},
+		{"git~2.4", "2.40.1-r0", false},
+		{"git~2.40", "2.41.0-r0", false},
+		{"git<2.40", "2.39.2-r0", true},
+		{"git<2.40", "2.40.1-r0", false},
+		{"git>=2.40", "2.40.1-r0", true},
+	}
+	for _, tt := range cases {
+		t.Run(tt.constraint+" "+tt.version, func(t *testing.T) {
+			c, ok := parseConstraint(tt.constraint)
+			assert.True(t, ok)
+			assert.EqualValues(t, tt.ok, c.matches(tt.version))
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package debian
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/blakesmith/ar"
+	"github.com/djcass44/all-your-base/pkg/debian"
+	"github.com/djcass44/all-your-base/pkg/requestutil"
+	"github.com/go-logr/logr"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+	"pault.ag/go/debian/control"
+)
+
+const (
+	controlArchive = "control.tar"
+	dataArchive    = "data.tar"
+)
+
+// dataReaders decompress the data archive
+// based on its extension.
+var dataReaders = map[string]func(r io.Reader) (io.Reader, error){
+	"": func(r io.Reader) (io.Reader, error) {
+		return r, nil
+	},
+	".gz": func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	".xz": func(r io.Reader) (io.Reader, error) {
+		return xz.NewReader(r)
+	},
+	".zst": func(r io.Reader) (io.Reader, error) {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	},
+	".bz2": func(r io.Reader) (io.Reader, error) {
+		return bzip2.NewReader(r), nil
+	},
+	".lzma": func(r io.Reader) (io.Reader, error) {
+		return lzma.NewReader(r)
+	},
+}
+
+// maintainerScripts are the scripts that dpkg runs when a
+// package is installed, in the order that they're run.
+var maintainerScripts = []string{
+	"preinst",
+	"postinst",
+}
+
+// controlFiles are the files from the
+// control archive that we care about.
+type controlFiles struct {
+	control *debian.Package
+	md5sums []byte
+	// conffiles are the paths of the
+	// package's configuration files
+	conffiles []string
+	// scripts contains the maintainer
+	// scripts, keyed by their name
+	scripts map[string][]byte
+}
+
+// readDeb reads a deb file one member at a time. The control archive
+// is parsed and then the data archive is streamed into the extract
+// function, so that the package never needs to be held in memory.
+func readDeb(ctx context.Context, r io.Reader, extract func(ctrl *controlFiles, data io.Reader) error) error {
+	log := logr.FromContextOrDiscard(ctx)
+	reader := ar.NewReader(r)
+
+	var ctrl *controlFiles
+	for {
+		header, err := reader.Next()
+		switch {
+		case err == io.EOF:
+			if ctrl == nil {
+				return errors.New("control archive could not be found")
+			}
+			return errors.New("data archive could not be found")
+		case err != nil:
+			log.Error(err, "failed to read file from archive")
+			return err
+		case header == nil:
+			continue
+		}
+		// GNU ar terminates names with a '/'
+		name := strings.TrimSuffix(strings.TrimSpace(header.Name), "/")
+		log.V(5).Info("reading archive member", "name", name, "size", header.Size)
+
+		switch {
+		case strings.HasPrefix(name, controlArchive):
+			ctrl, err = readControlArchive(ctx, reader)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", name, err)
+			}
+		case strings.HasPrefix(name, dataArchive):
+			// the control archive always comes first, so
+			// if we haven't seen it the package is invalid
+			if ctrl == nil {
+				return errors.New("data archive found before the control archive")
+			}
+			newReader, ok := dataReaders[strings.TrimPrefix(name, dataArchive)]
+			if !ok {
+				return fmt.Errorf("unknown or unsupported data archive: %s", name)
+			}
+			data, err := newReader(reader)
+			if err != nil {
+				return fmt.Errorf("decompressing %s: %w", name, err)
+			}
+			if c, ok := data.(io.Closer); ok {
+				defer c.Close()
+			}
+			return extract(ctrl, data)
+		}
+	}
+}
+
+// readControlArchive reads the files that we need
+// from a (possibly compressed) control archive.
+func readControlArchive(ctx context.Context, r io.Reader) (*controlFiles, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	dr, compression, err := requestutil.Decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+	log.V(5).Info("detected control archive compression", "compression", compression)
+
+	var out controlFiles
+	tr := tar.NewReader(dr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.Clean("/" + header.Name)
+		switch name {
+		case "/control":
+			var pkg debian.Package
+			if err := control.Unmarshal(&pkg, tr); err != nil {
+				return nil, fmt.Errorf("parsing control file: %w", err)
+			}
+			out.control = &pkg
+		case "/md5sums":
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("reading md5sums: %w", err)
+			}
+			out.md5sums = data
+		case "/conffiles":
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("reading conffiles: %w", err)
+			}
+			out.conffiles = strings.Fields(string(data))
+		default:
+			if slices.Contains(maintainerScripts, name[1:]) {
+				data, err := io.ReadAll(tr)
+				if err != nil {
+					return nil, fmt.Errorf("reading %s: %w", name, err)
+				}
+				if out.scripts == nil {
+					out.scripts = map[string][]byte{}
+				}
+				out.scripts[name[1:]] = data
+			}
+		}
+	}
+	if out.control == nil {
+		return nil, errors.New("control file could not be found")
+	}
+	return &out, nil
+}
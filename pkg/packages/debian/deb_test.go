@@ -0,0 +1,363 @@
+package debian
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/Snakdy/container-build-engine/pkg/oci/empty"
+	"github.com/blakesmith/ar"
+	"github.com/djcass44/all-your-base/pkg/debian"
+	"github.com/djcass44/all-your-base/pkg/lockfile"
+	"github.com/djcass44/all-your-base/pkg/packages"
+	"github.com/djcass44/all-your-base/pkg/triggers"
+	"github.com/dsnet/compress/bzip2"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// tarEntry is a file in a test archive. Names
+// ending with '/' are directories.
+type tarEntry struct {
+	name    string
+	content string
+}
+
+// newTar creates a tar archive containing the given files.
+func newTar(t *testing.T, files ...tarEntry) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, f := range files {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.name,
+			Mode:     0644,
+			Size:     int64(len(f.content)),
+		}
+		if strings.HasSuffix(f.name, "/") {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err := tw.Write([]byte(f.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+var (
+	helloControl = []tarEntry{
+		{"./", ""},
+		{"./control", "Package: hello\nVersion: 1.0\nArchitecture: amd64\n"},
+		{"./md5sums", "d41d8cd98f00b204e9800998ecf8427e  usr/bin/hello\n"},
+	}
+	helloData = []tarEntry{
+		{"./", ""},
+		{"./usr/", ""},
+		{"./usr/bin/", ""},
+		{"./usr/bin/hello", "#!/bin/sh\necho hello\n"},
+	}
+)
+
+// newDeb creates a deb file with a gzip compressed control archive
+// and a data archive that uses the given compression.
+func newDeb(t *testing.T, dataName string, compress func(w io.Writer) (io.WriteCloser, error), control, data []tarEntry) string {
+	controlArchive := new(bytes.Buffer)
+	gw := gzip.NewWriter(controlArchive)
+	_, err := gw.Write(newTar(t, control...))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	dataArchive := new(bytes.Buffer)
+	cw, err := compress(dataArchive)
+	require.NoError(t, err)
+	_, err = cw.Write(newTar(t, data...))
+	require.NoError(t, err)
+	require.NoError(t, cw.Close())
+
+	path := filepath.Join(t.TempDir(), "hello_1.0_amd64.deb")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	aw := ar.NewWriter(f)
+	require.NoError(t, aw.WriteGlobalHeader())
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", controlArchive.Bytes()},
+		{dataName, dataArchive.Bytes()},
+	} {
+		require.NoError(t, aw.WriteHeader(&ar.Header{
+			Name:    member.name,
+			ModTime: time.Unix(0, 0),
+			Mode:    0644,
+			Size:    int64(len(member.data)),
+		}))
+		_, err = aw.Write(member.data)
+		require.NoError(t, err)
+	}
+	return path
+}
+
+func TestPackageKeeper_UnpackCompression(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	var cases = []struct {
+		name     string
+		compress func(w io.Writer) (io.WriteCloser, error)
+	}{
+		{
+			"data.tar",
+			func(w io.Writer) (io.WriteCloser, error) {
+				return nopWriteCloser{w}, nil
+			},
+		},
+		{
+			"data.tar.gz",
+			func(w io.Writer) (io.WriteCloser, error) {
+				return gzip.NewWriter(w), nil
+			},
+		},
+		{
+			"data.tar.xz",
+			func(w io.Writer) (io.WriteCloser, error) {
+				return xz.NewWriter(w)
+			},
+		},
+		{
+			"data.tar.zst",
+			func(w io.Writer) (io.WriteCloser, error) {
+				return zstd.NewWriter(w)
+			},
+		},
+		{
+			"data.tar.bz2",
+			func(w io.Writer) (io.WriteCloser, error) {
+				return bzip2.NewWriter(w, nil)
+			},
+		},
+		{
+			"data.tar.lzma",
+			func(w io.Writer) (io.WriteCloser, error) {
+				return lzma.NewWriter(w)
+			},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			path := newDeb(t, tt.name, tt.compress, helloControl, helloData)
+
+			rootfs := fs.NewMemFS()
+			pkg := &PackageKeeper{arch: "amd64", recorded: map[string]debian.Package{"hello": {Package: "hello"}}}
+			require.NoError(t, pkg.Unpack(ctx, path, rootfs))
+
+			out, err := rootfs.ReadFile("/usr/bin/hello")
+			require.NoError(t, err)
+			assert.EqualValues(t, "#!/bin/sh\necho hello\n", string(out))
+
+			// the package was recorded, so the info
+			// files should have been written
+			out, err = rootfs.ReadFile("/var/lib/dpkg/status.d/hello.md5sums")
+			require.NoError(t, err)
+			assert.Contains(t, string(out), "usr/bin/hello")
+			out, err = rootfs.ReadFile("/var/lib/dpkg/info/hello.list")
+			require.NoError(t, err)
+			assert.EqualValues(t, "/.\n/usr\n/usr/bin\n/usr/bin/hello\n", string(out))
+		})
+	}
+
+	t.Run("unsupported compression", func(t *testing.T) {
+		path := newDeb(t, "data.tar.foo", func(w io.Writer) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		}, helloControl, helloData)
+		assert.Error(t, (&PackageKeeper{}).Unpack(ctx, path, fs.NewMemFS()))
+	})
+}
+
+func TestPackageKeeper_UnpackConffiles(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	control := append(slices.Clone(helloControl),
+		tarEntry{"./conffiles", "/etc/hello.conf\n"},
+		tarEntry{"./postinst", "#!/bin/sh\nexit 0\n"},
+	)
+	data := append(slices.Clone(helloData),
+		tarEntry{"./etc/", ""},
+		tarEntry{"./etc/hello.conf", "greeting=hello\n"},
+	)
+	path := newDeb(t, "data.tar.gz", func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	}, control, data)
+
+	expected := `Package: hello
+Status: install ok installed
+Architecture: amd64
+Version: 1.0
+Conffiles:
+ /etc/hello.conf 801ef2bfa1ce9046be4eb650dabcc017
+`
+
+	var cases = []struct {
+		name   string
+		base   ociv1.Image
+		status string
+	}{
+		{
+			"status database",
+			empty.Image,
+			"/var/lib/dpkg/status",
+		},
+		{
+			"status.d",
+			nil,
+			"/var/lib/dpkg/status.d/hello",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			rootfs := fs.NewMemFS()
+			pkg := &PackageKeeper{base: tt.base}
+			require.NoError(t, pkg.writeInstalled(ctx, []debian.Package{{Package: "hello", Version: "1.0", Architecture: "amd64"}}, rootfs))
+			require.NoError(t, pkg.Unpack(ctx, path, rootfs))
+
+			out, err := rootfs.ReadFile(tt.status)
+			require.NoError(t, err)
+			assert.Contains(t, string(out), expected)
+
+			out, err = rootfs.ReadFile("/var/lib/dpkg/info/hello.conffiles")
+			require.NoError(t, err)
+			assert.EqualValues(t, "/etc/hello.conf\n", string(out))
+
+			// the maintainer scripts are kept so that they can be emulated
+			assert.EqualValues(t, []triggers.Script{{Package: "hello", Name: "postinst", Content: "#!/bin/sh\nexit 0\n"}}, pkg.Scripts())
+		})
+	}
+}
+
+func TestPackageKeeper_UnpackDependencies(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/Packages" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("Package: libc6\nVersion: 2.36\nArchitecture: amd64\nFilename: libc6.deb\n\nPackage: libgcc-s1\nVersion: 12.2\nArchitecture: amd64\nDepends: libc6\nFilename: libgcc-s1.deb\n"))
+	}))
+	defer srv.Close()
+
+	idx, err := debian.NewFlatIndex(ctx, srv.URL, "./", nil)
+	require.NoError(t, err)
+
+	gz := func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	}
+	libc := newDeb(t, "data.tar.gz", gz, []tarEntry{
+		{"./", ""},
+		{"./control", "Package: libc6\nVersion: 2.36\nArchitecture: amd64\n"},
+		{"./conffiles", "/etc/ld.so.conf.d/libc.conf\n"},
+	}, []tarEntry{
+		{"./", ""},
+		{"./etc/", ""},
+		{"./etc/ld.so.conf.d/", ""},
+		{"./etc/ld.so.conf.d/libc.conf", "/usr/local/lib\n"},
+	})
+	libgcc := newDeb(t, "data.tar.gz", gz, []tarEntry{
+		{"./", ""},
+		{"./control", "Package: libgcc-s1\nVersion: 12.2\nArchitecture: amd64\nDepends: libc6\n"},
+	}, []tarEntry{
+		{"./", ""},
+	})
+
+	var cases = []struct {
+		name   string
+		base   ociv1.Image
+		status string
+	}{
+		{"status database", empty.Image, "/var/lib/dpkg/status"},
+		{"status.d", nil, "/var/lib/dpkg/status.d/libc6"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			rootfs := fs.NewMemFS()
+			keeper := &PackageKeeper{rootfs: rootfs, base: tt.base, resolver: debian.NewResolver("amd64", idx), arch: "amd64"}
+
+			// each package is recorded and unpacked by its own
+			// statement, with dependencies coming first
+			for _, step := range []struct{ name, path string }{{"libc6", libc}, {"libgcc-s1", libgcc}} {
+				_, err := keeper.Resolve(ctx, step.name, packages.ResolveOptions{}, true)
+				require.NoError(t, err)
+				require.NoError(t, keeper.Unpack(ctx, step.path, rootfs))
+			}
+
+			out, err := rootfs.ReadFile(tt.status)
+			require.NoError(t, err)
+			assert.Contains(t, string(out), "Conffiles:\n /etc/ld.so.conf.d/libc.conf")
+			assert.EqualValues(t, 1, strings.Count(string(out), "Package: libc6\n"))
+		})
+	}
+}
+
+func TestPackageKeeper_Check(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/Packages" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("Package: mawk\nVersion: 1.3.4\nArchitecture: amd64\nProvides: awk\nFilename: mawk.deb\n\nPackage: gawk\nVersion: 5.2.1\nArchitecture: amd64\nProvides: awk\nBreaks: mawk (<< 2)\nFilename: gawk.deb\n\nPackage: sed\nVersion: 4.9\nArchitecture: amd64\nFilename: sed.deb\n"))
+	}))
+	defer srv.Close()
+
+	idx, err := debian.NewFlatIndex(ctx, srv.URL, "./", nil)
+	require.NoError(t, err)
+	keeper := &PackageKeeper{rootfs: fs.NewMemFS(), resolver: debian.NewResolver("amd64", idx), arch: "amd64"}
+
+	// each of the names is resolved separately, in
+	// the same way as the entries in the build spec
+	resolve := func(names ...string) []lockfile.Package {
+		var out []lockfile.Package
+		for _, name := range names {
+			resolved, err := keeper.Resolve(ctx, name, packages.ResolveOptions{}, false)
+			require.NoError(t, err)
+			out = append(out, resolved...)
+		}
+		return out
+	}
+
+	t.Run("conflicting packages", func(t *testing.T) {
+		err := keeper.Check(ctx, resolve("mawk", "gawk"))
+		assert.ErrorContains(t, err, "package 'gawk' (5.2.1) conflicts with or breaks 'mawk' (1.3.4)")
+	})
+	t.Run("compatible packages", func(t *testing.T) {
+		assert.NoError(t, keeper.Check(ctx, resolve("mawk", "sed")))
+	})
+}
@@ -1,51 +1,105 @@
 package debian
 
 import (
+	"archive/tar"
 	"context"
-	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 
 	"chainguard.dev/apko/pkg/apk/fs"
 	ociv1 "github.com/google/go-containerregistry/pkg/v1"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	v1 "github.com/djcass44/all-your-base/pkg/api/v1"
 	"github.com/djcass44/all-your-base/pkg/archiveutil"
 	"github.com/djcass44/all-your-base/pkg/debian"
 	"github.com/djcass44/all-your-base/pkg/lockfile"
+	"github.com/djcass44/all-your-base/pkg/packages"
+	"github.com/djcass44/all-your-base/pkg/triggers"
 	"github.com/go-logr/logr"
 )
 
-func NewPackageKeeper(ctx context.Context, repositories []string, rootfs fs.FullFS, base ociv1.Image) (*PackageKeeper, error) {
+// NewPackageKeeper creates a PackageKeeper from the given repositories.
+// Packages are installed for the given Debian architecture (e.g. "arm64").
+func NewPackageKeeper(ctx context.Context, repositories []Repository, arch string, rootfs fs.FullFS, base ociv1.Image) (*PackageKeeper, error) {
 	log := logr.FromContextOrDiscard(ctx)
 
 	var indices []*debian.Index
+	bootstrap := map[string]bool{}
 	for _, repo := range repositories {
-		bits := strings.Split(repo, " ")
-		if len(bits) != 3 {
-			return nil, fmt.Errorf("malformed repository url, expecting: 'base release component'")
+		if repo.Bootstrap != "" && repo.Bootstrap != BootstrapMinbase {
+			return nil, fmt.Errorf("unsupported bootstrap variant: '%s'", repo.Bootstrap)
 		}
-		idx, err := debian.NewIndex(ctx, bits[0], bits[1], bits[2], "amd64")
-		if err != nil {
-			return nil, err
+		var keyring openpgp.EntityList
+		if len(repo.Keyring) > 0 {
+			var err error
+			keyring, err = debian.ReadKeyring(repo.Keyring)
+			if err != nil {
+				return nil, fmt.Errorf("reading keyring: %w", err)
+			}
+		}
+		add := func(idx *debian.Index) {
+			idx.SetPriority(repo.Priority)
+			indices = append(indices, idx)
+			if repo.Bootstrap == "" {
+				return
+			}
+			for _, name := range idx.Required() {
+				bootstrap[name] = true
+			}
+		}
+		archs := repo.Architectures
+		if len(archs) == 0 {
+			archs = []string{arch}
+		}
+		for _, uri := range repo.URIs {
+			uri = strings.TrimSuffix(uri, "/")
+			for _, suite := range repo.Suites {
+				if repo.Flat() {
+					idx, err := debian.NewFlatIndex(ctx, uri, suite, keyring)
+					if err != nil {
+						return nil, err
+					}
+					log.V(2).Info("added index", "count", idx.Count(), "source", uri, "suite", suite, "priority", repo.Priority)
+					add(idx)
+					continue
+				}
+				for _, component := range repo.Components {
+					for _, arch := range archs {
+						idx, err := debian.NewVerifiedIndex(ctx, uri, suite, component, arch, keyring)
+						if err != nil {
+							return nil, err
+						}
+						log.V(2).Info("added index", "count", idx.Count(), "source", uri, "suite", suite, "component", component, "arch", arch, "priority", repo.Priority)
+						add(idx)
+					}
+				}
+			}
 		}
-		log.V(2).Info("added index", "count", idx.Count(), "source", repo)
-		indices = append(indices, idx)
 	}
 	return &PackageKeeper{
-		rootfs:  rootfs,
-		indices: indices,
-		base:    base,
+		rootfs:    rootfs,
+		resolver:  debian.NewResolver(arch, indices...),
+		arch:      arch,
+		base:      base,
+		bootstrap: slices.Sorted(maps.Keys(bootstrap)),
 	}, nil
 }
 
+// Bootstrap returns the names of the packages that are needed to
+// build a minimal system, as selected by the repositories.
+func (p *PackageKeeper) Bootstrap() []string {
+	return p.bootstrap
+}
+
 func (p *PackageKeeper) Unpack(ctx context.Context, pkg string, rootfs fs.FullFS) error {
 	log := logr.FromContextOrDiscard(ctx).WithValues("pkg", pkg)
 	log.V(4).Info("unpacking deb")
 
-	// first we need to unpack the deb file using
-	// the equivalent of 'ar -x'
 	f, err := os.Open(pkg)
 	if err != nil {
 		log.Error(err, "failed to open file")
@@ -53,80 +107,138 @@ func (p *PackageKeeper) Unpack(ctx context.Context, pkg string, rootfs fs.FullFS
 	}
 	defer f.Close()
 
-	tmpFs := fs.NewMemFS()
+	// the deb file is read one member at a time, and the
+	// 'data.tar.X' file that contains the filesystem is
+	// streamed straight into the root filesystem
+	return readDeb(ctx, f, func(ctrl *controlFiles, data io.Reader) error {
+		owner := p.ownerFilter(ctx, ctrl.control)
+		// keep track of the files that we've written
+		// so that they can be listed in the info directory
+		var files []string
+		filter := func(target string, header *tar.Header) bool {
+			if !owner(target, header) {
+				return false
+			}
+			files = append(files, target)
+			return true
+		}
+		if err := archiveutil.UntarFilter(ctx, data, rootfs, filter); err != nil {
+			return err
+		}
 
-	if err := archiveutil.Unar(ctx, f, tmpFs); err != nil {
-		return err
-	}
+		// we can't run maintainer scripts, so keep
+		// track of them so that they can be emulated
+		p.addScripts(ctrl)
 
-	// then we need to unpack the 'data.tar.X' file
-	// that contains the filesystem
+		installed, ok := p.getRecorded(p.nameOf(ctrl.control))
+		if !ok {
+			return nil
+		}
+		if err := p.writeInfo(ctx, ctrl, files, rootfs); err != nil {
+			return err
+		}
+		if len(ctrl.conffiles) == 0 {
+			return nil
+		}
+		installed.Conffiles, err = hashConffiles(rootfs, ctrl.conffiles)
+		if err != nil {
+			return err
+		}
+		return p.updateInstalled(ctx, &installed, rootfs)
+	})
+}
 
-	if _, err := tmpFs.Stat(dataXZ); err == nil {
-		return p.unpackXZ(ctx, tmpFs, rootfs)
-	}
-	if _, err := tmpFs.Stat(dataZstd); err == nil {
-		return p.unpackZstd(ctx, tmpFs, rootfs)
+// addScripts keeps track of the maintainer scripts
+// that dpkg would run when the package is installed.
+func (p *PackageKeeper) addScripts(ctrl *controlFiles) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range maintainerScripts {
+		if script, ok := ctrl.scripts[name]; ok {
+			p.scripts = append(p.scripts, triggers.Script{Package: ctrl.control.Package, Name: name, Content: string(script)})
+		}
 	}
-
-	return errors.New("unknown or unsupported data archive")
 }
 
-const (
-	dataXZ   = "/data.tar.xz"
-	dataZstd = "/data.tar.zst"
-)
+// Scripts returns the maintainer scripts of
+// the packages that have been unpacked.
+func (p *PackageKeeper) Scripts() []triggers.Script {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.scripts)
+}
 
-func (*PackageKeeper) unpackZstd(ctx context.Context, src fs.FullFS, dst fs.FullFS) error {
-	log := logr.FromContextOrDiscard(ctx)
-	log.V(5).Info("unpacking zstandard data archive")
-	f, err := src.Open(dataZstd)
-	if err != nil {
-		log.Error(err, "failed to open data.tar.xz file")
-		return err
+// nameOf returns the name that a package is locked and recorded
+// as. Packages for a foreign architecture are qualified with it
+// (e.g. 'libc6:i386'), so that they don't replace the native
+// package of the same name and are resolved for the same
+// architecture when they're installed.
+func (p *PackageKeeper) nameOf(pkg *debian.Package) string {
+	if pkg.Architecture == "" || pkg.Architecture == debian.ArchAll || pkg.Architecture == p.arch {
+		return pkg.Package
 	}
-	return archiveutil.Zuntar(ctx, f, dst)
+	return pkg.Package + ":" + pkg.Architecture
 }
 
-func (*PackageKeeper) unpackXZ(ctx context.Context, src fs.FullFS, dst fs.FullFS) error {
-	log := logr.FromContextOrDiscard(ctx)
-	log.V(5).Info("unpacking xz data archive")
-	f, err := src.Open(dataXZ)
+func (p *PackageKeeper) Resolve(ctx context.Context, pkg string, opts packages.ResolveOptions, write bool) ([]lockfile.Package, error) {
+	// the package may contain an architecture
+	// qualifier (e.g. 'libc6:i386')
+	alternatives, err := debian.ParseRelation(pkg)
 	if err != nil {
-		log.Error(err, "failed to open data.tar.xz file")
-		return err
+		return nil, err
+	}
+	if len(alternatives) != 1 {
+		return nil, fmt.Errorf("expected a single package: %s", pkg)
+	}
+	out, err := p.resolver.Resolve(ctx, alternatives[0], opts.InstallRecommends)
+	if err != nil {
+		return nil, err
+	}
+	// the requested package is always selected first, which
+	// lets us find it when a virtual package was requested
+	names := make([]lockfile.Package, len(out))
+	installed := make([]debian.Package, len(out))
+	for i := range out {
+		names[i] = lockfile.Package{
+			Name:              p.nameOf(&out[i].Package),
+			Resolved:          strings.TrimSuffix(out[i].Index.Source(), "/") + "/" + strings.TrimPrefix(strings.TrimPrefix(out[i].Filename, "./"), "/"),
+			Integrity:         out[i].Sha256,
+			Version:           out[i].Version,
+			Type:              v1.PackageDebian,
+			Direct:            i == 0,
+			InstallRecommends: opts.InstallRecommends,
+		}
+		installed[i] = out[i].Package
 	}
-	return archiveutil.XZuntar(ctx, f, dst)
+	// only the requested package is recorded, as each of its
+	// dependencies is recorded when it's unpacked by its own
+	// statement (along with its conffiles)
+	if write {
+		if err := p.writeInstalled(ctx, installed[:1], p.rootfs); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
 }
 
-func (p *PackageKeeper) Resolve(ctx context.Context, pkg string, write bool) ([]lockfile.Package, error) {
-	for _, idx := range p.indices {
-		out, err := idx.GetPackageWithDependencies(ctx, map[string]debian.Package{}, &debian.PackageVersion{
-			Names: []string{pkg},
+// Check makes sure that a set of packages that were resolved
+// separately (e.g. each of the names in the build spec) can be
+// installed together.
+func (p *PackageKeeper) Check(ctx context.Context, pkgs []lockfile.Package) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	var candidates []debian.Candidate
+	for _, pkg := range pkgs {
+		name, _, _ := strings.Cut(pkg.Name, ":")
+		all := p.resolver.Candidates(name)
+		i := slices.IndexFunc(all, func(c debian.Candidate) bool {
+			return c.Version == pkg.Version && strings.HasSuffix(pkg.Resolved, "/"+strings.TrimPrefix(strings.TrimPrefix(c.Filename, "./"), "/"))
 		})
-		if err != nil {
-			return nil, err
-		}
-		if len(out) == 0 {
+		if i < 0 {
+			log.V(3).Info("unable to find package in any index", "pkg", pkg.Name, "version", pkg.Version)
 			continue
 		}
-		names := make([]lockfile.Package, len(out))
-		for i := range out {
-			names[i] = lockfile.Package{
-				Name:      out[i].Package,
-				Resolved:  strings.TrimSuffix(idx.Source(), "/") + "/" + strings.TrimPrefix(out[i].Filename, "/"),
-				Integrity: out[i].Sha256,
-				Version:   out[i].Version,
-				Type:      v1.PackageDebian,
-				Direct:    out[i].Package == pkg,
-			}
-		}
-		if write {
-			if err := p.writeInstalled(ctx, out, p.rootfs); err != nil {
-				return nil, err
-			}
-		}
-		return names, nil
+		candidates = append(candidates, all[i])
 	}
-	return nil, fmt.Errorf("package could not be found in any index: %s", pkg)
+	return debian.CheckConflicts(candidates)
 }
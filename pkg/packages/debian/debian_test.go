@@ -2,11 +2,17 @@ package debian
 
 import (
 	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"chainguard.dev/apko/pkg/apk/fs"
 	"github.com/Snakdy/container-build-engine/pkg/containers"
+	"github.com/djcass44/all-your-base/pkg/debian"
+	"github.com/djcass44/all-your-base/pkg/lockfile"
 	"github.com/djcass44/all-your-base/pkg/packages"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/logr/testr"
@@ -38,10 +44,10 @@ func TestPackageKeeper_Resolve(t *testing.T) {
 	baseImage, err := containers.GetImage(ctx, "harbor.dcas.dev/docker.io/library/debian:bullseye")
 	require.NoError(t, err)
 
-	pkg, err := NewPackageKeeper(ctx, []string{"https://mirror.aarnet.edu.au/pub/debian bullseye main"}, testfs, baseImage)
+	pkg, err := NewPackageKeeper(ctx, []Repository{{Source: debian.Source{URIs: []string{"https://mirror.aarnet.edu.au/pub/debian"}, Suites: []string{"bullseye"}, Components: []string{"main"}}}}, "amd64", testfs, baseImage)
 	require.NoError(t, err)
 
-	packageNames, err := pkg.Resolve(ctx, "git", true)
+	packageNames, err := pkg.Resolve(ctx, "git", packages.ResolveOptions{}, true)
 	assert.NoError(t, err)
 	t.Logf("%+v", packageNames)
 
@@ -51,3 +57,129 @@ func TestPackageKeeper_Resolve(t *testing.T) {
 		t.Logf("%+v", string(out))
 	}
 }
+
+func TestPackageKeeper_ownerFilter(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	pkg := &PackageKeeper{}
+
+	foo := &debian.Package{Package: "foo", Version: "1.0"}
+	bar := &debian.Package{Package: "bar", Version: "1.0", Replaces: []string{"foo"}}
+	zoo := &debian.Package{Package: "zoo", Version: "1.0"}
+
+	assert.True(t, pkg.ownerFilter(ctx, foo)("/usr/bin/foo", nil))
+	// bar replaces foo, so it can overwrite the file
+	assert.True(t, pkg.ownerFilter(ctx, bar)("/usr/bin/foo", nil))
+	// bar replaces foo, so foo can't overwrite the file
+	assert.True(t, pkg.ownerFilter(ctx, bar)("/usr/bin/bar", nil))
+	assert.False(t, pkg.ownerFilter(ctx, foo)("/usr/bin/bar", nil))
+	// packages without a relationship overwrite each other
+	assert.True(t, pkg.ownerFilter(ctx, zoo)("/usr/bin/bar", nil))
+}
+
+func TestPackageKeeper_Bootstrap(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/dists/stable/main/binary-amd64/Packages", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("Package: dpkg\nVersion: 1.21.22\nArchitecture: amd64\nEssential: yes\nPriority: required\n\nPackage: libc6\nVersion: 2.36-9\nArchitecture: amd64\nPriority: required\n\nPackage: curl\nVersion: 7.88.1-10\nArchitecture: amd64\nPriority: optional\n"))
+	})
+	mux.HandleFunc("/dists/backports/main/binary-amd64/Packages", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("Package: bash\nVersion: 5.2.15-2\nArchitecture: amd64\nEssential: yes\n"))
+	})
+
+	t.Run("minbase", func(t *testing.T) {
+		pkg, err := NewPackageKeeper(ctx, []Repository{
+			{Source: debian.Source{URIs: []string{srv.URL}, Suites: []string{"stable"}, Components: []string{"main"}}, Bootstrap: BootstrapMinbase},
+			{Source: debian.Source{URIs: []string{srv.URL}, Suites: []string{"backports"}, Components: []string{"main"}}},
+		}, "amd64", fs.NewMemFS(), nil)
+		require.NoError(t, err)
+		// only the repositories that ask for
+		// bootstrapping are used
+		assert.EqualValues(t, []string{"dpkg", "libc6"}, pkg.Bootstrap())
+	})
+	t.Run("unknown variant", func(t *testing.T) {
+		_, err := NewPackageKeeper(ctx, []Repository{
+			{Source: debian.Source{URIs: []string{srv.URL}, Suites: []string{"stable"}, Components: []string{"main"}}, Bootstrap: "buildd"},
+		}, "amd64", fs.NewMemFS(), nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestPackageKeeper_Arch(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/dists/stable/main/binary-arm64/Packages", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("Package: libc6\nVersion: 2.36-9\nArchitecture: arm64\nFilename: pool/main/g/glibc/libc6_2.36-9_arm64.deb\n"))
+	})
+
+	// the index for the architecture is
+	// used when the repository doesn't list any
+	pkg, err := NewPackageKeeper(ctx, []Repository{
+		{Source: debian.Source{URIs: []string{srv.URL}, Suites: []string{"stable"}, Components: []string{"main"}}},
+	}, "arm64", fs.NewMemFS(), nil)
+	require.NoError(t, err)
+
+	out, err := pkg.Resolve(ctx, "libc6", packages.ResolveOptions{}, false)
+	require.NoError(t, err)
+	require.Len(t, out, 1)
+	assert.EqualValues(t, srv.URL+"/pool/main/g/glibc/libc6_2.36-9_arm64.deb", out[0].Resolved)
+}
+
+func TestPackageKeeper_MultiArch(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/dists/stable/main/binary-amd64/Packages", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("Package: libc6\nVersion: 2.36-9\nArchitecture: amd64\nMulti-Arch: same\nFilename: pool/main/g/glibc/libc6_2.36-9_amd64.deb\n"))
+	})
+	mux.HandleFunc("/dists/stable/main/binary-i386/Packages", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("Package: libc6\nVersion: 2.36-9\nArchitecture: i386\nMulti-Arch: same\nFilename: pool/main/g/glibc/libc6_2.36-9_i386.deb\n\nPackage: wine32\nVersion: 8.0~repack-4\nArchitecture: i386\nDepends: libc6 (>= 2.34)\nFilename: pool/main/w/wine/wine32_8.0~repack-4_i386.deb\n"))
+	})
+	repos := []Repository{
+		{Source: debian.Source{URIs: []string{srv.URL}, Suites: []string{"stable"}, Components: []string{"main"}, Architectures: []string{"amd64", "i386"}}},
+	}
+
+	// lock the native package and a
+	// package that only exists for i386
+	keeper, err := NewPackageKeeper(ctx, repos, "amd64", fs.NewMemFS(), nil)
+	require.NoError(t, err)
+	lock := lockfile.Lock{Packages: map[string]lockfile.Package{}}
+	for _, name := range []string{"libc6", "wine32:i386"} {
+		out, err := keeper.Resolve(ctx, name, packages.ResolveOptions{}, false)
+		require.NoError(t, err)
+		for _, p := range out {
+			require.NoError(t, lock.Add(p))
+		}
+	}
+	assert.ElementsMatch(t, []string{"libc6", "libc6:i386", "wine32:i386"}, lock.SortedKeys())
+	assert.EqualValues(t, srv.URL+"/pool/main/g/glibc/libc6_2.36-9_amd64.deb", lock.Packages["libc6"].Resolved)
+	assert.EqualValues(t, srv.URL+"/pool/main/g/glibc/libc6_2.36-9_i386.deb", lock.Packages["libc6:i386"].Resolved)
+	assert.NoError(t, keeper.Check(ctx, slices.Collect(maps.Values(lock.Packages))))
+
+	// each locked package is resolved
+	// again by its name when it's built
+	rootfs := fs.NewMemFS()
+	keeper, err = NewPackageKeeper(ctx, repos, "amd64", rootfs, nil)
+	require.NoError(t, err)
+	for _, name := range lock.SortedKeys() {
+		out, err := keeper.Resolve(ctx, name, packages.ResolveOptions{}, true)
+		require.NoError(t, err)
+		assert.EqualValues(t, lock.Packages[name].Resolved, out[0].Resolved)
+		assert.EqualValues(t, name, out[0].Name)
+	}
+	for _, name := range []string{"libc6:amd64", "libc6:i386", "wine32"} {
+		_, err := rootfs.ReadFile("/var/lib/dpkg/status.d/" + name)
+		assert.NoError(t, err)
+	}
+}
@@ -2,39 +2,280 @@ package debian
 
 import (
 	"context"
+	"crypto/md5"
+	"errors"
 	"fmt"
-	"net/textproto"
+	"os"
 	"path/filepath"
 	"strings"
 
 	"chainguard.dev/apko/pkg/apk/fs"
 	"github.com/djcass44/all-your-base/pkg/debian"
 	"github.com/djcass44/all-your-base/pkg/packages"
+	"github.com/go-logr/logr"
 )
 
 var installedFiles = []string{
 	filepath.Join("/var", "lib", "dpkg", "status"),
 }
 
-// writeInstalled updates an Alpine "installed packages" database to include
-// a given package.
+// infoDir contains the per-package files
+// used by dpkg (e.g. 'dpkg -L').
+var infoDir = filepath.Join("/var", "lib", "dpkg", "info")
+
+// statusDir contains a status file per package. It's used instead of
+// the status database when there's no base image (e.g. distroless).
+var statusDir = filepath.Join("/var", "lib", "dpkg", "status.d")
+
+// writeInstalled updates the dpkg status database to include the given
+// packages. If there's no base image, each package is written to its
+// own file in the status.d directory instead. Packages that have already
+// been recorded are skipped, so that the stanza written when they were
+// unpacked (e.g. with their conffiles) isn't replaced.
 func (p *PackageKeeper) writeInstalled(ctx context.Context, pkg []debian.Package, rootfs fs.FullFS) error {
-	return packages.RecordAll(ctx, p.base, installedFiles, pkg, rootfs, func(t debian.Package) string {
+	p.mu.Lock()
+	if p.recorded == nil {
+		p.recorded = map[string]debian.Package{}
+	}
+	var missing []debian.Package
+	for i := range pkg {
+		if _, ok := p.recorded[p.nameOf(&pkg[i])]; ok {
+			continue
+		}
+		p.recorded[p.nameOf(&pkg[i])] = pkg[i]
+		missing = append(missing, pkg[i])
+	}
+	p.mu.Unlock()
+	if len(missing) == 0 {
+		return nil
+	}
+	pkg = missing
+
+	format := func(t debian.Package) string {
 		return packageToInstalled(&t)
-	})
+	}
+	if p.base == nil {
+		return packages.RecordEach(ctx, statusDir, pkg, rootfs, func(t debian.Package) string {
+			return infoName(&t)
+		}, format)
+	}
+	return packages.RecordAll(ctx, p.base, installedFiles, pkg, rootfs, format)
+}
+
+// getRecorded returns the package if it has been
+// written to the dpkg status database.
+func (p *PackageKeeper) getRecorded(pkg string) (debian.Package, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	installed, ok := p.recorded[pkg]
+	return installed, ok
 }
 
+// packageToInstalled converts a package into a status stanza. Fields
+// are written in the same order as dpkg so that the output is
+// reproducible.
 func packageToInstalled(pkg *debian.Package) string {
-	block := textproto.MIMEHeader{}
-	block.Set("Package", pkg.Package)
-	block.Set("Version", pkg.Version)
-	block.Set("Architecture", pkg.Architecture)
-	block.Set("Depends", strings.Join(pkg.Depends, ", "))
-	block.Set("Status", "install ok installed")
+	fields := []struct {
+		key   string
+		value string
+	}{
+		{"Package", pkg.Package},
+		{"Essential", pkg.Essential},
+		{"Status", "install ok installed"},
+		{"Priority", pkg.Priority},
+		{"Section", pkg.Section},
+		{"Installed-Size", pkg.InstalledSize},
+		{"Maintainer", pkg.Maintainer},
+		{"Architecture", pkg.Architecture},
+		{"Multi-Arch", pkg.MultiArch},
+		{"Source", pkg.Source},
+		{"Version", pkg.Version},
+		{"Replaces", strings.Join(pkg.Replaces, ", ")},
+		{"Provides", strings.Join(pkg.Provides, ", ")},
+		{"Depends", strings.Join(pkg.Depends, ", ")},
+		{"Pre-Depends", strings.Join(pkg.PreDepends, ", ")},
+		{"Recommends", strings.Join(pkg.Recommends, ", ")},
+		{"Suggests", strings.Join(pkg.Suggests, ", ")},
+		{"Breaks", strings.Join(pkg.Breaks, ", ")},
+		{"Conflicts", strings.Join(pkg.Conflicts, ", ")},
+		{"Enhances", strings.Join(pkg.Enhances, ", ")},
+		{"Conffiles", conffiles(pkg.Conffiles)},
+		{"Description", pkg.Description},
+		{"Homepage", pkg.Homepage},
+	}
+
+	sb := strings.Builder{}
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		// fields that only contain a list start on the next line
+		if strings.HasPrefix(f.value, "\n") {
+			sb.WriteString(fmt.Sprintf("%s:%s\n", f.key, formatValue(f.value)))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("%s: %s\n", f.key, formatValue(f.value)))
+	}
+	return sb.String()
+}
+
+// conffiles formats the list of configuration files so
+// that each one is written on its own line.
+func conffiles(files []string) string {
+	if len(files) == 0 {
+		return ""
+	}
+	return "\n" + strings.Join(files, "\n")
+}
+
+// formatValue folds a multi-line value (e.g. the long
+// description) so that it can be written to a stanza.
+func formatValue(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == "" {
+			lines[i] = "."
+		}
+	}
+	return strings.Join(lines, "\n ")
+}
+
+// infoName returns the name that dpkg uses for the files of a
+// package in the info directory. Multi-Arch: same packages can be
+// installed for several architectures, so they're qualified.
+func infoName(pkg *debian.Package) string {
+	if pkg.MultiArch == debian.MultiArchSame {
+		return pkg.Package + ":" + pkg.Architecture
+	}
+	return pkg.Package
+}
+
+// writeInfo writes the list of files installed by a package, their
+// checksums and its configuration files into the dpkg info directory.
+// When there's no base image, the checksums are written alongside the
+// status file instead.
+func (p *PackageKeeper) writeInfo(ctx context.Context, ctrl *controlFiles, files []string, rootfs fs.FullFS) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("pkg", ctrl.control.Package)
+	log.V(5).Info("writing package info files", "files", len(files))
+
+	if err := rootfs.MkdirAll(infoDir, 0755); err != nil {
+		return fmt.Errorf("creating info directory: %w", err)
+	}
+	name := infoName(ctrl.control)
+	if err := rootfs.WriteFile(filepath.Join(infoDir, name+".list"), []byte(fileList(files)), 0644); err != nil {
+		return fmt.Errorf("writing file list: %w", err)
+	}
+	if len(ctrl.conffiles) > 0 {
+		if err := rootfs.WriteFile(filepath.Join(infoDir, name+".conffiles"), []byte(strings.Join(ctrl.conffiles, "\n")+"\n"), 0644); err != nil {
+			return fmt.Errorf("writing conffiles: %w", err)
+		}
+	}
+	if len(ctrl.md5sums) == 0 {
+		log.V(4).Info("package doesn't contain any checksums")
+		return nil
+	}
+	dir := infoDir
+	if p.base == nil {
+		dir = statusDir
+		if err := rootfs.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating status directory: %w", err)
+		}
+	}
+	if err := rootfs.WriteFile(filepath.Join(dir, name+".md5sums"), ctrl.md5sums, 0644); err != nil {
+		return fmt.Errorf("writing checksums: %w", err)
+	}
+	return nil
+}
+
+// hashConffiles returns the "Conffiles" field of the status
+// database, which contains the MD5 checksum of each file as it
+// was unpacked.
+func hashConffiles(rootfs fs.FullFS, conffiles []string) ([]string, error) {
+	out := make([]string, 0, len(conffiles))
+	for _, path := range conffiles {
+		data, err := rootfs.ReadFile(path)
+		if err != nil {
+			// dpkg uses this value for conffiles that
+			// weren't shipped by the package
+			if errors.Is(err, os.ErrNotExist) {
+				out = append(out, path+" newconffile")
+				continue
+			}
+			return nil, fmt.Errorf("reading conffile '%s': %w", path, err)
+		}
+		out = append(out, fmt.Sprintf("%s %x", path, md5.Sum(data)))
+	}
+	return out, nil
+}
+
+// updateInstalled replaces the status stanza of a package
+// that has already been recorded.
+func (p *PackageKeeper) updateInstalled(ctx context.Context, pkg *debian.Package, rootfs fs.FullFS) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("pkg", pkg.Package)
+
+	p.mu.Lock()
+	p.recorded[p.nameOf(pkg)] = *pkg
+	p.mu.Unlock()
+
+	stanza := packageToInstalled(pkg)
+	if p.base == nil {
+		log.V(5).Info("updating package status file")
+		return rootfs.WriteFile(filepath.Join(statusDir, infoName(pkg)), []byte(stanza), 0644)
+	}
+	for _, path := range installedFiles {
+		data, err := rootfs.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		log.V(5).Info("updating package status", "path", path)
+		if err := rootfs.WriteFile(path, []byte(replaceStanza(string(data), pkg, stanza)), 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// replaceStanza replaces every stanza in the status database
+// that refers to the same package and architecture.
+func replaceStanza(status string, pkg *debian.Package, stanza string) string {
+	paragraphs := strings.Split(status, "\n\n")
+	for i, paragraph := range paragraphs {
+		var name, arch string
+		for _, line := range strings.Split(paragraph, "\n") {
+			k, v, _ := strings.Cut(line, ":")
+			switch k {
+			case "Package":
+				name = strings.TrimSpace(v)
+			case "Architecture":
+				arch = strings.TrimSpace(v)
+			}
+		}
+		if name == pkg.Package && arch == pkg.Architecture {
+			paragraphs[i] = strings.TrimSuffix(stanza, "\n")
+		}
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
 
+// fileList generates the contents of a package's '.list' file. Each
+// file is preceded by its parent directories, which are only listed
+// once, in the same way that they're ordered in the data archive.
+func fileList(files []string) string {
+	seen := map[string]bool{}
 	sb := strings.Builder{}
-	for k := range block {
-		sb.WriteString(fmt.Sprintf("%s: %s\n", k, block.Get(k)))
+	sb.WriteString("/.\n")
+
+	var add func(path string)
+	add = func(path string) {
+		if path == "/" || seen[path] {
+			return
+		}
+		add(filepath.Dir(path))
+		seen[path] = true
+		sb.WriteString(path + "\n")
+	}
+	for _, f := range files {
+		add(f)
 	}
 	return sb.String()
 }
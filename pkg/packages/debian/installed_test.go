@@ -0,0 +1,124 @@
+package debian
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/djcass44/all-your-base/pkg/debian"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"pault.ag/go/debian/control"
+)
+
+func TestPackageToInstalled(t *testing.T) {
+	pkg := &debian.Package{
+		Package:       "curl",
+		Priority:      "optional",
+		Section:       "web",
+		InstalledSize: "500",
+		Maintainer:    "Alessandro Ghedini <ghedo@debian.org>",
+		Architecture:  "amd64",
+		Version:       "7.88.1-10",
+		Depends:       []string{"libc6 (>= 2.34)", "libcurl4 (= 7.88.1-10)"},
+		Description:   "command line tool for transferring data with URL syntax\ncurl is a command line tool.\n\nIt supports many protocols.\n",
+		Homepage:      "https://curl.se/",
+	}
+	expected := `Package: curl
+Status: install ok installed
+Priority: optional
+Section: web
+Installed-Size: 500
+Maintainer: Alessandro Ghedini <ghedo@debian.org>
+Architecture: amd64
+Version: 7.88.1-10
+Depends: libc6 (>= 2.34), libcurl4 (= 7.88.1-10)
+Description: command line tool for transferring data with URL syntax
+ curl is a command line tool.
+ .
+ It supports many protocols.
+Homepage: https://curl.se/
+`
+	// the output must be the same every time
+	for range 10 {
+		assert.EqualValues(t, expected, packageToInstalled(pkg))
+	}
+}
+
+func TestFileList(t *testing.T) {
+	out := fileList([]string{
+		"/usr/bin/curl",
+		"/usr/share/doc/curl/copyright",
+		"/usr/share/man/man1/curl.1.gz",
+	})
+	assert.EqualValues(t, `/.
+/usr
+/usr/bin
+/usr/bin/curl
+/usr/share
+/usr/share/doc
+/usr/share/doc/curl
+/usr/share/doc/curl/copyright
+/usr/share/man
+/usr/share/man/man1
+/usr/share/man/man1/curl.1.gz
+`, out)
+}
+
+func TestInfoName(t *testing.T) {
+	assert.EqualValues(t, "curl", infoName(&debian.Package{Package: "curl", Architecture: "amd64"}))
+	assert.EqualValues(t, "libc6:amd64", infoName(&debian.Package{Package: "libc6", Architecture: "amd64", MultiArch: debian.MultiArchSame}))
+}
+
+func TestPackageToInstalled_roundTrip(t *testing.T) {
+	in := `Package: curl
+Status: install ok installed
+Architecture: amd64
+Multi-Arch: foreign
+Version: 7.88.1-10
+Description: command line tool for transferring data with URL syntax
+ curl is a command line tool.
+ .
+ It supports many protocols.
+`
+	var pkg debian.Package
+	require.NoError(t, control.Unmarshal(&pkg, strings.NewReader(in)))
+	assert.EqualValues(t, in, packageToInstalled(&pkg))
+}
+
+func TestPackageKeeper_writeInstalled(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	t.Run("scratch images use status.d", func(t *testing.T) {
+		testfs := fs.NewMemFS()
+		pkg := &PackageKeeper{arch: "amd64"}
+		err := pkg.writeInstalled(ctx, []debian.Package{
+			{Package: "base-files", Version: "12.4", Architecture: "amd64"},
+			{Package: "libc6", Version: "2.36-9", Architecture: "amd64", MultiArch: debian.MultiArchSame},
+		}, testfs)
+		require.NoError(t, err)
+
+		out, err := testfs.ReadFile("/var/lib/dpkg/status.d/base-files")
+		require.NoError(t, err)
+		assert.EqualValues(t, "Package: base-files\nStatus: install ok installed\nArchitecture: amd64\nVersion: 12.4\n", string(out))
+
+		_, err = testfs.ReadFile("/var/lib/dpkg/status.d/libc6:amd64")
+		assert.NoError(t, err)
+
+		_, err = testfs.ReadFile("/var/lib/dpkg/status")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+
+		_, ok := pkg.getRecorded("libc6")
+		assert.True(t, ok)
+	})
+}
+
+func TestReplaceStanza(t *testing.T) {
+	status := "Package: base-files\nStatus: install ok installed\nArchitecture: amd64\n\nPackage: hello\nStatus: install ok installed\nArchitecture: amd64\n\nPackage: hello\nStatus: install ok installed\nArchitecture: i386\n\n"
+	out := replaceStanza(status, &debian.Package{Package: "hello", Architecture: "amd64"}, "Package: hello\nArchitecture: amd64\nConffiles:\n /etc/hello.conf newconffile\n")
+	assert.EqualValues(t, "Package: base-files\nStatus: install ok installed\nArchitecture: amd64\n\nPackage: hello\nArchitecture: amd64\nConffiles:\n /etc/hello.conf newconffile\n\nPackage: hello\nStatus: install ok installed\nArchitecture: i386\n\n", out)
+}
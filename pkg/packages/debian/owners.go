@@ -0,0 +1,39 @@
+package debian
+
+import (
+	"archive/tar"
+	"context"
+
+	"github.com/djcass44/all-your-base/pkg/debian"
+	"github.com/go-logr/logr"
+)
+
+// ownerFilter returns a filter that decides whether the package can
+// write a file. When two packages contain the same file, the package
+// that "Replaces" the other one keeps it. Otherwise, the package
+// unpacked last wins.
+func (p *PackageKeeper) ownerFilter(ctx context.Context, pkg *debian.Package) func(string, *tar.Header) bool {
+	log := logr.FromContextOrDiscard(ctx).WithValues("pkg", pkg.Package)
+	return func(target string, _ *tar.Header) bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.owners == nil {
+			p.owners = map[string]*debian.Package{}
+		}
+
+		owner, ok := p.owners[target]
+		if !ok || owner.Package == pkg.Package {
+			p.owners[target] = pkg
+			return true
+		}
+		if owner.Overwrites(pkg) && !pkg.Overwrites(owner) {
+			log.V(4).Info("keeping file as it has been replaced by another package", "target", target, "owner", owner.Package)
+			return false
+		}
+		if !pkg.Overwrites(owner) {
+			log.Info("overwriting file owned by another package", "target", target, "owner", owner.Package)
+		}
+		p.owners[target] = pkg
+		return true
+	}
+}
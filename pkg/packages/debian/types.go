@@ -1,13 +1,51 @@
 package debian
 
 import (
+	"sync"
+
 	"chainguard.dev/apko/pkg/apk/fs"
 	"github.com/djcass44/all-your-base/pkg/debian"
+	"github.com/djcass44/all-your-base/pkg/triggers"
 	ociv1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
 type PackageKeeper struct {
-	rootfs  fs.FullFS
-	indices []*debian.Index
-	base    ociv1.Image
+	rootfs   fs.FullFS
+	resolver *debian.Resolver
+	base     ociv1.Image
+	// arch is the native architecture
+	arch string
+
+	mu sync.Mutex
+	// owners is the package that each unpacked file belongs to
+	owners map[string]*debian.Package
+	// bootstrap contains the packages needed
+	// to bootstrap a minimal system
+	bootstrap []string
+	// recorded contains the packages that have been
+	// written to the dpkg status database
+	recorded map[string]debian.Package
+	// scripts are the maintainer scripts of
+	// the packages that have been unpacked
+	scripts []triggers.Script
 }
+
+// Repository is a Debian repository.
+type Repository struct {
+	debian.Source
+	// Keyring contains the OpenPGP keys used to verify
+	// the repository. If it's empty, the repository
+	// isn't verified.
+	Keyring []byte
+	// Priority is the pinning priority of the repository.
+	// See debian.Index.SetPriority.
+	Priority int
+	// Bootstrap selects the packages from the repository
+	// that are needed to build a minimal system from scratch.
+	// The only supported variant is BootstrapMinbase.
+	Bootstrap string
+}
+
+// BootstrapMinbase selects all "Essential" and "required"
+// packages, like 'debootstrap --variant=minbase'.
+const BootstrapMinbase = "minbase"
@@ -23,23 +23,17 @@ func RecordAll[T any](ctx context.Context, base v1.Image, paths []string, packag
 	return nil
 }
 
+// Record appends the packages to the database at the given path. If
+// a base image is provided, its database is extended. Otherwise (e.g.
+// when building from scratch), a new database is created.
 func Record[T any](ctx context.Context, base v1.Image, path string, packages []T, rootfs fs.FullFS, format func(t T) string) error {
-	if base == nil {
-		return nil
-	}
-
 	log := logr.FromContextOrDiscard(ctx).WithValues("path", path)
 	log.V(5).Info("recording packages")
 
 	path = filepath.Clean(path)
 
-	if err := archiveutil.UntarFile(ctx, mutate.Extract(base), path, rootfs); err != nil {
-		return fmt.Errorf("extracting file from base image '%s': %w", path, err)
-	}
-
-	// otherwise, append and write
-	if err := rootfs.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return fmt.Errorf("creating parent directory: %w", err)
+	if err := Extract(ctx, base, path, rootfs); err != nil {
+		return err
 	}
 
 	log.V(5).Info("appending to the package record")
@@ -62,3 +56,48 @@ func Record[T any](ctx context.Context, base v1.Image, path string, packages []T
 
 	return nil
 }
+
+// Extract copies a file from the base image into the root filesystem
+// so that it can be extended. Nothing is copied if the file has already
+// been written, or if there's no base image.
+func Extract(ctx context.Context, base v1.Image, path string, rootfs fs.FullFS) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("path", path)
+
+	if err := rootfs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating parent directory: %w", err)
+	}
+	if base == nil {
+		return nil
+	}
+	if _, err := rootfs.Stat(path); err == nil {
+		log.V(6).Info("skipping extraction as the file already exists")
+		return nil
+	}
+	log.V(5).Info("extracting file from base image")
+	rc := mutate.Extract(base)
+	defer rc.Close()
+	if err := archiveutil.UntarFile(ctx, rc, path, rootfs); err != nil {
+		return fmt.Errorf("extracting file from base image '%s': %w", path, err)
+	}
+	return nil
+}
+
+// RecordEach writes each package to its own file within the given
+// directory (e.g. "/var/lib/dpkg/status.d/<package>") rather than
+// a single database.
+func RecordEach[T any](ctx context.Context, dir string, packages []T, rootfs fs.FullFS, name func(t T) string, format func(t T) string) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("dir", dir)
+	log.V(5).Info("recording packages")
+
+	if err := rootfs.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+	for _, pkg := range packages {
+		path := filepath.Join(dir, name(pkg))
+		log.V(5).Info("recording package", "pkg", pkg, "path", path)
+		if err := rootfs.WriteFile(path, []byte(format(pkg)), 0644); err != nil {
+			return fmt.Errorf("writing '%s': %w", path, err)
+		}
+	}
+	return nil
+}
@@ -1,13 +1,19 @@
 package packages
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"io"
 	"testing"
 
 	"chainguard.dev/apko/pkg/apk/fs"
 	"github.com/Snakdy/container-build-engine/pkg/oci/empty"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/logr/testr"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -44,6 +50,19 @@ func TestRecord(t *testing.T) {
 		assert.Contains(t, string(out), pkg[0].Name)
 		assert.Contains(t, string(out), pkg[1].Name)
 	})
+	t.Run("packages are recorded when there is no base image", func(t *testing.T) {
+		testfs := fs.NewMemFS()
+		err := Record(ctx, nil, RecordFile, pkg, testfs, func(t Package) string {
+			return t.Name
+		})
+		assert.NoError(t, err)
+
+		out, err := testfs.ReadFile(RecordFile)
+		assert.NoError(t, err)
+
+		assert.Contains(t, string(out), pkg[0].Name)
+		assert.Contains(t, string(out), pkg[1].Name)
+	})
 	t.Run("packages are recorded when there is an existing record", func(t *testing.T) {
 		testfs := fs.NewMemFS()
 		require.NoError(t, testfs.WriteFile(RecordFile, []byte("foo\nzoo\n"), 0644))
@@ -62,3 +81,70 @@ func TestRecord(t *testing.T) {
 		assert.Contains(t, string(out), "zoo")
 	})
 }
+
+// imageWithFile creates an image with a single
+// layer containing the given file.
+func imageWithFile(t *testing.T, path, content string) v1.Image {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: path, Mode: 0644, Size: int64(len(content))}))
+	_, err := tw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	require.NoError(t, err)
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	require.NoError(t, err)
+	return img
+}
+
+func TestRecord_Base(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	base := imageWithFile(t, "lib/apk/db/installed", "P:musl\n\n")
+	testfs := fs.NewMemFS()
+
+	format := func(t string) string {
+		return "P:" + t
+	}
+	require.NoError(t, Record(ctx, base, "/lib/apk/db/installed", []string{"git"}, testfs, format))
+	require.NoError(t, Record(ctx, base, "/lib/apk/db/installed", []string{"curl"}, testfs, format))
+
+	out, err := testfs.ReadFile("/lib/apk/db/installed")
+	require.NoError(t, err)
+	// the base database is only copied once
+	assert.EqualValues(t, "P:musl\n\nP:git\n\nP:curl\n\n", string(out))
+}
+
+func TestRecordEach(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	type Package struct {
+		Name string
+	}
+	pkg := []Package{
+		{
+			Name: "foo",
+		},
+		{
+			Name: "bar",
+		},
+	}
+
+	testfs := fs.NewMemFS()
+	err := RecordEach(ctx, "/var/lib/dpkg/status.d", pkg, testfs, func(t Package) string {
+		return t.Name
+	}, func(t Package) string {
+		return "Package: " + t.Name + "\n"
+	})
+	require.NoError(t, err)
+
+	for _, p := range pkg {
+		out, err := testfs.ReadFile("/var/lib/dpkg/status.d/" + p.Name)
+		require.NoError(t, err)
+		assert.EqualValues(t, "Package: "+p.Name+"\n", string(out))
+	}
+}
@@ -0,0 +1,145 @@
+package rpm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// xattrCapability is the extended attribute that the kernel
+// reads file capabilities from.
+const xattrCapability = "security.capability"
+
+const (
+	vfsCapRevision2      = 0x02000000
+	vfsCapFlagsEffective = 0x000001
+)
+
+// capabilities are the names of the capabilities, indexed
+// by their number (see capability.h).
+var capabilities = []string{
+	"cap_chown",
+	"cap_dac_override",
+	"cap_dac_read_search",
+	"cap_fowner",
+	"cap_fsetid",
+	"cap_kill",
+	"cap_setgid",
+	"cap_setuid",
+	"cap_setpcap",
+	"cap_linux_immutable",
+	"cap_net_bind_service",
+	"cap_net_broadcast",
+	"cap_net_admin",
+	"cap_net_raw",
+	"cap_ipc_lock",
+	"cap_ipc_owner",
+	"cap_sys_module",
+	"cap_sys_rawio",
+	"cap_sys_chroot",
+	"cap_sys_ptrace",
+	"cap_sys_pacct",
+	"cap_sys_admin",
+	"cap_sys_boot",
+	"cap_sys_nice",
+	"cap_sys_resource",
+	"cap_sys_time",
+	"cap_sys_tty_config",
+	"cap_mknod",
+	"cap_lease",
+	"cap_audit_write",
+	"cap_audit_control",
+	"cap_setfcap",
+	"cap_mac_override",
+	"cap_mac_admin",
+	"cap_syslog",
+	"cap_wake_alarm",
+	"cap_block_suspend",
+	"cap_audit_read",
+	"cap_perfmon",
+	"cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// parseCapabilities converts the textual representation of file
+// capabilities used by RPM (e.g. 'cap_net_bind_service=ep') into
+// the value of the security.capability xattr. An empty string
+// returns nil.
+func parseCapabilities(s string) ([]byte, error) {
+	var effective, permitted, inheritable uint64
+	sets := map[byte]*uint64{
+		'e': &effective,
+		'p': &permitted,
+		'i': &inheritable,
+	}
+
+	for _, clause := range strings.Fields(s) {
+		i := strings.IndexAny(clause, "=+-")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid capability clause: %s", clause)
+		}
+		var mask uint64
+		switch names := clause[:i]; names {
+		case "", "all":
+			mask = 1<<len(capabilities) - 1
+		default:
+			for _, name := range strings.Split(names, ",") {
+				bit := capabilityBit(name)
+				if bit < 0 {
+					return nil, fmt.Errorf("unknown capability: %s", name)
+				}
+				mask |= 1 << bit
+			}
+		}
+
+		// each clause is a list of operators followed by flags,
+		// e.g. "=ep" or "+p-i"
+		ops := clause[i:]
+		for len(ops) > 0 {
+			op := ops[0]
+			ops = ops[1:]
+			if op == '=' {
+				for _, set := range sets {
+					*set &^= mask
+				}
+			}
+			for len(ops) > 0 && !strings.ContainsRune("=+-", rune(ops[0])) {
+				set, ok := sets[ops[0]]
+				if !ok {
+					return nil, fmt.Errorf("unknown capability flag: %c", ops[0])
+				}
+				if op == '-' {
+					*set &^= mask
+				} else {
+					*set |= mask
+				}
+				ops = ops[1:]
+			}
+		}
+	}
+	if effective == 0 && permitted == 0 && inheritable == 0 {
+		return nil, nil
+	}
+
+	// see vfs_cap_data in capability.h
+	magic := uint32(vfsCapRevision2)
+	if effective != 0 {
+		magic |= vfsCapFlagsEffective
+	}
+	out := binary.LittleEndian.AppendUint32(nil, magic)
+	out = binary.LittleEndian.AppendUint32(out, uint32(permitted))
+	out = binary.LittleEndian.AppendUint32(out, uint32(inheritable))
+	out = binary.LittleEndian.AppendUint32(out, uint32(permitted>>32))
+	out = binary.LittleEndian.AppendUint32(out, uint32(inheritable>>32))
+	return out, nil
+}
+
+func capabilityBit(name string) int {
+	name = strings.ToLower(name)
+	for i, c := range capabilities {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
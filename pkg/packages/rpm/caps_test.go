@@ -0,0 +1,38 @@
+package rpm
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	var cases = []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"cap_net_bind_service=ep", "0100000200040000000000000000000000000000"},
+		{"cap_net_admin,cap_net_raw=p", "0000000200300000000000000000000000000000"},
+		{"cap_net_raw+ep", "0100000200200000000000000000000000000000"},
+		{"cap_setuid=eip cap_setuid-i", "0100000280000000000000000000000000000000"},
+		{"cap_bpf=ep", "0100000200000000000000008000000000000000"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			out, err := parseCapabilities(tt.in)
+			assert.NoError(t, err)
+			assert.EqualValues(t, tt.out, hex.EncodeToString(out))
+		})
+	}
+
+	t.Run("unknown capability", func(t *testing.T) {
+		_, err := parseCapabilities("cap_foo=ep")
+		assert.Error(t, err)
+	})
+	t.Run("unknown flag", func(t *testing.T) {
+		_, err := parseCapabilities("cap_chown=x")
+		assert.Error(t, err)
+	})
+}
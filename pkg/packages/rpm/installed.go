@@ -0,0 +1,132 @@
+package rpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/djcass44/all-your-base/pkg/archiveutil"
+	"github.com/djcass44/all-your-base/pkg/rpmdb"
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+var installedFiles = []string{
+	rpmdb.Path,
+	rpmdb.PathSysimage,
+}
+
+// legacyFiles are the BerkeleyDB and ndb rpmdb formats,
+// which we can't write to.
+var legacyFiles = []string{
+	filepath.Join("/var", "lib", "rpm", "Packages"),
+	filepath.Join("/var", "lib", "rpm", "Packages.db"),
+	filepath.Join("/usr", "lib", "sysimage", "rpm", "Packages"),
+	filepath.Join("/usr", "lib", "sysimage", "rpm", "Packages.db"),
+}
+
+// errLegacyDatabase is returned when the image has an
+// rpmdb that we can't write to.
+var errLegacyDatabase = errors.New("rpmdb uses an unsupported format")
+
+// writeInstalled updates the rpmdb to include a given package. If the
+// root filesystem doesn't have a database yet, the one from the base
+// image is extended. Images that have an older (e.g. BerkeleyDB) rpmdb
+// are left alone, rather than creating a second database that
+// disagrees with it.
+func (p *PackageKeeper) writeInstalled(ctx context.Context, h rpmdb.Header) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("pkg", h.NVRA())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.legacyDB {
+		return nil
+	}
+	path, db, err := p.readInstalled(ctx)
+	if errors.Is(err, errLegacyDatabase) {
+		log.Info("skipping package recording as the rpmdb isn't in the sqlite format", "err", err.Error())
+		p.legacyDB = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := db.Add(h); err != nil {
+		return err
+	}
+	data, err := db.Bytes()
+	if err != nil {
+		return fmt.Errorf("encoding rpmdb: %w", err)
+	}
+
+	log.V(5).Info("writing package to rpmdb", "path", path)
+	if err := p.rootfs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating parent directory: %w", err)
+	}
+	if err := p.rootfs.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("writing rpmdb '%s': %w", path, err)
+	}
+	return nil
+}
+
+// readInstalled finds the existing rpmdb, preferring one that
+// we've already written.
+func (p *PackageKeeper) readInstalled(ctx context.Context) (string, *rpmdb.Database, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	for _, path := range installedFiles {
+		data, err := p.rootfs.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		db, err := rpmdb.Read(data)
+		if err != nil {
+			return "", nil, fmt.Errorf("reading rpmdb '%s': %w", path, err)
+		}
+		return path, db, nil
+	}
+
+	if p.base != nil {
+		for _, path := range installedFiles {
+			data, err := archiveutil.ReadFile(ctx, mutate.Extract(p.base), path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return "", nil, fmt.Errorf("extracting file from base image '%s': %w", path, err)
+			}
+			log.V(4).Info("extending rpmdb from base image", "path", path)
+			db, err := rpmdb.Read(data)
+			if err != nil {
+				return "", nil, fmt.Errorf("reading rpmdb '%s': %w", path, err)
+			}
+			return path, db, nil
+		}
+	}
+
+	// don't create a new database if
+	// there's one we can't read
+	for _, path := range legacyFiles {
+		if _, err := p.rootfs.Stat(path); err == nil {
+			return "", nil, fmt.Errorf("%w: %s", errLegacyDatabase, path)
+		}
+	}
+	if p.base != nil {
+		for _, path := range legacyFiles {
+			_, err := archiveutil.ReadFile(ctx, mutate.Extract(p.base), path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return "", nil, fmt.Errorf("extracting file from base image '%s': %w", path, err)
+			}
+			return "", nil, fmt.Errorf("%w: %s", errLegacyDatabase, path)
+		}
+	}
+
+	log.V(4).Info("creating new rpmdb", "path", rpmdb.Path)
+	return rpmdb.Path, rpmdb.New(), nil
+}
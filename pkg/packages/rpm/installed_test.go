@@ -0,0 +1,50 @@
+package rpm
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/djcass44/all-your-base/pkg/rpmdb"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackageKeeper_writeInstalled(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	// a header containing only the package name
+	h := binary.BigEndian.AppendUint32(nil, 1)
+	h = binary.BigEndian.AppendUint32(h, 5)
+	h = binary.BigEndian.AppendUint32(h, rpmdb.TagName)
+	h = binary.BigEndian.AppendUint32(h, 6)
+	h = binary.BigEndian.AppendUint32(h, 0)
+	h = binary.BigEndian.AppendUint32(h, 1)
+	h = append(h, "bash\x00"...)
+
+	t.Run("new database", func(t *testing.T) {
+		rootfs := fs.NewMemFS()
+		pkg := &PackageKeeper{rootfs: rootfs}
+		require.NoError(t, pkg.writeInstalled(ctx, h))
+
+		_, err := rootfs.Stat(rpmdb.Path)
+		assert.NoError(t, err)
+	})
+	t.Run("berkeleydb", func(t *testing.T) {
+		rootfs := fs.NewMemFS()
+		require.NoError(t, rootfs.MkdirAll("/var/lib/rpm", 0755))
+		require.NoError(t, rootfs.WriteFile("/var/lib/rpm/Packages", []byte("bdb"), 0644))
+
+		// the package isn't recorded, rather than
+		// creating a second database
+		pkg := &PackageKeeper{rootfs: rootfs}
+		require.NoError(t, pkg.writeInstalled(ctx, h))
+		require.NoError(t, pkg.writeInstalled(ctx, h))
+
+		_, err := rootfs.Stat(rpmdb.Path)
+		assert.Error(t, err)
+	})
+}
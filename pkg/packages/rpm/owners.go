@@ -0,0 +1,105 @@
+package rpm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/djcass44/all-your-base/pkg/archiveutil"
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+const (
+	passwdFile = "/etc/passwd"
+	groupFile  = "/etc/group"
+)
+
+// idMap maps user and group names to their numeric ids.
+type idMap struct {
+	users  map[string]int
+	groups map[string]int
+}
+
+// owner is the user and group of a file
+// as recorded in the package header.
+type owner struct {
+	user  string
+	group string
+}
+
+// lookup returns the uid and gid of a file owner. If either
+// name can't be found, the fallback value is used.
+func (m idMap) lookup(o owner, uid, gid int) (int, int) {
+	if id, ok := m.users[o.user]; ok {
+		uid = id
+	}
+	if id, ok := m.groups[o.group]; ok {
+		gid = id
+	}
+	return uid, gid
+}
+
+// ids returns the users and groups known to the image. Packages
+// (e.g. 'setup') may create users, so entries in the root
+// filesystem take precedence over the base image.
+func (p *PackageKeeper) ids(ctx context.Context, rootfs fs.FullFS) idMap {
+	log := logr.FromContextOrDiscard(ctx)
+
+	p.mu.Lock()
+	if p.baseIDs == nil {
+		p.baseIDs = &idMap{users: map[string]int{}, groups: map[string]int{}}
+		if p.base != nil {
+			for path, dst := range map[string]map[string]int{passwdFile: p.baseIDs.users, groupFile: p.baseIDs.groups} {
+				data, err := archiveutil.ReadFile(ctx, mutate.Extract(p.base), path)
+				if err != nil {
+					log.V(4).Info("unable to read file from base image", "path", path, "err", err.Error())
+					continue
+				}
+				parseIDs(data, dst)
+			}
+		}
+	}
+	m := idMap{
+		users:  map[string]int{"root": 0},
+		groups: map[string]int{"root": 0},
+	}
+	for k, v := range p.baseIDs.users {
+		m.users[k] = v
+	}
+	for k, v := range p.baseIDs.groups {
+		m.groups[k] = v
+	}
+	p.mu.Unlock()
+
+	if rootfs == nil {
+		return m
+	}
+	if data, err := rootfs.ReadFile(passwdFile); err == nil {
+		parseIDs(data, m.users)
+	}
+	if data, err := rootfs.ReadFile(groupFile); err == nil {
+		parseIDs(data, m.groups)
+	}
+	return m
+}
+
+// parseIDs reads the name and id (the first and third
+// fields) from a passwd or group file.
+func parseIDs(data []byte, dst map[string]int) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		dst[fields[0]] = id
+	}
+}
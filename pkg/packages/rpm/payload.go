@@ -0,0 +1,106 @@
+package rpm
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cavaliergopher/rpm"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// fileMetadata holds the information about files
+// that isn't stored in the cpio payload.
+type fileMetadata struct {
+	// caps contains the security.capability xattr
+	// of each file that has capabilities.
+	caps   map[string][]byte
+	owners map[string]owner
+	ids    idMap
+}
+
+func newFileMetadata(pkg *rpm.Package) (fileMetadata, error) {
+	files := pkg.Files()
+	caps := pkg.Header.GetTag(tagFileCaps).StringSlice()
+
+	metadata := fileMetadata{
+		caps:   map[string][]byte{},
+		owners: make(map[string]owner, len(files)),
+	}
+	for i, f := range files {
+		name := filepath.Clean("/" + f.Name())
+		metadata.owners[name] = owner{user: f.Owner(), group: f.Group()}
+		if i >= len(caps) || caps[i] == "" {
+			continue
+		}
+		data, err := parseCapabilities(caps[i])
+		if err != nil {
+			return fileMetadata{}, fmt.Errorf("parsing capabilities of %s: %w", name, err)
+		}
+		if data != nil {
+			metadata.caps[name] = data
+		}
+	}
+	return metadata, nil
+}
+
+// newPayloadReader decompresses the payload of a package.
+func newPayloadReader(r io.Reader, compression string) (io.Reader, error) {
+	switch compression {
+	case compressionXZ:
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("creating xz reader: %w", err)
+		}
+		return xzReader, nil
+	case compressionGzip:
+		gzipReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("creating gzip reader: %w", err)
+		}
+		return gzipReader, nil
+	case compressionZstd:
+		zstdReader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("creating zstd reader: %w", err)
+		}
+		return zstdReader, nil
+	case compressionBzip2:
+		return bzip2.NewReader(r), nil
+	case compressionLZMA:
+		lzmaReader, err := lzma.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("creating lzma reader: %w", err)
+		}
+		return lzmaReader, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", compression)
+	}
+}
+
+// makedev encodes a device number the same way
+// as glibc's makedev.
+func makedev(major, minor int) int {
+	return (major&0xfffff000)<<32 | (major&0xfff)<<8 | (minor&0xffffff00)<<12 | minor&0xff
+}
+
+// setuidBits converts the setuid, setgid and sticky
+// bits of a unix mode into their Go equivalent.
+func setuidBits(mode int) os.FileMode {
+	var m os.FileMode
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
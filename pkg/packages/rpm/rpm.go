@@ -1,7 +1,6 @@
 package rpm
 
 import (
-	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
@@ -10,30 +9,64 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 
 	"chainguard.dev/apko/pkg/apk/fs"
 	"github.com/cavaliergopher/rpm"
 	v1 "github.com/djcass44/all-your-base/pkg/api/v1"
 	"github.com/djcass44/all-your-base/pkg/lockfile"
+	"github.com/djcass44/all-your-base/pkg/packages"
+	"github.com/djcass44/all-your-base/pkg/rpmdb"
+	"github.com/djcass44/all-your-base/pkg/triggers"
 	"github.com/djcass44/all-your-base/pkg/yum"
 	"github.com/djcass44/all-your-base/pkg/yum/yumindex"
+	"github.com/djcass44/all-your-base/pkg/yum/yummodules"
 	"github.com/go-logr/logr"
-	"github.com/klauspost/compress/zstd"
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/sassoftware/go-rpmutils/cpio"
-	"github.com/ulikunitz/xz"
 	"golang.org/x/exp/maps"
 )
 
 type PackageKeeper struct {
 	indices []*yumindex.Metadata
+	rootfs  fs.FullFS
+	base    ociv1.Image
+
+	// record contains the names of packages that should
+	// be added to the rpmdb when they are unpacked.
+	record map[string]bool
+	// baseIDs caches the users and groups of the base image
+	baseIDs *idMap
+	// legacyDB is set when the image has an rpmdb
+	// that packages can't be recorded in
+	legacyDB bool
+	// scripts are the install scriptlets of
+	// the packages that have been unpacked
+	scripts []triggers.Script
+	mu      sync.Mutex
 }
 
-func NewPackageKeeper(ctx context.Context, repositories []string) (*PackageKeeper, error) {
+// NewPackageKeeper creates a PackageKeeper from the given repositories.
+// Modules is a list of module streams (e.g. 'nodejs:18') that should be
+// enabled.
+func NewPackageKeeper(ctx context.Context, repositories []string, modules []string, rootfs fs.FullFS, base ociv1.Image) (*PackageKeeper, error) {
 	log := logr.FromContextOrDiscard(ctx)
 
+	streams := map[string]string{}
+	for _, m := range modules {
+		name, stream, err := yummodules.ParseStream(m)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := streams[name]; ok && s != stream {
+			return nil, fmt.Errorf("only one stream of a module can be enabled: %s (%s, %s)", name, s, stream)
+		}
+		streams[name] = stream
+	}
+
 	var indices []*yumindex.Metadata
 	for _, repo := range repositories {
-		idx, err := yum.NewIndex(ctx, repo)
+		idx, err := yum.NewIndex(ctx, repo, streams)
 		if err != nil {
 			return nil, err
 		}
@@ -42,6 +75,9 @@ func NewPackageKeeper(ctx context.Context, repositories []string) (*PackageKeepe
 	}
 	return &PackageKeeper{
 		indices: indices,
+		rootfs:  rootfs,
+		base:    base,
+		record:  map[string]bool{},
 	}, nil
 }
 
@@ -55,6 +91,16 @@ func (p *PackageKeeper) Unpack(ctx context.Context, pkgFile string, rootfs fs.Fu
 	}
 	defer f.Close()
 
+	// read the raw header first so that it can
+	// be copied into the rpmdb
+	header, err := rpmdb.ReadHeader(f)
+	if err != nil {
+		return fmt.Errorf("reading package header: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
 	pkg, err := rpm.Read(f)
 	if err != nil {
 		return fmt.Errorf("reading package header: %w", err)
@@ -66,38 +112,76 @@ func (p *PackageKeeper) Unpack(ctx context.Context, pkgFile string, rootfs fs.Fu
 		return fmt.Errorf("unsupported compression: %s", compression)
 	}
 
-	var reader io.Reader
-
-	switch compression {
-	case compressionXZ:
-		xzReader, err := xz.NewReader(f)
-		if err != nil {
-			return fmt.Errorf("creating xz reader: %w", err)
-		}
-		reader = xzReader
-	case compressionGzip:
-		gzipReader, err := gzip.NewReader(f)
-		if err != nil {
-			return fmt.Errorf("creating gzip reader: %w", err)
-		}
-		reader = gzipReader
-	case compressionZstd:
-		zstdReader, err := zstd.NewReader(f)
-		if err != nil {
-			return fmt.Errorf("creating zstd reader: %w", err)
-		}
-		reader = zstdReader
+	reader, err := newPayloadReader(f, compression)
+	if err != nil {
+		return err
 	}
 
 	if format := pkg.PayloadFormat(); format != "cpio" {
 		return fmt.Errorf("unsupported payload format: %s", format)
 	}
 
-	return p.Extract(ctx, rootfs, reader)
+	metadata, err := newFileMetadata(pkg)
+	if err != nil {
+		return err
+	}
+	metadata.ids = p.ids(ctx, rootfs)
+
+	if err := p.extract(ctx, rootfs, reader, metadata); err != nil {
+		return err
+	}
+	p.addScripts(pkg)
+
+	p.mu.Lock()
+	record := p.record[pkg.Name()]
+	p.mu.Unlock()
+	if record {
+		return p.writeInstalled(ctx, header)
+	}
+	return nil
+}
+
+// addScripts keeps track of the scriptlets that rpm
+// would run when the package is installed.
+func (p *PackageKeeper) addScripts(pkg *rpm.Package) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range installScripts {
+		content := pkg.Header.GetTag(s.tag).String()
+		// the interpreter can include arguments
+		// (e.g. '%post -p "/sbin/ldconfig -X"')
+		prog := pkg.Header.GetTag(s.progTag).StringSlice()
+		if content == "" && len(prog) == 0 {
+			continue
+		}
+		script := triggers.Script{Package: pkg.Name(), Name: s.name, Content: content}
+		switch {
+		case len(prog) == 0:
+		case content == "" && prog[0] != "<lua>":
+			// scriptlets without a body just run the
+			// interpreter (e.g. '%post -p /sbin/ldconfig')
+			script.Content = strings.Join(prog, " ")
+		default:
+			script.Interpreter = prog[0]
+		}
+		p.scripts = append(p.scripts, script)
+	}
+}
+
+// Scripts returns the install scriptlets of
+// the packages that have been unpacked.
+func (p *PackageKeeper) Scripts() []triggers.Script {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.scripts)
 }
 
 // Extract the contents of a cpio stream from r to the destination directory dest
 func (p *PackageKeeper) Extract(ctx context.Context, rootfs fs.FullFS, rs io.Reader) error {
+	return p.extract(ctx, rootfs, rs, fileMetadata{})
+}
+
+func (p *PackageKeeper) extract(ctx context.Context, rootfs fs.FullFS, rs io.Reader, metadata fileMetadata) error {
 	log := logr.FromContextOrDiscard(ctx)
 
 	linkMap := make(map[int][]string)
@@ -136,27 +220,29 @@ func (p *PackageKeeper) Extract(ctx context.Context, rootfs fs.FullFS, rs io.Rea
 				}
 			}
 		}
-		// FIXME: Need a makedev implementation in go.
 
 		switch entry.Mode() &^ 07777 {
-		case cpio.S_ISCHR:
-			// FIXME: skipping due to lack of makedev.
-			continue
-		case cpio.S_ISBLK:
-			// FIXME: skipping due to lack of makedev.
-			continue
+		case cpio.S_ISCHR, cpio.S_ISBLK, cpio.S_ISFIFO:
+			// the image layer is a tar, so we can record
+			// device nodes without needing privilege
+			dev := makedev(entry.Rdevmajor(), entry.Rdevminor())
+			log.V(8).Info("creating device node", "path", target, "mode", fmt.Sprintf("0%o", entry.Mode()), "dev", dev)
+			if err := rootfs.Mknod(target, uint32(entry.Mode()), dev); err != nil {
+				if os.IsExist(err) {
+					log.V(7).Info("skipping device node since the target already exists", "path", target)
+					continue
+				}
+				return fmt.Errorf("creating device node: %w", err)
+			}
 		case cpio.S_ISDIR:
 			log.V(8).Info("creating directory", "path", target)
-			m := os.FileMode(entry.Mode()).Perm()
+			m := os.FileMode(entry.Mode()).Perm() | setuidBits(entry.Mode())
 			if err := rootfs.Mkdir(target, m); err != nil && !os.IsExist(err) {
 				return fmt.Errorf("creating dir: %w", err)
 			}
-		case cpio.S_ISFIFO:
-			// skip
-			continue
 		case cpio.S_ISLNK:
 			buf := make([]byte, entry.Filesize())
-			if _, err := stream.Read(buf); err != nil {
+			if _, err := io.ReadFull(stream, buf); err != nil {
 				return fmt.Errorf("reading symlink name: %w", err)
 			}
 			filename := string(buf)
@@ -168,6 +254,8 @@ func (p *PackageKeeper) Extract(ctx context.Context, rootfs fs.FullFS, rs io.Rea
 				}
 				return fmt.Errorf("creating symlink: %w", err)
 			}
+			// chown follows symlinks, so there's nothing else to do
+			continue
 		case cpio.S_ISREG:
 			log.V(8).Info("creating file", "path", target)
 			// save hardlinks until after the target is written
@@ -199,7 +287,7 @@ func (p *PackageKeeper) Extract(ctx context.Context, rootfs fs.FullFS, rs io.Rea
 			}
 
 			// fix permissions
-			fileMode := os.FileMode(entry.Mode()).Perm()
+			fileMode := os.FileMode(entry.Mode()).Perm() | setuidBits(entry.Mode())
 			log.V(9).Info("updating file permissions", "file", target, "permissions", fileMode)
 			if err := rootfs.Chmod(target, fileMode); err != nil {
 				return fmt.Errorf("chmodding file %s: %w", target, err)
@@ -226,46 +314,143 @@ func (p *PackageKeeper) Extract(ctx context.Context, rootfs fs.FullFS, rs io.Rea
 		default:
 			return fmt.Errorf("unknown file mode 0%o for %s", entry.Mode(), entry.Filename())
 		}
+
+		// the cpio ownership is usually root, so prefer the
+		// names from the header
+		uid, gid := metadata.ids.lookup(metadata.owners[target], entry.Uid(), entry.Gid())
+		if uid != 0 || gid != 0 {
+			log.V(9).Info("updating file ownership", "file", target, "uid", uid, "gid", gid)
+			if err := rootfs.Chown(target, uid, gid); err != nil {
+				return fmt.Errorf("chowning file %s: %w", target, err)
+			}
+		}
+		if caps, ok := metadata.caps[target]; ok {
+			log.V(7).Info("setting file capabilities", "file", target)
+			if err := rootfs.SetXattr(target, xattrCapability, caps); err != nil {
+				return fmt.Errorf("setting capabilities on %s: %w", target, err)
+			}
+		}
 	}
 
 	return nil
 }
 
-func (p *PackageKeeper) Resolve(ctx context.Context, pkg string, _ bool) ([]lockfile.Package, error) {
+// Resolve finds a package and its dependencies. If write is set, the
+// package is added to the rpmdb when it is unpacked since the database
+// needs the header from the package file.
+func (p *PackageKeeper) Resolve(ctx context.Context, pkg string, opts packages.ResolveOptions, write bool) ([]lockfile.Package, error) {
 	log := logr.FromContextOrDiscard(ctx).WithValues("pkg", pkg)
+	if write {
+		p.mu.Lock()
+		p.record[pkg] = true
+		p.mu.Unlock()
+	}
 	// dedupe packages
-	packages := map[string]lockfile.Package{}
+	found := map[string]yumindex.Package{}
+	sources := map[string]string{}
+	collect := func(idx *yumindex.Metadata, p yumindex.Package) {
+		// if the package exists in multiple repositories,
+		// prefer the newest one
+		if existing, ok := found[p.Name]; ok {
+			e1, v1, r1 := p.EVR()
+			e2, v2, r2 := existing.EVR()
+			if yumindex.CompareEVR(e1, v1, r1, e2, v2, r2) <= 0 {
+				return
+			}
+		}
+		found[p.Name] = p
+		sources[p.Name] = idx.Source
+		log.V(4).Info("collecting package", "name", p.Name, "version", p.Version.Ver)
+	}
 	for _, idx := range p.indices {
-		for _, p := range idx.Package {
-			if p.Name == pkg {
-				log.V(3).Info("fetching dependencies", "pkg", p.Name)
-				dependencies := idx.GetProviders(ctx, p.Format.Requires.Entry.GetValues(), nil)
-				for _, dep := range dependencies {
-					packages[fmt.Sprintf("%s-%s", dep.Name, dep.Version.Ver)] = lockfile.Package{
-						Name:      dep.Name,
-						Type:      v1.PackageRPM,
-						Version:   dep.Version.Ver,
-						Resolved:  strings.TrimSuffix(idx.Source, "/") + "/" + strings.TrimPrefix(dep.Location.Href, "/"),
-						Integrity: dep.Checksum.Text,
-						Direct:    false,
-					}
-					log.V(4).Info("collecting package", "name", dep.Name, "version", dep.Version.Ver)
-				}
-				packages[fmt.Sprintf("%s-%s", p.Name, p.Version.Ver)] = lockfile.Package{
-					Name:      p.Name,
-					Type:      v1.PackageRPM,
-					Version:   p.Version.Ver,
-					Resolved:  strings.TrimSuffix(idx.Source, "/") + "/" + strings.TrimPrefix(p.Location.Href, "/"),
-					Integrity: p.Checksum.Text,
-					Direct:    true,
-				}
-				log.V(4).Info("collecting package", "name", p.Name, "version", p.Version.Ver)
+		p, ok := idx.GetPackage(pkg)
+		if !ok {
+			continue
+		}
+		log.V(3).Info("fetching dependencies", "pkg", p.Name, "weak", opts.InstallRecommends)
+		// keep track of what has been selected, so that the
+		// dependencies of supplementing packages reuse it
+		existing := map[string]bool{p.Name: true}
+		dependencies := idx.GetProviders(ctx, p.Dependencies(opts.InstallRecommends), existing, opts.InstallRecommends)
+		if opts.InstallRecommends {
+			dependencies = append(dependencies, idx.GetSupplements(ctx, append(dependencies, p), existing)...)
+		}
+		for _, dep := range dependencies {
+			collect(idx, dep)
+		}
+		collect(idx, p)
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("package could not be found in any index: %s", pkg)
+	}
+
+	// drop anything that has been replaced, and make sure
+	// that what's left can be installed side-by-side
+	selected := yumindex.Obsolete(ctx, maps.Values(found))
+	if err := yumindex.CheckConflicts(selected); err != nil {
+		return nil, fmt.Errorf("resolving package '%s': %w", pkg, err)
+	}
+
+	results := make([]lockfile.Package, len(selected))
+	for i, dep := range selected {
+		results[i] = lockfile.Package{
+			Name:              dep.Name,
+			Type:              v1.PackageRPM,
+			Version:           dep.Version.Ver,
+			Resolved:          strings.TrimSuffix(sources[dep.Name], "/") + "/" + strings.TrimPrefix(dep.Location.Href, "/"),
+			Integrity:         dep.Checksum.Text,
+			Direct:            dep.Name == pkg,
+			InstallRecommends: opts.InstallRecommends,
+		}
+	}
+	return results, nil
+}
+
+// Select checks a set of packages that were resolved separately (e.g.
+// each of the names in the build spec) as a whole. Packages that are
+// obsoleted by another package in the set are dropped, and an error is
+// returned if any of the packages conflict with each other.
+func (p *PackageKeeper) Select(ctx context.Context, pkgs []lockfile.Package) ([]lockfile.Package, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	byName := map[string][]yumindex.Package{}
+	for _, pkg := range pkgs {
+		byName[pkg.Name] = nil
+	}
+	for _, idx := range p.indices {
+		for _, pkg := range idx.Package {
+			if _, ok := byName[pkg.Name]; ok {
+				byName[pkg.Name] = append(byName[pkg.Name], pkg)
 			}
 		}
 	}
-	results := maps.Values(packages)
-	if len(results) > 0 {
-		return results, nil
+
+	var candidates []yumindex.Package
+	for _, pkg := range pkgs {
+		i := slices.IndexFunc(byName[pkg.Name], func(c yumindex.Package) bool {
+			return c.Version.Ver == pkg.Version && strings.HasSuffix(pkg.Resolved, "/"+strings.TrimPrefix(c.Location.Href, "/"))
+		})
+		if i < 0 {
+			log.V(3).Info("unable to find package in any index", "pkg", pkg.Name, "version", pkg.Version)
+			continue
+		}
+		candidates = append(candidates, byName[pkg.Name][i])
+	}
+
+	selected := yumindex.Obsolete(ctx, candidates)
+	if err := yumindex.CheckConflicts(selected); err != nil {
+		return nil, err
+	}
+	var out []lockfile.Package
+	for _, pkg := range pkgs {
+		obsoleted := !slices.ContainsFunc(selected, func(c yumindex.Package) bool {
+			return c.Name == pkg.Name
+		}) && slices.ContainsFunc(candidates, func(c yumindex.Package) bool {
+			return c.Name == pkg.Name
+		})
+		if !obsoleted {
+			out = append(out, pkg)
+		}
 	}
-	return nil, fmt.Errorf("package could not be found in any index: %s", pkg)
+	return out, nil
 }
@@ -1,12 +1,17 @@
 package rpm
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"os"
 	"path/filepath"
 	"testing"
 
 	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/djcass44/all-your-base/pkg/lockfile"
 	"github.com/djcass44/all-your-base/pkg/packages"
+	"github.com/djcass44/all-your-base/pkg/yum/yumindex"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/logr/testr"
 	"github.com/stretchr/testify/assert"
@@ -49,10 +54,185 @@ func TestPackageKeeper_Unpack(t *testing.T) {
 
 func TestPackageKeeper_Resolve(t *testing.T) {
 	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
-	pkg, err := NewPackageKeeper(ctx, []string{"https://cdn-ubi.redhat.com/content/public/ubi/dist/ubi8/8/x86_64/appstream/os"})
+	pkg, err := NewPackageKeeper(ctx, []string{"https://cdn-ubi.redhat.com/content/public/ubi/dist/ubi8/8/x86_64/appstream/os"}, nil, fs.NewMemFS(), nil)
 	require.NoError(t, err)
 
-	packageNames, err := pkg.Resolve(ctx, "git", false)
+	packageNames, err := pkg.Resolve(ctx, "git", packages.ResolveOptions{}, false)
 	assert.NoError(t, err)
 	t.Logf("%+v", packageNames)
 }
+
+type cpioEntry struct {
+	name    string
+	mode    int
+	uid     int
+	content string
+	rdev    [2]int
+}
+
+// newCpio creates a newc cpio archive.
+func newCpio(entries []cpioEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	pad := func() {
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	write := func(i int, e cpioEntry) {
+		_, _ = fmt.Fprintf(&buf, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			i+1, e.mode, e.uid, e.uid, 1, 0, len(e.content), 0, 0, e.rdev[0], e.rdev[1], len(e.name)+1, 0)
+		buf.WriteString(e.name + "\x00")
+		pad()
+		buf.WriteString(e.content)
+		pad()
+	}
+	for i, e := range entries {
+		write(i, e)
+	}
+	write(len(entries), cpioEntry{name: "TRAILER!!!"})
+	return &buf
+}
+
+func TestPackageKeeper_Extract(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	rootfs := fs.NewMemFS()
+	caps, err := parseCapabilities("cap_net_raw=ep")
+	require.NoError(t, err)
+
+	archive := newCpio([]cpioEntry{
+		{name: "./dev", mode: 040755},
+		{name: "./dev/null", mode: 020666, rdev: [2]int{1, 3}},
+		{name: "./usr/bin/ping", mode: 0100755, content: "ping"},
+		{name: "./usr/bin/sudo", mode: 0104111, content: "sudo"},
+		{name: "./var/log/httpd", mode: 040700},
+		{name: "./var/lib/thing", mode: 0100644, uid: 42, content: "thing"},
+	})
+	metadata := fileMetadata{
+		caps: map[string][]byte{"/usr/bin/ping": caps},
+		owners: map[string]owner{
+			"/var/log/httpd": {user: "apache", group: "apache"},
+		},
+		ids: idMap{
+			users:  map[string]int{"apache": 48},
+			groups: map[string]int{"apache": 48},
+		},
+	}
+	require.NoError(t, (&PackageKeeper{}).extract(ctx, rootfs, archive, metadata))
+
+	t.Run("device nodes", func(t *testing.T) {
+		dev, err := rootfs.Readnod("/dev/null")
+		require.NoError(t, err)
+		assert.EqualValues(t, makedev(1, 3), dev)
+	})
+	t.Run("capabilities", func(t *testing.T) {
+		data, err := rootfs.GetXattr("/usr/bin/ping", xattrCapability)
+		require.NoError(t, err)
+		assert.EqualValues(t, caps, data)
+	})
+	t.Run("setuid", func(t *testing.T) {
+		info, err := rootfs.Stat("/usr/bin/sudo")
+		require.NoError(t, err)
+		assert.NotZero(t, info.Mode()&os.ModeSetuid)
+	})
+	t.Run("ownership", func(t *testing.T) {
+		uid, gid := metadata.ids.lookup(metadata.owners["/var/log/httpd"], 0, 0)
+		assert.EqualValues(t, 48, uid)
+		assert.EqualValues(t, 48, gid)
+
+		uid, _ = metadata.ids.lookup(metadata.owners["/var/lib/thing"], 42, 42)
+		assert.EqualValues(t, 42, uid)
+	})
+}
+
+func TestMakedev(t *testing.T) {
+	assert.EqualValues(t, 0x103, makedev(1, 3))
+	assert.EqualValues(t, 0x10300, makedev(259, 0))
+	assert.EqualValues(t, 0x100800, makedev(8, 256))
+}
+
+// newIndexPackage creates a package for an in-memory index.
+func newIndexPackage(name, version string) yumindex.Package {
+	p := yumindex.Package{Name: name}
+	p.Version.Ver = version
+	p.Location.Href = "Packages/" + name + "-" + version + ".rpm"
+	p.Format.Provides.Entry = yumindex.EntryList{{Name: name}}
+	return p
+}
+
+func TestPackageKeeper_Select(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	coreutils := newIndexPackage("coreutils", "8.30")
+	coreutils.Format.Conflicts.Entry = yumindex.EntryList{{Name: "coreutils-single"}}
+	old := newIndexPackage("foo", "1.0")
+	replacement := newIndexPackage("bar", "2.0")
+	replacement.Format.Obsoletes.Entry = yumindex.EntryList{{Name: "foo", Flags: yumindex.FlagLT, Ver: "2.0"}}
+
+	pkg := &PackageKeeper{
+		indices: []*yumindex.Metadata{{
+			Source:  "https://example.org/os",
+			Package: []yumindex.Package{coreutils, newIndexPackage("coreutils-single", "8.30"), old, replacement},
+		}},
+		record: map[string]bool{},
+	}
+
+	// each of the names is resolved separately, in
+	// the same way as the entries in the build spec
+	resolve := func(names ...string) []lockfile.Package {
+		var out []lockfile.Package
+		for _, name := range names {
+			resolved, err := pkg.Resolve(ctx, name, packages.ResolveOptions{}, false)
+			require.NoError(t, err)
+			out = append(out, resolved...)
+		}
+		return out
+	}
+
+	t.Run("conflicting packages", func(t *testing.T) {
+		_, err := pkg.Select(ctx, resolve("coreutils", "coreutils-single"))
+		assert.ErrorContains(t, err, "package 'coreutils-8.30' conflicts with 'coreutils-single-8.30'")
+	})
+	t.Run("obsoleted packages", func(t *testing.T) {
+		out, err := pkg.Select(ctx, resolve("foo", "bar", "coreutils"))
+		require.NoError(t, err)
+		var names []string
+		for _, p := range out {
+			names = append(names, p.Name)
+		}
+		assert.ElementsMatch(t, []string{"bar", "coreutils"}, names)
+	})
+}
+
+func TestPackageKeeper_ResolveSupplements(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	app := newIndexPackage("app", "1.0")
+	app.Format.Requires.Entry = yumindex.EntryList{{Name: "openssl-libs"}}
+	openssl := newIndexPackage("openssl-libs", "1.0")
+	openssl.Format.Provides.Entry = append(openssl.Format.Provides.Entry, yumindex.Entry{Name: "crypto"})
+	// a newer provider of the same capability
+	alt := newIndexPackage("alt-crypto", "2.0")
+	alt.Format.Provides.Entry = append(alt.Format.Provides.Entry, yumindex.Entry{Name: "crypto"})
+	langpack := newIndexPackage("app-langpack", "1.0")
+	langpack.Format.Supplements.Entry = yumindex.EntryList{{Name: "app"}}
+	langpack.Format.Requires.Entry = yumindex.EntryList{{Name: "crypto"}}
+
+	pkg := &PackageKeeper{
+		indices: []*yumindex.Metadata{{
+			Source:  "https://example.org/os",
+			Package: []yumindex.Package{app, openssl, alt, langpack},
+		}},
+		record: map[string]bool{},
+	}
+
+	// the dependencies of the supplementing package are
+	// satisfied by what has already been selected
+	out, err := pkg.Resolve(ctx, "app", packages.ResolveOptions{InstallRecommends: true}, false)
+	require.NoError(t, err)
+	var names []string
+	for _, p := range out {
+		names = append(names, p.Name)
+	}
+	assert.ElementsMatch(t, []string{"app", "openssl-libs", "app-langpack"}, names)
+}
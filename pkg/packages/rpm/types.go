@@ -1,13 +1,37 @@
 package rpm
 
 const (
-	compressionXZ   = "xz"
-	compressionGzip = "gzip"
-	compressionZstd = "zstd"
+	compressionXZ    = "xz"
+	compressionGzip  = "gzip"
+	compressionZstd  = "zstd"
+	compressionBzip2 = "bzip2"
+	compressionLZMA  = "lzma"
 )
 
 var supportedRPMCompressionTypes = []string{
 	compressionXZ,
 	compressionGzip,
 	compressionZstd,
+	compressionBzip2,
+	compressionLZMA,
+}
+
+// Header tags that aren't exposed by the rpm library.
+const (
+	tagPreIn      = 1023
+	tagPostIn     = 1024
+	tagPreInProg  = 1085
+	tagPostInProg = 1086
+	tagFileCaps   = 5010
+)
+
+// installScripts are the scriptlets that rpm runs when a package
+// is installed, along with the tag containing their interpreter.
+var installScripts = []struct {
+	name    string
+	tag     int
+	progTag int
+}{
+	{"pre", tagPreIn, tagPreInProg},
+	{"post", tagPostIn, tagPostInProg},
 }
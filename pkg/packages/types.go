@@ -10,5 +10,22 @@ import (
 
 type PackageManager interface {
 	Unpack(ctx context.Context, pkg string, rootfs fs.FullFS) error
-	Resolve(ctx context.Context, pkg string, write bool) ([]lockfile.Package, error)
+	Resolve(ctx context.Context, pkg string, opts ResolveOptions, write bool) ([]lockfile.Package, error)
+}
+
+// ResolveOptions controls how a package and its
+// dependencies are resolved.
+type ResolveOptions struct {
+	// InstallRecommends pulls weak dependencies (e.g. Recommends)
+	// into the resolution. Package managers that have no concept
+	// of weak dependencies ignore it.
+	InstallRecommends bool
+	// Exclude lists the packages that must not be installed,
+	// including packages that provide them. Package managers
+	// that have no concept of exclusions ignore it.
+	Exclude []string
+	// Direct indicates that the package was requested
+	// explicitly, rather than as a dependency of another
+	// package.
+	Direct bool
 }
@@ -1,46 +1,141 @@
 package requestutil
 
 import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"net/http"
+	"path"
+	"path/filepath"
 
 	"github.com/carlmjohnson/requests"
-	"github.com/gabriel-vasile/mimetype"
 	"github.com/go-logr/logr"
-	"github.com/mholt/archives"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
+// Compression formats that can be detected
+const (
+	CompressionNone  = ""
+	CompressionGzip  = "gzip"
+	CompressionZstd  = "zstd"
+	CompressionXZ    = "xz"
+	CompressionBzip2 = "bzip2"
+)
+
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicXZ    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	magicBzip2 = []byte("BZh")
+)
+
+var extensions = map[string]string{
+	".gz":  CompressionGzip,
+	".zst": CompressionZstd,
+	".xz":  CompressionXZ,
+	".bz2": CompressionBzip2,
+}
+
+// ContentTypesGzip are the Content-Types of gzipped responses.
+//
+// Deprecated: the Content-Type is no longer used to detect
+// compression.
 var ContentTypesGzip = []string{
 	"application/gzip",
 	"application/x-gzip",
 }
 
+// WithGzip decompresses the response if it is gzipped.
+//
+// Deprecated: use WithDecompression instead, which also
+// handles zstd, xz and bzip2.
 func WithGzip(out io.Writer) requests.ResponseHandler {
+	return func(response *http.Response) error {
+		return WithDecompression(out, path.Base(response.Request.URL.Path))(response)
+	}
+}
+
+// WithDecompression decompresses the response if it has been
+// compressed with gzip, zstd, xz or bzip2. The name of the
+// requested file (e.g. primary.xml.zst) is only used to
+// assist with logging.
+func WithDecompression(out io.Writer, name string) requests.ResponseHandler {
 	return func(response *http.Response) error {
 		log := logr.FromContextOrDiscard(response.Request.Context())
-		var stream io.ReadCloser
-
-		// if it's a gzip response, decompress it
-		if isGzipped(response.Header.Get("Content-Type")) {
-			log.V(8).Info("decompressing gzip response")
-			dec, err := archives.Gz{}.OpenReader(response.Body)
-			if err != nil {
-				return fmt.Errorf("decompressing: %w", err)
-			}
-			stream = dec
-		} else {
-			stream = response.Body
-		}
 
-		_, err := io.Copy(out, stream)
+		stream, compression, err := Decompress(response.Body)
 		if err != nil {
+			return fmt.Errorf("decompressing: %w", err)
+		}
+		defer stream.Close()
+		if expected := extensions[filepath.Ext(name)]; expected != compression {
+			log.V(4).Info("detected compression does not match the file extension", "name", name, "expected", expected, "actual", compression)
+		}
+		log.V(8).Info("decompressing response", "compression", compression)
+
+		if _, err := io.Copy(out, stream); err != nil {
 			return fmt.Errorf("writing uncompressed output: %w", err)
 		}
 		return nil
 	}
 }
 
-func isGzipped(s string) bool {
-	return mimetype.EqualsAny(s, ContentTypesGzip...)
+// Decompress detects the compression of the stream using its magic
+// bytes and returns a reader that decompresses it. Streams that
+// aren't compressed are returned as-is.
+//
+// The magic bytes are preferred over file extensions or the
+// Content-Type as they can't lie. For example, a server may
+// transparently decompress a '.gz' file.
+func Decompress(r io.Reader) (io.ReadCloser, string, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(magicXZ))
+	if err != nil && err != io.EOF {
+		return nil, CompressionNone, err
+	}
+
+	compression := detectCompression(header)
+	switch compression {
+	case CompressionGzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, compression, err
+		}
+		return gr, compression, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, compression, err
+		}
+		return zr.IOReadCloser(), compression, nil
+	case CompressionXZ:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, compression, err
+		}
+		return io.NopCloser(xr), compression, nil
+	case CompressionBzip2:
+		return io.NopCloser(bzip2.NewReader(br)), compression, nil
+	default:
+		return io.NopCloser(br), compression, nil
+	}
+}
+
+func detectCompression(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, magicGzip):
+		return CompressionGzip
+	case bytes.HasPrefix(header, magicZstd):
+		return CompressionZstd
+	case bytes.HasPrefix(header, magicXZ):
+		return CompressionXZ
+	case bytes.HasPrefix(header, magicBzip2):
+		return CompressionBzip2
+	default:
+		return CompressionNone
+	}
 }
@@ -1,34 +1,67 @@
 package requestutil
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"io"
 	"testing"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
 )
 
-func TestIsGzipped(t *testing.T) {
+func TestDecompress(t *testing.T) {
+	payload := []byte("hello world\n")
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write(payload)
+	require.NoError(t, gw.Close())
+
+	var zs bytes.Buffer
+	zw, err := zstd.NewWriter(&zs)
+	require.NoError(t, err)
+	_, _ = zw.Write(payload)
+	require.NoError(t, zw.Close())
+
+	var xzb bytes.Buffer
+	xw, err := xz.NewWriter(&xzb)
+	require.NoError(t, err)
+	_, _ = xw.Write(payload)
+	require.NoError(t, xw.Close())
+
+	// bzip2 has no writer in the standard library
+	bz, err := hex.DecodeString("425a68393141592653594eece83600000251800010400006449080200031064c4101a7a9a580bb9431f8bb9229c28482776741b0")
+	require.NoError(t, err)
+
 	var cases = []struct {
-		s  string
-		ok bool
+		name        string
+		in          []byte
+		compression string
 	}{
-		{
-			"application/gzip",
-			true,
-		},
-		{
-			"application/x-gzip",
-			true,
-		},
-		{
-			"application/javascript",
-			false,
-		},
+		{"gzip", gz.Bytes(), CompressionGzip},
+		{"zstd", zs.Bytes(), CompressionZstd},
+		{"xz", xzb.Bytes(), CompressionXZ},
+		{"bzip2", bz, CompressionBzip2},
+		{"uncompressed", payload, CompressionNone},
+		{"empty", []byte{}, CompressionNone},
 	}
 
 	for _, tt := range cases {
-		t.Run(tt.s, func(t *testing.T) {
-			ok := isGzipped(tt.s)
-			assert.EqualValues(t, tt.ok, ok)
+		t.Run(tt.name, func(t *testing.T) {
+			r, compression, err := Decompress(bytes.NewReader(tt.in))
+			require.NoError(t, err)
+			defer r.Close()
+			assert.EqualValues(t, tt.compression, compression)
+
+			out, err := io.ReadAll(r)
+			require.NoError(t, err)
+			if len(tt.in) > 0 {
+				assert.EqualValues(t, payload, out)
+			}
 		})
 	}
 }
@@ -0,0 +1,239 @@
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Header tags that we need to identify a package.
+const (
+	TagName    = 1000
+	TagVersion = 1001
+	TagRelease = 1002
+	TagArch    = 1022
+)
+
+const (
+	leadSize       = 96
+	indexEntrySize = 16
+)
+
+// Header data types.
+const (
+	typeInt32       = 4
+	typeString      = 6
+	typeBin         = 7
+	typeStringArray = 8
+	typeI18NString  = 9
+)
+
+var headerMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+
+// tagSHA256Header is the SHA256 digest of the immutable header.
+const tagSHA256Header = 273
+
+// signatureDigests are the digests of the header that rpm copies
+// from the signature into the header when a package is installed.
+// They're used to verify the header and to find the package.
+var signatureDigests = []struct {
+	sig uint32
+	tag uint32
+	typ uint32
+}{
+	// RPMSIGTAG_MD5 becomes RPMTAG_SIGMD5
+	{1004, tagSigMD5, typeBin},
+	{tagSHA1Header, tagSHA1Header, typeString},
+	{tagSHA256Header, tagSHA256Header, typeString},
+}
+
+// Header is an RPM header as stored in the rpmdb. It's the
+// same as the header in the package file, without the
+// leading magic bytes.
+type Header []byte
+
+// ReadHeader reads the main header from an RPM package, along
+// with the digests of the header from the signature.
+func ReadHeader(r io.Reader) (Header, error) {
+	// skip the lead
+	if _, err := io.CopyN(io.Discard, r, leadSize); err != nil {
+		return nil, fmt.Errorf("reading lead: %w", err)
+	}
+	// skip the signature, which is padded to
+	// an 8-byte boundary
+	sig, err := readHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading signature: %w", err)
+	}
+	if pad := (8 - (len(sig)+8)%8) % 8; pad > 0 {
+		if _, err := io.CopyN(io.Discard, r, int64(pad)); err != nil {
+			return nil, fmt.Errorf("reading signature: %w", err)
+		}
+	}
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	for _, d := range signatureDigests {
+		if typ, _, _ := h.lookup(d.tag); typ != 0 {
+			continue
+		}
+		if value, count := sig.value(d.sig, d.typ); value != nil {
+			h = h.add(d.tag, d.typ, count, value)
+		}
+	}
+	return h, nil
+}
+
+func readHeader(r io.Reader) (Header, error) {
+	var intro [16]byte
+	if _, err := io.ReadFull(r, intro[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(intro[:4], headerMagic) {
+		return nil, errors.New("bad header magic")
+	}
+	il := binary.BigEndian.Uint32(intro[8:])
+	dl := binary.BigEndian.Uint32(intro[12:])
+	// rpm refuses headers larger than 256MiB, so should we
+	if il > 0xffff || dl > 256<<20 {
+		return nil, errors.New("header is too large")
+	}
+	h := make([]byte, 8+int(il)*indexEntrySize+int(dl))
+	copy(h, intro[8:])
+	if _, err := io.ReadFull(r, h[8:]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// String returns the value of a string tag, or an
+// empty string if the tag doesn't exist.
+func (h Header) String(tag uint32) string {
+	typ, data, _ := h.lookup(tag)
+	if typ != typeString || len(data) == 0 {
+		return ""
+	}
+	s, _, _ := bytes.Cut(data, []byte{0})
+	return string(s)
+}
+
+// Strings returns the values of a string, string array or
+// i18n string tag. Only the first (untranslated) value of an
+// i18n string is returned.
+func (h Header) Strings(tag uint32) []string {
+	typ, data, count := h.lookup(tag)
+	switch typ {
+	case typeString, typeI18NString:
+		count = 1
+	case typeStringArray:
+	default:
+		return nil
+	}
+	out := make([]string, 0, min(count, len(data)))
+	for i := 0; i < count && len(data) > 0; i++ {
+		s, rest, _ := bytes.Cut(data, []byte{0})
+		out = append(out, string(s))
+		data = rest
+	}
+	return out
+}
+
+// Uint32s returns the values of an int32 tag.
+func (h Header) Uint32s(tag uint32) []uint32 {
+	typ, data, count := h.lookup(tag)
+	if typ != typeInt32 || len(data) < count*4 {
+		return nil
+	}
+	out := make([]uint32, count)
+	for i := range out {
+		out[i] = binary.BigEndian.Uint32(data[i*4:])
+	}
+	return out
+}
+
+// Bytes returns the value of a binary tag.
+func (h Header) Bytes(tag uint32) []byte {
+	typ, data, count := h.lookup(tag)
+	if typ != typeBin || len(data) < count {
+		return nil
+	}
+	return data[:count]
+}
+
+// lookup finds the index entry of a tag, returning its type, the
+// data store from its offset onwards and the number of values.
+func (h Header) lookup(tag uint32) (uint32, []byte, int) {
+	if len(h) < 8 {
+		return 0, nil, 0
+	}
+	il := int(binary.BigEndian.Uint32(h))
+	dataStart := 8 + il*indexEntrySize
+	if il > 0xffff || dataStart > len(h) {
+		return 0, nil, 0
+	}
+	data := h[dataStart:]
+	for i := 0; i < il; i++ {
+		entry := h[8+i*indexEntrySize:]
+		if binary.BigEndian.Uint32(entry) != tag {
+			continue
+		}
+		offset := int(binary.BigEndian.Uint32(entry[8:]))
+		if offset >= len(data) {
+			return 0, nil, 0
+		}
+		return binary.BigEndian.Uint32(entry[4:]), data[offset:], int(binary.BigEndian.Uint32(entry[12:]))
+	}
+	return 0, nil, 0
+}
+
+// value returns the raw value of a binary or string tag, as
+// it's stored in the data store, along with its count.
+func (h Header) value(tag, typ uint32) ([]byte, int) {
+	t, data, count := h.lookup(tag)
+	if t != typ {
+		return nil, 0
+	}
+	switch typ {
+	case typeBin:
+		if len(data) < count {
+			return nil, 0
+		}
+		return data[:count], count
+	case typeString:
+		i := bytes.IndexByte(data, 0)
+		if i < 0 {
+			return nil, 0
+		}
+		return data[:i+1], 1
+	}
+	return nil, 0
+}
+
+// add appends a binary or string tag to the header. The tag is
+// placed after every other tag (and so outside the immutable
+// region), in the same way as the tags that rpm adds when a
+// package is installed.
+func (h Header) add(tag, typ uint32, count int, value []byte) Header {
+	il := binary.BigEndian.Uint32(h)
+	dl := binary.BigEndian.Uint32(h[4:])
+	dataStart := 8 + int(il)*indexEntrySize
+
+	out := make([]byte, 0, len(h)+indexEntrySize+len(value))
+	out = binary.BigEndian.AppendUint32(out, il+1)
+	out = binary.BigEndian.AppendUint32(out, dl+uint32(len(value)))
+	out = append(out, h[8:dataStart]...)
+	out = binary.BigEndian.AppendUint32(out, tag)
+	out = binary.BigEndian.AppendUint32(out, typ)
+	out = binary.BigEndian.AppendUint32(out, dl)
+	out = binary.BigEndian.AppendUint32(out, uint32(count))
+	out = append(out, h[dataStart:]...)
+	return append(out, value...)
+}
+
+// NVRA returns a human-readable identifier of the package.
+func (h Header) NVRA() string {
+	return fmt.Sprintf("%s-%s-%s.%s", h.String(TagName), h.String(TagVersion), h.String(TagRelease), h.String(TagArch))
+}
@@ -0,0 +1,146 @@
+package rpmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// Header tags used by the index tables.
+const (
+	tagSigMD5               = 261
+	tagSHA1Header           = 269
+	tagGroup                = 1016
+	tagProvideName          = 1047
+	tagRequireFlags         = 1048
+	tagRequireName          = 1049
+	tagConflictName         = 1054
+	tagTriggerName          = 1066
+	tagObsoleteName         = 1090
+	tagBasenames            = 1117
+	tagDirnames             = 1118
+	tagInstallTID           = 1128
+	tagRecommendName        = 5046
+	tagSuggestName          = 5049
+	tagSupplementName       = 5052
+	tagEnhanceName          = 5055
+	tagFileTriggerName      = 5069
+	tagTransFileTriggerName = 5079
+)
+
+// Dependency flags of requirements that are only needed
+// while a package is installed or removed.
+const (
+	senseScriptPre    = 1 << 9
+	senseScriptPost   = 1 << 10
+	senseScriptPreUn  = 1 << 11
+	senseScriptPostUn = 1 << 12
+	sensePreTrans     = 1 << 7
+	sensePostTrans    = 1 << 5
+	senseRPMLib       = 1 << 24
+	senseKeyring      = 1 << 26
+
+	senseInstallOnly = senseScriptPre | senseScriptPost | senseRPMLib | senseKeyring | sensePreTrans | sensePostTrans
+	senseEraseOnly   = senseScriptPreUn | senseScriptPostUn
+)
+
+// indexes are the secondary index tables that rpm keeps
+// alongside the Packages table, in the order it creates them.
+var indexes = []struct {
+	name string
+	tag  uint32
+}{
+	{"Name", TagName},
+	{"Basenames", tagBasenames},
+	{"Group", tagGroup},
+	{"Requirename", tagRequireName},
+	{"Providename", tagProvideName},
+	{"Conflictname", tagConflictName},
+	{"Obsoletename", tagObsoleteName},
+	{"Triggername", tagTriggerName},
+	{"Dirnames", tagDirnames},
+	{"Installtid", tagInstallTID},
+	{"Sigmd5", tagSigMD5},
+	{"Sha1header", tagSHA1Header},
+	{"Filetriggername", tagFileTriggerName},
+	{"Transfiletriggername", tagTransFileTriggerName},
+	{"Recommendname", tagRecommendName},
+	{"Suggestname", tagSuggestName},
+	{"Supplementname", tagSupplementName},
+	{"Enhancename", tagEnhanceName},
+}
+
+// indexTables generates the secondary index tables from the
+// package headers in the same way as rpm. Each row maps a key
+// (e.g. a file basename) to the package (hnum) and the position
+// of the key in the tag (idx).
+//
+// The sqlite indices over these tables aren't written. rpm
+// creates them the next time it opens the database for writing,
+// and queries that don't have them fall back to table scans.
+func indexTables(packages []entry) []table {
+	out := make([]table, len(indexes))
+	for i, index := range indexes {
+		keyType := "TEXT"
+		if index.tag == tagInstallTID || index.tag == tagSigMD5 {
+			keyType = "BLOB"
+		}
+		out[i] = table{
+			Name: index.name,
+			SQL:  fmt.Sprintf("CREATE TABLE '%s' (key '%s' NOT NULL, hnum INTEGER NOT NULL, idx INTEGER NOT NULL, FOREIGN KEY (hnum) REFERENCES 'Packages'(hnum))", index.name, keyType),
+		}
+		for _, e := range packages {
+			for idx, key := range indexKeys(e.header, index.tag) {
+				if key == nil {
+					continue
+				}
+				out[i].Rows = append(out[i].Rows, row{ID: int64(len(out[i].Rows) + 1), Values: []any{key, e.hnum, int64(idx)}})
+			}
+		}
+	}
+	return out
+}
+
+// indexKeys returns the keys of a tag, indexed by their position
+// within the tag. Keys that rpm doesn't index are nil.
+func indexKeys(h Header, tag uint32) []any {
+	switch tag {
+	case tagInstallTID:
+		values := h.Uint32s(tag)
+		out := make([]any, len(values))
+		for i, v := range values {
+			// rpm uses the in-memory representation of the
+			// integer, which is little-endian on all the
+			// architectures that we build images for
+			out[i] = binary.LittleEndian.AppendUint32(nil, v)
+		}
+		return out
+	case tagSigMD5:
+		if b := h.Bytes(tag); len(b) > 0 {
+			return []any{b}
+		}
+		return nil
+	}
+	values := h.Strings(tag)
+	var flags []uint32
+	if tag == tagRequireName {
+		flags = h.Uint32s(tagRequireFlags)
+	}
+	out := make([]any, len(values))
+	for i, v := range values {
+		if v == "" {
+			continue
+		}
+		// install-time requirements (e.g. of scriptlets)
+		// aren't indexed
+		if i < len(flags) && flags[i]&senseInstallOnly != 0 && flags[i]&senseEraseOnly == 0 {
+			continue
+		}
+		// only the first of each trigger name is indexed
+		if tag == tagTriggerName && slices.Contains(values[:i], v) {
+			continue
+		}
+		out[i] = v
+	}
+	return out
+}
@@ -0,0 +1,127 @@
+package rpmdb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Locations of the sqlite rpmdb. Newer distributions store it in
+// /usr/lib/sysimage and symlink /var/lib/rpm to it.
+const (
+	Path         = "/var/lib/rpm/rpmdb.sqlite"
+	PathSysimage = "/usr/lib/sysimage/rpm/rpmdb.sqlite"
+)
+
+const (
+	tablePackages = "Packages"
+	tableSequence = "sqlite_sequence"
+)
+
+// Database is an rpmdb containing the headers of installed packages.
+//
+// The index tables (e.g. Name, Basenames) are generated from the
+// headers whenever the database is written, so they always
+// match the Packages table.
+type Database struct {
+	packages []entry
+	sequence int64
+}
+
+type entry struct {
+	hnum   int64
+	header Header
+}
+
+// New creates an empty database.
+func New() *Database {
+	return &Database{}
+}
+
+// Read loads the packages from an existing sqlite rpmdb.
+func Read(data []byte) (*Database, error) {
+	rows, err := readTable(data, tablePackages)
+	if err != nil {
+		return nil, err
+	}
+	db := &Database{}
+	for _, r := range rows {
+		if len(r.Values) < 2 {
+			return nil, errCorrupt
+		}
+		blob, ok := r.Values[1].([]byte)
+		if !ok {
+			return nil, fmt.Errorf("%w: package %d has no header", errCorrupt, r.ID)
+		}
+		db.packages = append(db.packages, entry{hnum: r.ID, header: blob})
+		db.sequence = max(db.sequence, r.ID)
+	}
+	// the sequence may be higher than any of the rows
+	// if packages have been removed
+	sequence, err := readTable(data, tableSequence)
+	if err != nil {
+		return db, nil
+	}
+	for _, r := range sequence {
+		if len(r.Values) == 2 && r.Values[0] == tablePackages {
+			if seq, ok := r.Values[1].(int64); ok {
+				db.sequence = max(db.sequence, seq)
+			}
+		}
+	}
+	return db, nil
+}
+
+// Add records a package in the database. If a package with the
+// same name and architecture already exists, it is replaced.
+func (d *Database) Add(h Header) error {
+	name := h.String(TagName)
+	if name == "" {
+		return errors.New("header is missing the package name")
+	}
+	arch := h.String(TagArch)
+	d.packages = slices.DeleteFunc(d.packages, func(e entry) bool {
+		return e.header.String(TagName) == name && e.header.String(TagArch) == arch
+	})
+	d.sequence++
+	d.packages = append(d.packages, entry{hnum: d.sequence, header: h})
+	return nil
+}
+
+// Headers returns the headers of all installed packages.
+func (d *Database) Headers() []Header {
+	out := make([]Header, len(d.packages))
+	for i := range d.packages {
+		out[i] = d.packages[i].header
+	}
+	return out
+}
+
+// Bytes encodes the database as a sqlite file.
+func (d *Database) Bytes() ([]byte, error) {
+	rows := make([]row, len(d.packages))
+	for i, e := range d.packages {
+		// hnum is an alias of the rowid, so it's stored as null
+		rows[i] = row{ID: e.hnum, Values: []any{nil, []byte(e.header)}}
+	}
+	slices.SortFunc(rows, func(a, b row) int {
+		return int(a.ID - b.ID)
+	})
+	var sequence []row
+	if d.sequence > 0 {
+		sequence = []row{{ID: 1, Values: []any{tablePackages, d.sequence}}}
+	}
+	tables := []table{
+		{
+			Name: tablePackages,
+			SQL:  "CREATE TABLE 'Packages' (hnum INTEGER PRIMARY KEY AUTOINCREMENT,blob BLOB NOT NULL)",
+			Rows: rows,
+		},
+		{
+			Name: tableSequence,
+			SQL:  "CREATE TABLE sqlite_sequence(name,seq)",
+			Rows: sequence,
+		},
+	}
+	return writeDatabase(append(tables, indexTables(d.packages)...))
+}
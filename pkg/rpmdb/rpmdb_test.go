@@ -0,0 +1,252 @@
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHeader creates a minimal header containing the given string
+// tags. Padding is added to the data store to simulate the size
+// of a real header.
+func newHeader(tags map[uint32]string, padding int) Header {
+	var index, data []byte
+	for tag, value := range tags {
+		index = binary.BigEndian.AppendUint32(index, tag)
+		index = binary.BigEndian.AppendUint32(index, typeString)
+		index = binary.BigEndian.AppendUint32(index, uint32(len(data)))
+		index = binary.BigEndian.AppendUint32(index, 1)
+		data = append(data, value...)
+		data = append(data, 0)
+	}
+	data = append(data, make([]byte, padding)...)
+	h := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	h = binary.BigEndian.AppendUint32(h, uint32(len(data)))
+	h = append(h, index...)
+	return append(h, data...)
+}
+
+func TestDatabase(t *testing.T) {
+	db := New()
+	// use enough packages, and large enough headers that
+	// we need interior and overflow pages
+	for i := 0; i < 500; i++ {
+		h := newHeader(map[uint32]string{TagName: fmt.Sprintf("pkg-%d", i), TagArch: "x86_64"}, (i*997)%20000)
+		require.NoError(t, db.Add(h))
+	}
+	data, err := db.Bytes()
+	require.NoError(t, err)
+	assert.Zero(t, len(data)%pageSize)
+
+	t.Run("round trip", func(t *testing.T) {
+		out, err := Read(data)
+		require.NoError(t, err)
+		assert.EqualValues(t, db.Headers(), out.Headers())
+		assert.EqualValues(t, 500, out.sequence)
+	})
+	t.Run("replace existing package", func(t *testing.T) {
+		out, err := Read(data)
+		require.NoError(t, err)
+
+		h := newHeader(map[uint32]string{TagName: "pkg-3", TagArch: "x86_64", TagVersion: "2"}, 0)
+		require.NoError(t, out.Add(h))
+		require.NoError(t, out.Add(newHeader(map[uint32]string{TagName: "pkg-3", TagArch: "i686"}, 0)))
+
+		headers := out.Headers()
+		assert.Len(t, headers, 501)
+		assert.EqualValues(t, h, headers[499])
+		assert.EqualValues(t, 502, out.sequence)
+	})
+	t.Run("header without a name", func(t *testing.T) {
+		assert.Error(t, New().Add(newHeader(map[uint32]string{TagArch: "x86_64"}, 0)))
+	})
+}
+
+func TestRead(t *testing.T) {
+	_, err := Read([]byte("not a database"))
+	assert.Error(t, err)
+
+	data, err := New().Bytes()
+	require.NoError(t, err)
+	db, err := Read(data)
+	require.NoError(t, err)
+	assert.Empty(t, db.Headers())
+}
+
+func TestReadHeader(t *testing.T) {
+	// newPackage creates a package file
+	// containing the signature and header
+	newPackage := func(sig, h Header) *bytes.Buffer {
+		// lead, signature (padded to 8 bytes) then the header
+		var buf bytes.Buffer
+		buf.Write(make([]byte, leadSize))
+		buf.Write(headerMagic)
+		buf.Write(make([]byte, 4))
+		buf.Write(sig)
+		if buf.Len()%8 != 0 {
+			buf.Write(make([]byte, 8-buf.Len()%8))
+		}
+		buf.Write(headerMagic)
+		buf.Write(make([]byte, 4))
+		buf.Write(h)
+		buf.WriteString("payload")
+		return &buf
+	}
+
+	t.Run("unsigned", func(t *testing.T) {
+		h := newHeader(map[uint32]string{TagName: "bash", TagVersion: "5.1.8", TagRelease: "6.el9", TagArch: "x86_64"}, 0)
+		sig := newHeader(map[uint32]string{1000: "signature"}, 3)
+		buf := newPackage(sig, h)
+
+		out, err := ReadHeader(buf)
+		require.NoError(t, err)
+		assert.EqualValues(t, h, out)
+		assert.EqualValues(t, "bash-5.1.8-6.el9.x86_64", out.NVRA())
+		assert.EqualValues(t, "payload", buf.String())
+	})
+	t.Run("digests", func(t *testing.T) {
+		md5 := []byte{0xde, 0xad, 0xbe, 0xef, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+		h := newHeaderValues(tagValue{TagName, "bash"}, tagValue{TagArch, "x86_64"}, tagValue{tagRequireFlags, []uint32{1}})
+		sig := newHeaderValues(
+			tagValue{1004, md5},
+			tagValue{tagSHA1Header, "0a1b2c"},
+			tagValue{tagSHA256Header, "3d4e5f"},
+		)
+
+		// the digests are added to the end of the header
+		out, err := ReadHeader(newPackage(sig, h))
+		require.NoError(t, err)
+		assert.EqualValues(t, h[8:8+3*indexEntrySize], out[8:8+3*indexEntrySize])
+		assert.EqualValues(t, "bash", out.String(TagName))
+		assert.EqualValues(t, []uint32{1}, out.Uint32s(tagRequireFlags))
+		assert.EqualValues(t, md5, out.Bytes(tagSigMD5))
+		assert.EqualValues(t, "0a1b2c", out.String(tagSHA1Header))
+		assert.EqualValues(t, "3d4e5f", out.String(tagSHA256Header))
+
+		// and are indexed
+		db := New()
+		require.NoError(t, db.Add(out))
+		data, err := db.Bytes()
+		require.NoError(t, err)
+		rows, err := readTable(data, "Sigmd5")
+		require.NoError(t, err)
+		require.Len(t, rows, 1)
+		assert.EqualValues(t, []any{md5, int64(1), int64(0)}, rows[0].Values)
+		rows, err = readTable(data, "Sha1header")
+		require.NoError(t, err)
+		require.Len(t, rows, 1)
+		assert.EqualValues(t, []any{"0a1b2c", int64(1), int64(0)}, rows[0].Values)
+	})
+}
+
+// tagValue is a header tag used by newHeaderValues.
+type tagValue struct {
+	tag   uint32
+	value any
+}
+
+// newHeaderValues creates a header containing string, string
+// array, binary and int32 array tags.
+func newHeaderValues(values ...tagValue) Header {
+	var index, data []byte
+	for _, v := range values {
+		var typ, count uint32
+		offset := len(data)
+		switch value := v.value.(type) {
+		case string:
+			typ, count = typeString, 1
+			data = append(append(data, value...), 0)
+		case []string:
+			typ, count = typeStringArray, uint32(len(value))
+			for _, s := range value {
+				data = append(append(data, s...), 0)
+			}
+		case []byte:
+			typ, count = typeBin, uint32(len(value))
+			data = append(data, value...)
+		case []uint32:
+			for len(data)%4 != 0 {
+				data = append(data, 0)
+			}
+			offset = len(data)
+			typ, count = typeInt32, uint32(len(value))
+			for _, n := range value {
+				data = binary.BigEndian.AppendUint32(data, n)
+			}
+		}
+		index = binary.BigEndian.AppendUint32(index, v.tag)
+		index = binary.BigEndian.AppendUint32(index, typ)
+		index = binary.BigEndian.AppendUint32(index, uint32(offset))
+		index = binary.BigEndian.AppendUint32(index, count)
+	}
+	h := binary.BigEndian.AppendUint32(nil, uint32(len(values)))
+	h = binary.BigEndian.AppendUint32(h, uint32(len(data)))
+	h = append(h, index...)
+	return append(h, data...)
+}
+
+func TestDatabase_Indexes(t *testing.T) {
+	db := New()
+	require.NoError(t, db.Add(newHeaderValues(
+		tagValue{TagName, "bash"},
+		tagValue{TagArch, "x86_64"},
+		tagValue{tagBasenames, []string{"bash", "sh"}},
+		tagValue{tagDirnames, []string{"/usr/bin/"}},
+		tagValue{tagProvideName, []string{"/bin/sh", "bash"}},
+		tagValue{tagRequireName, []string{"/bin/sh", "libc.so.6", "rpmlib(CompressedFileNames)"}},
+		tagValue{tagRequireFlags, []uint32{senseScriptPre, 0, senseRPMLib}},
+	)))
+	require.NoError(t, db.Add(newHeaderValues(
+		tagValue{TagName, "coreutils"},
+		tagValue{TagArch, "x86_64"},
+		tagValue{tagBasenames, []string{"ls"}},
+		tagValue{tagInstallTID, []uint32{1}},
+	)))
+	data, err := db.Bytes()
+	require.NoError(t, err)
+
+	var cases = []struct {
+		table string
+		rows  [][]any
+	}{
+		{
+			"Name",
+			[][]any{{"bash", int64(1), int64(0)}, {"coreutils", int64(2), int64(0)}},
+		},
+		{
+			"Basenames",
+			[][]any{{"bash", int64(1), int64(0)}, {"sh", int64(1), int64(1)}, {"ls", int64(2), int64(0)}},
+		},
+		{
+			"Providename",
+			[][]any{{"/bin/sh", int64(1), int64(0)}, {"bash", int64(1), int64(1)}},
+		},
+		{
+			"Requirename",
+			[][]any{{"libc.so.6", int64(1), int64(1)}},
+		},
+		{
+			"Installtid",
+			[][]any{{[]byte{1, 0, 0, 0}, int64(2), int64(0)}},
+		},
+		{
+			"Conflictname",
+			nil,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.table, func(t *testing.T) {
+			rows, err := readTable(data, tt.table)
+			require.NoError(t, err)
+			var values [][]any
+			for _, r := range rows {
+				values = append(values, r.Values)
+			}
+			assert.EqualValues(t, tt.rows, values)
+		})
+	}
+}
@@ -0,0 +1,567 @@
+package rpmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// This file contains just enough of the SQLite file format
+// (https://www.sqlite.org/fileformat2.html) to read and write
+// rowid tables. We can't use a real SQLite driver without
+// enabling CGO.
+
+const (
+	sqliteMagic    = "SQLite format 3\x00"
+	sqliteHeader   = 100
+	pageSize       = 4096
+	sqliteVersion  = 3045001
+	schemaFormat   = 4
+	textEncodeUTF8 = 1
+
+	pageInteriorTable = 0x05
+	pageLeafTable     = 0x0d
+)
+
+var errCorrupt = errors.New("malformed database")
+
+// row is a single row in a rowid table.
+type row struct {
+	ID     int64
+	Values []any
+}
+
+// table is a rowid table that should be written to the database.
+type table struct {
+	Name string
+	SQL  string
+	Rows []row
+}
+
+// readTable returns all the rows of the table with the given name.
+func readTable(data []byte, name string) ([]row, error) {
+	if len(data) < sqliteHeader || string(data[:16]) != sqliteMagic {
+		return nil, errors.New("not a sqlite database")
+	}
+	size := int(binary.BigEndian.Uint16(data[16:18]))
+	if size == 1 {
+		size = 65536
+	}
+	db := &reader{
+		data:   data,
+		size:   size,
+		usable: size - int(data[20]),
+	}
+	if db.usable < 480 || len(data)%size != 0 {
+		return nil, errCorrupt
+	}
+
+	schema, err := db.rows(1)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema: %w", err)
+	}
+	for _, r := range schema {
+		if len(r.Values) < 4 || r.Values[0] != "table" || r.Values[1] != name {
+			continue
+		}
+		root, ok := r.Values[3].(int64)
+		if !ok {
+			return nil, errCorrupt
+		}
+		return db.rows(int(root))
+	}
+	return nil, fmt.Errorf("table not found: %s", name)
+}
+
+type reader struct {
+	data   []byte
+	size   int
+	usable int
+}
+
+func (db *reader) page(n int) ([]byte, error) {
+	if n < 1 || n*db.size > len(db.data) {
+		return nil, fmt.Errorf("%w: page %d out of range", errCorrupt, n)
+	}
+	return db.data[(n-1)*db.size : n*db.size], nil
+}
+
+// rows walks a table b-tree and returns its rows in order.
+func (db *reader) rows(root int) ([]row, error) {
+	var out []row
+	var walk func(n, depth int) error
+	walk = func(n, depth int) error {
+		if depth > 64 {
+			return fmt.Errorf("%w: b-tree is too deep", errCorrupt)
+		}
+		p, err := db.page(n)
+		if err != nil {
+			return err
+		}
+		off := 0
+		if n == 1 {
+			off = sqliteHeader
+		}
+		if len(p) < off+8 {
+			return errCorrupt
+		}
+		kind := p[off]
+		count := int(binary.BigEndian.Uint16(p[off+3:]))
+		headerLen := 8
+		if kind == pageInteriorTable {
+			headerLen = 12
+		}
+		if len(p) < off+headerLen+count*2 {
+			return errCorrupt
+		}
+		for i := 0; i < count; i++ {
+			ptr := int(binary.BigEndian.Uint16(p[off+headerLen+i*2:]))
+			if ptr >= len(p) {
+				return errCorrupt
+			}
+			cell := p[ptr:]
+			switch kind {
+			case pageInteriorTable:
+				if len(cell) < 4 {
+					return errCorrupt
+				}
+				if err := walk(int(binary.BigEndian.Uint32(cell)), depth+1); err != nil {
+					return err
+				}
+			case pageLeafTable:
+				r, err := db.cell(cell)
+				if err != nil {
+					return err
+				}
+				out = append(out, r)
+			default:
+				return fmt.Errorf("%w: unexpected page type 0x%02x", errCorrupt, kind)
+			}
+		}
+		if kind == pageInteriorTable {
+			return walk(int(binary.BigEndian.Uint32(p[off+8:])), depth+1)
+		}
+		return nil
+	}
+	if err := walk(root, 0); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// cell decodes a table leaf cell, following any overflow pages.
+func (db *reader) cell(cell []byte) (row, error) {
+	size, n := getVarint(cell)
+	if n == 0 {
+		return row{}, errCorrupt
+	}
+	cell = cell[n:]
+	id, n := getVarint(cell)
+	if n == 0 {
+		return row{}, errCorrupt
+	}
+	cell = cell[n:]
+
+	local := localPayload(int(size), db.usable)
+	if local > len(cell) {
+		return row{}, errCorrupt
+	}
+	payload := make([]byte, 0, size)
+	payload = append(payload, cell[:local]...)
+	if local < int(size) {
+		if len(cell) < local+4 {
+			return row{}, errCorrupt
+		}
+		next := int(binary.BigEndian.Uint32(cell[local:]))
+		for len(payload) < int(size) {
+			p, err := db.page(next)
+			if err != nil {
+				return row{}, err
+			}
+			chunk := min(int(size)-len(payload), db.usable-4)
+			payload = append(payload, p[4:4+chunk]...)
+			next = int(binary.BigEndian.Uint32(p))
+		}
+	}
+	values, err := decodeRecord(payload)
+	if err != nil {
+		return row{}, err
+	}
+	return row{ID: int64(id), Values: values}, nil
+}
+
+// writeDatabase creates a database containing the given tables.
+func writeDatabase(tables []table) ([]byte, error) {
+	w := &writer{}
+	// page 1 is the schema, and each table gets its
+	// own root page so that the schema can refer to it
+	w.alloc()
+	roots := make([]int, len(tables))
+	for i := range tables {
+		roots[i] = w.alloc()
+	}
+
+	schema := make([]row, len(tables))
+	for i, t := range tables {
+		schema[i] = row{
+			ID:     int64(i + 1),
+			Values: []any{"table", t.Name, t.Name, int64(roots[i]), t.SQL},
+		}
+		if err := w.tree(roots[i], t.Rows); err != nil {
+			return nil, fmt.Errorf("writing table %s: %w", t.Name, err)
+		}
+	}
+	if err := w.tree(1, schema); err != nil {
+		return nil, fmt.Errorf("writing schema: %w", err)
+	}
+
+	out := bytes.Join(w.pages, nil)
+	h := out[:sqliteHeader]
+	copy(h, sqliteMagic)
+	binary.BigEndian.PutUint16(h[16:], pageSize)
+	h[18] = 1 // legacy (rollback journal) read/write version
+	h[19] = 1
+	h[21] = 64 // payload fractions, these are fixed values
+	h[22] = 32
+	h[23] = 32
+	binary.BigEndian.PutUint32(h[24:], 1) // file change counter
+	binary.BigEndian.PutUint32(h[28:], uint32(len(w.pages)))
+	binary.BigEndian.PutUint32(h[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(h[44:], schemaFormat)
+	binary.BigEndian.PutUint32(h[56:], textEncodeUTF8)
+	binary.BigEndian.PutUint32(h[92:], 1) // version-valid-for
+	binary.BigEndian.PutUint32(h[96:], sqliteVersion)
+	return out, nil
+}
+
+type writer struct {
+	pages [][]byte
+}
+
+// alloc reserves a new page and returns its number.
+func (w *writer) alloc() int {
+	w.pages = append(w.pages, make([]byte, pageSize))
+	return len(w.pages)
+}
+
+type child struct {
+	page  int
+	maxID int64
+}
+
+// tree writes a b-tree containing rows, with its root at the given page.
+func (w *writer) tree(root int, rows []row) error {
+	offset := func(n int) int {
+		if n == 1 {
+			return sqliteHeader
+		}
+		return 0
+	}
+
+	// pack the cells into leaf pages
+	var leaves [][][]byte
+	var leafIDs []int64
+	var current [][]byte
+	used := 0
+	for i, r := range rows {
+		if i > 0 && r.ID <= rows[i-1].ID {
+			return errors.New("rows must be sorted by id")
+		}
+		cell, err := w.leafCell(r)
+		if err != nil {
+			return err
+		}
+		// the root page may be page 1, which has less space available
+		if len(current) > 0 && used+len(cell)+2 > pageSize-sqliteHeader-8 {
+			leaves = append(leaves, current)
+			leafIDs = append(leafIDs, rows[i-1].ID)
+			current = nil
+			used = 0
+		}
+		current = append(current, cell)
+		used += len(cell) + 2
+	}
+	leaves = append(leaves, current)
+	if len(rows) > 0 {
+		leafIDs = append(leafIDs, rows[len(rows)-1].ID)
+	}
+
+	if len(leaves) == 1 {
+		writePage(w.pages[root-1], offset(root), pageLeafTable, leaves[0], 0)
+		return nil
+	}
+	level := make([]child, len(leaves))
+	for i, cells := range leaves {
+		n := w.alloc()
+		writePage(w.pages[n-1], 0, pageLeafTable, cells, 0)
+		level[i] = child{page: n, maxID: leafIDs[i]}
+	}
+
+	// build interior pages until everything fits in the root
+	for {
+		var groups [][]child
+		var group []child
+		used := 0
+		for _, c := range level {
+			size := 4 + varintLen(uint64(c.maxID)) + 2
+			if len(group) > 1 && used+size > pageSize-sqliteHeader-12 {
+				groups = append(groups, group)
+				group = nil
+				used = 0
+			}
+			group = append(group, c)
+			used += size
+		}
+		groups = append(groups, group)
+
+		if len(groups) == 1 {
+			writeInterior(w.pages[root-1], offset(root), groups[0])
+			return nil
+		}
+		next := make([]child, len(groups))
+		for i, g := range groups {
+			n := w.alloc()
+			writeInterior(w.pages[n-1], 0, g)
+			next[i] = child{page: n, maxID: g[len(g)-1].maxID}
+		}
+		level = next
+	}
+}
+
+// leafCell encodes a row as a table leaf cell, spilling
+// the payload onto overflow pages if required.
+func (w *writer) leafCell(r row) ([]byte, error) {
+	payload, err := encodeRecord(r.Values)
+	if err != nil {
+		return nil, err
+	}
+	cell := appendVarint(nil, uint64(len(payload)))
+	cell = appendVarint(cell, uint64(r.ID))
+
+	local := localPayload(len(payload), pageSize)
+	cell = append(cell, payload[:local]...)
+	if local == len(payload) {
+		return cell, nil
+	}
+
+	// write the overflow chain
+	rest := payload[local:]
+	first := w.alloc()
+	n := first
+	for {
+		chunk := min(len(rest), pageSize-4)
+		page := w.pages[n-1]
+		copy(page[4:], rest[:chunk])
+		rest = rest[chunk:]
+		if len(rest) == 0 {
+			break
+		}
+		next := w.alloc()
+		binary.BigEndian.PutUint32(w.pages[n-1], uint32(next))
+		n = next
+	}
+	return binary.BigEndian.AppendUint32(cell, uint32(first)), nil
+}
+
+func writePage(page []byte, off int, kind byte, cells [][]byte, right int) {
+	headerLen := 8
+	if kind == pageInteriorTable {
+		headerLen = 12
+		binary.BigEndian.PutUint32(page[off+8:], uint32(right))
+	}
+	page[off] = kind
+	binary.BigEndian.PutUint16(page[off+3:], uint16(len(cells)))
+
+	// cell content grows backwards from the end of the page
+	end := len(page)
+	for i, c := range cells {
+		end -= len(c)
+		copy(page[end:], c)
+		binary.BigEndian.PutUint16(page[off+headerLen+i*2:], uint16(end))
+	}
+	// a value of 0 is interpreted as 65536
+	binary.BigEndian.PutUint16(page[off+5:], uint16(end))
+}
+
+func writeInterior(page []byte, off int, children []child) {
+	last := children[len(children)-1]
+	cells := make([][]byte, len(children)-1)
+	for i, c := range children[:len(children)-1] {
+		cell := binary.BigEndian.AppendUint32(nil, uint32(c.page))
+		cells[i] = appendVarint(cell, uint64(c.maxID))
+	}
+	writePage(page, off, pageInteriorTable, cells, last.page)
+}
+
+// localPayload returns how much of a payload is stored
+// on a table leaf page, with the rest overflowing.
+func localPayload(size, usable int) int {
+	maxLocal := usable - 35
+	if size <= maxLocal {
+		return size
+	}
+	minLocal := (usable-12)*32/255 - 23
+	k := minLocal + (size-minLocal)%(usable-4)
+	if k <= maxLocal {
+		return k
+	}
+	return minLocal
+}
+
+// encodeRecord converts a list of values into the SQLite
+// record format. Supported types are nil, int64, string
+// and []byte.
+func encodeRecord(values []any) ([]byte, error) {
+	var types []byte
+	var body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			types = appendVarint(types, 0)
+		case int64:
+			switch {
+			case v == 0:
+				types = appendVarint(types, 8)
+			case v == 1:
+				types = appendVarint(types, 9)
+			case v >= -128 && v <= 127:
+				types = appendVarint(types, 1)
+				body = append(body, byte(v))
+			case v >= -32768 && v <= 32767:
+				types = appendVarint(types, 2)
+				body = binary.BigEndian.AppendUint16(body, uint16(v))
+			case v >= -2147483648 && v <= 2147483647:
+				types = appendVarint(types, 4)
+				body = binary.BigEndian.AppendUint32(body, uint32(v))
+			default:
+				types = appendVarint(types, 6)
+				body = binary.BigEndian.AppendUint64(body, uint64(v))
+			}
+		case string:
+			types = appendVarint(types, uint64(len(v))*2+13)
+			body = append(body, v...)
+		case []byte:
+			types = appendVarint(types, uint64(len(v))*2+12)
+			body = append(body, v...)
+		default:
+			return nil, fmt.Errorf("unsupported value type: %T", v)
+		}
+	}
+	// the header size includes its own varint
+	size := len(types) + 1
+	for varintLen(uint64(size))+len(types) != size {
+		size = varintLen(uint64(size)) + len(types)
+	}
+	out := appendVarint(nil, uint64(size))
+	out = append(out, types...)
+	return append(out, body...), nil
+}
+
+func decodeRecord(data []byte) ([]any, error) {
+	size, n := getVarint(data)
+	if n == 0 || int(size) > len(data) {
+		return nil, errCorrupt
+	}
+	header := data[n:size]
+	body := data[size:]
+	var values []any
+	for len(header) > 0 {
+		t, n := getVarint(header)
+		if n == 0 {
+			return nil, errCorrupt
+		}
+		header = header[n:]
+
+		var length int
+		switch {
+		case t == 0, t == 8, t == 9:
+			length = 0
+		case t <= 4:
+			length = int(t)
+		case t == 5:
+			length = 6
+		case t == 6, t == 7:
+			length = 8
+		case t >= 12:
+			length = int(t-12) / 2
+		default:
+			return nil, fmt.Errorf("%w: unexpected serial type %d", errCorrupt, t)
+		}
+		if length > len(body) {
+			return nil, errCorrupt
+		}
+		v := body[:length]
+		body = body[length:]
+
+		switch {
+		case t == 0:
+			values = append(values, nil)
+		case t == 8:
+			values = append(values, int64(0))
+		case t == 9:
+			values = append(values, int64(1))
+		case t <= 6:
+			// sign-extend the big-endian integer
+			var i int64
+			if v[0]&0x80 != 0 {
+				i = -1
+			}
+			for _, b := range v {
+				i = i<<8 | int64(b)
+			}
+			values = append(values, i)
+		case t == 7:
+			// we never need floats, but we still need to skip them
+			values = append(values, nil)
+		case t%2 == 0:
+			values = append(values, bytes.Clone(v))
+		default:
+			values = append(values, string(v))
+		}
+	}
+	return values, nil
+}
+
+// appendVarint appends a SQLite (big-endian, not protobuf) varint.
+func appendVarint(b []byte, v uint64) []byte {
+	if v > 0x00ffffffffffffff {
+		// the 9th byte holds a full 8 bits
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+	var buf [8]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7f)
+	v >>= 7
+	for v > 0 {
+		i--
+		buf[i] = byte(v&0x7f) | 0x80
+		v >>= 7
+	}
+	return append(b, buf[i:]...)
+}
+
+func getVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+func varintLen(v uint64) int {
+	return len(appendVarint(nil, v))
+}
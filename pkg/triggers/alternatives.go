@@ -0,0 +1,222 @@
+package triggers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	memfs "chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+)
+
+// alternativesDir contains the links
+// to the selected alternatives.
+var alternativesDir = filepath.Join("/etc", "alternatives")
+
+// alternativeLink is a generic name (e.g. "editor")
+// and the link (e.g. "/usr/bin/editor") that points to it.
+type alternativeLink struct {
+	link string
+	name string
+}
+
+type alternative struct {
+	path     string
+	priority int
+	// followers maps the name of each follower
+	// (slave) link to the path that it points to
+	followers map[string]string
+}
+
+type alternativeGroup struct {
+	link      string
+	followers []alternativeLink
+	choices   []alternative
+	// selected is the path chosen with '--set', which
+	// is used instead of the highest priority choice
+	selected string
+}
+
+// Alternatives emulates 'update-alternatives', creating the
+// links for the highest priority alternative in each group.
+type Alternatives struct {
+	groups map[string]*alternativeGroup
+}
+
+func NewAlternatives() *Alternatives {
+	return &Alternatives{groups: map[string]*alternativeGroup{}}
+}
+
+func (*Alternatives) Name() string {
+	return "alternatives"
+}
+
+func (*Alternatives) Commands() []string {
+	return []string{"update-alternatives", "alternatives"}
+}
+
+func (a *Alternatives) Handle(_ context.Context, _ string, args []string) error {
+	// skip the options that don't change
+	// what the command does
+	for len(args) > 0 {
+		switch args[0] {
+		case "--quiet", "--verbose", "--debug", "--force", "--skip-auto":
+			args = args[1:]
+			continue
+		case "--altdir", "--admindir", "--instdir", "--root", "--log":
+			return fmt.Errorf("unsupported option: %s", args[0])
+		}
+		break
+	}
+	if len(args) == 0 {
+		return errors.New("no action was given")
+	}
+	switch args[0] {
+	case "--install":
+		return a.install(args[1:])
+	case "--set":
+		if len(args) < 3 {
+			return errors.New("--set requires a name and path")
+		}
+		g, ok := a.groups[args[1]]
+		if !ok {
+			return fmt.Errorf("unknown alternative: %s", args[1])
+		}
+		g.selected = args[2]
+		return nil
+	case "--remove", "--remove-all", "--auto", "--display", "--query", "--list", "--get-selections":
+		// installing a package from scratch
+		// never removes an alternative
+		return nil
+	}
+	return fmt.Errorf("unsupported action: %s", args[0])
+}
+
+// install records an alternative given with
+// '--install <link> <name> <path> <priority> [--slave <link> <name> <path>]...'.
+func (a *Alternatives) install(args []string) error {
+	if len(args) < 4 {
+		return errors.New("--install requires a link, name, path and priority")
+	}
+	priority, err := strconv.Atoi(args[3])
+	if err != nil {
+		return fmt.Errorf("parsing priority: %w", err)
+	}
+	for _, s := range args[:3] {
+		if strings.ContainsAny(s, "$`") {
+			return fmt.Errorf("variables aren't supported: %s", s)
+		}
+	}
+	name := args[1]
+	g, ok := a.groups[name]
+	if !ok {
+		g = &alternativeGroup{link: args[0]}
+		a.groups[name] = g
+	}
+	choice := alternative{path: args[2], priority: priority, followers: map[string]string{}}
+
+	rest := args[4:]
+	for len(rest) > 0 {
+		if rest[0] != "--slave" && rest[0] != "--follower" {
+			return fmt.Errorf("unexpected argument: %s", rest[0])
+		}
+		if len(rest) < 4 {
+			return fmt.Errorf("%s requires a link, name and path", rest[0])
+		}
+		follower := alternativeLink{link: rest[1], name: rest[2]}
+		if !slices.Contains(g.followers, follower) {
+			g.followers = append(g.followers, follower)
+		}
+		choice.followers[follower.name] = rest[3]
+		rest = rest[4:]
+	}
+
+	// installing the same path again replaces it
+	if i := slices.IndexFunc(g.choices, func(c alternative) bool {
+		return c.path == choice.path
+	}); i >= 0 {
+		g.choices[i] = choice
+		return nil
+	}
+	g.choices = append(g.choices, choice)
+	return nil
+}
+
+// best returns the selected alternative, or the one with the highest priority.
+// Alternatives with the same priority are chosen in the order they were added.
+func (g *alternativeGroup) best() alternative {
+	best := g.choices[0]
+	for _, c := range g.choices {
+		if c.path == g.selected {
+			return c
+		}
+		if c.priority > best.priority {
+			best = c
+		}
+	}
+	return best
+}
+
+func (a *Alternatives) Apply(ctx context.Context, rootfs memfs.FullFS) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	for _, name := range slices.Sorted(maps.Keys(a.groups)) {
+		g := a.groups[name]
+		best := g.best()
+		log.V(4).Info("selecting alternative", "name", name, "path", best.path, "priority", best.priority)
+
+		if err := alternativeLinks(ctx, rootfs, alternativeLink{link: g.link, name: name}, best.path); err != nil {
+			return err
+		}
+		for _, follower := range g.followers {
+			path, ok := best.followers[follower.name]
+			if !ok {
+				continue
+			}
+			if err := alternativeLinks(ctx, rootfs, follower, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// alternativeLinks creates the link (e.g. /usr/bin/editor -> /etc/alternatives/editor)
+// and the link to the alternative (e.g. /etc/alternatives/editor -> /usr/bin/vim.basic).
+func alternativeLinks(ctx context.Context, rootfs memfs.FullFS, l alternativeLink, path string) error {
+	altPath := filepath.Join(alternativesDir, l.name)
+	if err := replaceLink(ctx, rootfs, path, altPath); err != nil {
+		return err
+	}
+	return replaceLink(ctx, rootfs, altPath, l.link)
+}
+
+// replaceLink creates a symbolic link, replacing any existing link.
+// Regular files are never replaced, in the same way as update-alternatives.
+func replaceLink(ctx context.Context, rootfs memfs.FullFS, target, path string) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("path", path, "target", target)
+
+	if info, err := rootfs.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSymlink == 0 {
+			log.Info("not replacing file with a link to an alternative")
+			return nil
+		}
+		if err := rootfs.Remove(path); err != nil {
+			return fmt.Errorf("removing %s: %w", path, err)
+		}
+	}
+	if err := rootfs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating parent directory: %w", err)
+	}
+	log.V(5).Info("creating link")
+	if err := rootfs.Symlink(target, path); err != nil {
+		return fmt.Errorf("creating link %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package triggers
+
+import (
+	"context"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlternatives(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	var cases = []struct {
+		name     string
+		commands [][]string
+		expected map[string]string
+	}{
+		{
+			"highest priority",
+			[][]string{
+				{"--install", "/usr/bin/editor", "editor", "/bin/nano", "40", "--slave", "/usr/share/man/man1/editor.1.gz", "editor.1.gz", "/usr/share/man/man1/nano.1.gz"},
+				{"--quiet", "--install", "/usr/bin/editor", "editor", "/usr/bin/vim.basic", "50"},
+			},
+			map[string]string{
+				"/usr/bin/editor":               "/etc/alternatives/editor",
+				"/etc/alternatives/editor":      "/usr/bin/vim.basic",
+				"/etc/alternatives/editor.1.gz": "",
+			},
+		},
+		{
+			"followers",
+			[][]string{
+				{"--install", "/usr/bin/editor", "editor", "/bin/nano", "40", "--slave", "/usr/share/man/man1/editor.1.gz", "editor.1.gz", "/usr/share/man/man1/nano.1.gz"},
+			},
+			map[string]string{
+				"/usr/bin/editor":                 "/etc/alternatives/editor",
+				"/etc/alternatives/editor":        "/bin/nano",
+				"/usr/share/man/man1/editor.1.gz": "/etc/alternatives/editor.1.gz",
+				"/etc/alternatives/editor.1.gz":   "/usr/share/man/man1/nano.1.gz",
+			},
+		},
+		{
+			"selected",
+			[][]string{
+				{"--install", "/usr/bin/java", "java", "/usr/lib/jvm/java-17/bin/java", "1700"},
+				{"--install", "/usr/bin/java", "java", "/usr/lib/jvm/java-11/bin/java", "1100"},
+				{"--set", "java", "/usr/lib/jvm/java-11/bin/java"},
+			},
+			map[string]string{
+				"/etc/alternatives/java": "/usr/lib/jvm/java-11/bin/java",
+			},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			rootfs := fs.NewMemFS()
+			a := NewAlternatives()
+			for _, args := range tt.commands {
+				require.NoError(t, a.Handle(ctx, "update-alternatives", args))
+			}
+			require.NoError(t, a.Apply(ctx, rootfs))
+
+			for path, target := range tt.expected {
+				actual, err := rootfs.Readlink(path)
+				if target == "" {
+					assert.Error(t, err)
+					continue
+				}
+				require.NoError(t, err)
+				assert.EqualValues(t, target, actual)
+			}
+		})
+	}
+
+	t.Run("existing file", func(t *testing.T) {
+		rootfs := fs.NewMemFS()
+		require.NoError(t, rootfs.MkdirAll("/usr/bin", 0755))
+		require.NoError(t, rootfs.WriteFile("/usr/bin/editor", []byte("#!/bin/sh"), 0755))
+
+		a := NewAlternatives()
+		require.NoError(t, a.Handle(ctx, "update-alternatives", []string{"--install", "/usr/bin/editor", "editor", "/bin/nano", "40"}))
+		require.NoError(t, a.Apply(ctx, rootfs))
+
+		data, err := rootfs.ReadFile("/usr/bin/editor")
+		require.NoError(t, err)
+		assert.EqualValues(t, "#!/bin/sh", string(data))
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		a := NewAlternatives()
+		assert.Error(t, a.Handle(ctx, "update-alternatives", []string{"--config", "editor"}))
+		assert.Error(t, a.Handle(ctx, "update-alternatives", []string{"--install", "/usr/bin/editor", "editor", "$EDITOR", "40"}))
+		assert.Error(t, a.Handle(ctx, "update-alternatives", []string{"--install", "/usr/bin/editor", "editor"}))
+	})
+}
@@ -0,0 +1,42 @@
+package triggers
+
+import (
+	"context"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/djcass44/all-your-base/pkg/ldconfig"
+)
+
+// Ldconfig emulates 'ldconfig', creating the
+// SONAME links of the installed libraries.
+type Ldconfig struct {
+	called bool
+}
+
+func NewLdconfig() *Ldconfig {
+	return &Ldconfig{}
+}
+
+func (*Ldconfig) Name() string {
+	return "ldconfig"
+}
+
+func (*Ldconfig) Commands() []string {
+	return []string{"ldconfig"}
+}
+
+func (l *Ldconfig) Handle(context.Context, string, []string) error {
+	l.called = true
+	return nil
+}
+
+func (l *Ldconfig) Apply(ctx context.Context, rootfs fs.FullFS) error {
+	if !l.called {
+		return nil
+	}
+	dirs, err := ldconfig.Dirs(ctx, rootfs)
+	if err != nil {
+		return err
+	}
+	return ldconfig.Links(ctx, rootfs, dirs)
+}
@@ -0,0 +1,332 @@
+package triggers
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// shellKeywords are skipped when they start a command,
+// as the command that follows them is what we care about.
+var shellKeywords = map[string]bool{
+	"if":    true,
+	"then":  true,
+	"else":  true,
+	"elif":  true,
+	"fi":    true,
+	"do":    true,
+	"done":  true,
+	"while": true,
+	"until": true,
+	"!":     true,
+	"{":     true,
+	"}":     true,
+	"(":     true,
+	")":     true,
+}
+
+// command is a simple command from a shell script.
+type command struct {
+	name string
+	args []string
+	// redirected indicates that the output of the command
+	// is written to a file other than /dev/null
+	redirected bool
+	// conditional indicates that the command may not run
+	// during an install (e.g. it's inside an 'if', or in
+	// an arm of 'case "$1"' other than configure/install)
+	conditional bool
+}
+
+// installArgs are the first arguments given to
+// install scripts when a package is installed.
+var installArgs = []string{"configure", "install"}
+
+// block is a compound command that a script is inside of.
+type block struct {
+	// end is the keyword or operator that closes the block
+	end string
+	// install indicates that the block is an arm of a
+	// top-level 'case "$1"' that runs during an install
+	install bool
+	// dollarOne indicates that the block is a
+	// top-level 'case "$1"'
+	dollarOne bool
+}
+
+// parseScript finds the simple commands in a shell script. Only the
+// commands at the top level of the script, or in the configure/install
+// arms of a top-level 'case "$1"', are certain to run during an install.
+// Anything else (e.g. inside an 'if' or after '&&') is marked as
+// conditional.
+func parseScript(script string) []command {
+	var out []command
+	var words []string
+	var redirected bool
+	var blocks []block
+	// chained indicates that the next command
+	// follows a '&&' or '||'
+	chained := false
+	// casePattern indicates that the next words
+	// are a case pattern, up until the ')'
+	casePattern := false
+	var patterns []string
+
+	conditional := func() bool {
+		if chained {
+			return true
+		}
+		switch {
+		case len(blocks) == 0:
+			return false
+		case len(blocks) == 2 && blocks[0].dollarOne:
+			return !blocks[1].install
+		default:
+			return true
+		}
+	}
+	flush := func() {
+		cmd := words
+		words = nil
+		r := redirected
+		redirected = false
+		// skip variable assignments
+		for len(cmd) > 0 && isAssignment(cmd[0]) {
+			cmd = cmd[1:]
+		}
+		if len(cmd) == 0 {
+			return
+		}
+		// function definitions (e.g. "foo() {"), whose
+		// body only runs if the function is called
+		if len(cmd) > 1 && cmd[1] == "()" {
+			if cmd[len(cmd)-1] == "{" {
+				blocks = append(blocks, block{end: "}"})
+			}
+			return
+		}
+		out = append(out, command{name: cmd[0], args: cmd[1:], redirected: r, conditional: conditional()})
+	}
+	pop := func(end string) {
+		if len(blocks) > 0 && blocks[len(blocks)-1].end == end {
+			blocks = blocks[:len(blocks)-1]
+		}
+	}
+
+	tokens := tokenize(script)
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok.op == ";;":
+			flush()
+			chained = false
+			pop(";;")
+			if len(blocks) > 0 && blocks[len(blocks)-1].end == "esac" {
+				casePattern = true
+				patterns = nil
+			}
+		case tok.op == ")" && casePattern:
+			words = nil
+			casePattern = false
+			// the arm is a new block within the case
+			install := len(blocks) > 0 && blocks[len(blocks)-1].dollarOne && slices.ContainsFunc(patterns, func(p string) bool {
+				return slices.Contains(installArgs, p)
+			})
+			blocks = append(blocks, block{end: ";;", install: install})
+		case casePattern && tok.op != "" && tok.op != ";":
+			// alternatives (e.g. "configure|abort-upgrade)")
+		case tok.op == "(" && len(words) > 0 && i+1 < len(tokens) && tokens[i+1].op == ")":
+			words = append(words, "()")
+			i++
+		case tok.op == ">" || tok.op == ">>" || tok.op == ">&" || tok.op == "<" || tok.op == "&>":
+			// the next word is the target of the redirection
+			if i+1 < len(tokens) && tokens[i+1].op == "" {
+				i++
+				if tok.op != "<" && tok.op != ">&" && tokens[i].word != "/dev/null" {
+					redirected = true
+				}
+			}
+		case tok.op == "(" && len(words) == 0:
+			blocks = append(blocks, block{end: ")"})
+		case tok.op == ")":
+			flush()
+			pop(")")
+		case tok.op != "":
+			flush()
+			// everything in a pipeline runs if its first command does
+			chained = tok.op == "&&" || tok.op == "||" || (tok.op == "|" && chained)
+		case len(words) == 0 && tok.word == "esac":
+			casePattern = false
+			pop(";;")
+			pop("esac")
+		case casePattern:
+			patterns = append(patterns, tok.word)
+		case len(words) == 0 && tok.word == "case":
+			// skip the word being matched and the 'in'
+			var subject string
+			for i+1 < len(tokens) && tokens[i+1].op == "" {
+				i++
+				if tokens[i].word == "in" {
+					break
+				}
+				subject = tokens[i].word
+			}
+			dollarOne := len(blocks) == 0 && !chained && (subject == "$1" || subject == "${1}")
+			blocks = append(blocks, block{end: "esac", dollarOne: dollarOne})
+			casePattern = true
+			patterns = nil
+		case len(words) == 0 && (tok.word == "if" || tok.word == "{"):
+			blocks = append(blocks, block{end: map[string]string{"if": "fi", "{": "}"}[tok.word]})
+		case len(words) == 0 && (tok.word == "while" || tok.word == "until"):
+			blocks = append(blocks, block{end: "done"})
+		case len(words) == 0 && (tok.word == "fi" || tok.word == "done" || tok.word == "}"):
+			pop(tok.word)
+		case len(words) == 0 && shellKeywords[tok.word]:
+		case len(words) == 0 && (tok.word == "for" || tok.word == "select"):
+			// skip the loop variable and the list
+			for i+1 < len(tokens) && tokens[i+1].op == "" {
+				i++
+			}
+			blocks = append(blocks, block{end: "done"})
+		default:
+			words = append(words, tok.word)
+		}
+	}
+	flush()
+	return out
+}
+
+func isAssignment(s string) bool {
+	name, _, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// commandName returns the name of the command, without its directory
+// (e.g. /usr/sbin/adduser -> adduser). Names that can't be known
+// without running the script (e.g. "$FOO") are returned as they are.
+func commandName(name string) string {
+	if strings.ContainsAny(name, "$`") {
+		return name
+	}
+	return filepath.Base(name)
+}
+
+type token struct {
+	word string
+	op   string
+}
+
+// tokenize splits a shell script into words and operators. Quotes
+// are removed from words, but variables aren't expanded.
+func tokenize(script string) []token {
+	var out []token
+	sb := strings.Builder{}
+	inWord := false
+	endWord := func() {
+		if inWord {
+			out = append(out, token{word: sb.String()})
+			sb.Reset()
+			inWord = false
+		}
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\\' && i+1 < len(script):
+			i++
+			if script[i] != '\n' {
+				sb.WriteByte(script[i])
+				inWord = true
+			}
+		case c == '\'':
+			end := strings.IndexByte(script[i+1:], '\'')
+			if end < 0 {
+				end = len(script) - i - 1
+			}
+			sb.WriteString(script[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+		case c == '"':
+			i++
+			for ; i < len(script) && script[i] != '"'; i++ {
+				if script[i] == '\\' && i+1 < len(script) {
+					i++
+				}
+				sb.WriteByte(script[i])
+			}
+			inWord = true
+		case c == '$' && i+1 < len(script) && script[i+1] == '(':
+			// keep command substitutions as part of the word
+			depth := 0
+			for ; i < len(script); i++ {
+				sb.WriteByte(script[i])
+				if script[i] == '(' {
+					depth++
+				} else if script[i] == ')' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			inWord = true
+		case c == '`':
+			end := strings.IndexByte(script[i+1:], '`')
+			if end < 0 {
+				end = len(script) - i - 2
+			}
+			sb.WriteString(script[i:min(i+2+end, len(script))])
+			inWord = true
+			i += end + 1
+		case c == '#' && !inWord:
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+			i--
+		case c == ' ' || c == '\t':
+			endWord()
+		case strings.IndexByte("\n;&|()<>", c) >= 0:
+			// file descriptors (e.g. "2>") belong to the operator
+			if (c == '>' || c == '<') && inWord && isNumber(sb.String()) {
+				sb.Reset()
+				inWord = false
+			}
+			endWord()
+			op := string(c)
+			for _, o := range []string{";;", "&&", "||", ">>", ">&", "&>"} {
+				if strings.HasPrefix(script[i:], o) {
+					op = o
+					break
+				}
+			}
+			i += len(op) - 1
+			if op == "\n" {
+				op = ";"
+			}
+			out = append(out, token{op: op})
+		default:
+			sb.WriteByte(c)
+			inWord = true
+		}
+	}
+	endWord()
+	return out
+}
+
+func isNumber(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
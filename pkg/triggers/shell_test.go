@@ -0,0 +1,94 @@
+package triggers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScript(t *testing.T) {
+	var cases = []struct {
+		name     string
+		script   string
+		expected []command
+	}{
+		{
+			"debhelper",
+			`#!/bin/sh
+set -e
+
+case "$1" in
+    configure|abort-upgrade)
+        update-alternatives --install /usr/bin/editor editor \
+            /usr/bin/vim.basic 30 --slave /usr/share/man/man1/editor.1.gz editor.1.gz /usr/share/man/man1/vim.1.gz
+    ;;
+    *)
+        echo "postinst called with unknown argument" >&2
+        exit 1
+    ;;
+esac
+
+# Automatically added by dh_installsystemd
+if [ "$1" = "configure" ] && [ -x "$(command -v systemd-tmpfiles)" ]; then
+	systemd-tmpfiles --create foo.conf >/dev/null 2>&1 || true
+fi
+exit 0
+`,
+			[]command{
+				{name: "set", args: []string{"-e"}},
+				{name: "update-alternatives", args: []string{"--install", "/usr/bin/editor", "editor", "/usr/bin/vim.basic", "30", "--slave", "/usr/share/man/man1/editor.1.gz", "editor.1.gz", "/usr/share/man/man1/vim.1.gz"}},
+				{name: "echo", args: []string{"postinst called with unknown argument"}, conditional: true},
+				{name: "exit", args: []string{"1"}, conditional: true},
+				{name: "[", args: []string{"$1", "=", "configure", "]"}, conditional: true},
+				{name: "[", args: []string{"-x", "$(command -v systemd-tmpfiles)", "]"}, conditional: true},
+				{name: "systemd-tmpfiles", args: []string{"--create", "foo.conf"}, conditional: true},
+				{name: "true", args: []string{}, conditional: true},
+				{name: "exit", args: []string{"0"}},
+			},
+		},
+		{
+			"functions and redirection",
+			`setup() {
+	FOO=bar mkdir -p '/var/lib/foo'
+}
+for f in a b; do echo $f > /etc/foo.conf; done
+`,
+			[]command{
+				{name: "mkdir", args: []string{"-p", "/var/lib/foo"}, conditional: true},
+				{name: "echo", args: []string{"$f"}, redirected: true, conditional: true},
+			},
+		},
+		{
+			"case arms",
+			`case "$1" in
+	install|upgrade)
+		addgroup -S nginx
+		if true; then adduser -S nginx; fi
+		;;
+	remove)
+		deluser nginx ;;
+esac
+case "$2" in
+	*) ldconfig ;;
+esac
+getent group nginx >/dev/null || addgroup -S nginx
+addgroup -S www-data
+`,
+			[]command{
+				{name: "addgroup", args: []string{"-S", "nginx"}},
+				{name: "true", args: []string{}, conditional: true},
+				{name: "adduser", args: []string{"-S", "nginx"}, conditional: true},
+				{name: "deluser", args: []string{"nginx"}, conditional: true},
+				{name: "ldconfig", args: []string{}, conditional: true},
+				{name: "getent", args: []string{"group", "nginx"}},
+				{name: "addgroup", args: []string{"-S", "nginx"}, conditional: true},
+				{name: "addgroup", args: []string{"-S", "www-data"}},
+			},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.EqualValues(t, tt.expected, parseScript(tt.script))
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package triggers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	memfs "chainguard.dev/apko/pkg/apk/fs"
+	"github.com/djcass44/all-your-base/pkg/users"
+	"github.com/go-logr/logr"
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// tmpfilesDirs contain tmpfiles.d configuration files. A
+// file in an earlier directory overrides a file with the
+// same name in a later directory.
+var tmpfilesDirs = []string{
+	"/etc/tmpfiles.d",
+	"/run/tmpfiles.d",
+	"/usr/local/lib/tmpfiles.d",
+	"/usr/lib/tmpfiles.d",
+}
+
+// Tmpfiles emulates 'systemd-tmpfiles --create', creating the
+// directories, files and links described by tmpfiles.d. Only
+// the line types that create something are supported.
+type Tmpfiles struct {
+	base ociv1.Image
+	// all indicates that every configuration
+	// file should be read
+	all   bool
+	files []string
+	db    *users.Database
+}
+
+func NewTmpfiles(base ociv1.Image) *Tmpfiles {
+	return &Tmpfiles{base: base}
+}
+
+func (*Tmpfiles) Name() string {
+	return "tmpfiles"
+}
+
+func (*Tmpfiles) Commands() []string {
+	return []string{"systemd-tmpfiles"}
+}
+
+func (t *Tmpfiles) Handle(_ context.Context, _ string, args []string) error {
+	var create bool
+	var files []string
+	for _, arg := range args {
+		switch {
+		case arg == "--create":
+			create = true
+		case arg == "--remove", arg == "--clean", arg == "--boot":
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unsupported option: %s", arg)
+		case strings.ContainsAny(arg, "$`"):
+			return fmt.Errorf("variables aren't supported: %s", arg)
+		default:
+			files = append(files, arg)
+		}
+	}
+	if !create {
+		return nil
+	}
+	if len(files) == 0 {
+		t.all = true
+		return nil
+	}
+	for _, f := range files {
+		if !slices.Contains(t.files, f) {
+			t.files = append(t.files, f)
+		}
+	}
+	return nil
+}
+
+func (t *Tmpfiles) Apply(ctx context.Context, rootfs memfs.FullFS) error {
+	if !t.all && len(t.files) == 0 {
+		return nil
+	}
+	configs, err := t.configFiles(rootfs)
+	if err != nil {
+		return err
+	}
+	for _, path := range configs {
+		if err := t.apply(ctx, rootfs, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configFiles returns the configuration files
+// to read, sorted by their name.
+func (t *Tmpfiles) configFiles(rootfs memfs.FullFS) ([]string, error) {
+	found := map[string]string{}
+	for _, dir := range tmpfilesDirs {
+		entries, err := rootfs.ReadDir(dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("reading %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			if _, ok := found[entry.Name()]; ok || entry.IsDir() || !strings.HasSuffix(entry.Name(), ".conf") {
+				continue
+			}
+			found[entry.Name()] = filepath.Join(dir, entry.Name())
+		}
+	}
+	if !t.all {
+		selected := map[string]string{}
+		for _, f := range t.files {
+			if filepath.IsAbs(f) {
+				selected[filepath.Base(f)] = f
+				continue
+			}
+			if path, ok := found[f]; ok {
+				selected[f] = path
+			}
+		}
+		found = selected
+	}
+	var out []string
+	for _, name := range slices.Sorted(maps.Keys(found)) {
+		out = append(out, found[name])
+	}
+	return out, nil
+}
+
+func (t *Tmpfiles) apply(ctx context.Context, rootfs memfs.FullFS, path string) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("config", path)
+
+	data, err := rootfs.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			log.V(4).Info("skipping invalid line", "line", line)
+			continue
+		}
+		// lines with a '!' only apply while booting
+		lineType := fields[0]
+		if strings.Contains(lineType, "!") {
+			continue
+		}
+		lineType = strings.Trim(lineType, "-=~^")
+		target := fields[1]
+		if strings.Contains(target, "%") {
+			log.V(4).Info("skipping line as specifiers aren't supported", "line", line)
+			continue
+		}
+		field := func(i int) string {
+			if i < len(fields) && fields[i] != "-" {
+				return fields[i]
+			}
+			return ""
+		}
+		argument := ""
+		if len(fields) > 6 {
+			// the argument is the rest of the line
+			argument = strings.Join(fields[6:], " ")
+		}
+
+		switch lineType {
+		case "d", "D", "v", "q", "Q":
+			if err := rootfs.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("creating %s: %w", target, err)
+			}
+		case "f", "f+":
+			_, err := rootfs.Lstat(target)
+			if err == nil && lineType == "f" {
+				continue
+			}
+			if err := rootfs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("creating parent directory: %w", err)
+			}
+			if err := rootfs.WriteFile(target, []byte(argument), 0644); err != nil {
+				return fmt.Errorf("writing %s: %w", target, err)
+			}
+		case "L", "L+":
+			if argument == "" {
+				log.V(4).Info("skipping link without a target", "line", line)
+				continue
+			}
+			if _, err := rootfs.Lstat(target); err == nil {
+				if lineType == "L" {
+					continue
+				}
+				if err := rootfs.RemoveAll(target); err != nil {
+					return fmt.Errorf("removing %s: %w", target, err)
+				}
+			}
+			if err := rootfs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("creating parent directory: %w", err)
+			}
+			if err := rootfs.Symlink(argument, target); err != nil {
+				return fmt.Errorf("creating link %s: %w", target, err)
+			}
+			// links don't have a mode or owner
+			continue
+		case "z", "Z":
+			if _, err := rootfs.Lstat(target); err != nil {
+				continue
+			}
+		default:
+			log.V(4).Info("skipping unsupported line type", "line", line)
+			continue
+		}
+		if err := t.setAttributes(ctx, rootfs, target, field(2), field(3), field(4)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setAttributes sets the mode and owner of a file,
+// when they're given by a tmpfiles.d line.
+func (t *Tmpfiles) setAttributes(ctx context.Context, rootfs memfs.FullFS, path, mode, user, group string) error {
+	if mode = strings.TrimLeft(mode, "~:"); mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("parsing mode of %s: %w", path, err)
+		}
+		info, err := rootfs.Lstat(path)
+		if err != nil {
+			return err
+		}
+		perm := fs.FileMode(m) & fs.ModePerm
+		if m&01000 != 0 {
+			perm |= fs.ModeSticky
+		}
+		if err := rootfs.Chmod(path, info.Mode().Type()|perm); err != nil {
+			return fmt.Errorf("setting mode of %s: %w", path, err)
+		}
+	}
+	user, group = strings.TrimPrefix(user, ":"), strings.TrimPrefix(group, ":")
+	if user == "" && group == "" {
+		return nil
+	}
+	uid, okUser, err := t.lookup(ctx, rootfs, user, false)
+	if err != nil {
+		return err
+	}
+	gid, okGroup, err := t.lookup(ctx, rootfs, group, true)
+	if err != nil {
+		return err
+	}
+	if !okUser || !okGroup {
+		logr.FromContextOrDiscard(ctx).Info("unable to set owner as the user or group doesn't exist", "path", path, "user", user, "group", group)
+		return nil
+	}
+	if err := rootfs.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("setting owner of %s: %w", path, err)
+	}
+	return nil
+}
+
+// lookup returns the id of a user or group, which
+// may be given as a name or a number.
+func (t *Tmpfiles) lookup(ctx context.Context, rootfs memfs.FullFS, name string, group bool) (int, bool, error) {
+	if name == "" || name == "root" {
+		return 0, true, nil
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, true, nil
+	}
+	if t.db == nil {
+		db, err := users.Read(ctx, rootfs, t.base)
+		if err != nil {
+			return 0, false, err
+		}
+		t.db = db
+	}
+	if group {
+		g, ok := t.db.Group(name)
+		return g.Gid, ok, nil
+	}
+	u, ok := t.db.User(name)
+	return u.Uid, ok, nil
+}
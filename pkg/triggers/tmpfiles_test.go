@@ -0,0 +1,83 @@
+package triggers
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+
+	memfs "chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTmpfiles(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	newRootfs := func(t *testing.T) memfs.FullFS {
+		rootfs := memfs.NewMemFS()
+		require.NoError(t, rootfs.MkdirAll("/usr/lib/tmpfiles.d", 0755))
+		require.NoError(t, rootfs.MkdirAll("/etc/tmpfiles.d", 0755))
+		require.NoError(t, rootfs.WriteFile("/usr/lib/tmpfiles.d/foo.conf", []byte(`# comment
+d /var/lib/foo 0750 - - -
+D! /run/foo - - - -
+f /etc/foo.conf 0600 - - - hello world
+L /var/log/foo - - - - /var/lib/foo
+x /tmp/foo - - - -
+d /home/%u - - - -
+`), 0644))
+		require.NoError(t, rootfs.WriteFile("/usr/lib/tmpfiles.d/bar.conf", []byte("d /var/lib/bar\n"), 0644))
+		// configuration in /etc overrides the
+		// package's own configuration
+		require.NoError(t, rootfs.WriteFile("/etc/tmpfiles.d/bar.conf", []byte("d /var/lib/baz\n"), 0644))
+		return rootfs
+	}
+
+	t.Run("named file", func(t *testing.T) {
+		rootfs := newRootfs(t)
+		tf := NewTmpfiles(nil)
+		require.NoError(t, tf.Handle(ctx, "systemd-tmpfiles", []string{"--create", "foo.conf"}))
+		require.NoError(t, tf.Apply(ctx, rootfs))
+
+		info, err := rootfs.Stat("/var/lib/foo")
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+		assert.EqualValues(t, fs.FileMode(0750), info.Mode().Perm())
+
+		data, err := rootfs.ReadFile("/etc/foo.conf")
+		require.NoError(t, err)
+		assert.EqualValues(t, "hello world", string(data))
+
+		target, err := rootfs.Readlink("/var/log/foo")
+		require.NoError(t, err)
+		assert.EqualValues(t, "/var/lib/foo", target)
+
+		for _, path := range []string{"/run/foo", "/var/lib/baz"} {
+			_, err = rootfs.Stat(path)
+			assert.Error(t, err, path)
+		}
+	})
+
+	t.Run("all files", func(t *testing.T) {
+		rootfs := newRootfs(t)
+		tf := NewTmpfiles(nil)
+		require.NoError(t, tf.Handle(ctx, "systemd-tmpfiles", []string{"--create"}))
+		require.NoError(t, tf.Apply(ctx, rootfs))
+
+		_, err := rootfs.Stat("/var/lib/baz")
+		assert.NoError(t, err)
+		_, err = rootfs.Stat("/var/lib/bar")
+		assert.Error(t, err)
+	})
+
+	t.Run("not created", func(t *testing.T) {
+		rootfs := newRootfs(t)
+		tf := NewTmpfiles(nil)
+		require.NoError(t, tf.Handle(ctx, "systemd-tmpfiles", []string{"--remove", "foo.conf"}))
+		require.NoError(t, tf.Apply(ctx, rootfs))
+
+		_, err := rootfs.Stat("/var/lib/foo")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,226 @@
+package triggers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Script is an install script from a package, which
+// the package manager would normally run.
+type Script struct {
+	// Package is the name of the package
+	// that contains the script
+	Package string
+	// Name is the type of script (e.g. "postinst")
+	Name string
+	// Interpreter runs the script. Shell
+	// scripts don't need to set it.
+	Interpreter string
+	Content     string
+}
+
+// Source collects the install scripts of the
+// packages that it unpacks (e.g. a PackageKeeper).
+type Source interface {
+	Scripts() []Script
+}
+
+// Handler emulates the effects of the
+// commands that install scripts run.
+type Handler interface {
+	// Name identifies the handler so
+	// that it can be disabled.
+	Name() string
+	// Commands returns the names of the
+	// commands that the handler emulates.
+	Commands() []string
+	// Handle records a call to one of the commands.
+	Handle(ctx context.Context, name string, args []string) error
+	// Apply writes the effects of the recorded commands
+	// to the root filesystem, once every script has been read.
+	Apply(ctx context.Context, rootfs fs.FullFS) error
+}
+
+// Handlers returns every handler, in the order that they should
+// be applied. Reserved ids are never given to new users or groups.
+func Handlers(base ociv1.Image, reserved ...int) []Handler {
+	return []Handler{
+		NewUsers(base, reserved...),
+		NewAlternatives(),
+		NewLdconfig(),
+		NewTmpfiles(base),
+	}
+}
+
+// shells are the interpreters of scripts
+// that can be read by the emulator.
+var shells = []string{"sh", "bash", "ash", "dash"}
+
+// noops are commands that have no lasting effect on the image
+// (e.g. tests), or that don't apply to a container (e.g. starting
+// services). Commands that return true are skipped.
+var noops = map[string]func(cmd command) bool{
+	":":        always,
+	"true":     always,
+	"false":    always,
+	"set":      always,
+	"exit":     always,
+	"return":   always,
+	"local":    always,
+	"export":   always,
+	"unset":    always,
+	"shift":    always,
+	"readonly": always,
+	"read":     always,
+	"break":    always,
+	"continue": always,
+	"trap":     always,
+	"umask":    always,
+	"test":     always,
+	"[":        always,
+	"[[":       always,
+	"type":     always,
+	"command":  always,
+	"which":    always,
+	// read-only commands, unless
+	// their output is saved
+	"echo":       notRedirected,
+	"printf":     notRedirected,
+	"cat":        notRedirected,
+	"grep":       notRedirected,
+	"getent":     notRedirected,
+	"id":         notRedirected,
+	"uname":      notRedirected,
+	"dpkg":       notRedirected,
+	"dpkg-query": notRedirected,
+	"rpm":        notRedirected,
+	// services can't be managed during a build,
+	// and containers don't run an init system
+	"systemctl":               always,
+	"service":                 always,
+	"deb-systemd-helper":      always,
+	"deb-systemd-invoke":      always,
+	"invoke-rc.d":             always,
+	"update-rc.d":             always,
+	"rc-update":               always,
+	"dpkg-maintscript-helper": always,
+	"dpkg-trigger":            always,
+	// the applets are installed by InstallBusybox
+	"busybox": func(cmd command) bool {
+		return len(cmd.args) > 0 && cmd.args[0] == "--install"
+	},
+}
+
+func always(command) bool {
+	return true
+}
+
+func notRedirected(cmd command) bool {
+	return !cmd.redirected
+}
+
+// Unhandled is a script that contains commands
+// that couldn't be emulated.
+type Unhandled struct {
+	Script Script
+	// Commands are the names of the commands
+	// that couldn't be emulated
+	Commands []string
+}
+
+// Report describes the scripts that were read.
+type Report struct {
+	// Handled are the scripts whose
+	// commands were all emulated
+	Handled   []Script
+	Unhandled []Unhandled
+}
+
+// Emulator reproduces the effects of install scripts without
+// running them, by passing each command to a handler. Commands
+// that may not run during an install (e.g. inside an 'if') are
+// never emulated.
+type Emulator struct {
+	handlers []Handler
+	commands map[string]Handler
+}
+
+// NewEmulator creates an Emulator that uses the given handlers.
+func NewEmulator(handlers ...Handler) *Emulator {
+	e := &Emulator{
+		handlers: handlers,
+		commands: map[string]Handler{},
+	}
+	for _, h := range handlers {
+		for _, name := range h.Commands() {
+			e.commands[name] = h
+		}
+	}
+	return e
+}
+
+// Run reads the scripts and applies the effects of the commands that
+// they contain. Scripts are read in package order, so that the results
+// (e.g. the ids given to users) don't depend on the order in which
+// packages were unpacked.
+func (e *Emulator) Run(ctx context.Context, scripts []Script, rootfs fs.FullFS) (Report, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	scripts = slices.Clone(scripts)
+	slices.SortStableFunc(scripts, func(a, b Script) int {
+		return strings.Compare(a.Package, b.Package)
+	})
+
+	var report Report
+	for _, script := range scripts {
+		log := log.WithValues("pkg", script.Package, "script", script.Name)
+		if script.Interpreter != "" && !slices.Contains(shells, commandName(script.Interpreter)) {
+			log.V(4).Info("skipping script as it isn't a shell script", "interpreter", script.Interpreter)
+			report.Unhandled = append(report.Unhandled, Unhandled{Script: script, Commands: []string{script.Interpreter}})
+			continue
+		}
+		var unhandled []string
+		for _, cmd := range parseScript(script.Content) {
+			name := commandName(cmd.name)
+			if noop, ok := noops[name]; ok && noop(cmd) {
+				continue
+			}
+			// we can't tell whether the command would have
+			// run, so it's left for the user to check
+			if cmd.conditional {
+				log.V(4).Info("skipping command as it may not run during an install", "cmd", name, "args", cmd.args)
+				unhandled = append(unhandled, name)
+				continue
+			}
+			if h, ok := e.commands[name]; ok {
+				log.V(5).Info("emulating command", "handler", h.Name(), "cmd", name, "args", cmd.args)
+				if err := h.Handle(ctx, name, cmd.args); err != nil {
+					log.V(2).Info("unable to emulate command", "cmd", name, "args", cmd.args, "err", err.Error())
+					unhandled = append(unhandled, name)
+				}
+				continue
+			}
+			unhandled = append(unhandled, name)
+		}
+		if len(unhandled) == 0 {
+			report.Handled = append(report.Handled, script)
+			continue
+		}
+		slices.Sort(unhandled)
+		report.Unhandled = append(report.Unhandled, Unhandled{Script: script, Commands: slices.Compact(unhandled)})
+	}
+
+	for _, h := range e.handlers {
+		log.V(3).Info("applying handler", "handler", h.Name())
+		if err := h.Apply(ctx, rootfs); err != nil {
+			return report, fmt.Errorf("applying %s: %w", h.Name(), err)
+		}
+	}
+	return report, nil
+}
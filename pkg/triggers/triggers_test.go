@@ -0,0 +1,86 @@
+package triggers
+
+import (
+	"context"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmulator_Run(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	scripts := []Script{
+		{Package: "vim", Name: "postinst", Content: "#!/bin/sh\nset -e\nupdate-alternatives --install /usr/bin/vi vi /usr/bin/vim.basic 30\n"},
+		{Package: "nano", Name: "postinst", Content: "#!/bin/sh\nupdate-alternatives --install /usr/bin/vi vi /bin/nano 10\nchown nobody /var/lib/nano\n"},
+		{Package: "nginx", Name: "pre-install", Content: "#!/bin/sh\naddgroup -S nginx 2>/dev/null\nadduser -S -D -H -h /var/lib/nginx -s /sbin/nologin -G nginx -g nginx nginx 2>/dev/null\nexit 0\n"},
+		{Package: "lua", Name: "post", Interpreter: "<lua>", Content: "print('hello')"},
+	}
+
+	t.Run("all handlers", func(t *testing.T) {
+		rootfs := fs.NewMemFS()
+		report, err := NewEmulator(Handlers(nil)...).Run(ctx, scripts, rootfs)
+		require.NoError(t, err)
+
+		assert.ElementsMatch(t, []Script{scripts[0], scripts[2]}, report.Handled)
+		assert.EqualValues(t, []Unhandled{
+			{Script: scripts[3], Commands: []string{"<lua>"}},
+			{Script: scripts[1], Commands: []string{"chown"}},
+		}, report.Unhandled)
+
+		// the effects of commands are applied even
+		// if the script wasn't completely handled
+		target, err := rootfs.Readlink("/etc/alternatives/vi")
+		require.NoError(t, err)
+		assert.EqualValues(t, "/usr/bin/vim.basic", target)
+
+		passwd, err := rootfs.ReadFile("/etc/passwd")
+		require.NoError(t, err)
+		assert.EqualValues(t, "nginx:x:100:100:nginx:/var/lib/nginx:/sbin/nologin\n", string(passwd))
+	})
+
+	t.Run("conditional commands", func(t *testing.T) {
+		rootfs := fs.NewMemFS()
+		script := Script{Package: "foo", Name: "postinst", Content: "#!/bin/sh\ncase \"$1\" in\n\tabort-upgrade)\n\t\tupdate-alternatives --install /usr/bin/vi vi /bin/foo 50\n\t\t;;\nesac\n"}
+		report, err := NewEmulator(Handlers(nil)...).Run(ctx, []Script{scripts[0], script}, rootfs)
+		require.NoError(t, err)
+
+		assert.EqualValues(t, []Unhandled{{Script: script, Commands: []string{"update-alternatives"}}}, report.Unhandled)
+
+		target, err := rootfs.Readlink("/etc/alternatives/vi")
+		require.NoError(t, err)
+		assert.EqualValues(t, "/usr/bin/vim.basic", target)
+	})
+
+	t.Run("disabled handler", func(t *testing.T) {
+		rootfs := fs.NewMemFS()
+		report, err := NewEmulator(NewAlternatives()).Run(ctx, scripts[2:3], rootfs)
+		require.NoError(t, err)
+
+		assert.Empty(t, report.Handled)
+		assert.EqualValues(t, []Unhandled{{Script: scripts[2], Commands: []string{"addgroup", "adduser"}}}, report.Unhandled)
+
+		_, err = rootfs.Stat("/etc/passwd")
+		assert.Error(t, err)
+	})
+	t.Run("no handlers", func(t *testing.T) {
+		// scripts are still reported when
+		// nothing is being emulated
+		rootfs := fs.NewMemFS()
+		report, err := NewEmulator().Run(ctx, scripts[:2], rootfs)
+		require.NoError(t, err)
+
+		assert.Empty(t, report.Handled)
+		assert.EqualValues(t, []Unhandled{
+			{Script: scripts[1], Commands: []string{"chown", "update-alternatives"}},
+			{Script: scripts[0], Commands: []string{"update-alternatives"}},
+		}, report.Unhandled)
+
+		_, err = rootfs.Stat("/etc/alternatives/vi")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,502 @@
+package triggers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/djcass44/all-your-base/pkg/users"
+	"github.com/go-logr/logr"
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// noGroup is the group that Debian gives to
+// system users that don't ask for a group.
+var noGroup = users.Group{Name: "nogroup", Gid: 65534}
+
+// Users emulates the commands that create users and groups
+// (adduser, addgroup, useradd, groupadd and usermod). The
+// syntax of both the busybox and Debian versions of adduser
+// is understood.
+type Users struct {
+	base     ociv1.Image
+	reserved []int
+	ops      []func(ctx context.Context, db *users.Database) error
+}
+
+func NewUsers(base ociv1.Image, reserved ...int) *Users {
+	return &Users{base: base, reserved: reserved}
+}
+
+func (*Users) Name() string {
+	return "users"
+}
+
+func (*Users) Commands() []string {
+	return []string{"adduser", "addgroup", "useradd", "groupadd", "usermod", "systemd-sysusers"}
+}
+
+func (u *Users) Handle(_ context.Context, name string, args []string) error {
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "$`") {
+			return fmt.Errorf("variables aren't supported: %s", arg)
+		}
+	}
+	var op func(ctx context.Context, db *users.Database) error
+	var err error
+	switch name {
+	case "adduser":
+		op, err = parseAdduser(args)
+	case "addgroup", "groupadd":
+		op, err = parseAddgroup(args)
+	case "useradd":
+		op, err = parseUseradd(args)
+	case "usermod":
+		op, err = parseUsermod(args)
+	case "systemd-sysusers":
+		// sysusers.d is applied before the install scripts
+		// are emulated, so only check that nothing else
+		// (e.g. an inline configuration) was given
+		return checkSysusers(args)
+	default:
+		err = fmt.Errorf("unsupported command: %s", name)
+	}
+	if err != nil {
+		return err
+	}
+	u.ops = append(u.ops, op)
+	return nil
+}
+
+func (u *Users) Apply(ctx context.Context, rootfs fs.FullFS) error {
+	if len(u.ops) == 0 {
+		return nil
+	}
+	db, err := users.Read(ctx, rootfs, u.base)
+	if err != nil {
+		return err
+	}
+	db.Reserve(u.reserved...)
+	for _, op := range u.ops {
+		if err := op(ctx, db); err != nil {
+			return err
+		}
+	}
+	return db.Write(ctx, rootfs)
+}
+
+// checkSysusers returns an error unless systemd-sysusers is
+// only given configuration files, which are always applied.
+func checkSysusers(args []string) error {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unsupported option: %s", arg)
+		}
+		if filepath.IsAbs(arg) && !strings.Contains(arg, "/sysusers.d/") {
+			return fmt.Errorf("configuration outside of sysusers.d isn't supported: %s", arg)
+		}
+	}
+	return nil
+}
+
+// options describes the options that a command accepts, mapping
+// each spelling (e.g. "-h", "--home") to a canonical name.
+type options struct {
+	values map[string]string
+	flags  map[string]string
+}
+
+// parse returns the value of each option that was given (flags
+// have an empty value), along with the remaining arguments.
+func (o options) parse(args []string) (map[string]string, []string, error) {
+	out := map[string]string{}
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return out, append(positional, args[i+1:]...), nil
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := strings.Cut(arg, "=")
+			if key, ok := o.flags[name]; ok && !hasValue {
+				out[key] = ""
+				continue
+			}
+			key, ok := o.values[name]
+			if !ok {
+				return nil, nil, fmt.Errorf("unsupported option: %s", name)
+			}
+			if !hasValue {
+				if i+1 >= len(args) {
+					return nil, nil, fmt.Errorf("option requires a value: %s", name)
+				}
+				i++
+				value = args[i]
+			}
+			out[key] = value
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			// short options can be combined (e.g. "-SDH"), and
+			// the last can take a value (e.g. "-Gwheel")
+			for j := 1; j < len(arg); j++ {
+				name := "-" + arg[j:j+1]
+				if key, ok := o.flags[name]; ok {
+					out[key] = ""
+					continue
+				}
+				key, ok := o.values[name]
+				if !ok {
+					return nil, nil, fmt.Errorf("unsupported option: %s", name)
+				}
+				value := arg[j+1:]
+				if value == "" {
+					if i+1 >= len(args) {
+						return nil, nil, fmt.Errorf("option requires a value: %s", name)
+					}
+					i++
+					value = args[i]
+				}
+				out[key] = value
+				break
+			}
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	return out, positional, nil
+}
+
+// id parses an optional numeric id, returning
+// -1 so that an id is chosen automatically.
+func id(opts map[string]string, key string) (int, error) {
+	v, ok := opts[key]
+	if !ok {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", key, err)
+	}
+	return n, nil
+}
+
+// common options that don't change the users
+// or groups that are created
+var quietFlags = map[string]string{
+	"-q":                "quiet",
+	"--quiet":           "quiet",
+	"--force-badname":   "quiet",
+	"--allow-badname":   "quiet",
+	"--allow-bad-names": "quiet",
+	"-f":                "quiet",
+	"--force":           "quiet",
+}
+
+func withQuiet(flags map[string]string) map[string]string {
+	for k, v := range quietFlags {
+		if _, ok := flags[k]; !ok {
+			flags[k] = v
+		}
+	}
+	return flags
+}
+
+var adduserOptions = options{
+	values: map[string]string{
+		"-h":           "home",
+		"--home":       "home",
+		"-g":           "gecos",
+		"--gecos":      "gecos",
+		"--comment":    "gecos",
+		"-s":           "shell",
+		"--shell":      "shell",
+		"-G":           "group",
+		"--ingroup":    "group",
+		"-u":           "uid",
+		"--uid":        "uid",
+		"--gid":        "gid",
+		"-k":           "skel",
+		"--firstuid":   "firstuid",
+		"--lastuid":    "lastuid",
+		"--extrausers": "extrausers",
+	},
+	flags: withQuiet(map[string]string{
+		"-S":                  "system",
+		"--system":            "system",
+		"-D":                  "nopassword",
+		"--disabled-password": "nopassword",
+		"--disabled-login":    "nopassword",
+		"-H":                  "nohome",
+		"--no-create-home":    "nohome",
+		"--group":             "usergroup",
+	}),
+}
+
+// parseAdduser parses 'adduser [options] USER [GROUP]'. When
+// a group is given, an existing user is added to the group.
+func parseAdduser(args []string) (func(context.Context, *users.Database) error, error) {
+	opts, positional, err := adduserOptions.parse(args)
+	if err != nil {
+		return nil, err
+	}
+	switch len(positional) {
+	case 1:
+	case 2:
+		delete(opts, "quiet")
+		if len(opts) > 0 {
+			return nil, errors.New("options can't be used when adding a user to a group")
+		}
+		return addMember(positional[1], positional[0]), nil
+	default:
+		return nil, errors.New("expected a user name")
+	}
+	uid, err := id(opts, "uid")
+	if err != nil {
+		return nil, err
+	}
+	gid, err := id(opts, "gid")
+	if err != nil {
+		return nil, err
+	}
+	user := users.User{
+		Name:  positional[0],
+		Uid:   uid,
+		Gid:   -1,
+		Gecos: opts["gecos"],
+		Home:  opts["home"],
+		Shell: opts["shell"],
+	}
+	group, hasGroup := opts["group"]
+	_, system := opts["system"]
+	_, userGroup := opts["usergroup"]
+	// the Debian version of adduser gives system users the 'nogroup'
+	// group, whereas busybox creates a group for each user
+	debianSystem := system && !userGroup && !hasGroup && slices.ContainsFunc(args, func(s string) bool {
+		return strings.HasPrefix(s, "--")
+	})
+
+	return func(ctx context.Context, db *users.Database) error {
+		switch {
+		case hasGroup:
+			g, err := db.AddGroup(users.Group{Name: group, Gid: -1})
+			if err != nil {
+				return err
+			}
+			user.Gid = g.Gid
+		case gid >= 0:
+			g, err := db.AddGroup(users.Group{Name: user.Name, Gid: gid})
+			if err != nil {
+				return err
+			}
+			user.Gid = g.Gid
+		case debianSystem:
+			g, err := db.AddGroup(noGroup)
+			if err != nil {
+				return err
+			}
+			user.Gid = g.Gid
+		}
+		u, err := db.AddUser(user)
+		if err != nil {
+			return err
+		}
+		logr.FromContextOrDiscard(ctx).V(4).Info("added user", "name", u.Name, "uid", u.Uid, "gid", u.Gid)
+		return nil
+	}, nil
+}
+
+var addgroupOptions = options{
+	values: map[string]string{
+		"-g":    "gid",
+		"--gid": "gid",
+		"-K":    "key",
+		"--key": "key",
+	},
+	flags: withQuiet(map[string]string{
+		"-S":           "system",
+		"--system":     "system",
+		"-r":           "system",
+		"-o":           "nonunique",
+		"--non-unique": "nonunique",
+	}),
+}
+
+// parseAddgroup parses 'addgroup [options] [USER] GROUP'
+// and 'groupadd [options] GROUP'.
+func parseAddgroup(args []string) (func(context.Context, *users.Database) error, error) {
+	opts, positional, err := addgroupOptions.parse(args)
+	if err != nil {
+		return nil, err
+	}
+	switch len(positional) {
+	case 1:
+	case 2:
+		return addMember(positional[1], positional[0]), nil
+	default:
+		return nil, errors.New("expected a group name")
+	}
+	gid, err := id(opts, "gid")
+	if err != nil {
+		return nil, err
+	}
+	group := users.Group{Name: positional[0], Gid: gid}
+	return func(ctx context.Context, db *users.Database) error {
+		g, err := db.AddGroup(group)
+		if err != nil {
+			return err
+		}
+		logr.FromContextOrDiscard(ctx).V(4).Info("added group", "name", g.Name, "gid", g.Gid)
+		return nil
+	}, nil
+}
+
+var useraddOptions = options{
+	values: map[string]string{
+		"-u":         "uid",
+		"--uid":      "uid",
+		"-g":         "group",
+		"--gid":      "group",
+		"-G":         "groups",
+		"--groups":   "groups",
+		"-d":         "home",
+		"--home-dir": "home",
+		"--home":     "home",
+		"-s":         "shell",
+		"--shell":    "shell",
+		"-c":         "gecos",
+		"--comment":  "gecos",
+		"-k":         "skel",
+		"--skel":     "skel",
+		"-K":         "key",
+		"--key":      "key",
+		"-e":         "expire",
+		"-f":         "inactive",
+		"-p":         "password",
+	},
+	flags: map[string]string{
+		"-r":               "system",
+		"--system":         "system",
+		"-M":               "nohome",
+		"--no-create-home": "nohome",
+		"-m":               "createhome",
+		"--create-home":    "createhome",
+		"-N":               "nousergroup",
+		"--no-user-group":  "nousergroup",
+		"-U":               "usergroup",
+		"--user-group":     "usergroup",
+		"-l":               "nolog",
+		"--no-log-init":    "nolog",
+		"-o":               "nonunique",
+		"--non-unique":     "nonunique",
+	},
+}
+
+// parseUseradd parses 'useradd [options] USER'.
+func parseUseradd(args []string) (func(context.Context, *users.Database) error, error) {
+	opts, positional, err := useraddOptions.parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) != 1 {
+		return nil, errors.New("expected a user name")
+	}
+	uid, err := id(opts, "uid")
+	if err != nil {
+		return nil, err
+	}
+	user := users.User{
+		Name:  positional[0],
+		Uid:   uid,
+		Gid:   -1,
+		Gecos: opts["gecos"],
+		Home:  opts["home"],
+		Shell: opts["shell"],
+	}
+	group, hasGroup := opts["group"]
+	var groups []string
+	if v := opts["groups"]; v != "" {
+		groups = strings.Split(v, ",")
+	}
+	if _, ok := opts["nousergroup"]; ok && !hasGroup {
+		group, hasGroup = "users", true
+	}
+
+	return func(ctx context.Context, db *users.Database) error {
+		if hasGroup {
+			if gid, err := strconv.Atoi(group); err == nil {
+				user.Gid = gid
+			} else {
+				g, err := db.AddGroup(users.Group{Name: group, Gid: -1})
+				if err != nil {
+					return err
+				}
+				user.Gid = g.Gid
+			}
+		}
+		u, err := db.AddUser(user)
+		if err != nil {
+			return err
+		}
+		for _, g := range groups {
+			if err := addMember(g, u.Name)(ctx, db); err != nil {
+				return err
+			}
+		}
+		logr.FromContextOrDiscard(ctx).V(4).Info("added user", "name", u.Name, "uid", u.Uid, "gid", u.Gid)
+		return nil
+	}, nil
+}
+
+var usermodOptions = options{
+	values: map[string]string{
+		"-G":       "groups",
+		"--groups": "groups",
+	},
+	flags: map[string]string{
+		"-a":       "append",
+		"--append": "append",
+	},
+}
+
+// parseUsermod parses 'usermod -a -G GROUPS USER', which is the
+// only use of usermod that can be emulated without knowing the
+// existing state of the user.
+func parseUsermod(args []string) (func(context.Context, *users.Database) error, error) {
+	opts, positional, err := usermodOptions.parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) != 1 {
+		return nil, errors.New("expected a user name")
+	}
+	if _, ok := opts["append"]; !ok {
+		return nil, errors.New("only appending to groups is supported")
+	}
+	groups := strings.Split(opts["groups"], ",")
+	return func(ctx context.Context, db *users.Database) error {
+		for _, g := range groups {
+			if err := addMember(g, positional[0])(ctx, db); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// addMember adds a user to a group, creating the group if needed.
+func addMember(group, user string) func(context.Context, *users.Database) error {
+	return func(ctx context.Context, db *users.Database) error {
+		if group == "" {
+			return nil
+		}
+		if _, err := db.AddGroup(users.Group{Name: group, Gid: -1}); err != nil {
+			return err
+		}
+		logr.FromContextOrDiscard(ctx).V(4).Info("adding user to group", "user", user, "group", group)
+		return db.AddMember(group, user)
+	}
+}
@@ -0,0 +1,98 @@
+package triggers
+
+import (
+	"context"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsers(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	var cases = []struct {
+		name     string
+		commands [][]string
+		passwd   string
+		group    string
+	}{
+		{
+			"busybox",
+			[][]string{
+				{"addgroup", "-S", "-g", "82", "www-data"},
+				{"adduser", "-S", "-D", "-H", "-h", "/var/lib/nginx", "-s", "/sbin/nologin", "-G", "www-data", "-g", "nginx", "nginx"},
+				{"addgroup", "nginx", "www-data"},
+			},
+			"nginx:x:100:82:nginx:/var/lib/nginx:/sbin/nologin\n",
+			"www-data:x:82:nginx\n",
+		},
+		{
+			"debian",
+			[][]string{
+				{"adduser", "--system", "--quiet", "--home", "/var/lib/postgresql", "--no-create-home", "--shell", "/bin/bash", "--group", "--gecos", "PostgreSQL administrator", "postgres"},
+				{"adduser", "--system", "--home=/nonexistent", "--no-create-home", "messagebus"},
+			},
+			"postgres:x:100:100:PostgreSQL administrator:/var/lib/postgresql:/bin/bash\nmessagebus:x:101:65534::/nonexistent:/usr/sbin/nologin\n",
+			"postgres:x:100:\nnogroup:x:65534:\n",
+		},
+		{
+			"shadow",
+			[][]string{
+				{"groupadd", "-r", "dbus"},
+				{"useradd", "-r", "-g", "dbus", "-G", "audio,video", "-d", "/", "-s", "/sbin/nologin", "-c", "System message bus", "dbus"},
+				{"useradd", "-rMU", "-u", "500", "app"},
+				{"usermod", "-aG", "video", "app"},
+			},
+			"dbus:x:100:100:System message bus:/:/sbin/nologin\napp:x:500:500::/nonexistent:/usr/sbin/nologin\n",
+			"dbus:x:100:\naudio:x:101:dbus\nvideo:x:102:dbus,app\napp:x:500:\n",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			rootfs := fs.NewMemFS()
+			u := NewUsers(nil)
+			for _, args := range tt.commands {
+				require.NoError(t, u.Handle(ctx, args[0], args[1:]))
+			}
+			require.NoError(t, u.Apply(ctx, rootfs))
+
+			passwd, err := rootfs.ReadFile("/etc/passwd")
+			require.NoError(t, err)
+			assert.EqualValues(t, tt.passwd, string(passwd))
+
+			group, err := rootfs.ReadFile("/etc/group")
+			require.NoError(t, err)
+			assert.EqualValues(t, tt.group, string(group))
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		u := NewUsers(nil)
+		assert.Error(t, u.Handle(ctx, "adduser", []string{"--system", "$USER"}))
+		assert.Error(t, u.Handle(ctx, "useradd", []string{"--bogus", "foo"}))
+		assert.Error(t, u.Handle(ctx, "usermod", []string{"-s", "/bin/sh", "foo"}))
+		assert.Error(t, u.Handle(ctx, "systemd-sysusers", []string{"--replace=/usr/lib/sysusers.d/foo.conf", "-"}))
+		assert.Error(t, u.Handle(ctx, "systemd-sysusers", []string{"/tmp/foo.conf"}))
+	})
+
+	t.Run("sysusers", func(t *testing.T) {
+		u := NewUsers(nil)
+		assert.NoError(t, u.Handle(ctx, "systemd-sysusers", nil))
+		assert.NoError(t, u.Handle(ctx, "systemd-sysusers", []string{"foo.conf", "/usr/lib/sysusers.d/bar.conf"}))
+	})
+
+	t.Run("reserved ids", func(t *testing.T) {
+		rootfs := fs.NewMemFS()
+		u := NewUsers(nil, 100)
+		require.NoError(t, u.Handle(ctx, "adduser", []string{"-S", "-D", "-H", "nginx"}))
+		require.NoError(t, u.Apply(ctx, rootfs))
+
+		passwd, err := rootfs.ReadFile("/etc/passwd")
+		require.NoError(t, err)
+		assert.EqualValues(t, "nginx:x:101:101::/nonexistent:/usr/sbin/nologin\n", string(passwd))
+	})
+}
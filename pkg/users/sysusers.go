@@ -0,0 +1,242 @@
+package users
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+)
+
+// sysusersDirs contain sysusers.d configuration files. A
+// file in an earlier directory overrides a file with the
+// same name in a later directory.
+var sysusersDirs = []string{
+	"/etc/sysusers.d",
+	"/run/sysusers.d",
+	"/usr/local/lib/sysusers.d",
+	"/usr/lib/sysusers.d",
+}
+
+// sysusersHome is the home directory that systemd-sysusers
+// gives to users that don't ask for one.
+const sysusersHome = "/"
+
+// SysusersEntry is a line of a sysusers.d configuration file.
+type SysusersEntry struct {
+	// Type is one of "u" (user), "g" (group)
+	// or "m" (add a user to a group)
+	Type string
+	Name string
+	// ID is the requested id. For users, it may also
+	// give the primary group (e.g. "100:100", "100:adm").
+	// For "m" lines, it's the name of the group.
+	ID    string
+	Gecos string
+	Home  string
+	Shell string
+}
+
+// ReadSysusers reads the sysusers.d configuration files in the root
+// filesystem. Files are read in the order of their names, in the same
+// way as systemd-sysusers.
+func ReadSysusers(ctx context.Context, rootfs fs.FullFS) ([]SysusersEntry, error) {
+	found := map[string]string{}
+	for _, dir := range sysusersDirs {
+		entries, err := rootfs.ReadDir(dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("reading %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			if _, ok := found[entry.Name()]; ok || entry.IsDir() || !strings.HasSuffix(entry.Name(), ".conf") {
+				continue
+			}
+			found[entry.Name()] = filepath.Join(dir, entry.Name())
+		}
+	}
+	var out []SysusersEntry
+	for _, name := range slices.Sorted(maps.Keys(found)) {
+		data, err := rootfs.ReadFile(found[name])
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", found[name], err)
+		}
+		out = append(out, parseSysusers(ctx, found[name], data)...)
+	}
+	return out, nil
+}
+
+func parseSysusers(ctx context.Context, path string, data []byte) []SysusersEntry {
+	log := logr.FromContextOrDiscard(ctx).WithValues("config", path)
+
+	var out []SysusersEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitQuoted(line)
+		if len(fields) < 2 {
+			log.V(4).Info("skipping invalid line", "line", line)
+			continue
+		}
+		if strings.Contains(line, "%") {
+			log.V(4).Info("skipping line as specifiers aren't supported", "line", line)
+			continue
+		}
+		field := func(i int) string {
+			if i < len(fields) && fields[i] != "-" {
+				return fields[i]
+			}
+			return ""
+		}
+		entry := SysusersEntry{
+			// users are always locked, so 'u!' is the same as 'u'
+			Type:  strings.TrimSuffix(fields[0], "!"),
+			Name:  fields[1],
+			ID:    field(2),
+			Gecos: field(3),
+			Home:  field(4),
+			Shell: field(5),
+		}
+		switch entry.Type {
+		case "u", "g", "m":
+			out = append(out, entry)
+		default:
+			log.V(4).Info("skipping unsupported line type", "line", line)
+		}
+	}
+	return out
+}
+
+// splitQuoted splits a line into fields, allowing
+// fields to contain spaces when they're quoted.
+func splitQuoted(line string) []string {
+	var out []string
+	var sb strings.Builder
+	var quote rune
+	inField := false
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			sb.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t':
+			if inField {
+				out = append(out, sb.String())
+				sb.Reset()
+				inField = false
+			}
+		default:
+			sb.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		out = append(out, sb.String())
+	}
+	return out
+}
+
+// AddSysusers adds the users and groups described by sysusers.d
+// entries. Entries that already exist are left untouched, and ids
+// that are already in use are replaced by a free system id.
+func (d *Database) AddSysusers(ctx context.Context, entries []SysusersEntry) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	for _, e := range entries {
+		log := log.WithValues("type", e.Type, "name", e.Name)
+		switch e.Type {
+		case "g":
+			if _, err := d.AddGroup(Group{Name: e.Name, Gid: d.requestedID(ctx, d.group, e.ID)}); err != nil {
+				return err
+			}
+		case "u":
+			uid, group, _ := strings.Cut(e.ID, ":")
+			u := User{
+				Name:  e.Name,
+				Uid:   d.requestedID(ctx, d.passwd, uid),
+				Gid:   -1,
+				Gecos: e.Gecos,
+				Home:  e.Home,
+				Shell: e.Shell,
+			}
+			if u.Home == "" {
+				u.Home = sysusersHome
+			}
+			if group != "" {
+				gid, err := d.primaryGroup(ctx, e.Name, group)
+				if err != nil {
+					return err
+				}
+				u.Gid = gid
+			}
+			if _, err := d.AddUser(u); err != nil {
+				return err
+			}
+		case "m":
+			// the user and group are created if
+			// they don't already exist
+			if _, err := d.AddGroup(Group{Name: e.ID, Gid: -1}); err != nil {
+				return err
+			}
+			if _, err := d.AddUser(User{Name: e.Name, Uid: -1, Gid: -1, Home: sysusersHome}); err != nil {
+				return err
+			}
+			if err := d.AddMember(e.ID, e.Name); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported sysusers type: %s", e.Type)
+		}
+		log.V(5).Info("added sysusers entry")
+	}
+	return nil
+}
+
+// requestedID returns the id given by a sysusers.d entry, or -1 if
+// a free id should be used instead. Ids given as a path (which take
+// the owner of the file) are never used.
+func (d *Database) requestedID(ctx context.Context, entries [][]string, id string) int {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return -1
+	}
+	if hasID(entries, n) {
+		logr.FromContextOrDiscard(ctx).V(4).Info("requested id is already in use", "id", n)
+		return -1
+	}
+	return n
+}
+
+// primaryGroup returns the id of the primary group given by a
+// 'u' entry, which may be a group name or id. Numeric ids that
+// don't belong to a group create one with the same name as the user.
+func (d *Database) primaryGroup(ctx context.Context, user, group string) (int, error) {
+	gid, err := strconv.Atoi(group)
+	if err != nil {
+		g, err := d.AddGroup(Group{Name: group, Gid: -1})
+		return g.Gid, err
+	}
+	if hasID(d.group, gid) {
+		return gid, nil
+	}
+	g, err := d.AddGroup(Group{Name: user, Gid: d.requestedID(ctx, d.group, group)})
+	return g.Gid, err
+}
@@ -0,0 +1,75 @@
+package users
+
+import (
+	"context"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSysusers(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	rootfs := fs.NewMemFS()
+	require.NoError(t, rootfs.MkdirAll("/usr/lib/sysusers.d", 0755))
+	require.NoError(t, rootfs.MkdirAll("/etc/sysusers.d", 0755))
+	require.NoError(t, rootfs.WriteFile("/usr/lib/sysusers.d/nginx.conf", []byte(`# nginx
+u nginx - "nginx web server" /var/lib/nginx
+m nginx www-data
+`), 0644))
+	require.NoError(t, rootfs.WriteFile("/usr/lib/sysusers.d/dbus.conf", []byte("u! messagebus 81:81 - - -\nr - 500-900\ng %n -\n"), 0644))
+	// files in /etc override the packaged configuration
+	require.NoError(t, rootfs.WriteFile("/usr/lib/sysusers.d/postgresql.conf", []byte("u postgres 26\n"), 0644))
+	require.NoError(t, rootfs.WriteFile("/etc/sysusers.d/postgresql.conf", []byte("u postgres 70 - /var/lib/pgsql /bin/bash\n"), 0644))
+
+	entries, err := ReadSysusers(ctx, rootfs)
+	require.NoError(t, err)
+	assert.EqualValues(t, []SysusersEntry{
+		{Type: "u", Name: "messagebus", ID: "81:81"},
+		{Type: "u", Name: "nginx", Gecos: "nginx web server", Home: "/var/lib/nginx"},
+		{Type: "m", Name: "nginx", ID: "www-data"},
+		{Type: "u", Name: "postgres", ID: "70", Home: "/var/lib/pgsql", Shell: "/bin/bash"},
+	}, entries)
+}
+
+func TestDatabase_AddSysusers(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	rootfs := fs.NewMemFS()
+	require.NoError(t, rootfs.MkdirAll("/etc", 0755))
+	require.NoError(t, rootfs.WriteFile(PasswdFile, []byte("root:x:0:0:root:/root:/bin/sh\n"), 0644))
+	require.NoError(t, rootfs.WriteFile(GroupFile, []byte("root:x:0:root\nadm:x:4:\n"), 0644))
+
+	db, err := Read(ctx, rootfs, nil)
+	require.NoError(t, err)
+	// e.g. the uid of the non-root user
+	db.Reserve(100)
+
+	require.NoError(t, db.AddSysusers(ctx, []SysusersEntry{
+		{Type: "g", Name: "www-data", ID: "33"},
+		{Type: "u", Name: "messagebus", ID: "81:81"},
+		{Type: "u", Name: "syslog", ID: "-:adm"},
+		// the id is already in use
+		{Type: "u", Name: "nginx", ID: "0", Gecos: "nginx web server", Home: "/var/lib/nginx"},
+		{Type: "m", Name: "nginx", ID: "www-data"},
+		{Type: "m", Name: "nginx", ID: "video"},
+	}))
+	require.NoError(t, db.Write(ctx, rootfs))
+
+	for path, expected := range map[string]string{
+		PasswdFile: "root:x:0:0:root:/root:/bin/sh\nmessagebus:x:81:81::/:/usr/sbin/nologin\nsyslog:x:101:4::/:/usr/sbin/nologin\nnginx:x:102:102:nginx web server:/var/lib/nginx:/usr/sbin/nologin\n",
+		GroupFile:  "root:x:0:root\nadm:x:4:\nwww-data:x:33:nginx\nmessagebus:x:81:\nnginx:x:102:\nvideo:x:101:nginx\n",
+	} {
+		data, err := rootfs.ReadFile(path)
+		require.NoError(t, err)
+		assert.EqualValues(t, expected, string(data), path)
+	}
+}
+
+func TestSplitQuoted(t *testing.T) {
+	assert.EqualValues(t, []string{"u", "nginx", "-", "nginx web server", ""}, splitQuoted(`u  nginx	- "nginx web server" ''`))
+}
@@ -0,0 +1,268 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/djcass44/all-your-base/pkg/packages"
+	"github.com/go-logr/logr"
+	ociv1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const (
+	PasswdFile = "/etc/passwd"
+	GroupFile  = "/etc/group"
+	ShadowFile = "/etc/shadow"
+)
+
+// the range of ids that are given to system
+// users and groups that don't ask for one
+const (
+	minSystemID = 100
+	maxSystemID = 999
+)
+
+const (
+	defaultHome  = "/nonexistent"
+	defaultShell = "/usr/sbin/nologin"
+)
+
+// User is an entry in /etc/passwd.
+type User struct {
+	Name  string
+	Uid   int
+	Gid   int
+	Gecos string
+	Home  string
+	Shell string
+}
+
+// Group is an entry in /etc/group.
+type Group struct {
+	Name    string
+	Gid     int
+	Members []string
+}
+
+// Database contains the users and groups of an image. Entries
+// are kept in the order that they were read, and new entries
+// are appended so that existing files change as little as possible.
+type Database struct {
+	passwd [][]string
+	group  [][]string
+	shadow [][]string
+	// reserved ids are never given to users or groups
+	// that don't ask for one (e.g. the id of a user that
+	// will be created later)
+	reserved []int
+}
+
+// Read reads the users and groups from the root filesystem, copying
+// the files from the base image first if needed.
+func Read(ctx context.Context, rootfs fs.FullFS, base ociv1.Image) (*Database, error) {
+	db := &Database{}
+	for path, dst := range map[string]*[][]string{PasswdFile: &db.passwd, GroupFile: &db.group, ShadowFile: &db.shadow} {
+		if err := packages.Extract(ctx, base, path, rootfs); err != nil {
+			return nil, err
+		}
+		data, err := rootfs.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		*dst = parseEntries(data)
+	}
+	return db, nil
+}
+
+func parseEntries(data []byte) [][]string {
+	var out [][]string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		out = append(out, strings.Split(line, ":"))
+	}
+	return out
+}
+
+// Write saves the users and groups to the root filesystem.
+func (d *Database) Write(ctx context.Context, rootfs fs.FullFS) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	if err := rootfs.MkdirAll("/etc", 0755); err != nil {
+		return fmt.Errorf("creating /etc: %w", err)
+	}
+	for _, f := range []struct {
+		path    string
+		entries [][]string
+		mode    os.FileMode
+	}{
+		{PasswdFile, d.passwd, 0644},
+		{GroupFile, d.group, 0644},
+		{ShadowFile, d.shadow, 0640},
+	} {
+		if len(f.entries) == 0 {
+			continue
+		}
+		sb := strings.Builder{}
+		for _, entry := range f.entries {
+			sb.WriteString(strings.Join(entry, ":") + "\n")
+		}
+		log.V(5).Info("writing file", "path", f.path, "entries", len(f.entries))
+		if err := rootfs.WriteFile(f.path, []byte(sb.String()), f.mode); err != nil {
+			return fmt.Errorf("writing %s: %w", f.path, err)
+		}
+	}
+	return nil
+}
+
+func find(entries [][]string, name string) int {
+	return slices.IndexFunc(entries, func(e []string) bool {
+		return e[0] == name
+	})
+}
+
+// hasID returns true if an entry uses the given id.
+func hasID(entries [][]string, id int) bool {
+	return slices.ContainsFunc(entries, func(e []string) bool {
+		return len(e) > 2 && e[2] == strconv.Itoa(id)
+	})
+}
+
+// User returns the user with the given name.
+func (d *Database) User(name string) (User, bool) {
+	i := find(d.passwd, name)
+	if i < 0 || len(d.passwd[i]) < 7 {
+		return User{}, false
+	}
+	e := d.passwd[i]
+	uid, _ := strconv.Atoi(e[2])
+	gid, _ := strconv.Atoi(e[3])
+	return User{Name: e[0], Uid: uid, Gid: gid, Gecos: e[4], Home: e[5], Shell: e[6]}, true
+}
+
+// Group returns the group with the given name.
+func (d *Database) Group(name string) (Group, bool) {
+	i := find(d.group, name)
+	if i < 0 || len(d.group[i]) < 4 {
+		return Group{}, false
+	}
+	e := d.group[i]
+	gid, _ := strconv.Atoi(e[2])
+	var members []string
+	if e[3] != "" {
+		members = strings.Split(e[3], ",")
+	}
+	return Group{Name: e[0], Gid: gid, Members: members}, true
+}
+
+// Reserve prevents the given ids from being
+// given to users or groups that don't ask for one.
+func (d *Database) Reserve(ids ...int) {
+	d.reserved = append(d.reserved, ids...)
+}
+
+// freeID returns the lowest system id that isn't
+// reserved or used by any of the given files.
+func (d *Database) freeID(files ...[][]string) (int, error) {
+	for id := minSystemID; id <= maxSystemID; id++ {
+		if !slices.Contains(d.reserved, id) && !slices.ContainsFunc(files, func(entries [][]string) bool {
+			return hasID(entries, id)
+		}) {
+			return id, nil
+		}
+	}
+	return 0, errors.New("no system ids are available")
+}
+
+// AddGroup adds a group. If the Gid is negative, the lowest free
+// system id is used. Groups that already exist are left untouched
+// and returned as they are.
+func (d *Database) AddGroup(g Group) (Group, error) {
+	if existing, ok := d.Group(g.Name); ok {
+		return existing, nil
+	}
+	if g.Gid < 0 {
+		gid, err := d.freeID(d.group)
+		if err != nil {
+			return Group{}, fmt.Errorf("adding group '%s': %w", g.Name, err)
+		}
+		g.Gid = gid
+	}
+	d.group = append(d.group, []string{g.Name, "x", strconv.Itoa(g.Gid), strings.Join(g.Members, ",")})
+	return g, nil
+}
+
+// AddUser adds a locked user. If the Uid is negative, the lowest
+// free system id is used. If the Gid is negative, a group with the
+// same name as the user is created (preferring the same id as the
+// user). Users that already exist are left untouched and returned
+// as they are.
+func (d *Database) AddUser(u User) (User, error) {
+	if existing, ok := d.User(u.Name); ok {
+		return existing, nil
+	}
+	if u.Uid < 0 {
+		// try to find an id that can be
+		// shared with the user's group
+		files := [][][]string{d.passwd}
+		if u.Gid < 0 {
+			if _, ok := d.Group(u.Name); !ok {
+				files = append(files, d.group)
+			}
+		}
+		uid, err := d.freeID(files...)
+		if err != nil {
+			return User{}, fmt.Errorf("adding user '%s': %w", u.Name, err)
+		}
+		u.Uid = uid
+	}
+	if u.Gid < 0 {
+		gid := -1
+		if !hasID(d.group, u.Uid) && !slices.Contains(d.reserved, u.Uid) {
+			gid = u.Uid
+		}
+		g, err := d.AddGroup(Group{Name: u.Name, Gid: gid})
+		if err != nil {
+			return User{}, err
+		}
+		u.Gid = g.Gid
+	}
+	if u.Home == "" {
+		u.Home = defaultHome
+	}
+	if u.Shell == "" {
+		u.Shell = defaultShell
+	}
+	d.passwd = append(d.passwd, []string{u.Name, "x", strconv.Itoa(u.Uid), strconv.Itoa(u.Gid), u.Gecos, u.Home, u.Shell})
+	if find(d.shadow, u.Name) < 0 {
+		d.shadow = append(d.shadow, []string{u.Name, "!", "", "0", "99999", "7", "", "", ""})
+	}
+	return u, nil
+}
+
+// AddMember adds a user to the members of a group.
+func (d *Database) AddMember(group, user string) error {
+	i := find(d.group, group)
+	if i < 0 || len(d.group[i]) < 4 {
+		return fmt.Errorf("group '%s' doesn't exist", group)
+	}
+	var members []string
+	if d.group[i][3] != "" {
+		members = strings.Split(d.group[i][3], ",")
+	}
+	if slices.Contains(members, user) {
+		return nil
+	}
+	d.group[i][3] = strings.Join(append(members, user), ",")
+	return nil
+}
@@ -0,0 +1,53 @@
+package users
+
+import (
+	"context"
+	"testing"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	rootfs := fs.NewMemFS()
+	require.NoError(t, rootfs.MkdirAll("/etc", 0755))
+	require.NoError(t, rootfs.WriteFile(PasswdFile, []byte("root:x:0:0:root:/root:/bin/sh\nnobody:x:100:101:nobody:/:/sbin/nologin\n"), 0644))
+	require.NoError(t, rootfs.WriteFile(GroupFile, []byte("root:x:0:root\nnogroup:x:101:\n"), 0644))
+
+	db, err := Read(ctx, rootfs, nil)
+	require.NoError(t, err)
+
+	// existing entries are left alone
+	u, err := db.AddUser(User{Name: "nobody", Uid: 65534, Gid: 65534})
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, u.Uid)
+
+	// ids are shared with the user's group when possible
+	u, err = db.AddUser(User{Name: "nginx", Uid: -1, Gid: -1, Home: "/var/lib/nginx"})
+	require.NoError(t, err)
+	assert.EqualValues(t, User{Name: "nginx", Uid: 102, Gid: 102, Home: "/var/lib/nginx", Shell: defaultShell}, u)
+
+	g, err := db.AddGroup(Group{Name: "www-data", Gid: -1})
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, g.Gid)
+	require.NoError(t, db.AddMember("www-data", "nginx"))
+	require.NoError(t, db.AddMember("www-data", "nginx"))
+	assert.Error(t, db.AddMember("missing", "nginx"))
+
+	require.NoError(t, db.Write(ctx, rootfs))
+
+	for path, expected := range map[string]string{
+		PasswdFile: "root:x:0:0:root:/root:/bin/sh\nnobody:x:100:101:nobody:/:/sbin/nologin\nnginx:x:102:102::/var/lib/nginx:/usr/sbin/nologin\n",
+		GroupFile:  "root:x:0:root\nnogroup:x:101:\nnginx:x:102:\nwww-data:x:100:nginx\n",
+		ShadowFile: "nginx:!::0:99999:7:::\n",
+	} {
+		data, err := rootfs.ReadFile(path)
+		require.NoError(t, err)
+		assert.EqualValues(t, expected, string(data), path)
+	}
+}
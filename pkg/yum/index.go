@@ -11,11 +11,15 @@ import (
 	"github.com/carlmjohnson/requests"
 	"github.com/djcass44/all-your-base/pkg/requestutil"
 	"github.com/djcass44/all-your-base/pkg/yum/yumindex"
+	"github.com/djcass44/all-your-base/pkg/yum/yummodules"
 	"github.com/djcass44/all-your-base/pkg/yum/yumrepo"
 	"github.com/go-logr/logr"
 )
 
-func NewIndex(ctx context.Context, repository string) (*yumindex.Metadata, error) {
+// NewIndex downloads the primary package list of a repository. If the
+// repository contains modular packages, packages from module streams
+// that haven't been enabled are removed.
+func NewIndex(ctx context.Context, repository string, modules map[string]string) (*yumindex.Metadata, error) {
 	log := logr.FromContextOrDiscard(ctx).WithValues("repo", repository)
 	log.V(1).Info("downloading index")
 
@@ -33,7 +37,7 @@ func NewIndex(ctx context.Context, repository string) (*yumindex.Metadata, error
 	var buf bytes.Buffer
 	log.V(4).Info("downloading primary index", "src", primaryURL)
 
-	if err := requests.URL(primaryURL).Handle(requestutil.WithGzip(&buf)).Fetch(ctx); err != nil {
+	if err := requests.URL(primaryURL).Handle(requestutil.WithDecompression(&buf, primaryURL)).Fetch(ctx); err != nil {
 		return nil, fmt.Errorf("downloading primary index: %w", err)
 	}
 	var index yumindex.Metadata
@@ -41,6 +45,24 @@ func NewIndex(ctx context.Context, repository string) (*yumindex.Metadata, error
 		return nil, fmt.Errorf("decoding xml index: %w", err)
 	}
 	index.Source = repository
+
+	// filter out modular packages
+	if modulesURL := repoData.ModulesYAML(); modulesURL != "" {
+		if !strings.HasPrefix(modulesURL, "http") {
+			modulesURL = fmt.Sprintf("%s/%s", repository, modulesURL)
+		}
+		log.V(4).Info("downloading modules", "src", modulesURL)
+		buf.Reset()
+		if err := requests.URL(modulesURL).Handle(requestutil.WithDecompression(&buf, modulesURL)).Fetch(ctx); err != nil {
+			return nil, fmt.Errorf("downloading modules: %w", err)
+		}
+		repoModules, err := yummodules.Parse(ctx, &buf)
+		if err != nil {
+			return nil, err
+		}
+		index.Package = repoModules.Filter(ctx, index.Package, modules)
+	}
+
 	return &index, nil
 }
 
@@ -51,7 +73,7 @@ func getMetadata(ctx context.Context, repository string) (*yumrepo.RepoData, err
 	target := fmt.Sprintf("%s/repodata/repomd.xml", repository)
 
 	var buf bytes.Buffer
-	if err := requests.URL(target).Handle(requestutil.WithGzip(&buf)).Fetch(ctx); err != nil {
+	if err := requests.URL(target).Handle(requestutil.WithDecompression(&buf, target)).Fetch(ctx); err != nil {
 		log.Info("failed to download repomd.xml", "url", target)
 		return nil, err
 	}
@@ -20,7 +20,7 @@ func TestGetMetadata(t *testing.T) {
 func TestNewIndex(t *testing.T) {
 	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
 
-	index, err := NewIndex(ctx, "https://cdn-ubi.redhat.com/content/public/ubi/dist/ubi8/8/x86_64/baseos/os")
+	index, err := NewIndex(ctx, "https://cdn-ubi.redhat.com/content/public/ubi/dist/ubi8/8/x86_64/baseos/os", nil)
 	assert.NoError(t, err)
 	require.NotNil(t, index)
 	assert.NotZero(t, index.Packages)
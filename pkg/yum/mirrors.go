@@ -0,0 +1,135 @@
+package yum
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/carlmjohnson/requests"
+	"github.com/djcass44/all-your-base/pkg/requestutil"
+	"github.com/djcass44/all-your-base/pkg/yum/yumrepo"
+	"github.com/go-logr/logr"
+)
+
+const repomdSuffix = "/repodata/repomd.xml"
+
+// ResolveBaseURL figures out the baseurl of a repository from a
+// .repo file. The baseurl is preferred if it's set, otherwise
+// the mirrorlist or metalink is used to find a working mirror.
+//
+// When using a metalink, the repomd.xml served by the mirror
+// must match one of the hashes in the metalink.
+func ResolveBaseURL(ctx context.Context, repo yumrepo.Repository) (string, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("repo", repo.ID)
+
+	var candidates []string
+	var verifier *yumrepo.MetalinkFile
+	switch {
+	case len(repo.BaseURL) > 0:
+		candidates = repo.BaseURL
+	case repo.Metalink != "":
+		file, err := getMetalink(ctx, repo.Metalink)
+		if err != nil {
+			return "", err
+		}
+		candidates = trimRepomd(file.URLs())
+		verifier = file
+	case repo.MirrorList != "":
+		mirrors, file, err := getMirrorList(ctx, repo.MirrorList)
+		if err != nil {
+			return "", err
+		}
+		candidates = mirrors
+		verifier = file
+	default:
+		return "", fmt.Errorf("repository '%s' has no baseurl, mirrorlist or metalink", repo.ID)
+	}
+	log.V(4).Info("resolving baseurl", "candidates", len(candidates))
+
+	var errs []error
+	for _, candidate := range candidates {
+		candidate = strings.TrimSuffix(candidate, "/")
+		if strings.Contains(candidate, "$") {
+			errs = append(errs, fmt.Errorf("unresolved variable in url: %s", candidate))
+			continue
+		}
+		var buf bytes.Buffer
+		target := candidate + repomdSuffix
+		if err := requests.URL(target).Handle(requestutil.WithDecompression(&buf, target)).Fetch(ctx); err != nil {
+			log.V(4).Info("failed to fetch repomd.xml from mirror", "url", target, "err", err.Error())
+			errs = append(errs, err)
+			continue
+		}
+		if verifier != nil && !verifier.Verify(buf.Bytes()) {
+			log.Info("skipping mirror as repomd.xml does not match the metalink", "url", target)
+			errs = append(errs, fmt.Errorf("repomd.xml does not match metalink: %s", target))
+			continue
+		}
+		log.V(2).Info("resolved baseurl", "url", candidate)
+		return candidate, nil
+	}
+	return "", fmt.Errorf("resolving repository '%s': %w", repo.ID, errors.Join(errs...))
+}
+
+func getMetalink(ctx context.Context, target string) (*yumrepo.MetalinkFile, error) {
+	var buf bytes.Buffer
+	if err := requests.URL(target).ToBytesBuffer(&buf).Fetch(ctx); err != nil {
+		return nil, fmt.Errorf("downloading metalink: %w", err)
+	}
+	return parseMetalink(buf.Bytes())
+}
+
+func parseMetalink(data []byte) (*yumrepo.MetalinkFile, error) {
+	var metalink yumrepo.Metalink
+	if err := xml.Unmarshal(data, &metalink); err != nil {
+		return nil, fmt.Errorf("decoding metalink: %w", err)
+	}
+	file, ok := metalink.File("repomd.xml")
+	if !ok {
+		return nil, errors.New("metalink does not contain repomd.xml")
+	}
+	return file, nil
+}
+
+// getMirrorList downloads a list of mirrors. Some servers return
+// a metalink document instead of a plain list, in which case
+// the metalink is returned so that it can be verified.
+func getMirrorList(ctx context.Context, target string) ([]string, *yumrepo.MetalinkFile, error) {
+	var buf bytes.Buffer
+	if err := requests.URL(target).ToBytesBuffer(&buf).Fetch(ctx); err != nil {
+		return nil, nil, fmt.Errorf("downloading mirrorlist: %w", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("<metalink")) {
+		file, err := parseMetalink(buf.Bytes())
+		if err != nil {
+			return nil, nil, err
+		}
+		return trimRepomd(file.URLs()), file, nil
+	}
+	return parseMirrorList(buf.Bytes()), nil, nil
+}
+
+func parseMirrorList(data []byte) []string {
+	var mirrors []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		mirrors = append(mirrors, line)
+	}
+	return mirrors
+}
+
+func trimRepomd(urls []string) []string {
+	out := make([]string, len(urls))
+	for i := range urls {
+		out[i] = strings.TrimSuffix(urls[i], repomdSuffix)
+	}
+	return out
+}
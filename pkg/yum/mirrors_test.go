@@ -0,0 +1,68 @@
+package yum
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/djcass44/all-your-base/pkg/yum/yumrepo"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveBaseURL(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	repomd := []byte(`<repomd><revision>1</revision></repomd>`)
+	sum := sha256.Sum256(repomd)
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/good/repodata/repomd.xml", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(repomd)
+	})
+	mux.HandleFunc("/stale/repodata/repomd.xml", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`<repomd><revision>0</revision></repomd>`))
+	})
+	mux.HandleFunc("/mirrorlist", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprintf(w, "# mirrors\n%s/missing/\n%s/good/\n", srv.URL, srv.URL)
+	})
+	mux.HandleFunc("/metalink", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprintf(w, `<metalink><files><file name="repomd.xml">
+<verification><hash type="sha256">%s</hash></verification>
+<resources>
+<url protocol="http" preference="100">%s/stale/repodata/repomd.xml</url>
+<url protocol="http" preference="90">%s/good/repodata/repomd.xml</url>
+</resources>
+</file></files></metalink>`, hex.EncodeToString(sum[:]), srv.URL, srv.URL)
+	})
+
+	var cases = []struct {
+		name string
+		repo yumrepo.Repository
+	}{
+		{"baseurl", yumrepo.Repository{ID: "baseurl", BaseURL: []string{srv.URL + "/good"}}},
+		{"mirrorlist", yumrepo.Repository{ID: "mirrorlist", MirrorList: srv.URL + "/mirrorlist"}},
+		{"metalink", yumrepo.Repository{ID: "metalink", Metalink: srv.URL + "/metalink"}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			baseURL, err := ResolveBaseURL(ctx, tt.repo)
+			require.NoError(t, err)
+			assert.EqualValues(t, srv.URL+"/good", baseURL)
+		})
+	}
+
+	t.Run("unresolved variable", func(t *testing.T) {
+		_, err := ResolveBaseURL(ctx, yumrepo.Repository{ID: "vars", BaseURL: []string{srv.URL + "/$releasever"}})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,80 @@
+package yumindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-logr/logr"
+)
+
+// Obsoletes checks whether the package obsoletes the
+// other package. Obsoletes only match package names.
+func (p *Package) Obsoletes(other *Package) bool {
+	if p.Name == other.Name {
+		return false
+	}
+	for _, e := range p.Format.Obsoletes.Entry {
+		if e.Name == other.Name && e.MatchesEVR(other.EVR()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConflictsWith checks whether the package conflicts with the other
+// package and returns the entry that caused the conflict.
+func (p *Package) ConflictsWith(other *Package) (Entry, bool) {
+	if p.Name == other.Name {
+		return Entry{}, false
+	}
+	for _, e := range p.Format.Conflicts.Entry {
+		if e.ProvidedBy(other) {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Obsolete removes any package that has been obsoleted by
+// another package in the list. This mirrors what dnf does
+// when an obsoleting package is installed.
+func Obsolete(ctx context.Context, packages []Package) []Package {
+	log := logr.FromContextOrDiscard(ctx)
+
+	var out []Package
+	for i := range packages {
+		var obsoleted bool
+		for j := range packages {
+			if i == j {
+				continue
+			}
+			if packages[j].Obsoletes(&packages[i]) {
+				log.V(3).Info("dropping obsoleted package", "pkg", packages[i].NEVRA(), "obsoletedBy", packages[j].NEVRA())
+				obsoleted = true
+				break
+			}
+		}
+		if !obsoleted {
+			out = append(out, packages[i])
+		}
+	}
+	return out
+}
+
+// CheckConflicts returns an error describing every pair of
+// packages in the list that cannot be installed together.
+func CheckConflicts(packages []Package) error {
+	var errs []error
+	for i := range packages {
+		for j := range packages {
+			if i == j {
+				continue
+			}
+			if e, ok := packages[i].ConflictsWith(&packages[j]); ok {
+				errs = append(errs, fmt.Errorf("package '%s' conflicts with '%s' (conflicts: %s)", packages[i].NEVRA(), packages[j].NEVRA(), e.String()))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,63 @@
+package yumindex
+
+import (
+	"context"
+	"encoding/xml"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckConflicts(t *testing.T) {
+	var metadata Metadata
+	require.NoError(t, xml.Unmarshal([]byte(primaryDB), &metadata))
+
+	coreutils, ok := metadata.GetPackage("coreutils")
+	require.True(t, ok)
+	coreutilsSingle, ok := metadata.GetPackage("coreutils-single")
+	require.True(t, ok)
+
+	t.Run("conflicting packages", func(t *testing.T) {
+		err := CheckConflicts([]Package{coreutils, coreutilsSingle})
+		assert.ErrorContains(t, err, "package 'coreutils-8.30-15.el8.x86_64' conflicts with 'coreutils-single-8.30-15.el8.x86_64' (conflicts: coreutils-single)")
+	})
+	t.Run("compatible packages", func(t *testing.T) {
+		err := CheckConflicts([]Package{coreutils})
+		assert.NoError(t, err)
+	})
+}
+
+func TestObsolete(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	old := Package{Name: "foo"}
+	old.Version.Ver = "1.0"
+	replacement := Package{Name: "bar"}
+	replacement.Version.Ver = "2.0"
+	replacement.Format.Obsoletes.Entry = EntryList{{Name: "foo", Flags: FlagLT, Ver: "2.0"}}
+
+	out := Obsolete(ctx, []Package{old, replacement})
+	require.Len(t, out, 1)
+	assert.EqualValues(t, "bar", out[0].Name)
+}
+
+func TestSelectCandidate(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	var metadata Metadata
+	require.NoError(t, xml.Unmarshal([]byte(primaryDB), &metadata))
+
+	// both coreutils and coreutils-single provide 'coreutils',
+	// but they conflict, so only one should be selected
+	matches := metadata.GetPackageAndDependencies(ctx, "coreutils", nil, false)
+	var names []string
+	for _, m := range matches {
+		names = append(names, m.Name)
+	}
+	assert.Contains(t, names, "coreutils")
+	assert.NotContains(t, names, "coreutils-single")
+	assert.NoError(t, CheckConflicts(matches))
+}
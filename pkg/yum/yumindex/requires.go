@@ -2,20 +2,31 @@ package yumindex
 
 import (
 	"context"
+	"slices"
+	"strings"
 
 	"github.com/go-logr/logr"
 	"golang.org/x/exp/maps"
 )
 
-func (m *Metadata) GetProviders(ctx context.Context, requires []string, existingPackages map[string]bool) []Package {
+// multilibArches are architectures that are usually only
+// present for compatability, so we avoid them when there's
+// an alternative.
+var multilibArches = []string{"i386", "i486", "i586", "i686"}
+
+func (m *Metadata) GetProviders(ctx context.Context, requires []string, existingPackages map[string]bool, weak bool) []Package {
 	log := logr.FromContextOrDiscard(ctx)
-	log.V(3).Info("checking for packages", "requires", requires)
+	log.V(3).Info("checking for packages", "requires", requires, "weak", weak)
+
+	if existingPackages == nil {
+		existingPackages = map[string]bool{}
+	}
 
 	// collect a list of unique package matches
 	matches := map[string]Package{}
 
 	for _, r := range requires {
-		packages := m.GetPackageAndDependencies(ctx, r, existingPackages)
+		packages := m.GetPackageAndDependencies(ctx, r, existingPackages, weak)
 		for _, p := range packages {
 			matches[p.Name] = p
 		}
@@ -24,7 +35,7 @@ func (m *Metadata) GetProviders(ctx context.Context, requires []string, existing
 	return maps.Values(matches)
 }
 
-func (m *Metadata) GetPackageAndDependencies(ctx context.Context, pkg string, existingPackages map[string]bool) []Package {
+func (m *Metadata) GetPackageAndDependencies(ctx context.Context, pkg string, existingPackages map[string]bool, weak bool) []Package {
 	log := logr.FromContextOrDiscard(ctx)
 	log.V(3).Info("fetching package and dependencies", "pkg", pkg)
 
@@ -36,9 +47,59 @@ func (m *Metadata) GetPackageAndDependencies(ctx context.Context, pkg string, ex
 		return nil
 	}
 
+	candidates := m.getCandidates(pkg)
+	if len(candidates) == 0 {
+		return nil
+	}
+	// if we've already selected something that satisfies
+	// the requirement, then there's nothing to do
+	for _, c := range candidates {
+		if existingPackages[c.Name] {
+			existingPackages[pkg] = true
+			return nil
+		}
+	}
+	p := selectCandidate(pkg, candidates)
+	log.V(4).Info("found matching package", "entry", pkg, "pkg", p.NEVRA(), "candidates", len(candidates))
+
 	// collect a list of unique package matches
-	matches := map[string]Package{}
+	matches := map[string]Package{
+		p.Name: p,
+	}
+	existingPackages[pkg] = true
+	existingPackages[p.Name] = true
+
+	// collect dependencies
+	for _, r := range p.Dependencies(weak) {
+		packages := m.GetPackageAndDependencies(ctx, r, existingPackages, weak)
+		for _, p := range packages {
+			matches[p.Name] = p
+			existingPackages[p.Name] = true
+		}
+	}
+
+	return maps.Values(matches)
+}
 
+// GetPackage returns the best package with the given name. If
+// there are multiple versions, the newest is returned.
+func (m *Metadata) GetPackage(name string) (Package, bool) {
+	var candidates []Package
+	for _, p := range m.Package {
+		if p.Name == name {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return Package{}, false
+	}
+	return selectCandidate(name, candidates), true
+}
+
+// getCandidates returns every package that provides
+// the given capability.
+func (m *Metadata) getCandidates(pkg string) []Package {
+	var candidates []Package
 	for _, p := range m.Package {
 		for _, e := range p.Format.Provides.Entry {
 			// a bunch of them are empty, so just skip
@@ -47,21 +108,105 @@ func (m *Metadata) GetPackageAndDependencies(ctx context.Context, pkg string, ex
 				continue
 			}
 			if e.Name == pkg {
-				log.V(4).Info("found matching package", "entry", e.Name)
-				matches[pkg] = p
-				existingPackages[pkg] = true
-
-				// collect dependencies
-				for _, r := range p.Format.Requires.Entry.GetValues() {
-					packages := m.GetPackageAndDependencies(ctx, r, existingPackages)
-					for _, p := range packages {
-						matches[p.Name] = p
-						existingPackages[p.Name] = true
-					}
-				}
+				candidates = append(candidates, p)
+				break
+			}
+		}
+	}
+	return candidates
+}
+
+// selectCandidate picks the package that should be used to satisfy
+// a capability when there are multiple providers. Similar to dnf, we
+// prefer (in order):
+//
+//  1. packages that obsolete another candidate
+//  2. packages whose name matches the capability
+//  3. packages that aren't multilib
+//  4. newer packages
+func selectCandidate(pkg string, candidates []Package) Package {
+	obsoletes := func(p *Package) bool {
+		for i := range candidates {
+			if p.Obsoletes(&candidates[i]) {
+				return true
+			}
+		}
+		return false
+	}
+	slices.SortStableFunc(candidates, func(a, b Package) int {
+		if oa, ob := obsoletes(&a), obsoletes(&b); oa != ob {
+			if oa {
+				return -1
+			}
+			return 1
+		}
+		if na, nb := a.Name == pkg, b.Name == pkg; na != nb {
+			if na {
+				return -1
+			}
+			return 1
+		}
+		if ma, mb := slices.Contains(multilibArches, a.Arch), slices.Contains(multilibArches, b.Arch); ma != mb {
+			if mb {
+				return -1
+			}
+			return 1
+		}
+		if c := CompareEVR(b.Version.Epoch, b.Version.Ver, b.Version.Rel, a.Version.Epoch, a.Version.Ver, a.Version.Rel); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return candidates[0]
+}
+
+// GetSupplements returns the packages that declare that they
+// supplement one of the given packages, along with their
+// dependencies. This is the reverse of a Recommends relationship.
+func (m *Metadata) GetSupplements(ctx context.Context, installed []Package, existingPackages map[string]bool) []Package {
+	log := logr.FromContextOrDiscard(ctx)
+
+	// collect everything that the installed
+	// packages provide
+	provided := map[string]bool{}
+	for _, p := range installed {
+		provided[p.Name] = true
+		for _, e := range p.Format.Provides.Entry {
+			provided[e.Name] = true
+		}
+	}
+
+	// collect a list of unique package matches
+	matches := map[string]Package{}
+
+	for _, p := range m.Package {
+		for _, e := range p.Format.Supplements.Entry {
+			// rich dependencies (e.g. '(foo and bar)') are
+			// not supported, so we skip them
+			if e.Name == "" || strings.HasPrefix(e.Name, "(") {
+				continue
+			}
+			if !provided[e.Name] {
+				continue
+			}
+			log.V(4).Info("found supplementing package", "pkg", p.Name, "supplements", e.Name)
+			for _, dep := range m.GetPackageAndDependencies(ctx, p.Name, existingPackages, true) {
+				matches[dep.Name] = dep
 			}
+			break
 		}
 	}
 
 	return maps.Values(matches)
 }
+
+// Dependencies returns the names of the capabilities
+// that the package requires. If weak is set, the
+// capabilities that it recommends are included.
+func (p *Package) Dependencies(weak bool) []string {
+	deps := p.Format.Requires.Entry.GetValues()
+	if weak {
+		deps = append(deps, p.Format.Recommends.Entry.GetValues()...)
+	}
+	return deps
+}
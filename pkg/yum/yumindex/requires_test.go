@@ -21,9 +21,35 @@ func TestMetadata_GetProviders(t *testing.T) {
 	var metadata Metadata
 	require.NoError(t, xml.Unmarshal([]byte(primaryDB), &metadata))
 
-	matches := metadata.GetProviders(ctx, []string{"libacl.so.1()(64bit)", "libacl.so.1(ACL_1.0)(64bit)", "libc.so.6()(64bit)", "libc.so.6(GLIBC_2.11)(64bit)", "libc.so.6(GLIBC_2.14)(64bit)", "libc.so.6(GLIBC_2.15)(64bit)", "libc.so.6(GLIBC_2.2.5)(64bit)", "libc.so.6(GLIBC_2.28)(64bit)", "libc.so.6(GLIBC_2.3)(64bit)", "libc.so.6(GLIBC_2.3.4)(64bit)", "libc.so.6(GLIBC_2.4)(64bit)", "libselinux.so.1()(64bit)", "libtinfo.so.6()(64bit)", "rtld(GNU_HASH)"}, nil)
+	matches := metadata.GetProviders(ctx, []string{"libacl.so.1()(64bit)", "libacl.so.1(ACL_1.0)(64bit)", "libc.so.6()(64bit)", "libc.so.6(GLIBC_2.11)(64bit)", "libc.so.6(GLIBC_2.14)(64bit)", "libc.so.6(GLIBC_2.15)(64bit)", "libc.so.6(GLIBC_2.2.5)(64bit)", "libc.so.6(GLIBC_2.28)(64bit)", "libc.so.6(GLIBC_2.3)(64bit)", "libc.so.6(GLIBC_2.3.4)(64bit)", "libc.so.6(GLIBC_2.4)(64bit)", "libselinux.so.1()(64bit)", "libtinfo.so.6()(64bit)", "rtld(GNU_HASH)"}, nil, false)
 	for _, m := range matches {
 		t.Logf("match: %s=%s", m.Name, m.Version.Ver)
 	}
 	assert.NotEmpty(t, matches)
 }
+
+func TestMetadata_GetPackageAndDependencies(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	var metadata Metadata
+	require.NoError(t, xml.Unmarshal([]byte(primaryDB), &metadata))
+
+	names := func(packages []Package) []string {
+		out := make([]string, len(packages))
+		for i := range packages {
+			out[i] = packages[i].Name
+		}
+		return out
+	}
+
+	t.Run("weak dependencies are skipped", func(t *testing.T) {
+		matches := metadata.GetPackageAndDependencies(ctx, "crontabs", nil, false)
+		assert.Contains(t, names(matches), "crontabs")
+		assert.NotContains(t, names(matches), "cronie")
+	})
+	t.Run("weak dependencies are included", func(t *testing.T) {
+		matches := metadata.GetPackageAndDependencies(ctx, "crontabs", nil, true)
+		assert.Contains(t, names(matches), "crontabs")
+		assert.Contains(t, names(matches), "cronie")
+	})
+}
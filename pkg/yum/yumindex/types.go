@@ -2,6 +2,7 @@ package yumindex
 
 import (
 	"encoding/xml"
+	"fmt"
 )
 
 type Metadata struct {
@@ -92,11 +93,8 @@ type Package struct {
 			Entry EntryList `xml:"entry"`
 		} `xml:"recommends"`
 		Supplements struct {
-			Text  string `xml:",chardata"`
-			Entry struct {
-				Text string `xml:",chardata"`
-				Name string `xml:"name,attr"`
-			} `xml:"entry"`
+			Text  string    `xml:",chardata"`
+			Entry EntryList `xml:"entry"`
 		} `xml:"supplements"`
 	} `xml:"format"`
 }
@@ -119,3 +117,32 @@ func (e EntryList) GetValues() []string {
 	}
 	return v
 }
+
+// String returns a human-readable representation of the
+// entry (e.g. 'filesystem < 3').
+func (e Entry) String() string {
+	if !e.HasVersion() {
+		return e.Name
+	}
+	var op string
+	switch e.Flags {
+	case FlagEQ:
+		op = "="
+	case FlagLT:
+		op = "<"
+	case FlagLE:
+		op = "<="
+	case FlagGT:
+		op = ">"
+	case FlagGE:
+		op = ">="
+	}
+	evr := e.Ver
+	if e.Epoch != "" && e.Epoch != "0" {
+		evr = e.Epoch + ":" + evr
+	}
+	if e.Rel != "" {
+		evr += "-" + e.Rel
+	}
+	return fmt.Sprintf("%s %s %s", e.Name, op, evr)
+}
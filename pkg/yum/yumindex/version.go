@@ -0,0 +1,229 @@
+package yumindex
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Version flags used by dependency entries
+const (
+	FlagEQ = "EQ"
+	FlagLT = "LT"
+	FlagLE = "LE"
+	FlagGT = "GT"
+	FlagGE = "GE"
+)
+
+// EVR returns the epoch, version and release of the package.
+func (p *Package) EVR() (string, string, string) {
+	return p.Version.Epoch, p.Version.Ver, p.Version.Rel
+}
+
+// NEVRA returns the fully-qualified name of the package
+// (e.g. coreutils-8.30-15.el8.x86_64).
+func (p *Package) NEVRA() string {
+	s := p.Name + "-"
+	if p.Version.Epoch != "" && p.Version.Epoch != "0" {
+		s += p.Version.Epoch + ":"
+	}
+	s += p.Version.Ver
+	if p.Version.Rel != "" {
+		s += "-" + p.Version.Rel
+	}
+	if p.Arch != "" {
+		s += "." + p.Arch
+	}
+	return s
+}
+
+// HasVersion returns true if the entry constrains the
+// version of whatever it refers to.
+func (e Entry) HasVersion() bool {
+	return e.Flags != "" && e.Ver != ""
+}
+
+// MatchesEVR checks whether the given epoch, version and release
+// satisfy the version constraint of the entry. Entries without a
+// constraint match everything.
+func (e Entry) MatchesEVR(epoch, ver, rel string) bool {
+	if !e.HasVersion() {
+		return true
+	}
+	c := CompareEVR(epoch, ver, rel, e.Epoch, e.Ver, e.Rel)
+	switch e.Flags {
+	case FlagEQ:
+		return c == 0
+	case FlagLT:
+		return c < 0
+	case FlagLE:
+		return c <= 0
+	case FlagGT:
+		return c > 0
+	case FlagGE:
+		return c >= 0
+	default:
+		return true
+	}
+}
+
+// ProvidedBy checks whether the given package provides
+// something that satisfies the entry.
+func (e Entry) ProvidedBy(p *Package) bool {
+	// packages implicitly provide their own name
+	if e.Name == p.Name && e.MatchesEVR(p.EVR()) {
+		return true
+	}
+	for _, provide := range p.Format.Provides.Entry {
+		if provide.Name != e.Name {
+			continue
+		}
+		// unversioned provides satisfy anything, which
+		// is the same behaviour as rpm
+		if !provide.HasVersion() || e.MatchesEVR(provide.Epoch, provide.Ver, provide.Rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// CompareEVR compares two epoch-version-release tuples and returns
+// -1, 0 or 1 if the first is older, equal or newer than the second.
+// If either release is empty, releases are not compared.
+func CompareEVR(e1, v1, r1, e2, v2, r2 string) int {
+	if c := compareEpoch(e1, e2); c != 0 {
+		return c
+	}
+	if c := CompareVersion(v1, v2); c != 0 {
+		return c
+	}
+	if r1 == "" || r2 == "" {
+		return 0
+	}
+	return CompareVersion(r1, r2)
+}
+
+func compareEpoch(e1, e2 string) int {
+	i1, _ := strconv.Atoi(e1)
+	i2, _ := strconv.Atoi(e2)
+	switch {
+	case i1 < i2:
+		return -1
+	case i1 > i2:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// CompareVersion compares two version (or release) strings using
+// the same algorithm as rpmvercmp and returns -1, 0 or 1 if the
+// first is older, equal or newer than the second.
+func CompareVersion(a, b string) int {
+	if a == b {
+		return 0
+	}
+	for {
+		a = strings.TrimLeftFunc(a, isSeparator)
+		b = strings.TrimLeftFunc(b, isSeparator)
+
+		// a tilde sorts before everything, even
+		// the end of the string
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		// a caret sorts after the end of the string,
+		// but before everything else
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if a == "" {
+				return -1
+			}
+			if b == "" {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+
+		// grab the next segment, which is either
+		// all digits or all letters
+		numeric := isDigit(a[0])
+		segA, segB := a, b
+		a = strings.TrimLeftFunc(a, segmentFunc(numeric))
+		b = strings.TrimLeftFunc(b, segmentFunc(numeric))
+		segA, segB = segA[:len(segA)-len(a)], segB[:len(segB)-len(b)]
+
+		// segments of different types, numeric
+		// segments are always newer
+		if segB == "" {
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+
+		if numeric {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) > len(segB) {
+					return 1
+				}
+				return -1
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isSeparator(r rune) bool {
+	if r >= 0x80 {
+		return true
+	}
+	return !isDigit(byte(r)) && !isAlpha(byte(r)) && r != '~' && r != '^'
+}
+
+func segmentFunc(numeric bool) func(r rune) bool {
+	return func(r rune) bool {
+		if r >= 0x80 {
+			return false
+		}
+		if numeric {
+			return isDigit(byte(r))
+		}
+		return isAlpha(byte(r))
+	}
+}
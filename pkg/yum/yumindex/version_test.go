@@ -0,0 +1,80 @@
+package yumindex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersion(t *testing.T) {
+	var cases = []struct {
+		a, b string
+		out  int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0", "1.0", 1},
+		{"2.0.1", "2.0", 1},
+		{"2.0", "2.0.1", -1},
+		{"1.0a", "1.0", 1},
+		{"1.0", "1.0a", -1},
+		{"5.5p1", "5.5p10", -1},
+		{"10xyz", "10.1xyz", -1},
+		{"xyz10", "xyz10.1", -1},
+		{"1.0010", "1.9", 1},
+		{"1.05", "1.5", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0^", "1.0", 1},
+		{"1.0^git1", "1.01", -1},
+		{"1.0^git1~pre", "1.0^git1", -1},
+		{"8.30", "8.24", 1},
+		{"15.el8", "15.el8_8", -1},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			assert.EqualValues(t, tt.out, CompareVersion(tt.a, tt.b))
+		})
+	}
+}
+
+func TestEntry_MatchesEVR(t *testing.T) {
+	var cases = []struct {
+		name  string
+		entry Entry
+		evr   [3]string
+		ok    bool
+	}{
+		{
+			"unversioned",
+			Entry{Name: "foo"},
+			[3]string{"0", "1.0", "1"},
+			true,
+		},
+		{
+			"less than",
+			Entry{Name: "coreutils", Flags: FlagLT, Epoch: "0", Ver: "8.24", Rel: "100"},
+			[3]string{"0", "8.30", "15.el8"},
+			false,
+		},
+		{
+			"epoch wins",
+			Entry{Name: "foo", Flags: FlagGE, Epoch: "0", Ver: "2.0"},
+			[3]string{"1", "1.0", "1"},
+			true,
+		},
+		{
+			"release ignored when missing",
+			Entry{Name: "foo", Flags: FlagEQ, Ver: "1.0"},
+			[3]string{"0", "1.0", "5"},
+			true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.EqualValues(t, tt.ok, tt.entry.MatchesEVR(tt.evr[0], tt.evr[1], tt.evr[2]))
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package yummodules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/djcass44/all-your-base/pkg/yum/yumindex"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Parse reads a modules.yaml stream containing
+// module streams and defaults.
+func Parse(ctx context.Context, r io.Reader) (*Modules, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	modules := &Modules{
+		Defaults: map[string]string{},
+	}
+	dec := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var doc Document
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("decoding module document: %w", err)
+		}
+		switch doc.Document {
+		case DocumentModule:
+			modules.Streams = append(modules.Streams, doc.Data)
+		case DocumentDefaults:
+			if doc.Data.Stream != "" {
+				modules.Defaults[doc.Data.Module] = string(doc.Data.Stream)
+			}
+		default:
+			log.V(6).Info("skipping unsupported document", "document", doc.Document)
+		}
+	}
+	log.V(3).Info("parsed modules", "streams", len(modules.Streams), "defaults", len(modules.Defaults))
+	return modules, nil
+}
+
+// ParseStream parses a module stream in the form 'name:stream'.
+func ParseStream(s string) (string, string, error) {
+	name, stream, ok := strings.Cut(s, ":")
+	if !ok || name == "" || stream == "" {
+		return "", "", fmt.Errorf("malformed module stream, expecting 'name:stream': %s", s)
+	}
+	// profiles (e.g. 'nodejs:18/common') don't
+	// affect which packages are available
+	stream, _, _ = strings.Cut(stream, "/")
+	return name, stream, nil
+}
+
+// Filter removes packages that belong to module streams that have not
+// been enabled. Modules that have not been explicitly enabled use their
+// default stream, if they have one.
+//
+// Similar to dnf, non-modular packages that share a name with a package
+// from an enabled stream are also removed so that the modular version
+// is always used.
+func (m *Modules) Filter(ctx context.Context, packages []yumindex.Package, enabled map[string]string) []yumindex.Package {
+	log := logr.FromContextOrDiscard(ctx)
+
+	if len(m.Streams) == 0 {
+		return packages
+	}
+
+	// figure out which stream each module should be using
+	streams := map[string]string{}
+	for k, v := range m.Defaults {
+		streams[k] = v
+	}
+	for k, v := range enabled {
+		streams[k] = v
+	}
+
+	modular := map[string]bool{}
+	active := map[string]bool{}
+	activeNames := map[string]bool{}
+	for _, s := range m.Streams {
+		isActive := streams[s.Name] == string(s.Stream)
+		if isActive {
+			log.V(4).Info("enabling module stream", "module", s.Name, "stream", s.Stream, "context", s.Context)
+		}
+		for _, nevra := range s.Artifacts.Rpms {
+			modular[nevra] = true
+			if isActive {
+				active[nevra] = true
+				activeNames[nameFromNEVRA(nevra)] = true
+			}
+		}
+	}
+
+	var out []yumindex.Package
+	for _, p := range packages {
+		nevra := NEVRA(&p)
+		switch {
+		case modular[nevra] && !active[nevra]:
+			log.V(6).Info("filtering package from inactive module stream", "pkg", nevra)
+			continue
+		case !modular[nevra] && activeNames[p.Name]:
+			log.V(6).Info("filtering non-modular package masked by an active module stream", "pkg", nevra)
+			continue
+		}
+		out = append(out, p)
+	}
+	log.V(3).Info("filtered modular packages", "before", len(packages), "after", len(out))
+	return out
+}
+
+// NEVRA returns the package name in the same format used by
+// module artifacts (e.g. nodejs-1:18.14.2-2.module+el9.1.0.x86_64).
+// Unlike yumindex.Package.NEVRA, the epoch is always included.
+func NEVRA(p *yumindex.Package) string {
+	epoch := p.Version.Epoch
+	if epoch == "" {
+		epoch = "0"
+	}
+	return fmt.Sprintf("%s-%s:%s-%s.%s", p.Name, epoch, p.Version.Ver, p.Version.Rel, p.Arch)
+}
+
+// nameFromNEVRA extracts the package name from
+// a 'name-epoch:version-release.arch' string.
+func nameFromNEVRA(s string) string {
+	// drop the release
+	i := strings.LastIndex(s, "-")
+	if i < 0 {
+		return s
+	}
+	// drop the version
+	j := strings.LastIndex(s[:i], "-")
+	if j < 0 {
+		return s[:i]
+	}
+	return s[:j]
+}
@@ -0,0 +1,99 @@
+package yummodules
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/djcass44/all-your-base/pkg/yum/yumindex"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	f, err := os.Open("./testdata/modules.yaml")
+	require.NoError(t, err)
+	defer f.Close()
+
+	modules, err := Parse(ctx, f)
+	require.NoError(t, err)
+
+	require.Len(t, modules.Streams, 2)
+	assert.EqualValues(t, "18", modules.Streams[0].Stream)
+	assert.EqualValues(t, "20", modules.Streams[1].Stream)
+	assert.Len(t, modules.Streams[0].Artifacts.Rpms, 3)
+	assert.EqualValues(t, map[string]string{"php": "8.1"}, modules.Defaults)
+}
+
+func TestModules_Filter(t *testing.T) {
+	ctx := logr.NewContext(context.TODO(), testr.NewWithOptions(t, testr.Options{Verbosity: 10}))
+
+	f, err := os.Open("./testdata/modules.yaml")
+	require.NoError(t, err)
+	defer f.Close()
+
+	modules, err := Parse(ctx, f)
+	require.NoError(t, err)
+
+	newPackage := func(name, epoch, ver, rel string) yumindex.Package {
+		p := yumindex.Package{Name: name, Arch: "x86_64"}
+		p.Version.Epoch = epoch
+		p.Version.Ver = ver
+		p.Version.Rel = rel
+		return p
+	}
+	packages := []yumindex.Package{
+		newPackage("nodejs", "1", "18.12.1", "1.module+el9.1.0.z+17326+318294bb"),
+		newPackage("nodejs", "1", "20.9.0", "1.module+el9.3.0+20705+0d4b3ff3"),
+		newPackage("nodejs", "1", "16.20.2", "1.el9"),
+		newPackage("git", "0", "2.39.3", "1.el9_2"),
+	}
+
+	versions := func(packages []yumindex.Package) []string {
+		out := make([]string, len(packages))
+		for i := range packages {
+			out[i] = packages[i].Name + "-" + packages[i].Version.Ver
+		}
+		return out
+	}
+
+	t.Run("modular packages are hidden by default", func(t *testing.T) {
+		out := modules.Filter(ctx, packages, nil)
+		assert.ElementsMatch(t, []string{"nodejs-16.20.2", "git-2.39.3"}, versions(out))
+	})
+	t.Run("enabled streams are visible", func(t *testing.T) {
+		out := modules.Filter(ctx, packages, map[string]string{"nodejs": "20"})
+		assert.ElementsMatch(t, []string{"nodejs-20.9.0", "git-2.39.3"}, versions(out))
+	})
+}
+
+func TestParseStream(t *testing.T) {
+	var cases = []struct {
+		in     string
+		name   string
+		stream string
+		ok     bool
+	}{
+		{"nodejs:18", "nodejs", "18", true},
+		{"nodejs:18/common", "nodejs", "18", true},
+		{"nodejs", "", "", false},
+		{"nodejs:", "", "", false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			name, stream, err := ParseStream(tt.in)
+			if !tt.ok {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.EqualValues(t, tt.name, name)
+			assert.EqualValues(t, tt.stream, stream)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package yummodules
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Document types that can be found in a modules.yaml file
+const (
+	DocumentModule   = "modulemd"
+	DocumentDefaults = "modulemd-defaults"
+)
+
+// Document is a single YAML document in a modules.yaml file.
+// It is used for both "modulemd" and "modulemd-defaults"
+// documents as they share most of their structure.
+//
+// https://github.com/fedora-modularity/libmodulemd/blob/main/yaml_specs/modulemd_stream_v2.yaml
+type Document struct {
+	Document string `json:"document"`
+	Version  int    `json:"version"`
+	Data     Data   `json:"data"`
+}
+
+type Data struct {
+	// Name is the name of the module (modulemd only)
+	Name string `json:"name,omitempty"`
+	// Module is the name of the module (modulemd-defaults only)
+	Module    string      `json:"module,omitempty"`
+	Stream    FlexString  `json:"stream,omitempty"`
+	Version   json.Number `json:"version,omitempty"`
+	Context   string      `json:"context,omitempty"`
+	Arch      string      `json:"arch,omitempty"`
+	Artifacts Artifacts   `json:"artifacts,omitempty"`
+}
+
+type Artifacts struct {
+	// Rpms is the list of packages (in NEVRA form)
+	// that make up the module stream.
+	Rpms []string `json:"rpms,omitempty"`
+}
+
+// Modules contains the module streams and defaults
+// published by a repository.
+type Modules struct {
+	Streams  []Data
+	Defaults map[string]string
+}
+
+// FlexString is a string that may be written as a
+// YAML number (e.g. 'stream: 18').
+type FlexString string
+
+func (f *FlexString) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*f = FlexString(s)
+		return nil
+	}
+	*f = FlexString(strings.TrimSpace(string(b)))
+	return nil
+}
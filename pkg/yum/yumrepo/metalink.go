@@ -0,0 +1,121 @@
+package yumrepo
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"hash"
+	"slices"
+	"strings"
+)
+
+// Metalink is the document returned by a metalink= URL. It
+// contains a list of mirrors along with the expected hashes
+// of the repomd.xml.
+type Metalink struct {
+	XMLName xml.Name       `xml:"metalink"`
+	Files   []MetalinkFile `xml:"files>file"`
+}
+
+type MetalinkFile struct {
+	Name         string              `xml:"name,attr"`
+	Size         int64               `xml:"size"`
+	Verification []MetalinkHash      `xml:"verification>hash"`
+	Alternates   []MetalinkAlternate `xml:"alternates>alternate"`
+	Resources    []MetalinkURL       `xml:"resources>url"`
+}
+
+// MetalinkAlternate is an older version of the file that
+// mirrors may still be serving while they catch up.
+type MetalinkAlternate struct {
+	Size         int64          `xml:"size"`
+	Verification []MetalinkHash `xml:"verification>hash"`
+}
+
+type MetalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type MetalinkURL struct {
+	Protocol   string `xml:"protocol,attr"`
+	Location   string `xml:"location,attr"`
+	Preference int    `xml:"preference,attr"`
+	Value      string `xml:",chardata"`
+}
+
+// hashPreference is the order in which we pick the hash
+// to verify, strongest first.
+var hashPreference = []string{"sha512", "sha256", "sha1", "md5"}
+
+// File returns the file with the given name.
+func (m *Metalink) File(name string) (*MetalinkFile, bool) {
+	for i := range m.Files {
+		if m.Files[i].Name == name {
+			return &m.Files[i], true
+		}
+	}
+	return nil, false
+}
+
+// URLs returns the HTTP(S) mirrors of the file, ordered
+// by their preference.
+func (f *MetalinkFile) URLs() []string {
+	resources := slices.Clone(f.Resources)
+	slices.SortStableFunc(resources, func(a, b MetalinkURL) int {
+		return b.Preference - a.Preference
+	})
+	var urls []string
+	for _, r := range resources {
+		if r.Protocol != "" && r.Protocol != "http" && r.Protocol != "https" {
+			continue
+		}
+		urls = append(urls, strings.TrimSpace(r.Value))
+	}
+	return urls
+}
+
+// Verify checks that data matches the current version of
+// the file, or one of its alternates.
+func (f *MetalinkFile) Verify(data []byte) bool {
+	if verify(f.Verification, data) {
+		return true
+	}
+	for _, a := range f.Alternates {
+		if verify(a.Verification, data) {
+			return true
+		}
+	}
+	return false
+}
+
+func verify(hashes []MetalinkHash, data []byte) bool {
+	for _, alg := range hashPreference {
+		i := slices.IndexFunc(hashes, func(h MetalinkHash) bool {
+			return strings.EqualFold(h.Type, alg)
+		})
+		if i < 0 {
+			continue
+		}
+		h := newHash(alg)
+		h.Write(data)
+		return hex.EncodeToString(h.Sum(nil)) == strings.ToLower(strings.TrimSpace(hashes[i].Value))
+	}
+	return false
+}
+
+func newHash(alg string) hash.Hash {
+	switch alg {
+	case "sha512":
+		return sha512.New()
+	case "sha256":
+		return sha256.New()
+	case "sha1":
+		return sha1.New()
+	default:
+		return md5.New()
+	}
+}
@@ -0,0 +1,43 @@
+package yumrepo
+
+import (
+	"encoding/xml"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetalinkFile(t *testing.T) {
+	data, err := os.ReadFile("./testdata/metalink.xml")
+	require.NoError(t, err)
+
+	var metalink Metalink
+	require.NoError(t, xml.Unmarshal(data, &metalink))
+
+	file, ok := metalink.File("repomd.xml")
+	require.True(t, ok)
+
+	t.Run("urls are ordered by preference", func(t *testing.T) {
+		assert.EqualValues(t, []string{
+			"https://mirror.example.com/fedora/releases/39/Everything/x86_64/os/repodata/repomd.xml",
+			"https://mirror.example.com.au/fedora/releases/39/Everything/x86_64/os/repodata/repomd.xml",
+		}, file.URLs())
+	})
+
+	var cases = []struct {
+		name string
+		in   string
+		ok   bool
+	}{
+		{"current", "hello world", true},
+		{"alternate", "hello world\n", true},
+		{"mismatch", "goodbye world", false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.EqualValues(t, tt.ok, file.Verify([]byte(tt.in)))
+		})
+	}
+}
@@ -1,8 +1,26 @@
 package yumrepo
 
+// Data types that we care about in the repomd.xml
+const (
+	DataPrimary = "primary"
+	DataModules = "modules"
+)
+
 func (d *RepoData) PrimaryXML() string {
+	return d.Location(DataPrimary)
+}
+
+// ModulesYAML returns the location of the modules.yaml
+// file. Repositories that don't contain modular packages
+// won't have one.
+func (d *RepoData) ModulesYAML() string {
+	return d.Location(DataModules)
+}
+
+// Location returns the location of the data with the given type.
+func (d *RepoData) Location(dataType string) string {
 	for _, i := range d.Data {
-		if i.Type == "primary" {
+		if i.Type == dataType {
 			return i.Location.Href
 		}
 	}
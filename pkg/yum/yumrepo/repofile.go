@@ -0,0 +1,139 @@
+package yumrepo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Repository is a single section of a yum .repo file.
+type Repository struct {
+	ID         string
+	Name       string
+	BaseURL    []string
+	MirrorList string
+	Metalink   string
+	Enabled    bool
+	GPGCheck   bool
+	GPGKey     []string
+}
+
+// ParseRepoFile reads the repositories from a yum .repo file.
+// Variables (e.g. $basearch and $releasever) are substituted
+// using the given vars. Unknown variables are left untouched.
+func ParseRepoFile(r io.Reader, vars map[string]string) ([]Repository, error) {
+	var repos []Repository
+	var current *Repository
+	var key string
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		// start of a new repository
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			repos = append(repos, Repository{
+				ID:      Substitute(strings.TrimSpace(line[1:len(line)-1]), vars),
+				Enabled: true,
+			})
+			current = &repos[len(repos)-1]
+			key = ""
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: option found outside of a repository section", lineNo)
+		}
+		// indented lines continue the previous option
+		// (e.g. multiple baseurls)
+		if raw[0] == ' ' || raw[0] == '\t' {
+			if key == "" {
+				return nil, fmt.Errorf("line %d: continuation line without an option", lineNo)
+			}
+			current.set(key, Substitute(line, vars), true)
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected 'key=value': %s", lineNo, line)
+		}
+		key = strings.ToLower(strings.TrimSpace(k))
+		current.set(key, Substitute(strings.TrimSpace(v), vars), false)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+func (r *Repository) set(key, value string, continued bool) {
+	switch key {
+	case "name":
+		if continued {
+			r.Name += " " + value
+			return
+		}
+		r.Name = value
+	case "baseurl":
+		r.BaseURL = append(r.BaseURL, splitList(value)...)
+	case "mirrorlist":
+		r.MirrorList = value
+	case "metalink":
+		r.Metalink = value
+	case "enabled":
+		r.Enabled = parseBool(value)
+	case "gpgcheck":
+		r.GPGCheck = parseBool(value)
+	case "gpgkey":
+		r.GPGKey = append(r.GPGKey, splitList(value)...)
+	}
+}
+
+// Substitute replaces yum variables (e.g. $basearch or ${releasever})
+// in s. Variables that aren't in vars are left as-is.
+func Substitute(s string, vars map[string]string) string {
+	return os.Expand(s, func(k string) string {
+		if v, ok := vars[k]; ok {
+			return v
+		}
+		return "$" + k
+	})
+}
+
+// BaseArch converts a Go architecture into the
+// value yum uses for $basearch.
+func BaseArch(goarch string) string {
+	switch goarch {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "386":
+		return "i386"
+	case "arm":
+		return "armhfp"
+	default:
+		return goarch
+	}
+}
+
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+func parseBool(s string) bool {
+	switch strings.ToLower(s) {
+	case "1", "yes", "true", "on":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,45 @@
+package yumrepo
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRepoFile(t *testing.T) {
+	f, err := os.Open("./testdata/fedora.repo")
+	require.NoError(t, err)
+	defer f.Close()
+
+	repos, err := ParseRepoFile(f, map[string]string{
+		"basearch":   "x86_64",
+		"releasever": "39",
+	})
+	require.NoError(t, err)
+	require.Len(t, repos, 3)
+
+	assert.EqualValues(t, "fedora", repos[0].ID)
+	assert.EqualValues(t, "Fedora 39 - x86_64", repos[0].Name)
+	assert.Empty(t, repos[0].BaseURL)
+	assert.EqualValues(t, "https://mirrors.fedoraproject.org/metalink?repo=fedora-39&arch=x86_64", repos[0].Metalink)
+	assert.True(t, repos[0].Enabled)
+	assert.True(t, repos[0].GPGCheck)
+	assert.EqualValues(t, []string{"file:///etc/pki/rpm-gpg/RPM-GPG-KEY-fedora-39-x86_64"}, repos[0].GPGKey)
+
+	assert.False(t, repos[1].Enabled)
+
+	assert.EqualValues(t, "ubi-8-baseos", repos[2].ID)
+	assert.EqualValues(t, []string{
+		"https://cdn-ubi.redhat.com/content/public/ubi/dist/ubi8/8/x86_64/baseos/os",
+		"https://mirror.example.com/ubi8/x86_64/baseos/os",
+	}, repos[2].BaseURL)
+}
+
+func TestSubstitute(t *testing.T) {
+	vars := map[string]string{"basearch": "aarch64"}
+	assert.EqualValues(t, "aarch64/os", Substitute("$basearch/os", vars))
+	assert.EqualValues(t, "aarch64-os", Substitute("${basearch}-os", vars))
+	assert.EqualValues(t, "$releasever/aarch64", Substitute("$releasever/$basearch", vars))
+}
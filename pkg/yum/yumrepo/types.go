@@ -21,7 +21,7 @@ type Data struct {
 
 type Checksum struct {
 	Type  string `xml:"type,attr"`
-	Value string `xml:"text"`
+	Value string `xml:",chardata"`
 }
 
 type Location struct {